@@ -19,30 +19,56 @@ limitations under the License.
 package main
 
 import (
-	//ctx "context"
+	ctx "context"
 	"flag"
 	"fmt"
-	"net"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/google/gnxi/utils/credentials"
+	"github.com/google/link022/agent/capture"
+	"github.com/google/link022/agent/certstore"
 	"github.com/google/link022/agent/context"
 	"github.com/google/link022/agent/controller"
+	"github.com/google/link022/agent/denylist"
+	"github.com/google/link022/agent/dot1x"
+	"github.com/google/link022/agent/filter"
 	"github.com/google/link022/agent/gnmi"
-	//"github.com/google/link022/agent/monitoring"
+	"github.com/google/link022/agent/gnoi"
+	"github.com/google/link022/agent/grpcserver"
+	"github.com/google/link022/agent/logging"
+	"github.com/google/link022/agent/monitoring"
+	"github.com/google/link022/agent/portal"
+	"github.com/google/link022/agent/ratelimit"
+	"github.com/google/link022/agent/steering"
 	"github.com/google/link022/agent/syscmd"
+	"github.com/google/link022/agent/system"
+	"github.com/google/link022/agent/uplink"
+	capturepb "github.com/google/link022/proto/capture"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/reflection"
 
 	log "github.com/golang/glog"
 	pb "github.com/openconfig/gnmi/proto/gnmi"
+	certpb "github.com/openconfig/gnoi/cert"
+	filepb "github.com/openconfig/gnoi/file"
+	ospb "github.com/openconfig/gnoi/os"
+	syspb "github.com/openconfig/gnoi/system"
 )
 
 var (
 	ethINTFName    = flag.String("eth_intf_name", "eth0", "The management network interface on this device.")
 	wlanINTFName   = flag.String("wlan_intf_name", "wlan0", "The WLAN interface on this device for AP radio.")
+	wlan2INTFName  = flag.String("second_wlan_intf_name", "", "The WLAN interface on this device for the second AP radio, if any.")
 	gnmiPort       = flag.Int("gnmi_port", 10162, "The port GNMI server listening on.")
 	controllerAddr = flag.String("controller_address", "", "The WiFi Controller of this device.")
+	neighborTTL    = flag.Duration("neighbor_ttl", 5*time.Minute, "How long a neighbor BSS is kept after it was last seen.")
+	certStoreDir   = flag.String("cert_store_dir", "/etc/link022/certs", "The folder containing the certificates the gRPC server can use.")
+	fileDirs       = flag.String("file_dirs", "/var/run/link022", "The comma-separated folders the gNOI File service can access, next to the log folder.")
+	filePutDir     = flag.String("file_put_dir", "/var/run/link022/files", "The folder the gNOI File service can put files in.")
+	osDir          = flag.String("os_dir", "/opt/link022", "The folder containing the installed versions of the agent.")
+	agentUnit      = flag.String("agent_unit", "link022", "The systemd unit running the agent, restarted to activate a new version.")
 
 	cmdRunner = syscmd.Runner()
 )
@@ -60,10 +86,30 @@ func main() {
 	deviceConfig.Hostname = hostname
 	log.Infof("Hostname = %s.", hostname)
 
+	// Capture the logs of the agent, to filter the console logs and send them to remote syslog servers.
+	logger := logging.NewLogger(hostname)
+	if err := logger.CaptureGlog(); err != nil {
+		log.Errorf("Failed to capture the agent logs. Error: %v.", err)
+	}
+
+	// The agent runs from one of the installed versions, with the hostapd of that version if it has one.
+	osServer := gnoi.NewOSServer(cmdRunner, *osDir, *agentUnit)
+	if version := osServer.Version(); version != "" {
+		log.Infof("Software version = %s.", version)
+	}
+	if hostapd := osServer.Hostapd(); hostapd != "" {
+		syscmd.HostapdBinary = hostapd
+	}
+
 	// Load AP network interface configuration.
 	deviceConfig.ETHINTFName = *ethINTFName
 	deviceConfig.WLANINTFName = *wlanINTFName
-	log.Infof("Eth interface = %s. WLAN interface = %s.", *ethINTFName, *wlanINTFName)
+	deviceConfig.WLANINTFNames = []string{*wlanINTFName}
+	if *wlan2INTFName != "" {
+		deviceConfig.WLANINTFNames = append(deviceConfig.WLANINTFNames, *wlan2INTFName)
+	}
+	log.Infof("Eth interface = %s. WLAN interfaces = %v.", *ethINTFName, deviceConfig.WLANINTFNames)
+	deviceConfig.NeighborTTL = *neighborTTL
 
 	// Get gNMI server address.
 	deviceIPv4, err := cmdRunner.DeviceIPv4()
@@ -90,25 +136,135 @@ func main() {
 
 	// Start a goroutine to collect states periodically
 	// Monitoing service is disabled because it has a bug that causes internal OpenConfig model being invalid.
-	//backgroundContext := ctx.Background()
+	backgroundContext := ctx.Background()
 	//go monitoring.UpdateDeviceStatus(backgroundContext, gnmiServer)
 
-	// Start the GNMI server.
-	var opts []grpc.ServerOption
-	if *controllerAddr == "" {
-		// Add credential check if no controller specified.
-		opts = credentials.ServerCredentials()
+	// Start a goroutine to scan neighbor BSSs periodically.
+	go monitoring.UpdateNeighbors(backgroundContext, gnmiServer)
+
+	// Start a goroutine to collect channel utilization and radio counters periodically.
+	go monitoring.UpdateRadioCounters(backgroundContext, gnmiServer)
+
+	// Start a goroutine to collect BSS counters periodically.
+	go monitoring.UpdateBSSCounters(backgroundContext, gnmiServer)
+
+	// Start a goroutine to collect RADIUS server counters periodically.
+	go monitoring.UpdateRadiusCounters(backgroundContext, gnmiServer)
+
+	// Start a goroutine to check the AP alarms and publish the active ones periodically.
+	go monitoring.UpdateAlarms(backgroundContext, gnmiServer)
+
+	// Start a goroutine to run dynamic transmit power control.
+	go monitoring.UpdateTransmitPower(backgroundContext, gnmiServer)
+
+	// Start a goroutine to steer dual-band clients to 5 GHz.
+	go steering.NewSteerer(cmdRunner, denylist.Default()).Run(backgroundContext, gnmiServer)
+
+	// Start a goroutine to blacklist clients failing 802.1X authentication too many times.
+	go dot1x.NewTracker(denylist.Default()).Run(backgroundContext, gnmiServer)
+
+	// Start a goroutine to install the layer 2 traffic filters of SSIDs.
+	go filter.NewFilter(cmdRunner).Run(backgroundContext, gnmiServer)
+
+	// Start a goroutine to serve the captive portals of NAT SSIDs.
+	go portal.NewPortal(cmdRunner).Run(backgroundContext, gnmiServer)
+
+	// Start a goroutine to enforce the rate limits of SSIDs and their clients.
+	go ratelimit.NewLimiter(cmdRunner, *ethINTFName).Run(backgroundContext, gnmiServer)
+
+	// The GNMI server moves to the new address of the management interface when it changes.
+	var grpcManager *grpcserver.Manager
+	uplinkManager := uplink.NewManager(cmdRunner, *ethINTFName, hostname, func(ipAddress string) {
+		if err := grpcManager.SetManagementIP(ipAddress); err != nil {
+			log.Errorf("Failed to move the GNMI server to %s. Error: %v.", ipAddress, err)
+			return
+		}
+		deviceConfig.GNMIServerAddr = grpcManager.Addr()
+	})
+
+	// The gNOI services are served next to GNMI, they keep their state when the gRPC server is replaced.
+	systemServer := gnoi.NewSystemServer(cmdRunner)
+	fileServer := gnoi.NewFileServer(append(strings.Split(*fileDirs, ","), flagValue("log_dir")), *filePutDir)
+	captureServer := capture.NewServer()
+	certStore := certstore.New(*certStoreDir, flagValue("ca"))
+	certServer := gnoi.NewCertServer(certStore, func() string {
+		return grpcManager.CertificateID()
+	})
+
+	// Create the GNMI servers required by the gRPC server settings.
+	grpcManager = grpcserver.NewManager(hostname, *gnmiPort, certStore, func(defaultCredentials bool) grpcserver.Server {
+		var opts []grpc.ServerOption
+		if defaultCredentials && *controllerAddr == "" {
+			// Add credential check if no controller specified.
+			opts = credentials.ServerCredentials()
+		}
+		// Any request received after a change of the management interface confirms it.
+		opts = append(opts,
+			grpc.UnaryInterceptor(func(c ctx.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+				uplinkManager.Confirm()
+				return handler(c, req)
+			}),
+			grpc.StreamInterceptor(func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+				uplinkManager.Confirm()
+				return handler(srv, stream)
+			}))
+
+		g := grpc.NewServer(opts...)
+		pb.RegisterGNMIServer(g, gnmiServer)
+		syspb.RegisterSystemServer(g, systemServer)
+		certpb.RegisterCertificateManagementServer(g, certServer)
+		filepb.RegisterFileServer(g, fileServer)
+		ospb.RegisterOSServer(g, osServer)
+		capturepb.RegisterCaptureServer(g, captureServer)
+		reflection.Register(g)
+		return g
+	})
+	if err := grpcManager.SetManagementIP(deviceIPv4); err != nil {
+		log.Exitf("Failed to set the management address %s. Error: %v.", deviceIPv4, err)
 	}
-	g := grpc.NewServer(opts...)
-	pb.RegisterGNMIServer(g, gnmiServer)
-	reflection.Register(g)
-	listen, err := net.Listen("tcp", gNMIServerAddr)
-	if err != nil {
+	// Start the GNMI server with the default settings, the configured ones are applied once loaded.
+	if err := grpcManager.Update(nil); err != nil {
 		log.Exitf("Failed to listen on %s. Error: %v.", gNMIServerAddr, err)
 	}
 
+	// Start a goroutine to apply the gRPC server settings.
+	go grpcManager.Run(backgroundContext, gnmiServer, func(addr string) {
+		deviceConfig.GNMIServerAddr = addr
+	})
+
+	// Start a goroutine to keep a newly activated version once it runs as configured, otherwise it is rolled back.
+	go osServer.ConfirmActivation(backgroundContext, func() error {
+		if err := grpcManager.Err(); err != nil {
+			return err
+		}
+		return monitoring.CheckHealth(gnmiServer)
+	})
+
+	// Start a goroutine to apply the settings of the management interface.
+	go uplinkManager.Run(backgroundContext, gnmiServer)
+
+	// Start a goroutine to apply the NTP, DNS and clock settings.
+	go system.NewServices(cmdRunner, hostname, osServer.Version(), uplinkManager.DNSServers).Run(backgroundContext, gnmiServer)
+
+	// Start a goroutine to apply the SSH server settings.
+	go system.NewSSHServer(cmdRunner, hostname).Run(backgroundContext, gnmiServer)
+
+	// Start a goroutine to apply the logging settings and forward the hostapd events.
+	go logger.Run(backgroundContext, gnmiServer)
+
+	// Start a goroutine to remove the old packet captures.
+	go captureServer.Run(backgroundContext)
+
 	log.Infof("Running GNMI server. Listen on %s.", gNMIServerAddr)
-	if err := g.Serve(listen); err != nil {
-		log.Exitf("Failed to run GNMI server on %s. Error: %v.", gNMIServerAddr, err)
+	if err := grpcManager.Wait(); err != nil {
+		log.Exitf("Failed to run GNMI server. Error: %v.", err)
+	}
+}
+
+// flagValue returns the value of a flag defined by a library, e.g. the CA certificate of the credentials, empty if it is not defined.
+func flagValue(name string) string {
+	if f := flag.Lookup(name); f != nil {
+		return f.Value.String()
 	}
+	return ""
 }
@@ -0,0 +1,201 @@
+/* Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package alarm keeps the alarms raised by the agent features, and publishes them in the alarms of the AP.
+//
+// An alarm is identified by its type and the resource it is raised on, e.g. the address of a RADIUS server.
+// Raising an active alarm again only updates its text, and an alarm stays active until it is cleared.
+package alarm
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/golang/glog"
+	"github.com/google/link022/generated/ocstruct"
+	"github.com/openconfig/ygot/ygot"
+)
+
+// Types of the alarms raised by the agent.
+const (
+	HostapdDown            = "HOSTAPD_DOWN"
+	RadiusUnreachable      = "RADIUS_UNREACHABLE"
+	ApplyFailed            = "CONFIG_APPLY_FAILED"
+	ControllerDisconnected = "CONTROLLER_DISCONNECTED"
+	ConfigDrift            = "CONFIG_DRIFT"
+	HighCPU                = "HIGH_CPU_USAGE"
+	HighMemory             = "HIGH_MEMORY_USAGE"
+	RadarDetected          = "DFS_RADAR_DETECTED"
+)
+
+// severities are the severities of each alarm type.
+var severities = map[string]ocstruct.E_OpenconfigAlarmTypes_OPENCONFIG_ALARM_SEVERITY{
+	HostapdDown:            ocstruct.OpenconfigAlarmTypes_OPENCONFIG_ALARM_SEVERITY_CRITICAL,
+	RadiusUnreachable:      ocstruct.OpenconfigAlarmTypes_OPENCONFIG_ALARM_SEVERITY_MAJOR,
+	ApplyFailed:            ocstruct.OpenconfigAlarmTypes_OPENCONFIG_ALARM_SEVERITY_MAJOR,
+	ControllerDisconnected: ocstruct.OpenconfigAlarmTypes_OPENCONFIG_ALARM_SEVERITY_MINOR,
+	ConfigDrift:            ocstruct.OpenconfigAlarmTypes_OPENCONFIG_ALARM_SEVERITY_MINOR,
+	HighCPU:                ocstruct.OpenconfigAlarmTypes_OPENCONFIG_ALARM_SEVERITY_WARNING,
+	HighMemory:             ocstruct.OpenconfigAlarmTypes_OPENCONFIG_ALARM_SEVERITY_WARNING,
+	RadarDetected:          ocstruct.OpenconfigAlarmTypes_OPENCONFIG_ALARM_SEVERITY_WARNING,
+}
+
+// Alarm is an active alarm.
+type Alarm struct {
+	ID       string
+	TypeID   string
+	Resource string
+	Severity ocstruct.E_OpenconfigAlarmTypes_OPENCONFIG_ALARM_SEVERITY
+	Text     string
+	// TimeCreated is when the alarm was raised first.
+	TimeCreated time.Time
+}
+
+// ID returns the ID of the alarm of the given type raised on a resource, e.g. "RADIUS_UNREACHABLE:192.168.1.10".
+// The ID of an alarm without resource is its type.
+func ID(typeID, resource string) string {
+	if resource == "" {
+		return typeID
+	}
+	return typeID + ":" + resource
+}
+
+// Manager keeps the active alarms.
+type Manager struct {
+	now func() time.Time
+
+	mu     sync.Mutex
+	alarms map[string]*Alarm // ID -> alarm
+}
+
+// New creates a Manager without active alarm.
+func New() *Manager {
+	return &Manager{
+		now:    time.Now,
+		alarms: make(map[string]*Alarm),
+	}
+}
+
+var defaultManager = New()
+
+// Default returns the alarm manager shared by all features of the agent.
+func Default() *Manager {
+	return defaultManager
+}
+
+// Raise raises the alarm of the given type on a resource, or updates its text if it is already active.
+func (m *Manager) Raise(typeID, resource, text string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.raise(typeID, resource, text)
+}
+
+// Clear clears the alarm of the given type on a resource, if it is active.
+func (m *Manager) Clear(typeID, resource string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.clear(ID(typeID, resource))
+}
+
+// Set sets the active alarms of the given type, as a resource -> text map.
+// Alarms of the type raised on other resources are cleared.
+func (m *Manager) Set(typeID string, raised map[string]string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, alarm := range m.alarms {
+		if _, ok := raised[alarm.Resource]; alarm.TypeID == typeID && !ok {
+			m.clear(id)
+		}
+	}
+	for resource, text := range raised {
+		m.raise(typeID, resource, text)
+	}
+}
+
+func (m *Manager) raise(typeID, resource, text string) {
+	id := ID(typeID, resource)
+	if alarm, ok := m.alarms[id]; ok {
+		alarm.Text = text
+		return
+	}
+	severity, ok := severities[typeID]
+	if !ok {
+		severity = ocstruct.OpenconfigAlarmTypes_OPENCONFIG_ALARM_SEVERITY_UNKNOWN
+	}
+	m.alarms[id] = &Alarm{
+		ID:          id,
+		TypeID:      typeID,
+		Resource:    resource,
+		Severity:    severity,
+		Text:        text,
+		TimeCreated: m.now(),
+	}
+	log.Warningf("Alarm %s raised: %s", id, text)
+}
+
+func (m *Manager) clear(id string) {
+	if _, ok := m.alarms[id]; !ok {
+		return
+	}
+	delete(m.alarms, id)
+	log.Infof("Alarm %s cleared.", id)
+}
+
+// Alarms returns the active alarms, ordered by ID.
+func (m *Manager) Alarms() []*Alarm {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var alarms []*Alarm
+	for _, alarm := range m.alarms {
+		alarmCopy := *alarm
+		alarms = append(alarms, &alarmCopy)
+	}
+	sort.Slice(alarms, func(i, j int) bool {
+		return alarms[i].ID < alarms[j].ID
+	})
+	return alarms
+}
+
+// UpdateState replaces the alarms of the given AP with the active alarms.
+func (m *Manager) UpdateState(ap *ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint) error {
+	alarmsNode := &ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System_Alarms{}
+	for _, alarm := range m.Alarms() {
+		alarmNode, err := alarmsNode.NewAlarm(alarm.ID)
+		if err != nil {
+			return err
+		}
+		alarmNode.State = &ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System_Alarms_Alarm_State{
+			Id:          ygot.String(alarm.ID),
+			Severity:    alarm.Severity,
+			Text:        ygot.String(alarm.Text),
+			TimeCreated: ygot.Uint64(uint64(alarm.TimeCreated.UnixNano())),
+			TypeId: &ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System_Alarms_Alarm_State_TypeId_Union_String{
+				String: alarm.TypeID,
+			},
+		}
+		if alarm.Resource != "" {
+			alarmNode.State.Resource = ygot.String(alarm.Resource)
+		}
+	}
+
+	if ap.System == nil {
+		ap.System = &ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System{}
+	}
+	ap.System.Alarms = alarmsNode
+	return nil
+}
@@ -0,0 +1,122 @@
+/* Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alarm
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/link022/generated/ocstruct"
+	"github.com/openconfig/ygot/ygot"
+)
+
+// testManager creates a Manager whose clock advances by one second on each alarm raised.
+func testManager() *Manager {
+	m := New()
+	now := time.Unix(1538755500, 0)
+	m.now = func() time.Time {
+		now = now.Add(time.Second)
+		return now
+	}
+	return m
+}
+
+func TestRaiseAndClear(t *testing.T) {
+	m := testManager()
+	m.Raise(ControllerDisconnected, "192.168.1.1:10162", "Cannot connect to the controller.")
+	m.Raise(ApplyFailed, "", "Failed to apply the configuration.")
+	// Raising an active alarm only updates its text.
+	m.Raise(ControllerDisconnected, "192.168.1.1:10162", "Cannot connect to the controller: timeout.")
+	m.Clear(ApplyFailed, "")
+	m.Clear(HighCPU, "")
+
+	want := []*Alarm{{
+		ID:          "CONTROLLER_DISCONNECTED:192.168.1.1:10162",
+		TypeID:      ControllerDisconnected,
+		Resource:    "192.168.1.1:10162",
+		Severity:    ocstruct.OpenconfigAlarmTypes_OPENCONFIG_ALARM_SEVERITY_MINOR,
+		Text:        "Cannot connect to the controller: timeout.",
+		TimeCreated: time.Unix(1538755501, 0),
+	}}
+	if got := m.Alarms(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Incorrect alarms (got: %+v, want: %+v).", got, want)
+	}
+}
+
+func TestSet(t *testing.T) {
+	m := testManager()
+	m.Set(RadiusUnreachable, map[string]string{
+		"192.168.11.250": "RADIUS server 192.168.11.250 does not answer.",
+		"192.168.11.251": "RADIUS server 192.168.11.251 does not answer.",
+	})
+	m.Raise(HighMemory, "", "Memory usage is 95%.")
+	m.Set(RadiusUnreachable, map[string]string{
+		"192.168.11.251": "RADIUS server 192.168.11.251 does not answer.",
+	})
+
+	var ids []string
+	for _, alarm := range m.Alarms() {
+		ids = append(ids, alarm.ID)
+	}
+	want := []string{"HIGH_MEMORY_USAGE", "RADIUS_UNREACHABLE:192.168.11.251"}
+	if !reflect.DeepEqual(ids, want) {
+		t.Errorf("Incorrect alarms (got: %v, want: %v).", ids, want)
+	}
+}
+
+func TestUpdateState(t *testing.T) {
+	m := testManager()
+	m.Raise(HostapdDown, "", "hostapd is not running.")
+	m.Raise(RadarDetected, "5260", "Radar detected by wlan0 on 5260 MHz, the channel is unavailable.")
+
+	ap := &ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint{Hostname: ygot.String("test-pi-1")}
+	if err := m.UpdateState(ap); err != nil {
+		t.Fatalf("Updating the alarms failed. Error: %v.", err)
+	}
+	want := map[string]*ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System_Alarms_Alarm_State{
+		"HOSTAPD_DOWN": {
+			Id:          ygot.String("HOSTAPD_DOWN"),
+			Severity:    ocstruct.OpenconfigAlarmTypes_OPENCONFIG_ALARM_SEVERITY_CRITICAL,
+			Text:        ygot.String("hostapd is not running."),
+			TimeCreated: ygot.Uint64(1538755501000000000),
+			TypeId:      &ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System_Alarms_Alarm_State_TypeId_Union_String{String: HostapdDown},
+		},
+		"DFS_RADAR_DETECTED:5260": {
+			Id:          ygot.String("DFS_RADAR_DETECTED:5260"),
+			Resource:    ygot.String("5260"),
+			Severity:    ocstruct.OpenconfigAlarmTypes_OPENCONFIG_ALARM_SEVERITY_WARNING,
+			Text:        ygot.String("Radar detected by wlan0 on 5260 MHz, the channel is unavailable."),
+			TimeCreated: ygot.Uint64(1538755502000000000),
+			TypeId:      &ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System_Alarms_Alarm_State_TypeId_Union_String{String: RadarDetected},
+		},
+	}
+	if len(ap.System.Alarms.Alarm) != len(want) {
+		t.Errorf("Incorrect number of alarms: %d.", len(ap.System.Alarms.Alarm))
+	}
+	for id, alarm := range ap.System.Alarms.Alarm {
+		if !reflect.DeepEqual(alarm.State, want[id]) {
+			t.Errorf("Incorrect state of alarm %s (got: %+v, want: %+v).", id, alarm.State, want[id])
+		}
+	}
+
+	// Cleared alarms are removed.
+	m.Clear(HostapdDown, "")
+	m.Clear(RadarDetected, "5260")
+	if err := m.UpdateState(ap); err != nil || len(ap.System.Alarms.Alarm) != 0 {
+		t.Errorf("Incorrect alarms after clearing them: %v (error: %v).", ap.System.Alarms.Alarm, err)
+	}
+}
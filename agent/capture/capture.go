@@ -0,0 +1,445 @@
+/* Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package capture captures the packets of the interfaces of the AP with dumpcap, to pcapng files or streamed to the caller.
+package capture
+
+import (
+	"bytes"
+	ctx "context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/golang/glog"
+	capturepb "github.com/google/link022/proto/capture"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// defaultMaxDuration is the duration of the captures without a maximum duration.
+	defaultMaxDuration = time.Minute
+	// maxDuration is the maximum duration of a capture.
+	maxDuration = 10 * time.Minute
+	// defaultMaxBytes is the size of the captures without a maximum size.
+	defaultMaxBytes = 10 * 1000 * 1000
+	// maxBytes is the maximum size of a capture. The capture folder is usually in memory.
+	maxBytes = 50 * 1000 * 1000
+	// maxCaptures is the maximum number of captures running at the same time, to files or streamed.
+	maxCaptures = 2
+	// maxCaptureFiles is the number of capture files kept, the oldest ones are removed first.
+	maxCaptureFiles = 10
+	// captureFileTTL is how long the capture files are kept.
+	captureFileTTL  = 24 * time.Hour
+	cleanupInterval = 10 * time.Minute
+
+	captureFileExt = ".pcapng"
+)
+
+var (
+	captureFolder = "/var/run/link022/captures"
+
+	// e.g. br_100, wlan0.mon
+	intfNameRegex = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.\-]*$`)
+
+	// interfaceExists returns whether the given interface exists. Captures do not create the interfaces they capture,
+	// e.g. a WLAN monitor interface must be created beforehand.
+	interfaceExists = func(name string) bool {
+		_, err := net.InterfaceByName(name)
+		return err == nil
+	}
+
+	// startDumpcap starts dumpcap with the given arguments, it is killed once the context is done.
+	// It returns the stdout of dumpcap, and a func waiting for it to exit.
+	startDumpcap = func(c ctx.Context, args ...string) (io.ReadCloser, func() error, error) {
+		cmd := exec.CommandContext(c, "dumpcap", args...)
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		stdout, err := cmd.StdoutPipe()
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := cmd.Start(); err != nil {
+			return nil, nil, err
+		}
+		return stdout, func() error {
+			if err := cmd.Wait(); err != nil {
+				return fmt.Errorf("%v: %s", err, strings.TrimSpace(stderr.String()))
+			}
+			return nil
+		}, nil
+	}
+)
+
+// capture is a capture to a file.
+type capture struct {
+	id     string
+	file   string
+	cancel ctx.CancelFunc
+	// done is closed once dumpcap exited.
+	done chan struct{}
+}
+
+// Server is the Capture service of the AP. It limits the number of captures running at the same time,
+// and removes the old capture files.
+type Server struct {
+	capturepb.UnimplementedCaptureServer
+
+	now func() time.Time
+
+	mu       sync.Mutex
+	captures map[string]*capture
+	streams  int
+}
+
+// NewServer creates a capture Server.
+func NewServer() *Server {
+	return &Server{
+		now:      time.Now,
+		captures: make(map[string]*capture),
+	}
+}
+
+// Run removes the old capture files periodically until the context is done, it then stops the running captures.
+func (s *Server) Run(bkgdContext ctx.Context) {
+	cleanup := time.NewTicker(cleanupInterval)
+	defer cleanup.Stop()
+	for {
+		s.cleanup()
+
+		select {
+		case <-bkgdContext.Done():
+			s.mu.Lock()
+			for _, c := range s.captures {
+				c.cancel()
+			}
+			s.mu.Unlock()
+			return
+		case <-cleanup.C:
+		}
+	}
+}
+
+// checkParams checks the given capture parameters. It returns the dumpcap arguments capturing the packets,
+// the duration and the size of the capture.
+func checkParams(params *capturepb.CaptureParams) ([]string, time.Duration, uint64, error) {
+	if params == nil {
+		return nil, 0, 0, status.Error(codes.InvalidArgument, "no capture parameters")
+	}
+	if !intfNameRegex.MatchString(params.Interface) {
+		return nil, 0, 0, status.Errorf(codes.InvalidArgument, "invalid interface %q", params.Interface)
+	}
+	if !interfaceExists(params.Interface) {
+		return nil, 0, 0, status.Errorf(codes.NotFound, "interface %q does not exist", params.Interface)
+	}
+	duration := time.Duration(params.MaxDuration)
+	if duration == 0 {
+		duration = defaultMaxDuration
+	}
+	if duration > maxDuration {
+		return nil, 0, 0, status.Errorf(codes.InvalidArgument, "captures are limited to %v", maxDuration)
+	}
+	size := params.MaxBytes
+	if size == 0 {
+		size = defaultMaxBytes
+	}
+	if size > maxBytes {
+		return nil, 0, 0, status.Errorf(codes.InvalidArgument, "captures are limited to %d bytes", maxBytes)
+	}
+
+	args := []string{"-q", "-i", params.Interface}
+	if params.Filter != "" {
+		args = append(args, "-f", params.Filter)
+	}
+	if params.SnapLength != 0 {
+		args = append(args, "-s", fmt.Sprint(params.SnapLength))
+	}
+	return args, duration, size, nil
+}
+
+// checkCapacity checks that another capture can run. It must be called with the lock held.
+func (s *Server) checkCapacity() error {
+	if len(s.captures)+s.streams >= maxCaptures {
+		return status.Errorf(codes.ResourceExhausted, "at most %d captures can run at the same time", maxCaptures)
+	}
+	return nil
+}
+
+// reserveStream counts a new stream in the running captures, if another capture can run.
+func (s *Server) reserveStream() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.checkCapacity(); err != nil {
+		return err
+	}
+	s.streams++
+	return nil
+}
+
+func (s *Server) releaseStream() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.streams--
+}
+
+// Start starts a capture to a file of the capture folder.
+func (s *Server) Start(c ctx.Context, req *capturepb.StartRequest) (*capturepb.StartResponse, error) {
+	args, duration, size, err := checkParams(req.Params)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(captureFolder, 0755); err != nil {
+		return nil, status.Errorf(codes.Internal, "unable to create the capture folder: %v", err)
+	}
+	s.cleanup()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.checkCapacity(); err != nil {
+		return nil, err
+	}
+	id := fmt.Sprintf("%s-%s", s.now().UTC().Format("20060102-150405"), req.Params.Interface)
+	for i := 2; s.captures[id] != nil || fileExists(captureFile(id)); i++ {
+		id = fmt.Sprintf("%s-%s-%d", s.now().UTC().Format("20060102-150405"), req.Params.Interface, i)
+	}
+	file := captureFile(id)
+	// dumpcap stops the capture at its limits, the duration is also enforced if it does not start capturing.
+	// The size is in kB.
+	args = append(args,
+		"-a", fmt.Sprintf("duration:%d", int((duration+time.Second-1)/time.Second)),
+		"-a", fmt.Sprintf("filesize:%d", (size+999)/1000),
+		"-w", file)
+	captureContext, cancel := ctx.WithTimeout(ctx.Background(), duration+5*time.Second)
+	stdout, wait, err := startDumpcap(captureContext, args...)
+	if err != nil {
+		cancel()
+		return nil, status.Errorf(codes.Internal, "unable to start the capture: %v", err)
+	}
+	stdout.Close()
+
+	capt := &capture{id: id, file: file, cancel: cancel, done: make(chan struct{})}
+	s.captures[id] = capt
+	go func() {
+		if err := wait(); err != nil && captureContext.Err() == nil {
+			log.Errorf("Capture %s failed: %v", id, err)
+		}
+		cancel()
+		s.mu.Lock()
+		delete(s.captures, id)
+		s.mu.Unlock()
+		close(capt.done)
+		log.Infof("Capture %s completed.", id)
+	}()
+	log.Infof("Started capture %s of interface %s.", id, req.Params.Interface)
+	return &capturepb.StartResponse{Id: id, File: file}, nil
+}
+
+// Stop stops a running capture to a file.
+func (s *Server) Stop(c ctx.Context, req *capturepb.StopRequest) (*capturepb.StopResponse, error) {
+	s.mu.Lock()
+	capt, ok := s.captures[req.Id]
+	s.mu.Unlock()
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "capture %q is not running", req.Id)
+	}
+	capt.cancel()
+	select {
+	case <-capt.done:
+	case <-c.Done():
+		return nil, status.FromContextError(c.Err()).Err()
+	}
+	return &capturepb.StopResponse{}, nil
+}
+
+// List lists the capture files, oldest first.
+func (s *Server) List(c ctx.Context, req *capturepb.ListRequest) (*capturepb.ListResponse, error) {
+	infos, err := captureFiles()
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "unable to list the capture files: %v", err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	resp := &capturepb.ListResponse{}
+	for _, info := range infos {
+		id := strings.TrimSuffix(info.Name(), captureFileExt)
+		resp.Captures = append(resp.Captures, &capturepb.CaptureInfo{
+			Id:           id,
+			File:         captureFile(id),
+			Running:      s.captures[id] != nil,
+			Size:         uint64(info.Size()),
+			LastModified: uint64(info.ModTime().UnixNano()),
+		})
+	}
+	return resp, nil
+}
+
+// Stream captures packets and streams them, until the limits of the capture are reached or the call ends.
+func (s *Server) Stream(req *capturepb.StreamRequest, stream capturepb.Capture_StreamServer) error {
+	args, duration, size, err := checkParams(req.Params)
+	if err != nil {
+		return err
+	}
+	if err := s.reserveStream(); err != nil {
+		return err
+	}
+	defer s.releaseStream()
+
+	// dumpcap writes pcap rather than pcapng to stdout, which is simpler to split in packets.
+	args = append(args, "-P", "-w", "-")
+	captureContext, cancel := ctx.WithTimeout(stream.Context(), duration)
+	defer cancel()
+	stdout, wait, err := startDumpcap(captureContext, args...)
+	if err != nil {
+		return status.Errorf(codes.Internal, "unable to start the capture: %v", err)
+	}
+
+	err = streamPackets(stdout, size, stream.Send)
+	// dumpcap is killed once the packets are read, if it is still running.
+	stopped := captureContext.Err() != nil
+	cancel()
+	stdout.Close()
+	waitErr := wait()
+	switch {
+	case err == io.EOF && !stopped && waitErr != nil:
+		return status.Errorf(codes.Internal, "capture failed: %v", waitErr)
+	case err == io.EOF || stopped:
+		// dumpcap exited, or the duration elapsed or the call ended.
+		return nil
+	}
+	return err
+}
+
+// streamPackets reads the pcap packets of the given reader, and sends them until size bytes are sent.
+func streamPackets(r io.Reader, size uint64, send func(*capturepb.Packet) error) error {
+	header := make([]byte, 24)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return err
+	}
+	var order binary.ByteOrder
+	var nano bool
+	switch {
+	case binary.LittleEndian.Uint32(header) == 0xa1b2c3d4:
+		order = binary.LittleEndian
+	case binary.BigEndian.Uint32(header) == 0xa1b2c3d4:
+		order = binary.BigEndian
+	case binary.LittleEndian.Uint32(header) == 0xa1b23c4d:
+		order, nano = binary.LittleEndian, true
+	case binary.BigEndian.Uint32(header) == 0xa1b23c4d:
+		order, nano = binary.BigEndian, true
+	default:
+		return status.Error(codes.Internal, "invalid pcap header")
+	}
+	linkType := order.Uint32(header[20:])
+
+	var sent uint64
+	for sent < size {
+		record := make([]byte, 16)
+		if _, err := io.ReadFull(r, record); err != nil {
+			return err
+		}
+		sec, frac := order.Uint32(record), order.Uint32(record[4:])
+		timestamp := uint64(sec)*uint64(time.Second) + uint64(frac)*uint64(time.Microsecond)
+		if nano {
+			timestamp = uint64(sec)*uint64(time.Second) + uint64(frac)
+		}
+		data := make([]byte, order.Uint32(record[8:]))
+		if _, err := io.ReadFull(r, data); err != nil {
+			if err == io.ErrUnexpectedEOF {
+				return io.EOF
+			}
+			return err
+		}
+		if err := send(&capturepb.Packet{
+			Timestamp: timestamp,
+			Length:    order.Uint32(record[12:]),
+			Data:      data,
+			LinkType:  linkType,
+		}); err != nil {
+			return err
+		}
+		sent += uint64(len(data))
+	}
+	return nil
+}
+
+// cleanup removes the capture files older than captureFileTTL, and the oldest ones over maxCaptureFiles.
+// The files being captured are kept.
+func (s *Server) cleanup() {
+	infos, err := captureFiles()
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Errorf("Failed to list the capture files: %v", err)
+		}
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	kept := len(infos)
+	for _, info := range infos {
+		id := strings.TrimSuffix(info.Name(), captureFileExt)
+		if s.captures[id] != nil {
+			continue
+		}
+		if kept < maxCaptureFiles && s.now().Sub(info.ModTime()) < captureFileTTL {
+			continue
+		}
+		if err := os.Remove(captureFile(id)); err != nil {
+			log.Errorf("Failed to remove capture file %s: %v", info.Name(), err)
+			continue
+		}
+		log.Infof("Removed capture file %s.", info.Name())
+		kept--
+	}
+}
+
+// captureFiles returns the capture files, oldest first.
+func captureFiles() ([]os.FileInfo, error) {
+	infos, err := ioutil.ReadDir(captureFolder)
+	if err != nil {
+		return nil, err
+	}
+	var files []os.FileInfo
+	for _, info := range infos {
+		if info.Mode().IsRegular() && strings.HasSuffix(info.Name(), captureFileExt) {
+			files = append(files, info)
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].ModTime().Before(files[j].ModTime()) })
+	return files, nil
+}
+
+func captureFile(id string) string {
+	return path.Join(captureFolder, id+captureFileExt)
+}
+
+func fileExists(p string) bool {
+	_, err := os.Stat(p)
+	return !os.IsNotExist(err)
+}
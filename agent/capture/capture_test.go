@@ -0,0 +1,239 @@
+/* Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package capture
+
+import (
+	"bytes"
+	ctx "context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	capturepb "github.com/google/link022/proto/capture"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// packetStream collects the packets of a Stream RPC.
+type packetStream struct {
+	grpc.ServerStream
+	packets []*capturepb.Packet
+}
+
+func (s *packetStream) Context() ctx.Context {
+	return ctx.Background()
+}
+
+func (s *packetStream) Send(packet *capturepb.Packet) error {
+	s.packets = append(s.packets, packet)
+	return nil
+}
+
+// testPcap returns a little-endian pcap stream of Ethernet packets with the given data.
+func testPcap(packets ...[]byte) []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, []uint32{0xa1b2c3d4, 0x00040002, 0, 0, 65535, 1})
+	for i, data := range packets {
+		binary.Write(&buf, binary.LittleEndian, []uint32{uint32(1538755500 + i), 250000, uint32(len(data)), uint32(len(data) + 10)})
+		buf.Write(data)
+	}
+	return buf.Bytes()
+}
+
+// testServer creates a Server writing its captures to a temp folder. It returns the Server, the arguments of the
+// dumpcap commands it starts, and a func restoring the capture folder.
+func testServer(t *testing.T) (*Server, chan []string, func()) {
+	tempFolder, err := ioutil.TempDir("", "link022")
+	if err != nil {
+		t.Fatalf("Unable to create a temp folder.")
+	}
+	originalFolder, originalStart, originalExists := captureFolder, startDumpcap, interfaceExists
+	captureFolder = path.Join(tempFolder, "captures")
+	interfaceExists = func(string) bool { return true }
+
+	dumpcaps := make(chan []string, 10)
+	startDumpcap = func(c ctx.Context, args ...string) (io.ReadCloser, func() error, error) {
+		dumpcaps <- args
+		// File captures write their file and run until they are stopped.
+		if args[len(args)-1] != "-" {
+			if err := ioutil.WriteFile(args[len(args)-1], []byte("pcapng"), 0644); err != nil {
+				return nil, nil, err
+			}
+			return ioutil.NopCloser(&bytes.Buffer{}), func() error {
+				<-c.Done()
+				return c.Err()
+			}, nil
+		}
+		return ioutil.NopCloser(bytes.NewReader(testPcap([]byte("first packet"), []byte("second packet")))), func() error { return nil }, nil
+	}
+
+	s := NewServer()
+	s.now = func() time.Time { return time.Date(2018, 10, 5, 16, 5, 0, 0, time.UTC) }
+	return s, dumpcaps, func() {
+		captureFolder, startDumpcap, interfaceExists = originalFolder, originalStart, originalExists
+		os.RemoveAll(tempFolder)
+	}
+}
+
+func TestCheckParams(t *testing.T) {
+	originalExists := interfaceExists
+	defer func() { interfaceExists = originalExists }()
+	interfaceExists = func(name string) bool { return name != "wlan0.mon" }
+
+	args, duration, size, err := checkParams(&capturepb.CaptureParams{Interface: "br_100", Filter: "ether proto 0x888e", SnapLength: 256})
+	if err != nil {
+		t.Fatalf("Checking the parameters failed. Error: %v.", err)
+	}
+	wantArgs := []string{"-q", "-i", "br_100", "-f", "ether proto 0x888e", "-s", "256"}
+	if !reflect.DeepEqual(args, wantArgs) || duration != defaultMaxDuration || size != defaultMaxBytes {
+		t.Errorf("Incorrect capture %v, %v, %d (want: %v, %v, %d).", args, duration, size, wantArgs, defaultMaxDuration, defaultMaxBytes)
+	}
+
+	for _, params := range []*capturepb.CaptureParams{
+		nil,
+		{Interface: "-w"},
+		{Interface: "br_100 -w"},
+		{Interface: "wlan0", MaxDuration: uint64(time.Hour)},
+		{Interface: "wlan0", MaxBytes: 1 << 30},
+	} {
+		if _, _, _, err := checkParams(params); status.Code(err) != codes.InvalidArgument {
+			t.Errorf("Expected %+v to be invalid, got %v.", params, err)
+		}
+	}
+	if _, _, _, err := checkParams(&capturepb.CaptureParams{Interface: "wlan0.mon"}); status.Code(err) != codes.NotFound {
+		t.Errorf("Expected a missing interface, got %v.", err)
+	}
+}
+
+func TestStartStopList(t *testing.T) {
+	s, dumpcaps, restore := testServer(t)
+	defer restore()
+	c := ctx.Background()
+
+	req := &capturepb.StartRequest{Params: &capturepb.CaptureParams{Interface: "br_100", MaxDuration: uint64(30 * time.Second), MaxBytes: 1500000}}
+	resp, err := s.Start(c, req)
+	if err != nil {
+		t.Fatalf("Starting the capture failed. Error: %v.", err)
+	}
+	want := &capturepb.StartResponse{Id: "20181005-160500-br_100", File: path.Join(captureFolder, "20181005-160500-br_100.pcapng")}
+	if !reflect.DeepEqual(resp, want) {
+		t.Errorf("Incorrect capture %+v, want %+v.", resp, want)
+	}
+	wantArgs := "-q -i br_100 -a duration:30 -a filesize:1500 -w " + want.File
+	if args := strings.Join(<-dumpcaps, " "); args != wantArgs {
+		t.Errorf("Incorrect dumpcap arguments (got: %q, want: %q).", args, wantArgs)
+	}
+
+	// A second capture in the same second gets another ID, a third one can not run.
+	if resp, err := s.Start(c, req); err != nil || resp.Id != "20181005-160500-br_100-2" {
+		t.Errorf("Incorrect second capture %+v (error: %v).", resp, err)
+	}
+	if _, err := s.Start(c, req); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("Expected too many captures, got %v.", err)
+	}
+	if err := s.Stream(&capturepb.StreamRequest{Params: req.Params}, &packetStream{}); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("Expected too many captures, got %v.", err)
+	}
+
+	if _, err := s.Stop(c, &capturepb.StopRequest{Id: resp.Id}); err != nil {
+		t.Fatalf("Stopping the capture failed. Error: %v.", err)
+	}
+	if _, err := s.Stop(c, &capturepb.StopRequest{Id: resp.Id}); status.Code(err) != codes.NotFound {
+		t.Errorf("Expected a stopped capture, got %v.", err)
+	}
+	list, err := s.List(c, &capturepb.ListRequest{})
+	if err != nil || len(list.Captures) != 2 {
+		t.Fatalf("Incorrect captures %+v (error: %v).", list, err)
+	}
+	running := map[string]bool{}
+	for _, capt := range list.Captures {
+		running[capt.Id] = capt.Running
+	}
+	if want := map[string]bool{resp.Id: false, "20181005-160500-br_100-2": true}; !reflect.DeepEqual(running, want) {
+		t.Errorf("Incorrect running captures %v, want %v.", running, want)
+	}
+}
+
+func TestStream(t *testing.T) {
+	s, dumpcaps, restore := testServer(t)
+	defer restore()
+
+	// The capture stops once the maximum size is reached.
+	stream := &packetStream{}
+	if err := s.Stream(&capturepb.StreamRequest{Params: &capturepb.CaptureParams{Interface: "wlan0", MaxBytes: 5}}, stream); err != nil {
+		t.Fatalf("Streaming the capture failed. Error: %v.", err)
+	}
+	if args, want := strings.Join(<-dumpcaps, " "), "-q -i wlan0 -P -w -"; args != want {
+		t.Errorf("Incorrect dumpcap arguments (got: %q, want: %q).", args, want)
+	}
+	want := []*capturepb.Packet{{Timestamp: 1538755500250000000, Length: 22, Data: []byte("first packet"), LinkType: 1}}
+	if !reflect.DeepEqual(stream.packets, want) {
+		t.Errorf("Incorrect packets %+v, want %+v.", stream.packets, want)
+	}
+
+	// All the packets are streamed until dumpcap exits.
+	stream = &packetStream{}
+	if err := s.Stream(&capturepb.StreamRequest{Params: &capturepb.CaptureParams{Interface: "wlan0"}}, stream); err != nil || len(stream.packets) != 2 {
+		t.Errorf("Incorrect packets %+v (error: %v).", stream.packets, err)
+	}
+	if s.streams != 0 {
+		t.Errorf("Expected no running stream, got %d.", s.streams)
+	}
+}
+
+func TestCleanup(t *testing.T) {
+	s, _, restore := testServer(t)
+	defer restore()
+	if err := os.MkdirAll(captureFolder, 0755); err != nil {
+		t.Fatal(err)
+	}
+	now := s.now()
+	for i := 0; i < maxCaptureFiles+3; i++ {
+		file := path.Join(captureFolder, fmt.Sprintf("capture-%02d%s", i, captureFileExt))
+		if err := ioutil.WriteFile(file, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		modTime := now.Add(-time.Duration(maxCaptureFiles+3-i) * time.Minute)
+		if i == 5 {
+			modTime = now.Add(-captureFileTTL - time.Minute)
+		}
+		os.Chtimes(file, modTime, modTime)
+	}
+	// Running captures are kept.
+	s.captures["capture-00"] = &capture{}
+
+	s.cleanup()
+	infos, err := captureFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, info := range infos {
+		got = append(got, strings.TrimSuffix(info.Name(), captureFileExt))
+	}
+	want := []string{"capture-00", "capture-04", "capture-06", "capture-07", "capture-08", "capture-09", "capture-10", "capture-11", "capture-12"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Incorrect capture files after the cleanup (got: %v, want: %v).", got, want)
+	}
+}
@@ -0,0 +1,257 @@
+/* Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package certstore keeps the certificates of the agent in a local folder, by certificate ID.
+//
+// Each certificate is stored in PEM format as <id>.crt, with its private key in <id>.key.
+// ca.crt contains the CA certificates trusted to authenticate the clients of the agent. Without ca.crt, the clients are
+// authenticated with the CA certificates of the agent flags.
+package certstore
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	certExt        = ".crt"
+	keyExt         = ".key"
+	caBundleFileID = "ca"
+)
+
+// Store keeps certificates in a local folder.
+type Store struct {
+	dir string
+	// clientCAFile is the PEM file of the CA certificates authenticating the clients if the store has none, may be empty.
+	clientCAFile string
+}
+
+// New creates a Store keeping its certificates in the given folder. The clients are authenticated with the CA certificates
+// of clientCAFile until CA certificates are saved in the store.
+func New(dir, clientCAFile string) *Store {
+	return &Store{dir: dir, clientCAFile: clientCAFile}
+}
+
+// ValidateID checks that a certificate ID can be used as a file name in the store.
+func ValidateID(id string) error {
+	if id == "" || id == "." || id == ".." || id == caBundleFileID || strings.ContainsAny(id, "/\\") {
+		return fmt.Errorf("invalid certificate ID %q", id)
+	}
+	return nil
+}
+
+// Certificate loads the certificate with the given ID and its private key.
+func (s *Store) Certificate(id string) (*tls.Certificate, error) {
+	if err := ValidateID(id); err != nil {
+		return nil, err
+	}
+	cert, err := tls.LoadX509KeyPair(path.Join(s.dir, id+certExt), path.Join(s.dir, id+keyExt))
+	if err != nil {
+		return nil, fmt.Errorf("unable to load certificate %s: %v", id, err)
+	}
+	return &cert, nil
+}
+
+// IDs returns the IDs of the certificates in the store, in ascending order.
+func (s *Store) IDs() ([]string, error) {
+	files, err := ioutil.ReadDir(s.dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, file := range files {
+		id := strings.TrimSuffix(file.Name(), certExt)
+		if file.Mode().IsRegular() && id != file.Name() && ValidateID(id) == nil {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// Exists checks whether the store has a certificate with the given ID.
+func (s *Store) Exists(id string) bool {
+	if ValidateID(id) != nil {
+		return false
+	}
+	_, err := os.Stat(path.Join(s.dir, id+certExt))
+	return err == nil
+}
+
+// Load returns the PEM certificate and private key with the given ID, and when the certificate was saved.
+func (s *Store) Load(id string) ([]byte, []byte, time.Time, error) {
+	if err := ValidateID(id); err != nil {
+		return nil, nil, time.Time{}, err
+	}
+	certPath := path.Join(s.dir, id+certExt)
+	fileInfo, err := os.Stat(certPath)
+	if err != nil {
+		return nil, nil, time.Time{}, err
+	}
+	certPEM, err := ioutil.ReadFile(certPath)
+	if err != nil {
+		return nil, nil, time.Time{}, err
+	}
+	keyPEM, err := ioutil.ReadFile(path.Join(s.dir, id+keyExt))
+	if err != nil {
+		return nil, nil, time.Time{}, err
+	}
+	return certPEM, keyPEM, fileInfo.ModTime(), nil
+}
+
+// Save saves the PEM certificate and private key with the given ID, replacing the existing ones.
+// The certificate has to match the key. Servers use it from their next connection.
+func (s *Store) Save(id string, certPEM, keyPEM []byte) error {
+	if err := ValidateID(id); err != nil {
+		return err
+	}
+	if _, err := tls.X509KeyPair(certPEM, keyPEM); err != nil {
+		return fmt.Errorf("invalid certificate %s: %v", id, err)
+	}
+	// The key goes first, the certificate is only listed once complete.
+	if err := s.writeFile(id+keyExt, keyPEM, 0600); err != nil {
+		return err
+	}
+	return s.writeFile(id+certExt, certPEM, 0644)
+}
+
+// Remove deletes the certificate with the given ID and its private key.
+func (s *Store) Remove(id string) error {
+	if err := ValidateID(id); err != nil {
+		return err
+	}
+	if err := os.Remove(path.Join(s.dir, id+certExt)); err != nil {
+		return err
+	}
+	if err := os.Remove(path.Join(s.dir, id+keyExt)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// CABundle returns the PEM CA certificates trusted to authenticate clients, nil if the store has none.
+func (s *Store) CABundle() ([]byte, error) {
+	caBundle, err := ioutil.ReadFile(path.Join(s.dir, caBundleFileID+certExt))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return caBundle, err
+}
+
+// SaveCABundle replaces the CA certificates trusted to authenticate clients. An empty bundle removes them,
+// clients are then authenticated with the CA certificates of clientCAFile.
+func (s *Store) SaveCABundle(caBundle []byte) error {
+	if len(caBundle) == 0 {
+		if err := os.Remove(path.Join(s.dir, caBundleFileID+certExt)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	if !x509.NewCertPool().AppendCertsFromPEM(caBundle) {
+		return errors.New("no valid CA certificate in the bundle")
+	}
+	return s.writeFile(caBundleFileID+certExt, caBundle, 0644)
+}
+
+// writeFile replaces a file of the store atomically, so that servers never load a partial file.
+func (s *Store) writeFile(fileName string, content []byte, perm os.FileMode) error {
+	if err := os.MkdirAll(s.dir, 0700); err != nil {
+		return err
+	}
+	tempFile, err := ioutil.TempFile(s.dir, "."+fileName)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tempFile.Name())
+	if _, err := tempFile.Write(content); err != nil {
+		tempFile.Close()
+		return err
+	}
+	if err := tempFile.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tempFile.Name(), perm); err != nil {
+		return err
+	}
+	return os.Rename(tempFile.Name(), path.Join(s.dir, fileName))
+}
+
+// ClientCAs loads the CA certificates trusted to authenticate clients, from the store or else from clientCAFile.
+// It fails if there is none, as the clients could not be authenticated.
+func (s *Store) ClientCAs() (*x509.CertPool, error) {
+	caBundle, err := s.CABundle()
+	if err != nil {
+		return nil, err
+	}
+	source := "the store"
+	if caBundle == nil {
+		if s.clientCAFile == "" {
+			return nil, errors.New("no CA certificate to authenticate the clients")
+		}
+		if caBundle, err = ioutil.ReadFile(s.clientCAFile); err != nil {
+			return nil, fmt.Errorf("unable to load the CA certificates to authenticate the clients: %v", err)
+		}
+		source = s.clientCAFile
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caBundle) {
+		return nil, fmt.Errorf("no valid CA certificate in %s", source)
+	}
+	return pool, nil
+}
+
+// serverConfig creates the TLS configuration of a server using the certificate with the given ID.
+// Clients always have to present a certificate signed by a trusted CA.
+func (s *Store) serverConfig(id string) (*tls.Config, error) {
+	cert, err := s.Certificate(id)
+	if err != nil {
+		return nil, err
+	}
+	clientCAs, err := s.ClientCAs()
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{*cert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientCAs,
+		// gRPC clients negotiate HTTP/2.
+		NextProtos: []string{"h2"},
+	}, nil
+}
+
+// ServerTLSConfig returns the TLS configuration of a server using the certificate with the given ID.
+// The certificates are loaded from the store on each connection, so that replaced certificates are used right away.
+func (s *Store) ServerTLSConfig(id string) (*tls.Config, error) {
+	if _, err := s.serverConfig(id); err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return s.serverConfig(id)
+		},
+	}, nil
+}
@@ -0,0 +1,173 @@
+/* Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package certstore
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// writeCert writes a self-signed certificate for localhost and its key to the given folder.
+func writeCert(t *testing.T, dir, id string) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Unable to generate a key. Error: %v.", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "localhost"},
+		DNSNames:              []string{"localhost"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Unable to create a certificate. Error: %v.", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("Unable to marshal the key. Error: %v.", err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	if err := ioutil.WriteFile(path.Join(dir, id+certExt), certPEM, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path.Join(dir, id+keyExt), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certPEM
+}
+
+func TestValidateID(t *testing.T) {
+	for _, id := range []string{"gnmi", "gnmi-2018.10"} {
+		if err := ValidateID(id); err != nil {
+			t.Errorf("Expected %q to be valid. Error: %v.", id, err)
+		}
+	}
+	for _, id := range []string{"", ".", "..", "ca", "../gnmi", "a\\b"} {
+		if err := ValidateID(id); err == nil {
+			t.Errorf("Expected %q to be invalid.", id)
+		}
+	}
+}
+
+func TestServerTLSConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certstore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	flagCA := path.Join(dir, "flags", "ca.crt")
+	s := New(path.Join(dir, "certs"), flagCA)
+	if err := os.MkdirAll(path.Join(dir, "certs"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(path.Dir(flagCA), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.ServerTLSConfig("gnmi"); err == nil {
+		t.Error("Expected an error for a missing certificate.")
+	}
+	writeCert(t, s.dir, "gnmi")
+	// The clients can not be authenticated without CA certificates.
+	if _, err := s.ServerTLSConfig("gnmi"); err == nil {
+		t.Error("Expected an error without CA certificates.")
+	}
+
+	// The CA certificates of the flags are used until the store has its own.
+	flagCAPEM := writeCert(t, path.Dir(flagCA), "ca")
+	config, err := s.ServerTLSConfig("gnmi")
+	if err != nil {
+		t.Fatalf("Loading the TLS configuration failed. Error: %v.", err)
+	}
+	serverConfig, err := config.GetConfigForClient(&tls.ClientHelloInfo{})
+	if err != nil || len(serverConfig.Certificates) != 1 || serverConfig.ClientAuth != tls.RequireAndVerifyClientCert || serverConfig.ClientCAs == nil {
+		t.Errorf("Incorrect server configuration %+v (error: %v).", serverConfig, err)
+	}
+	if subjects := serverConfig.ClientCAs.Subjects(); len(subjects) != 1 {
+		t.Errorf("Expected the CA of the flags, got %d CAs.", len(subjects))
+	}
+
+	caPEM := writeCert(t, s.dir, "client")
+	if err := s.SaveCABundle(append(caPEM, flagCAPEM...)); err != nil {
+		t.Fatal(err)
+	}
+	serverConfig, err = config.GetConfigForClient(&tls.ClientHelloInfo{})
+	if err != nil || serverConfig.ClientAuth != tls.RequireAndVerifyClientCert || len(serverConfig.ClientCAs.Subjects()) != 2 {
+		t.Errorf("Incorrect server configuration with CA certificates %+v (error: %v).", serverConfig, err)
+	}
+}
+
+func TestSaveAndRemove(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certstore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s := New(path.Join(dir, "certs"), "")
+
+	// The certificates are generated in another folder, and saved in the store.
+	certPEM := writeCert(t, dir, "gnmi")
+	keyPEM, err := ioutil.ReadFile(path.Join(dir, "gnmi"+keyExt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Save("gnmi", certPEM, []byte("invalid")); err == nil {
+		t.Error("Expected an error for a key not matching the certificate.")
+	}
+	if err := s.Save("gnmi", certPEM, keyPEM); err != nil {
+		t.Fatalf("Saving the certificate failed. Error: %v.", err)
+	}
+	if err := s.SaveCABundle(certPEM); err != nil {
+		t.Fatalf("Saving the CA bundle failed. Error: %v.", err)
+	}
+	if ids, err := s.IDs(); err != nil || !reflect.DeepEqual(ids, []string{"gnmi"}) {
+		t.Errorf("Incorrect certificate IDs %v (error: %v).", ids, err)
+	}
+	if gotCert, gotKey, _, err := s.Load("gnmi"); err != nil || string(gotCert) != string(certPEM) || string(gotKey) != string(keyPEM) {
+		t.Errorf("Incorrect certificate loaded (error: %v).", err)
+	}
+
+	if err := s.Remove("gnmi"); err != nil {
+		t.Fatalf("Removing the certificate failed. Error: %v.", err)
+	}
+	if err := s.SaveCABundle(nil); err != nil {
+		t.Fatalf("Removing the CA bundle failed. Error: %v.", err)
+	}
+	if s.Exists("gnmi") {
+		t.Error("Expected the certificate to be removed.")
+	}
+	if caBundle, err := s.CABundle(); err != nil || caBundle != nil {
+		t.Errorf("Expected no CA bundle, got %s (error: %v).", caBundle, err)
+	}
+}
@@ -18,15 +18,20 @@ package context
 
 import (
 	"sync"
+	"time"
 )
 
 // DeviceConfig contains the configuration specific to this device.
 type DeviceConfig struct {
-	ETHINTFName    string
-	WLANINTFName   string
+	ETHINTFName  string
+	WLANINTFName string
+	// WLANINTFNames contains the WLAN interfaces running the AP radios, in ascending radio ID order.
+	// The first one is WLANINTFName.
+	WLANINTFNames  []string
 	Hostname       string
 	ControllerAddr string
 	GNMIServerAddr string
+	NeighborTTL    time.Duration
 }
 
 var (
@@ -18,8 +18,10 @@ package controller
 
 import (
 	"errors"
+	"fmt"
 	"time"
 
+	"github.com/google/link022/agent/alarm"
 	devctx "github.com/google/link022/agent/context"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
@@ -81,10 +83,13 @@ func Connect() {
 	for {
 		if err := ReportAPInfo(syncRequired); err != nil {
 			log.Errorf("Cannot connect to the controller, retry in %s. Error: %v.", heartbeatInterval, err)
+			alarm.Default().Raise(alarm.ControllerDisconnected, devctx.GetDeviceConfig().ControllerAddr,
+				fmt.Sprintf("Cannot connect to the controller: %v", err))
 			// Disconncetion detected, do a re-sync.
 			syncRequired = true
 		} else if syncRequired {
 			log.Info("Controller connected and received the sync request.")
+			alarm.Default().Clear(alarm.ControllerDisconnected, devctx.GetDeviceConfig().ControllerAddr)
 			syncRequired = false
 		}
 		time.Sleep(heartbeatInterval)
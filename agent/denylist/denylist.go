@@ -0,0 +1,148 @@
+/* Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package denylist shares the hostapd deny ACL of each BSS among agent features.
+//
+// Several features (e.g. band steering and 802.1X blacklist) deny stations temporarily.
+// A station stays denied on a BSS until every feature that denied it allows it again.
+package denylist
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/google/link022/agent/syscmd"
+)
+
+// DenyList tracks which features deny each station on each BSS interface.
+type DenyList struct {
+	mu        sync.Mutex
+	cmdRunner *syscmd.CommandRunner
+	owners    map[string]map[string]map[string]bool // BSS interface name -> station MAC -> owner -> true
+}
+
+// New creates a DenyList updating hostapd with the given runner.
+func New(cmdRunner *syscmd.CommandRunner) *DenyList {
+	return &DenyList{
+		cmdRunner: cmdRunner,
+		owners:    make(map[string]map[string]map[string]bool),
+	}
+}
+
+var defaultDenyList = New(syscmd.Runner())
+
+// Default returns the deny list shared by all features of the agent.
+func Default() *DenyList {
+	return defaultDenyList
+}
+
+// Deny denies a station on a BSS interface on behalf of the given owner.
+// hostapd is only updated when the station is not denied by any other owner yet.
+func (d *DenyList) Deny(owner, intfName, mac string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if len(d.owners[intfName][mac]) == 0 {
+		if err := d.cmdRunner.DenyStation(intfName, mac); err != nil {
+			return err
+		}
+	}
+	if d.owners[intfName] == nil {
+		d.owners[intfName] = make(map[string]map[string]bool)
+	}
+	if d.owners[intfName][mac] == nil {
+		d.owners[intfName][mac] = make(map[string]bool)
+	}
+	d.owners[intfName][mac][owner] = true
+	return nil
+}
+
+// Allow withdraws the denial of a station on a BSS interface made by the given owner.
+// hostapd is only updated when no other owner still denies the station.
+func (d *DenyList) Allow(owner, intfName, mac string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	macOwners := d.owners[intfName][mac]
+	if !macOwners[owner] {
+		return nil
+	}
+	if len(macOwners) == 1 {
+		if err := d.cmdRunner.AllowStation(intfName, mac); err != nil {
+			return err
+		}
+	}
+
+	delete(macOwners, owner)
+	if len(macOwners) == 0 {
+		delete(d.owners[intfName], mac)
+	}
+	if len(d.owners[intfName]) == 0 {
+		delete(d.owners, intfName)
+	}
+	return nil
+}
+
+// Reset replaces the denials of the given owner after hostapd restarted on the given BSS interfaces
+// (BSS interface name -> station MACs). hostapd only denies the stations in the deny lists of its
+// configuration, so Reset returns the stations denied by any owner on those interfaces, in ascending
+// order, to be saved in those lists. The denials of the other owners are kept.
+func (d *DenyList) Reset(owner string, denied map[string][]string) map[string][]string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	allDenied := make(map[string][]string)
+	for intfName, macs := range denied {
+		for mac, macOwners := range d.owners[intfName] {
+			delete(macOwners, owner)
+			if len(macOwners) == 0 {
+				delete(d.owners[intfName], mac)
+			}
+		}
+		for _, mac := range macs {
+			if d.owners[intfName] == nil {
+				d.owners[intfName] = make(map[string]map[string]bool)
+			}
+			if d.owners[intfName][mac] == nil {
+				d.owners[intfName][mac] = make(map[string]bool)
+			}
+			d.owners[intfName][mac][owner] = true
+		}
+		if len(d.owners[intfName]) == 0 {
+			delete(d.owners, intfName)
+			continue
+		}
+		for mac := range d.owners[intfName] {
+			allDenied[intfName] = append(allDenied[intfName], mac)
+		}
+		sort.Strings(allDenied[intfName])
+	}
+	return allDenied
+}
+
+// Denied returns the stations denied on a BSS interface by the given owner, in ascending order.
+func (d *DenyList) Denied(owner, intfName string) []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var macs []string
+	for mac, macOwners := range d.owners[intfName] {
+		if macOwners[owner] {
+			macs = append(macs, mac)
+		}
+	}
+	sort.Strings(macs)
+	return macs
+}
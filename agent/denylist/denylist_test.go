@@ -0,0 +1,119 @@
+/* Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package denylist
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/link022/agent/syscmd"
+)
+
+const (
+	testIntf       = "wlan0"
+	testStationMAC = "12:34:56:78:9a:bc"
+)
+
+func TestSharedDenial(t *testing.T) {
+	var cmds []string
+	d := New(&syscmd.CommandRunner{
+		ExecCommand: func(wait bool, cmd string, args ...string) (string, error) {
+			// Skip the control interface arguments: -p <dir> -i <intf>.
+			cmds = append(cmds, strings.Join(args[4:], " "))
+			return "OK", nil
+		},
+	})
+
+	steps := []struct {
+		deny  bool
+		owner string
+	}{
+		{deny: true, owner: "steering"},
+		{deny: true, owner: "dot1x"},
+		{deny: true, owner: "dot1x"},
+		{deny: false, owner: "steering"},
+		{deny: false, owner: "unknown"},
+		{deny: false, owner: "dot1x"},
+	}
+	for _, step := range steps {
+		var err error
+		if step.deny {
+			err = d.Deny(step.owner, testIntf, testStationMAC)
+		} else {
+			err = d.Allow(step.owner, testIntf, testStationMAC)
+		}
+		if err != nil {
+			t.Errorf("Updating deny list failed. Error: %v.", err)
+		}
+		if step.owner == "steering" && !step.deny {
+			if got := d.Denied("dot1x", testIntf); !reflect.DeepEqual(got, []string{testStationMAC}) {
+				t.Errorf("Station not denied by the remaining owner (got: %v).", got)
+			}
+		}
+	}
+
+	want := []string{
+		"deny_acl ADD_MAC " + testStationMAC,
+		"deny_acl DEL_MAC " + testStationMAC,
+	}
+	if !reflect.DeepEqual(cmds, want) {
+		t.Errorf("Incorrect hostapd commands (got: %v, want: %v).", cmds, want)
+	}
+	if len(d.owners) != 0 {
+		t.Errorf("Deny list not cleaned up: %v.", d.owners)
+	}
+}
+
+func TestReset(t *testing.T) {
+	var cmds []string
+	d := New(&syscmd.CommandRunner{
+		ExecCommand: func(wait bool, cmd string, args ...string) (string, error) {
+			cmds = append(cmds, strings.Join(args[4:], " "))
+			return "OK", nil
+		},
+	})
+	if err := d.Deny("steering", testIntf, testStationMAC); err != nil {
+		t.Errorf("Updating deny list failed. Error: %v.", err)
+	}
+
+	// The MAC ACL deny list replaces the previous one, the steering denial is kept.
+	if err := d.Deny("acl", testIntf, "02:00:00:00:00:02"); err != nil {
+		t.Errorf("Updating deny list failed. Error: %v.", err)
+	}
+	cmds = nil
+	allDenied := d.Reset("acl", map[string][]string{testIntf: {"02:00:00:00:00:01"}, "wlan0_1": nil})
+	if want := map[string][]string{testIntf: {"02:00:00:00:00:01", testStationMAC}}; !reflect.DeepEqual(allDenied, want) {
+		t.Errorf("Incorrect denied stations after reset (got: %v, want: %v).", allDenied, want)
+	}
+	if len(cmds) != 0 {
+		t.Errorf("Unexpected hostapd commands on reset: %v.", cmds)
+	}
+	if got := d.Denied("steering", testIntf); !reflect.DeepEqual(got, []string{testStationMAC}) {
+		t.Errorf("Steering denial forgotten after reset (got: %v).", got)
+	}
+	if got := d.Denied("acl", testIntf); !reflect.DeepEqual(got, []string{"02:00:00:00:00:01"}) {
+		t.Errorf("Incorrect denials after reset (got: %v).", got)
+	}
+
+	// The station is still denied in hostapd, allowing it updates hostapd.
+	if err := d.Allow("steering", testIntf, testStationMAC); err != nil {
+		t.Errorf("Updating deny list failed. Error: %v.", err)
+	}
+	if want := []string{"deny_acl DEL_MAC " + testStationMAC}; !reflect.DeepEqual(cmds, want) {
+		t.Errorf("Incorrect hostapd commands (got: %v, want: %v).", cmds, want)
+	}
+}
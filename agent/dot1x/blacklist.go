@@ -0,0 +1,296 @@
+/* Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package dot1x blacklists clients that keep failing 802.1X authentication.
+//
+// hostapd has no limit on authentication failures. The agent counts the EAP failures
+// of each client, and denies it on all BSSs of the SSID for the configured blacklist time
+// once it reaches the max auth failures of the SSID. The blacklisted clients are published
+// in the 802.1X timers state of the SSID, with their expiration time.
+package dot1x
+
+import (
+	ctx "context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/golang/glog"
+	"github.com/google/link022/agent/context"
+	"github.com/google/link022/agent/denylist"
+	"github.com/google/link022/agent/gnmi"
+	"github.com/google/link022/agent/hostapd"
+	"github.com/google/link022/agent/monitoring"
+	"github.com/google/link022/agent/util/ocutil"
+	"github.com/google/link022/generated/ocstruct"
+	"github.com/openconfig/ygot/ygot"
+)
+
+const (
+	// refreshInterval is how often the blacklist configuration and BSS list are refreshed.
+	refreshInterval = 15 * time.Second
+	// denyListOwner identifies the deny list entries made by 802.1X blacklist.
+	denyListOwner = "dot1x-blacklist"
+)
+
+// Tracker counts the authentication failures of clients, and blacklists them.
+type Tracker struct {
+	mu       sync.Mutex
+	denyList *denylist.DenyList
+	now      func() time.Time
+
+	settings    map[string]ocutil.Dot1XBlacklist // SSID -> blacklist settings
+	bsss        map[string]*monitoring.BSS       // BSS interface name -> BSS
+	failures    map[string]map[string]uint8      // SSID -> client MAC -> consecutive failures
+	blacklisted map[string]map[string]time.Time  // SSID -> client MAC -> blacklist expiration time
+}
+
+// NewTracker creates a Tracker denying clients through the given deny list.
+func NewTracker(denyList *denylist.DenyList) *Tracker {
+	return &Tracker{
+		denyList:    denyList,
+		now:         time.Now,
+		settings:    make(map[string]ocutil.Dot1XBlacklist),
+		bsss:        make(map[string]*monitoring.BSS),
+		failures:    make(map[string]map[string]uint8),
+		blacklisted: make(map[string]map[string]time.Time),
+	}
+}
+
+// Run tracks authentication failures based on hostapd events until the context is done.
+// The blacklist configuration is loaded from the GNMI server periodically.
+func (t *Tracker) Run(bkgdContext ctx.Context, gnmiServer *gnmi.Server) {
+	hostName := context.GetDeviceConfig().Hostname
+	events := make(chan *hostapd.Event)
+	monitors := hostapd.NewMonitors(bkgdContext, hostapd.CtrlInterfaceDir, false, events)
+	defer monitors.Stop()
+
+	refresh := time.NewTicker(refreshInterval)
+	defer refresh.Stop()
+	for {
+		select {
+		case <-bkgdContext.Done():
+			return
+		case event := <-events:
+			t.HandleEvent(event)
+		case <-refresh.C:
+			t.UpdateSettings(blacklistSettings(gnmiServer, hostName), monitoring.BSSs())
+			t.expire()
+			t.publishState(gnmiServer, hostName)
+			monitors.Update(t.trackedBSSs())
+		}
+	}
+}
+
+// UpdateSettings replaces the blacklist settings (SSID -> settings) and the BSSs on the AP.
+// Clients blacklisted on an SSID without a failure limit any more are removed from the blacklist.
+func (t *Tracker) UpdateSettings(settings map[string]ocutil.Dot1XBlacklist, bssList []*monitoring.BSS) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for ssid, blacklistedClients := range t.blacklisted {
+		if _, ok := settings[ssid]; ok {
+			continue
+		}
+		for mac := range blacklistedClients {
+			t.unblock(ssid, mac)
+		}
+	}
+	for ssid := range t.failures {
+		if _, ok := settings[ssid]; !ok {
+			delete(t.failures, ssid)
+		}
+	}
+
+	t.settings = settings
+	t.bsss = make(map[string]*monitoring.BSS)
+	for _, bss := range bssList {
+		t.bsss[bss.IntfName] = bss
+	}
+}
+
+// Blacklisted returns the clients currently blacklisted on an SSID, in ascending order.
+func (t *Tracker) Blacklisted(ssid string) []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var macs []string
+	for mac := range t.blacklisted[ssid] {
+		macs = append(macs, mac)
+	}
+	sort.Strings(macs)
+	return macs
+}
+
+// HandleEvent processes a hostapd event received from a BSS interface.
+func (t *Tracker) HandleEvent(event *hostapd.Event) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	bss, ok := t.bsss[event.IntfName]
+	if !ok || len(event.Args) == 0 {
+		return
+	}
+	settings, ok := t.settings[bss.SSID]
+	if !ok {
+		return
+	}
+	mac := event.Args[0]
+
+	switch event.Name {
+	case "CTRL-EVENT-EAP-FAILURE":
+		if t.failures[bss.SSID] == nil {
+			t.failures[bss.SSID] = make(map[string]uint8)
+		}
+		t.failures[bss.SSID][mac]++
+		if t.failures[bss.SSID][mac] >= settings.MaxAuthFailures {
+			delete(t.failures[bss.SSID], mac)
+			t.block(bss.SSID, mac, settings.BlacklistTime)
+		}
+	case "CTRL-EVENT-EAP-SUCCESS":
+		delete(t.failures[bss.SSID], mac)
+	}
+}
+
+// block denies the client on all BSSs of the SSID.
+func (t *Tracker) block(ssid, mac string, blacklistTime time.Duration) {
+	for _, bss := range t.ssidBSSs(ssid) {
+		if err := t.denyList.Deny(denyListOwner, bss.IntfName, mac); err != nil {
+			log.Errorf("Failed to blacklist %s on %s: %v", mac, bss.IntfName, err)
+		}
+	}
+	if t.blacklisted[ssid] == nil {
+		t.blacklisted[ssid] = make(map[string]time.Time)
+	}
+	t.blacklisted[ssid][mac] = t.now().Add(blacklistTime)
+	log.Infof("Client %s blacklisted on SSID %s for %v after too many authentication failures.", mac, ssid, blacklistTime)
+}
+
+// unblock allows the client on all BSSs of the SSID again.
+func (t *Tracker) unblock(ssid, mac string) {
+	for _, bss := range t.ssidBSSs(ssid) {
+		if err := t.denyList.Allow(denyListOwner, bss.IntfName, mac); err != nil {
+			log.Errorf("Failed to remove %s from the blacklist of %s: %v", mac, bss.IntfName, err)
+		}
+	}
+	delete(t.blacklisted[ssid], mac)
+	if len(t.blacklisted[ssid]) == 0 {
+		delete(t.blacklisted, ssid)
+	}
+	log.Infof("Client %s removed from the blacklist of SSID %s.", mac, ssid)
+}
+
+// expire removes the clients whose blacklist time is over.
+func (t *Tracker) expire() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for ssid, blacklistedClients := range t.blacklisted {
+		for mac, expiration := range blacklistedClients {
+			if !t.now().Before(expiration) {
+				t.unblock(ssid, mac)
+			}
+		}
+	}
+}
+
+// ssidBSSs returns the BSSs of the given SSID.
+func (t *Tracker) ssidBSSs(ssid string) []*monitoring.BSS {
+	var bssList []*monitoring.BSS
+	for _, bss := range t.bsss {
+		if bss.SSID == ssid {
+			bssList = append(bssList, bss)
+		}
+	}
+	return bssList
+}
+
+// trackedBSSs returns the interface names of all BSSs of SSIDs with a failure limit.
+func (t *Tracker) trackedBSSs() map[string]bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	bssIntfs := make(map[string]bool)
+	for intfName, bss := range t.bsss {
+		if _, ok := t.settings[bss.SSID]; ok {
+			bssIntfs[intfName] = true
+		}
+	}
+	return bssIntfs
+}
+
+// publishState updates the 802.1X timers state of SSIDs with a failure limit, including their blacklisted clients.
+func (t *Tracker) publishState(gnmiServer *gnmi.Server, hostName string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	err := gnmiServer.InternalUpdate(func(config ygot.ValidatedGoStruct) error {
+		device, ok := config.(*ocstruct.Device)
+		if !ok {
+			return errors.New("configuration has invalid type")
+		}
+		apConfig := ocutil.FindAPConfig(device, hostName)
+		if apConfig == nil || apConfig.Ssids == nil {
+			return nil
+		}
+		for ssidName, ssid := range apConfig.Ssids.Ssid {
+			if ssid.Dot1XTimers == nil {
+				continue
+			}
+			ssid.Dot1XTimers.State = t.timersState(ssidName)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Errorf("Error in updating 802.1X timers state: %v", err)
+	}
+}
+
+// timersState generates the 802.1X timers state of an SSID, nil if the SSID has no failure limit.
+// The expiration time of blacklisted clients is in seconds since the Unix epoch.
+func (t *Tracker) timersState(ssid string) *ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_Ssids_Ssid_Dot1XTimers_State {
+	settings, ok := t.settings[ssid]
+	if !ok {
+		return nil
+	}
+	state := &ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_Ssids_Ssid_Dot1XTimers_State{
+		MaxAuthFailures: ygot.Uint8(settings.MaxAuthFailures),
+		BlacklistTime:   ygot.Uint16(uint16(settings.BlacklistTime / time.Second)),
+	}
+	for mac, expiration := range t.blacklisted[ssid] {
+		client, err := state.NewBlacklistedClient(mac)
+		if err != nil {
+			log.Errorf("Failed to publish blacklisted client %s: %v", mac, err)
+			continue
+		}
+		client.ExpirationTime = ygot.Uint64(uint64(expiration.Unix()))
+	}
+	return state
+}
+
+// blacklistSettings returns the blacklist settings of each SSID with a failure limit.
+func blacklistSettings(gnmiServer *gnmi.Server, hostName string) map[string]ocutil.Dot1XBlacklist {
+	settings := make(map[string]ocutil.Dot1XBlacklist)
+	gnmiServer.InternalUpdate(func(config ygot.ValidatedGoStruct) error {
+		device, ok := config.(*ocstruct.Device)
+		if !ok {
+			return errors.New("configuration has invalid type")
+		}
+		settings = ocutil.Dot1XBlacklistSSIDs(ocutil.FindAPConfig(device, hostName))
+		return nil
+	})
+	return settings
+}
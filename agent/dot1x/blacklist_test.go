@@ -0,0 +1,162 @@
+/* Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dot1x
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/link022/agent/denylist"
+	"github.com/google/link022/agent/hostapd"
+	"github.com/google/link022/agent/monitoring"
+	"github.com/google/link022/agent/syscmd"
+	"github.com/google/link022/agent/util/ocutil"
+	"github.com/google/link022/generated/ocstruct"
+	"github.com/openconfig/ygot/ygot"
+)
+
+const (
+	testSSID      = "Auth-Emu"
+	testClientMAC = "12:34:56:78:9a:bc"
+	testIntf      = "wlan0_1"
+)
+
+var testStartTime = time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// newTestTracker creates a Tracker with a fake clock, which records the executed hostapd_cli commands.
+func newTestTracker() (*Tracker, *time.Time, *[]string) {
+	var cmds []string
+	now := testStartTime
+	cmdRunner := &syscmd.CommandRunner{
+		ExecCommand: func(wait bool, cmd string, args ...string) (string, error) {
+			// Skip the control interface arguments: -p <dir> -i <intf>.
+			cmds = append(cmds, args[3]+" "+strings.Join(args[4:], " "))
+			return "OK", nil
+		},
+	}
+	tracker := NewTracker(denylist.New(cmdRunner))
+	tracker.now = func() time.Time { return now }
+	tracker.UpdateSettings(
+		map[string]ocutil.Dot1XBlacklist{testSSID: {MaxAuthFailures: 3, BlacklistTime: time.Minute}},
+		[]*monitoring.BSS{{IntfName: testIntf, SSID: testSSID}},
+	)
+	return tracker, &now, &cmds
+}
+
+func eapEvent(name string) *hostapd.Event {
+	return &hostapd.Event{IntfName: testIntf, Name: name, Args: []string{testClientMAC}}
+}
+
+func TestBlacklist(t *testing.T) {
+	tests := []struct {
+		name        string
+		events      []string
+		blacklisted []string
+	}{{
+		name:        "TooManyFailures",
+		events:      []string{"CTRL-EVENT-EAP-FAILURE", "CTRL-EVENT-EAP-FAILURE", "CTRL-EVENT-EAP-FAILURE"},
+		blacklisted: []string{testClientMAC},
+	}, {
+		name:   "BelowLimit",
+		events: []string{"CTRL-EVENT-EAP-FAILURE", "CTRL-EVENT-EAP-FAILURE"},
+	}, {
+		name:   "SuccessResetsFailures",
+		events: []string{"CTRL-EVENT-EAP-FAILURE", "CTRL-EVENT-EAP-FAILURE", "CTRL-EVENT-EAP-SUCCESS", "CTRL-EVENT-EAP-FAILURE"},
+	}}
+
+	for _, test := range tests {
+		tracker, _, cmds := newTestTracker()
+		for _, event := range test.events {
+			tracker.HandleEvent(eapEvent(event))
+		}
+		if got := tracker.Blacklisted(testSSID); !reflect.DeepEqual(got, test.blacklisted) {
+			t.Errorf("[%s] Incorrect blacklist (got: %v, want: %v).", test.name, got, test.blacklisted)
+		}
+		if len(*cmds) != len(test.blacklisted) {
+			t.Errorf("[%s] Incorrect hostapd commands: %v.", test.name, *cmds)
+		}
+	}
+}
+
+func TestBlacklistExpire(t *testing.T) {
+	tracker, now, cmds := newTestTracker()
+	for i := 0; i < 3; i++ {
+		tracker.HandleEvent(eapEvent("CTRL-EVENT-EAP-FAILURE"))
+	}
+
+	*now = now.Add(30 * time.Second)
+	tracker.expire()
+	if len(tracker.Blacklisted(testSSID)) != 1 {
+		t.Errorf("Client removed from the blacklist before expiration.")
+	}
+
+	*now = now.Add(30 * time.Second)
+	tracker.expire()
+	if got := tracker.Blacklisted(testSSID); len(got) != 0 {
+		t.Errorf("Client still blacklisted after expiration: %v.", got)
+	}
+	want := []string{
+		testIntf + " deny_acl ADD_MAC " + testClientMAC,
+		testIntf + " deny_acl DEL_MAC " + testClientMAC,
+	}
+	if !reflect.DeepEqual(*cmds, want) {
+		t.Errorf("Incorrect hostapd commands (got: %v, want: %v).", *cmds, want)
+	}
+}
+
+func TestBlacklistDisabled(t *testing.T) {
+	tracker, _, cmds := newTestTracker()
+	for i := 0; i < 3; i++ {
+		tracker.HandleEvent(eapEvent("CTRL-EVENT-EAP-FAILURE"))
+	}
+	tracker.UpdateSettings(map[string]ocutil.Dot1XBlacklist{}, []*monitoring.BSS{{IntfName: testIntf, SSID: testSSID}})
+
+	if got := tracker.Blacklisted(testSSID); len(got) != 0 {
+		t.Errorf("Client still blacklisted after removing the failure limit: %v.", got)
+	}
+	if len(*cmds) != 2 {
+		t.Errorf("Incorrect hostapd commands: %v.", *cmds)
+	}
+	if bssIntfs := tracker.trackedBSSs(); len(bssIntfs) != 0 {
+		t.Errorf("BSSs still tracked after removing the failure limit: %v.", bssIntfs)
+	}
+}
+
+func TestTimersState(t *testing.T) {
+	tracker, _, _ := newTestTracker()
+	for i := 0; i < 3; i++ {
+		tracker.HandleEvent(eapEvent("CTRL-EVENT-EAP-FAILURE"))
+	}
+
+	want := &ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_Ssids_Ssid_Dot1XTimers_State{
+		MaxAuthFailures: ygot.Uint8(3),
+		BlacklistTime:   ygot.Uint16(60),
+		BlacklistedClient: map[string]*ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_Ssids_Ssid_Dot1XTimers_State_BlacklistedClient{
+			testClientMAC: {
+				Mac:            ygot.String(testClientMAC),
+				ExpirationTime: ygot.Uint64(uint64(testStartTime.Add(time.Minute).Unix())),
+			},
+		},
+	}
+	if got := tracker.timersState(testSSID); !reflect.DeepEqual(got, want) {
+		t.Errorf("Incorrect 802.1X timers state (got: %+v, want: %+v).", got, want)
+	}
+	if got := tracker.timersState("Guest-Emu"); got != nil {
+		t.Errorf("Expected no 802.1X timers state for an SSID without failure limit, got %+v.", got)
+	}
+}
@@ -0,0 +1,296 @@
+/* Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package filter installs the layer 2 traffic filters of SSIDs as nftables bridge rules.
+//
+// The rules match the bridge ports of each BSS, so SSIDs sharing a VLAN bridge are filtered
+// independently. Broadcast and multicast filters apply to the frames sent to clients.
+// DHCP-required and IPv6 NDP filters rely on hostapd proxy ARP, which learns the client addresses
+// by snooping DHCP and neighbor discovery. Clients learned from DHCP show up as permanent IPv4
+// neighbors of the bridge of the BSS, only their traffic is forwarded on SSIDs requiring DHCP.
+package filter
+
+import (
+	ctx "context"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/golang/glog"
+	"github.com/google/link022/agent/context"
+	"github.com/google/link022/agent/gnmi"
+	"github.com/google/link022/agent/monitoring"
+	"github.com/google/link022/agent/syscmd"
+	"github.com/google/link022/agent/util/ocutil"
+	"github.com/google/link022/generated/ocstruct"
+	"github.com/openconfig/ygot/ygot"
+)
+
+const (
+	// refreshInterval is how often the filters and the clients that completed DHCP are refreshed.
+	refreshInterval = 5 * time.Second
+
+	tableFamily   = "bridge"
+	tableName     = "link022"
+	rulesFileName = "l2filter.nft"
+)
+
+var runFolder = "/var/run/link022"
+
+// Filter keeps the nftables rules in sync with the filters of the SSIDs.
+type Filter struct {
+	cmdRunner *syscmd.CommandRunner
+
+	applied      bool
+	appliedRules string
+}
+
+// NewFilter creates a Filter updating nftables with the given runner.
+func NewFilter(cmdRunner *syscmd.CommandRunner) *Filter {
+	return &Filter{cmdRunner: cmdRunner}
+}
+
+// Run refreshes the nftables rules periodically until the context is done.
+// The filter configuration is loaded from the GNMI server.
+func (f *Filter) Run(bkgdContext ctx.Context, gnmiServer *gnmi.Server) {
+	hostName := context.GetDeviceConfig().Hostname
+	refresh := time.NewTicker(refreshInterval)
+	defer refresh.Stop()
+	for {
+		select {
+		case <-bkgdContext.Done():
+			return
+		case <-refresh.C:
+			if err := f.Update(filterSettings(gnmiServer, hostName), monitoring.BSSs()); err != nil {
+				log.Errorf("Error in updating L2 filters: %v", err)
+			}
+		}
+	}
+}
+
+// Update installs the rules of the given filters (SSID -> filters) on the BSSs of the AP.
+// nftables is only updated when the rules change.
+func (f *Filter) Update(filters map[string]ocutil.L2Filter, bssList []*monitoring.BSS) error {
+	dhcpClients, err := f.dhcpClients(filters, bssList)
+	if err != nil {
+		return err
+	}
+
+	rules := Ruleset(filters, bssList, dhcpClients)
+	if f.applied && rules == f.appliedRules {
+		return nil
+	}
+
+	if len(rules) == 0 {
+		// The table may not exist, e.g. no filter was installed before the agent started.
+		if err := f.cmdRunner.DeleteNftTable(tableFamily, tableName); err != nil && f.applied {
+			return err
+		}
+	} else {
+		if err := syscmd.SaveToFile(runFolder, rulesFileName, rules); err != nil {
+			return err
+		}
+		if err := f.cmdRunner.ApplyNftRules(path.Join(runFolder, rulesFileName)); err != nil {
+			return err
+		}
+	}
+	f.applied = true
+	f.appliedRules = rules
+	return nil
+}
+
+// dhcpClients returns the MAC of clients that completed DHCP on each BSS requiring DHCP
+// (BSS intf -> MACs). hostapd proxy ARP adds the clients as permanent neighbors of the bridge
+// the BSS is a port of, so only the neighbors of the bridges of its ports are used.
+func (f *Filter) dhcpClients(filters map[string]ocutil.L2Filter, bssList []*monitoring.BSS) (map[string][]string, error) {
+	dhcpClients := make(map[string][]string)
+	bridgeClients := make(map[string][]string)
+	for _, bss := range bssList {
+		filter, ok := filters[bss.SSID]
+		if !ok || !filter.DHCPRequired {
+			continue
+		}
+
+		found := make(map[string]bool)
+		for _, port := range bssPortNames(bss.IntfName, filter) {
+			bridge, err := f.cmdRunner.BridgeOf(port)
+			if err != nil {
+				// Dynamic VLAN ports only exist once a client is assigned to the VLAN.
+				log.V(1).Infof("Unable to find the bridge of %s. Error: %v.", port, err)
+				continue
+			}
+			if len(bridge) == 0 {
+				continue
+			}
+			clients, ok := bridgeClients[bridge]
+			if !ok {
+				if clients, err = f.cmdRunner.PermanentNeighborMACs(bridge); err != nil {
+					return nil, err
+				}
+				bridgeClients[bridge] = clients
+			}
+			for _, client := range clients {
+				found[client] = true
+			}
+		}
+
+		var macs []string
+		for mac := range found {
+			macs = append(macs, mac)
+		}
+		sort.Strings(macs)
+		dhcpClients[bss.IntfName] = macs
+	}
+	return dhcpClients, nil
+}
+
+// Ruleset generates the nftables rules of the given filters (SSID -> filters) on the BSSs of the AP.
+// dhcpClients contains the MAC of clients that completed DHCP on each BSS (BSS intf -> MACs).
+// The rules are generated in a stable order. It returns an empty string if no BSS has filters.
+func Ruleset(filters map[string]ocutil.L2Filter, bssList []*monitoring.BSS, dhcpClients map[string][]string) string {
+	sortedBSSs := make([]*monitoring.BSS, len(bssList))
+	copy(sortedBSSs, bssList)
+	sort.Slice(sortedBSSs, func(i, j int) bool {
+		return sortedBSSs[i].IntfName < sortedBSSs[j].IntfName
+	})
+
+	sets := ""
+	chainRules := ""
+	for _, bss := range sortedBSSs {
+		filter, ok := filters[bss.SSID]
+		if !ok {
+			continue
+		}
+		chainRules += fmt.Sprintf("\n\t\t# SSID %s on %s\n", bss.SSID, bss.IntfName)
+		chainRules += bssRules(filter, bss.IntfName, bssPorts(bss.IntfName, filter))
+		if filter.DHCPRequired {
+			sets += dhcpClientSet(bss.IntfName, dhcpClients[bss.IntfName])
+		}
+	}
+	if len(chainRules) == 0 {
+		return ""
+	}
+
+	rules := fmt.Sprintf("table %s %s\ndelete table %s %s\n\n", tableFamily, tableName, tableFamily, tableName)
+	rules += fmt.Sprintf("table %s %s {\n", tableFamily, tableName)
+	rules += sets
+	rules += "\tchain forward {\n\t\ttype filter hook forward priority 0; policy accept;\n"
+	rules += chainRules
+	rules += "\t}\n}\n"
+	return rules
+}
+
+// dhcpClientSet generates the set of clients that completed DHCP on a BSS.
+func dhcpClientSet(bssINTFName string, clients []string) string {
+	set := fmt.Sprintf("\tset %s {\n\t\ttype ether_addr\n", dhcpClientSetName(bssINTFName))
+	if len(clients) != 0 {
+		sortedClients := make([]string, len(clients))
+		copy(sortedClients, clients)
+		sort.Strings(sortedClients)
+		set += fmt.Sprintf("\t\telements = { %s }\n", strings.Join(sortedClients, ", "))
+	}
+	return set + "\t}\n\n"
+}
+
+// dhcpClientSetName returns the name of the set of clients that completed DHCP on a BSS.
+func dhcpClientSetName(bssINTFName string) string {
+	return "dhcp_clients_" + bssINTFName
+}
+
+// bssRules generates the rules of a BSS. Rules on traffic from the clients go first,
+// followed by rules on traffic to the clients.
+func bssRules(filter ocutil.L2Filter, bssINTFName, ports string) string {
+	rules := ""
+	rule := func(format string, args ...interface{}) {
+		rules += "\t\t" + fmt.Sprintf(format, args...) + "\n"
+	}
+
+	if filter.StationIsolation && filter.ProxyARP() {
+		// Proxy ARP forwards the traffic between clients through the bridge.
+		rule("iifname %s oifname %s drop", ports, ports)
+	}
+	if filter.DHCPRequired {
+		rule("iifname %s udp dport 67 accept", ports)
+		rule("iifname %s ether saddr != @%s drop", ports, dhcpClientSetName(bssINTFName))
+	}
+
+	if filter.BroadcastFilter || filter.MulticastFilter {
+		rule("oifname %s ether type arp accept", ports)
+		rule("oifname %s udp dport { 68, 546 } accept", ports)
+	}
+	if filter.NDPFilter {
+		// hostapd answers neighbor solicitations on behalf of the clients.
+		rule("oifname %s icmpv6 type nd-neighbor-solicit drop", ports)
+		if filter.RouterAdvertInterval > 0 {
+			rule("oifname %s icmpv6 type nd-router-advert limit rate %d/hour accept", ports, routerAdvertsPerHour(filter.RouterAdvertInterval))
+			rule("oifname %s icmpv6 type nd-router-advert drop", ports)
+		}
+	}
+	if filter.MulticastFilter {
+		rule("oifname %s icmpv6 type { nd-neighbor-solicit, nd-neighbor-advert, nd-router-advert } accept", ports)
+		rule("oifname %s meta pkttype multicast drop", ports)
+	}
+	if filter.BroadcastFilter {
+		rule("oifname %s meta pkttype broadcast drop", ports)
+	}
+	return rules
+}
+
+// bssPortNames returns the bridge ports of a BSS.
+// With dynamic VLAN, hostapd adds a "<BSS intf>.<VLAN ID>" port for each VLAN.
+func bssPortNames(bssINTFName string, filter ocutil.L2Filter) []string {
+	ports := []string{bssINTFName}
+	for _, vlanID := range filter.DynamicVLANIDs {
+		ports = append(ports, fmt.Sprintf("%s.%d", bssINTFName, vlanID))
+	}
+	return ports
+}
+
+// bssPorts returns the bridge ports of a BSS in nftables syntax.
+func bssPorts(bssINTFName string, filter ocutil.L2Filter) string {
+	var ports []string
+	for _, port := range bssPortNames(bssINTFName, filter) {
+		ports = append(ports, fmt.Sprintf("%q", port))
+	}
+	if len(ports) == 1 {
+		return ports[0]
+	}
+	return fmt.Sprintf("{ %s }", strings.Join(ports, ", "))
+}
+
+// routerAdvertsPerHour converts the minimum interval between router advertisements to a rate.
+func routerAdvertsPerHour(interval time.Duration) int {
+	if rate := int(time.Hour / interval); rate > 0 {
+		return rate
+	}
+	return 1
+}
+
+// filterSettings returns the filters of each SSID with layer 2 traffic filters.
+func filterSettings(gnmiServer *gnmi.Server, hostName string) map[string]ocutil.L2Filter {
+	filters := make(map[string]ocutil.L2Filter)
+	gnmiServer.InternalUpdate(func(config ygot.ValidatedGoStruct) error {
+		device, ok := config.(*ocstruct.Device)
+		if !ok {
+			return errors.New("configuration has invalid type")
+		}
+		filters = ocutil.L2FilterSSIDs(ocutil.FindAPConfig(device, hostName))
+		return nil
+	})
+	return filters
+}
@@ -0,0 +1,239 @@
+/* Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package filter
+
+import (
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/link022/agent/monitoring"
+	"github.com/google/link022/agent/syscmd"
+	"github.com/google/link022/agent/util/ocutil"
+)
+
+const (
+	testGuestSSID = "Guest-Emu"
+	testAuthSSID  = "Auth-Emu"
+)
+
+var testBSSList = []*monitoring.BSS{
+	{IntfName: "wlan0_1", SSID: testGuestSSID},
+	{IntfName: "wlan0", SSID: testAuthSSID},
+}
+
+func TestRuleset(t *testing.T) {
+	filters := map[string]ocutil.L2Filter{
+		testGuestSSID: {
+			BroadcastFilter:      true,
+			MulticastFilter:      true,
+			NDPFilter:            true,
+			RouterAdvertInterval: 30 * time.Second,
+			StationIsolation:     true,
+			DynamicVLANIDs:       []int{666, 300},
+		},
+		testAuthSSID: {DHCPRequired: true, StationIsolation: true},
+	}
+	want := `table bridge link022
+delete table bridge link022
+
+table bridge link022 {
+	set dhcp_clients_wlan0 {
+		type ether_addr
+		elements = { 02:00:00:00:00:01, 12:34:56:78:9a:bc }
+	}
+
+	chain forward {
+		type filter hook forward priority 0; policy accept;
+
+		# SSID Auth-Emu on wlan0
+		iifname "wlan0" oifname "wlan0" drop
+		iifname "wlan0" udp dport 67 accept
+		iifname "wlan0" ether saddr != @dhcp_clients_wlan0 drop
+
+		# SSID Guest-Emu on wlan0_1
+		iifname { "wlan0_1", "wlan0_1.666", "wlan0_1.300" } oifname { "wlan0_1", "wlan0_1.666", "wlan0_1.300" } drop
+		oifname { "wlan0_1", "wlan0_1.666", "wlan0_1.300" } ether type arp accept
+		oifname { "wlan0_1", "wlan0_1.666", "wlan0_1.300" } udp dport { 68, 546 } accept
+		oifname { "wlan0_1", "wlan0_1.666", "wlan0_1.300" } icmpv6 type nd-neighbor-solicit drop
+		oifname { "wlan0_1", "wlan0_1.666", "wlan0_1.300" } icmpv6 type nd-router-advert limit rate 120/hour accept
+		oifname { "wlan0_1", "wlan0_1.666", "wlan0_1.300" } icmpv6 type nd-router-advert drop
+		oifname { "wlan0_1", "wlan0_1.666", "wlan0_1.300" } icmpv6 type { nd-neighbor-solicit, nd-neighbor-advert, nd-router-advert } accept
+		oifname { "wlan0_1", "wlan0_1.666", "wlan0_1.300" } meta pkttype multicast drop
+		oifname { "wlan0_1", "wlan0_1.666", "wlan0_1.300" } meta pkttype broadcast drop
+	}
+}
+`
+	got := Ruleset(filters, testBSSList, map[string][]string{
+		"wlan0":   {"12:34:56:78:9a:bc", "02:00:00:00:00:01"},
+		"wlan0_1": {"02:00:00:00:00:02"},
+	})
+	if got != want {
+		t.Errorf("Incorrect ruleset (got:\n%s\nwant:\n%s).", got, want)
+	}
+
+	// The ruleset does not depend on the order of BSSs and clients.
+	reversedBSSList := []*monitoring.BSS{testBSSList[1], testBSSList[0]}
+	if again := Ruleset(filters, reversedBSSList, map[string][]string{"wlan0": {"02:00:00:00:00:01", "12:34:56:78:9a:bc"}}); again != got {
+		t.Errorf("Ruleset not deterministic (got:\n%s\nwant:\n%s).", again, got)
+	}
+}
+
+func TestRulesetWithoutFilters(t *testing.T) {
+	tests := []struct {
+		name    string
+		filters map[string]ocutil.L2Filter
+	}{{
+		name: "NoFilters",
+	}, {
+		name:    "FiltersOfOtherSSIDs",
+		filters: map[string]ocutil.L2Filter{"Other-SSID": {BroadcastFilter: true}},
+	}}
+
+	for _, test := range tests {
+		if got := Ruleset(test.filters, testBSSList, nil); got != "" {
+			t.Errorf("[%s] Unexpected ruleset:\n%s", test.name, got)
+		}
+	}
+}
+
+func TestRulesetWithoutDHCPClients(t *testing.T) {
+	got := Ruleset(map[string]ocutil.L2Filter{testAuthSSID: {DHCPRequired: true}}, testBSSList, nil)
+	if !strings.Contains(got, "\tset dhcp_clients_wlan0 {\n\t\ttype ether_addr\n\t}\n") {
+		t.Errorf("Incorrect empty DHCP client set:\n%s", got)
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	tempRunFolder, err := ioutil.TempDir("", "link022")
+	if err != nil {
+		t.Fatalf("Unable to create a temp run time folder.")
+	}
+	originalRunFolder := runFolder
+	runFolder = tempRunFolder
+	defer func() {
+		runFolder = originalRunFolder
+	}()
+
+	var cmds []string
+	neighInfo := ""
+	f := NewFilter(&syscmd.CommandRunner{
+		ExecCommand: func(wait bool, cmd string, args ...string) (string, error) {
+			if cmd != "ip" {
+				cmds = append(cmds, cmd+" "+strings.Join(args, " "))
+				return "", nil
+			}
+			switch strings.Join(args, " ") {
+			case "-o link show dev wlan0":
+				return "5: wlan0: <BROADCAST,MULTICAST,UP,LOWER_UP> mtu 1500 qdisc mq master br_250 state UP\n", nil
+			case "-4 neigh show dev br_250 nud permanent":
+				return neighInfo, nil
+			}
+			return "", fmt.Errorf("unexpected command ip %v", args)
+		},
+	})
+	dhcpFilters := map[string]ocutil.L2Filter{testAuthSSID: {DHCPRequired: true}}
+	applyCmd := "nft -f " + tempRunFolder + "/" + rulesFileName
+
+	steps := []struct {
+		name      string
+		filters   map[string]ocutil.L2Filter
+		neighInfo string
+		cmds      []string
+	}{{
+		name:    "Install",
+		filters: dhcpFilters,
+		cmds:    []string{applyCmd},
+	}, {
+		name:    "Unchanged",
+		filters: dhcpFilters,
+	}, {
+		name:      "NewDHCPClient",
+		filters:   dhcpFilters,
+		neighInfo: "192.168.1.10 lladdr 12:34:56:78:9a:bc PERMANENT\n",
+		cmds:      []string{applyCmd},
+	}, {
+		name: "Remove",
+		cmds: []string{"nft delete table bridge link022"},
+	}, {
+		name: "StillRemoved",
+	}}
+
+	for _, step := range steps {
+		cmds = nil
+		neighInfo = step.neighInfo
+		if err := f.Update(step.filters, testBSSList); err != nil {
+			t.Errorf("[%s] Updating filters failed. Error: %v.", step.name, err)
+		}
+		if !reflect.DeepEqual(cmds, step.cmds) {
+			t.Errorf("[%s] Incorrect commands (got: %v, want: %v).", step.name, cmds, step.cmds)
+		}
+	}
+
+	rules, err := ioutil.ReadFile(tempRunFolder + "/" + rulesFileName)
+	if err != nil {
+		t.Fatalf("Unable to read the rules file. Error: %v.", err)
+	}
+	if !strings.Contains(string(rules), "elements = { 12:34:56:78:9a:bc }") {
+		t.Errorf("DHCP client missing in the rules file:\n%s", rules)
+	}
+}
+
+func TestDHCPClients(t *testing.T) {
+	links := map[string]string{
+		"wlan0":       "master br_250",
+		"wlan0_1":     "master br_nat0",
+		"wlan0_1.666": "master br_666",
+	}
+	neighbors := map[string]string{
+		"br_250":  "192.168.1.10 lladdr 12:34:56:78:9a:bc PERMANENT\n",
+		"br_nat0": "192.168.50.10 lladdr 02:00:00:00:00:01 PERMANENT\n",
+		"br_666":  "192.168.66.10 lladdr 02:00:00:00:00:02 PERMANENT\n",
+	}
+	f := NewFilter(&syscmd.CommandRunner{
+		ExecCommand: func(wait bool, cmd string, args ...string) (string, error) {
+			intfName := args[len(args)-1]
+			if args[1] == "neigh" {
+				intfName = args[len(args)-3]
+				return neighbors[intfName], nil
+			}
+			link, ok := links[intfName]
+			if !ok {
+				return "", fmt.Errorf("device %s does not exist", intfName)
+			}
+			return "5: " + intfName + ": <UP> mtu 1500 " + link + " state UP\n", nil
+		},
+	})
+
+	filters := map[string]ocutil.L2Filter{
+		testGuestSSID: {DHCPRequired: true, DynamicVLANIDs: []int{666, 300}},
+		testAuthSSID:  {DHCPRequired: true},
+	}
+	got, err := f.dhcpClients(filters, testBSSList)
+	if err != nil {
+		t.Fatalf("Fetching DHCP clients failed. Error: %v.", err)
+	}
+	want := map[string][]string{
+		"wlan0":   {"12:34:56:78:9a:bc"},
+		"wlan0_1": {"02:00:00:00:00:01", "02:00:00:00:00:02"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Incorrect DHCP clients (got: %v, want: %v).", got, want)
+	}
+}
@@ -18,9 +18,12 @@ package gnmi
 import (
 	"errors"
 	"fmt"
+	"reflect"
 	"time"
 
+	"github.com/google/link022/agent/alarm"
 	"github.com/google/link022/agent/context"
+	"github.com/google/link022/agent/hostapd"
 	"github.com/google/link022/agent/service"
 	"github.com/google/link022/agent/syscmd"
 	"github.com/google/link022/agent/util/ocutil"
@@ -42,6 +45,12 @@ func handleSet(updatedConfig ygot.ValidatedGoStruct) (err error) {
 		if r := recover(); r != nil {
 			err = fmt.Errorf("panic detected when handling updated config: %v", r)
 		}
+		// The alarm stays raised until a configuration is applied.
+		if err != nil {
+			alarm.Default().Raise(alarm.ApplyFailed, "", fmt.Sprintf("Failed to apply the configuration: %v", err))
+		} else {
+			alarm.Default().Clear(alarm.ApplyFailed, "")
+		}
 	}()
 
 	return handleSetInternal(updatedConfig)
@@ -74,6 +83,28 @@ func handleSetInternal(updatedConfig ygot.ValidatedGoStruct) error {
 	if apConfig == nil {
 		return fmt.Errorf("not found the configuration for this AP (hostname = %s)", deviceConfig.Hostname)
 	}
+	// Reject the terminal server settings which can not be applied, e.g. an enabled telnet server.
+	if err := ocutil.CheckTerminalServers(apConfig); err != nil {
+		return err
+	}
+	// Reject a hostapd control interface the agent can not drive hostapd through.
+	if err := ocutil.CheckCtrlInterface(officeAPs.Gasket, hostapd.CtrlInterfaceDir); err != nil {
+		return err
+	}
+	// Reject invalid NAT subnets, the SSIDs would be bridged to their VLANs instead.
+	if err := ocutil.CheckNATSSIDs(officeAPs.Gasket); err != nil {
+		return err
+	}
+
+	// Enable and disable SSIDs and update MAC ACLs without reconfiguring the AP if nothing else changes.
+	updated, err := updateRunningAP(officeAPs, apConfig, deviceConfig)
+	if err != nil {
+		log.Warningf("Failed to update the running AP, reconfiguring the AP. Error: %v.", err)
+	}
+	if updated {
+		log.Info("Updated SSIDs and MAC ACLs on the running AP.")
+		return saveConfig(configString)
+	}
 
 	// Check and clean up the existing configuration.
 	var changedVLANIDs []int
@@ -82,8 +113,13 @@ func handleSetInternal(updatedConfig ygot.ValidatedGoStruct) error {
 		return fmt.Errorf("unable to fetch the existing VLAN with error (%v), may need to reboot the device.", err)
 	}
 
+	// The management VLAN is managed by package uplink.
+	if mgmtIntf := ocutil.ManagementIntfSettings(officeAPs.Gasket, apConfig); mgmtIntf != nil && mgmtIntf.VLANID != 0 {
+		existingVLANIDs = withoutVLAN(existingVLANIDs, mgmtIntf.VLANID)
+	}
+
 	resetIntf := false
-	newVLANIDs := ocutil.VLANIDs(apConfig)
+	newVLANIDs := ocutil.UplinkVLANIDs(apConfig, ocutil.NATSSIDs(officeAPs.Gasket))
 	if ocutil.VLANChanged(existingVLANIDs, newVLANIDs) {
 		log.Infof("VLAN changes (%v -> %v) on interface %s.", existingVLANIDs, newVLANIDs, deviceConfig.ETHINTFName)
 		changedVLANIDs = existingVLANIDs
@@ -100,12 +136,78 @@ func handleSetInternal(updatedConfig ygot.ValidatedGoStruct) error {
 
 	// Process the incoming configuration.
 	if err = service.ApplyConfig(apConfig, officeAPs.Gasket, resetIntf, deviceConfig.ETHINTFName,
-		deviceConfig.WLANINTFName); err != nil {
+		deviceConfig.WLANINTFNames); err != nil {
 		return err
 	}
 	log.Info("Device configuration succeeded.")
 
-	// Save the succeeded config file.
+	return saveConfig(configString)
+}
+
+// updateRunningAP applies the new AP configuration on the running hostapd, by enabling and disabling SSIDs
+// and updating MAC ACLs. It returns false if the configuration has other changes from the applied one,
+// which require reconfiguring the AP.
+func updateRunningAP(officeAPs *ocstruct.Device, apConfig *ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint, deviceConfig *context.DeviceConfig) (bool, error) {
+	appliedConfigContent, err := loadExistingConfigContent()
+	if err != nil || appliedConfigContent == nil {
+		return false, err
+	}
+	appliedAPs := &ocstruct.Device{}
+	if err := ocstruct.Unmarshal(appliedConfigContent, appliedAPs); err != nil {
+		return false, err
+	}
+	if !sameExceptMACACLs(appliedAPs.Gasket, officeAPs.Gasket) {
+		return false, nil
+	}
+
+	appliedAPConfig := ocutil.FindAPConfig(appliedAPs, deviceConfig.Hostname)
+	if appliedAPConfig == nil {
+		return false, nil
+	}
+	aclChanged := !reflect.DeepEqual(ocutil.MACACLSSIDs(appliedAPs.Gasket), ocutil.MACACLSSIDs(officeAPs.Gasket))
+	if !reflect.DeepEqual(appliedAPConfig, apConfig) {
+		toggled, err := service.ToggleSSIDs(appliedAPConfig, apConfig, officeAPs.Gasket, deviceConfig.WLANINTFNames)
+		if err != nil || !toggled {
+			return false, err
+		}
+	} else if !aclChanged {
+		return false, nil
+	}
+
+	if aclChanged {
+		if err := service.ApplyMACACLs(apConfig, officeAPs.Gasket, deviceConfig.WLANINTFNames); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// sameExceptMACACLs checks whether two gasket configurations only differ in MAC ACLs.
+func sameExceptMACACLs(gasketA, gasketB *ocstruct.OpenconfigGasket_Gasket) bool {
+	var settingsA, settingsB ocstruct.OpenconfigGasket_Gasket
+	if gasketA != nil {
+		settingsA = *gasketA
+	}
+	if gasketB != nil {
+		settingsB = *gasketB
+	}
+	settingsA.MacAcls, settingsB.MacAcls = nil, nil
+	return reflect.DeepEqual(settingsA, settingsB)
+}
+
+// withoutVLAN removes a VLAN from a VLAN ID list.
+func withoutVLAN(vlanIDs []int, vlanID int) []int {
+	remaining := make([]int, 0, len(vlanIDs))
+	for _, id := range vlanIDs {
+		if id != vlanID {
+			remaining = append(remaining, id)
+		}
+	}
+	return remaining
+}
+
+// saveConfig saves the succeeded configuration to file.
+func saveConfig(configString string) error {
 	if err := syscmd.SaveToFile(runFolder, apConfigFileName, configString); err != nil {
 		return err
 	}
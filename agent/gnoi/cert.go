@@ -0,0 +1,418 @@
+/* Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gnoi
+
+import (
+	ctx "context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	log "github.com/golang/glog"
+	"github.com/google/link022/agent/certstore"
+	certpb "github.com/openconfig/gnoi/cert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// defaultKeySize is the size of the RSA keys generated for CSRs, unless the request asks for a larger one.
+	defaultKeySize = 2048
+	// maxKeySize is the size of the largest RSA key generated for CSRs.
+	maxKeySize = 4096
+	// grpcServerEndpoint is the endpoint reported for the certificate used by the gRPC server.
+	grpcServerEndpoint = "gnmi"
+)
+
+// finalizeTimeout is how long a rotated certificate is kept until the rotation is finalized.
+var finalizeTimeout = 5 * time.Minute
+
+// CertServer is the gNOI CertificateManagement service of the AP. The certificates are kept in a certificate store,
+// which the gRPC server loads its certificate from on each connection.
+type CertServer struct {
+	certs *certstore.Store
+	// inUse returns the ID of the certificate used by the gRPC server, empty if none.
+	inUse func() string
+
+	mu sync.Mutex
+	// busy indicates a certificate is being installed or rotated.
+	busy bool
+}
+
+// NewCertServer creates a CertServer managing the certificates of the given store.
+// inUse returns the ID of the certificate used by the gRPC server, which can not be revoked.
+func NewCertServer(certs *certstore.Store, inUse func() string) *CertServer {
+	return &CertServer{
+		certs: certs,
+		inUse: inUse,
+	}
+}
+
+// begin starts installing or rotating a certificate. Only one certificate is installed or rotated at a time.
+func (s *CertServer) begin() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.busy {
+		return status.Error(codes.Unavailable, "another certificate is being installed or rotated")
+	}
+	s.busy = true
+	return nil
+}
+
+func (s *CertServer) end() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.busy = false
+}
+
+// Install installs a new certificate. The client loads a certificate with its key pair, or a certificate signed
+// from a CSR generated by the AP.
+func (s *CertServer) Install(stream certpb.CertificateManagement_InstallServer) error {
+	if err := s.begin(); err != nil {
+		return err
+	}
+	defer s.end()
+
+	recv := func() (*certpb.GenerateCSRRequest, *certpb.LoadCertificateRequest, error) {
+		req, err := stream.Recv()
+		if err != nil {
+			return nil, nil, err
+		}
+		return req.GetGenerateCsr(), req.GetLoadCertificate(), nil
+	}
+	sendCSR := func(resp *certpb.GenerateCSRResponse) error {
+		return stream.Send(&certpb.InstallCertificateResponse{
+			InstallResponse: &certpb.InstallCertificateResponse_GeneratedCsr{GeneratedCsr: resp},
+		})
+	}
+	checkID := func(id string) error {
+		if err := certstore.ValidateID(id); err != nil {
+			return status.Error(codes.InvalidArgument, err.Error())
+		}
+		if s.certs.Exists(id) {
+			return status.Errorf(codes.AlreadyExists, "certificate %s already exists, it can only be rotated", id)
+		}
+		return nil
+	}
+	load, generatedKey, err := receiveCertificate(recv, sendCSR, checkID)
+	if err != nil {
+		return err
+	}
+
+	caBundle, err := caBundle(load)
+	if err != nil {
+		return err
+	}
+	if err := s.save(load, generatedKey, caBundle); err != nil {
+		return err
+	}
+	log.Infof("Installed certificate %s.", load.CertificateId)
+	return stream.Send(&certpb.InstallCertificateResponse{
+		InstallResponse: &certpb.InstallCertificateResponse_LoadCertificate{LoadCertificate: &certpb.LoadCertificateResponse{}},
+	})
+}
+
+// Rotate replaces an existing certificate. The new certificate is used right away, the client checks it on
+// new connections and finalizes the rotation. The previous certificate is restored if the rotation is not
+// finalized in time, or if the stream ends before.
+func (s *CertServer) Rotate(stream certpb.CertificateManagement_RotateServer) error {
+	if err := s.begin(); err != nil {
+		return err
+	}
+	defer s.end()
+
+	recv := func() (*certpb.GenerateCSRRequest, *certpb.LoadCertificateRequest, error) {
+		req, err := stream.Recv()
+		if err != nil {
+			return nil, nil, err
+		}
+		return req.GetGenerateCsr(), req.GetLoadCertificate(), nil
+	}
+	sendCSR := func(resp *certpb.GenerateCSRResponse) error {
+		return stream.Send(&certpb.RotateCertificateResponse{
+			RotateResponse: &certpb.RotateCertificateResponse_GeneratedCsr{GeneratedCsr: resp},
+		})
+	}
+	checkID := func(id string) error {
+		if err := certstore.ValidateID(id); err != nil {
+			return status.Error(codes.InvalidArgument, err.Error())
+		}
+		if !s.certs.Exists(id) {
+			return status.Errorf(codes.NotFound, "certificate %s does not exist, it has to be installed first", id)
+		}
+		return nil
+	}
+	load, generatedKey, err := receiveCertificate(recv, sendCSR, checkID)
+	if err != nil {
+		return err
+	}
+
+	id := load.CertificateId
+	previousCert, previousKey, _, err := s.certs.Load(id)
+	if err != nil {
+		return status.Errorf(codes.Internal, "unable to load certificate %s: %v", id, err)
+	}
+	previousCABundle, err := s.certs.CABundle()
+	if err != nil {
+		return status.Errorf(codes.Internal, "unable to load the CA certificates: %v", err)
+	}
+	caBundle, err := caBundle(load)
+	if err != nil {
+		return err
+	}
+	if err := s.save(load, generatedKey, caBundle); err != nil {
+		return err
+	}
+	rollback := func(reason error) error {
+		log.Warningf("Rolling back the rotation of certificate %s: %v", id, reason)
+		if err := s.certs.Save(id, previousCert, previousKey); err != nil {
+			log.Errorf("Failed to restore certificate %s: %v", id, err)
+		}
+		if caBundle != nil {
+			if err := s.certs.SaveCABundle(previousCABundle); err != nil {
+				log.Errorf("Failed to restore the CA certificates: %v", err)
+			}
+		}
+		return status.Errorf(codes.Aborted, "rotation of certificate %s rolled back: %v", id, reason)
+	}
+
+	if err := stream.Send(&certpb.RotateCertificateResponse{
+		RotateResponse: &certpb.RotateCertificateResponse_LoadCertificate{LoadCertificate: &certpb.LoadCertificateResponse{}},
+	}); err != nil {
+		return rollback(err)
+	}
+
+	finalized := make(chan error, 1)
+	go func() {
+		req, err := stream.Recv()
+		if err == nil && req.GetFinalizeRotation() == nil {
+			err = errors.New("expected the rotation to be finalized")
+		}
+		finalized <- err
+	}()
+	select {
+	case err := <-finalized:
+		if err != nil {
+			return rollback(err)
+		}
+	case <-time.After(finalizeTimeout):
+		return rollback(errors.New("the rotation was not finalized in time"))
+	}
+	log.Infof("Rotated certificate %s.", id)
+	return nil
+}
+
+// receiveCertificate receives the certificate to install or rotate, after generating a CSR if the client requests one.
+// checkID checks the certificate ID of the requests. It returns the certificate to load, and the PEM private key
+// generated for the CSR if any.
+func receiveCertificate(recv func() (*certpb.GenerateCSRRequest, *certpb.LoadCertificateRequest, error),
+	sendCSR func(*certpb.GenerateCSRResponse) error, checkID func(id string) error) (*certpb.LoadCertificateRequest, []byte, error) {
+	csrReq, load, err := recv()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var generatedKey []byte
+	if csrReq != nil {
+		if err := checkID(csrReq.CertificateId); err != nil {
+			return nil, nil, err
+		}
+		var csrPEM []byte
+		if csrPEM, generatedKey, err = generateCSR(csrReq.CsrParams); err != nil {
+			return nil, nil, err
+		}
+		if err := sendCSR(&certpb.GenerateCSRResponse{Csr: &certpb.CSR{Type: certpb.CertificateType_CT_X509, Csr: csrPEM}}); err != nil {
+			return nil, nil, err
+		}
+		if _, load, err = recv(); err != nil {
+			return nil, nil, err
+		}
+		if load != nil && load.CertificateId != csrReq.CertificateId {
+			return nil, nil, status.Errorf(codes.InvalidArgument, "certificate %s does not match the CSR of certificate %s", load.CertificateId, csrReq.CertificateId)
+		}
+	}
+	if load == nil {
+		return nil, nil, status.Error(codes.InvalidArgument, "expected a certificate to load")
+	}
+	if err := checkID(load.CertificateId); err != nil {
+		return nil, nil, err
+	}
+	return load, generatedKey, nil
+}
+
+// save saves the certificate of the given request with its private key, and the given CA certificates if any.
+// The private key of the request replaces the generated one.
+func (s *CertServer) save(load *certpb.LoadCertificateRequest, generatedKey, caBundle []byte) error {
+	if load.Certificate == nil || load.Certificate.Type != certpb.CertificateType_CT_X509 {
+		return status.Error(codes.InvalidArgument, "expected a X.509 certificate")
+	}
+	keyPEM := generatedKey
+	if load.KeyPair != nil && len(load.KeyPair.PrivateKey) != 0 {
+		keyPEM = load.KeyPair.PrivateKey
+	}
+	if keyPEM == nil {
+		return status.Error(codes.InvalidArgument, "no private key, a key pair has to be loaded with a certificate not signed from a CSR")
+	}
+	if err := s.certs.Save(load.CertificateId, load.Certificate.Certificate, keyPEM); err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	if caBundle == nil {
+		return nil
+	}
+	if err := s.certs.SaveCABundle(caBundle); err != nil {
+		return status.Errorf(codes.Internal, "unable to save the CA certificates: %v", err)
+	}
+	return nil
+}
+
+// caBundle returns the PEM bundle of the CA certificates of the given request, nil if it has none.
+func caBundle(load *certpb.LoadCertificateRequest) ([]byte, error) {
+	var bundle []byte
+	for _, caCert := range load.CaCertificates {
+		if caCert == nil || caCert.Type != certpb.CertificateType_CT_X509 {
+			return nil, status.Error(codes.InvalidArgument, "expected X.509 CA certificates")
+		}
+		bundle = append(bundle, caCert.Certificate...)
+		if len(bundle) != 0 && bundle[len(bundle)-1] != '\n' {
+			bundle = append(bundle, '\n')
+		}
+	}
+	if bundle != nil && !x509.NewCertPool().AppendCertsFromPEM(bundle) {
+		return nil, status.Error(codes.InvalidArgument, "no valid CA certificate")
+	}
+	return bundle, nil
+}
+
+// generateCSR generates a RSA private key, and a CSR with the given parameters. It returns the PEM CSR and private key.
+func generateCSR(params *certpb.CSRParams) ([]byte, []byte, error) {
+	if params == nil {
+		return nil, nil, status.Error(codes.InvalidArgument, "no CSR parameters")
+	}
+	if params.Type != certpb.CertificateType_CT_X509 || params.KeyType != certpb.KeyType_KT_RSA {
+		return nil, nil, status.Error(codes.InvalidArgument, "only X.509 CSRs with RSA keys can be generated")
+	}
+	keySize := int(params.MinKeySize)
+	if keySize > maxKeySize {
+		return nil, nil, status.Errorf(codes.InvalidArgument, "keys larger than %d bits can not be generated", maxKeySize)
+	}
+	if keySize < defaultKeySize {
+		keySize = defaultKeySize
+	}
+	key, err := rsa.GenerateKey(rand.Reader, keySize)
+	if err != nil {
+		return nil, nil, status.Errorf(codes.Internal, "unable to generate a key: %v", err)
+	}
+
+	template := &x509.CertificateRequest{
+		Subject: pkix.Name{
+			CommonName:         params.CommonName,
+			Country:            nonEmpty(params.Country),
+			Province:           nonEmpty(params.State),
+			Locality:           nonEmpty(params.City),
+			Organization:       nonEmpty(params.Organization),
+			OrganizationalUnit: nonEmpty(params.OrganizationalUnit),
+		},
+		EmailAddresses: nonEmpty(params.EmailId),
+	}
+	if ip := net.ParseIP(params.IpAddress); ip != nil {
+		template.IPAddresses = []net.IP{ip}
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, template, key)
+	if err != nil {
+		return nil, nil, status.Errorf(codes.Internal, "unable to generate a CSR: %v", err)
+	}
+	csrPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	return csrPEM, keyPEM, nil
+}
+
+// GetCertificates returns the certificates of the store. The one used by the gRPC server has its endpoint.
+func (s *CertServer) GetCertificates(c ctx.Context, req *certpb.GetCertificatesRequest) (*certpb.GetCertificatesResponse, error) {
+	ids, err := s.certs.IDs()
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "unable to list the certificates: %v", err)
+	}
+	inUse := s.inUse()
+	resp := &certpb.GetCertificatesResponse{}
+	for _, id := range ids {
+		certPEM, _, modTime, err := s.certs.Load(id)
+		if err != nil {
+			log.Warningf("Failed to load certificate %s: %v", id, err)
+			continue
+		}
+		info := &certpb.CertificateInfo{
+			CertificateId:    id,
+			Certificate:      &certpb.Certificate{Type: certpb.CertificateType_CT_X509, Certificate: certPEM},
+			ModificationTime: modTime.UnixNano(),
+		}
+		if id == inUse {
+			info.Endpoints = []*certpb.Endpoint{{Type: certpb.Endpoint_EP_DAEMON, Endpoint: grpcServerEndpoint}}
+		}
+		resp.CertificateInfo = append(resp.CertificateInfo, info)
+	}
+	return resp, nil
+}
+
+// RevokeCertificates removes the given certificates from the store. The certificate used by the gRPC server
+// can not be revoked.
+func (s *CertServer) RevokeCertificates(c ctx.Context, req *certpb.RevokeCertificatesRequest) (*certpb.RevokeCertificatesResponse, error) {
+	inUse := s.inUse()
+	resp := &certpb.RevokeCertificatesResponse{}
+	for _, id := range req.CertificateId {
+		var err error
+		switch {
+		case id == inUse:
+			err = errors.New("the certificate is used by the gRPC server")
+		case !s.certs.Exists(id):
+			err = errors.New("the certificate does not exist")
+		default:
+			err = s.certs.Remove(id)
+		}
+		if err != nil {
+			resp.CertificateRevocationError = append(resp.CertificateRevocationError, &certpb.CertificateRevocationError{
+				CertificateId: id,
+				ErrorMessage:  err.Error(),
+			})
+			continue
+		}
+		log.Infof("Revoked certificate %s.", id)
+		resp.RevokedCertificateId = append(resp.RevokedCertificateId, id)
+	}
+	return resp, nil
+}
+
+// CanGenerateCSR checks whether the AP can generate a CSR with the given key and certificate types.
+func (s *CertServer) CanGenerateCSR(c ctx.Context, req *certpb.CanGenerateCSRRequest) (*certpb.CanGenerateCSRResponse, error) {
+	return &certpb.CanGenerateCSRResponse{
+		CanGenerate: req.CertificateType == certpb.CertificateType_CT_X509 && req.KeyType == certpb.KeyType_KT_RSA && req.KeySize <= maxKeySize,
+	}, nil
+}
+
+// nonEmpty returns a slice with the given value, nil if it is empty.
+func nonEmpty(value string) []string {
+	if value == "" {
+		return nil
+	}
+	return []string{value}
+}
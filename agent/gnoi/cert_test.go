@@ -0,0 +1,306 @@
+/* Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gnoi
+
+import (
+	"bytes"
+	ctx "context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/link022/agent/certstore"
+	certpb "github.com/openconfig/gnoi/cert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// installStream answers the Install RPC with the requests returned by recv, which gets the responses sent so far.
+type installStream struct {
+	grpc.ServerStream
+	recv      func(responses []*certpb.InstallCertificateResponse) (*certpb.InstallCertificateRequest, error)
+	responses []*certpb.InstallCertificateResponse
+}
+
+func (s *installStream) Send(resp *certpb.InstallCertificateResponse) error {
+	s.responses = append(s.responses, resp)
+	return nil
+}
+
+func (s *installStream) Recv() (*certpb.InstallCertificateRequest, error) {
+	return s.recv(s.responses)
+}
+
+// rotateStream answers the Rotate RPC with the given requests, then with io.EOF.
+type rotateStream struct {
+	grpc.ServerStream
+	requests  []*certpb.RotateCertificateRequest
+	responses []*certpb.RotateCertificateResponse
+}
+
+func (s *rotateStream) Send(resp *certpb.RotateCertificateResponse) error {
+	s.responses = append(s.responses, resp)
+	return nil
+}
+
+func (s *rotateStream) Recv() (*certpb.RotateCertificateRequest, error) {
+	if len(s.requests) == 0 {
+		return nil, io.EOF
+	}
+	req := s.requests[0]
+	s.requests = s.requests[1:]
+	return req, nil
+}
+
+// testCA is a self-signed CA signing the test certificates.
+type testCA struct {
+	cert    *x509.Certificate
+	certPEM []byte
+	key     crypto.Signer
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Unable to generate a key. Error: %v.", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Unable to create the CA certificate. Error: %v.", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCA{cert: cert, certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), key: key}
+}
+
+// sign returns a PEM certificate for the given public key, signed by the CA.
+func (ca *testCA) sign(t *testing.T, serial int64, pub crypto.PublicKey) []byte {
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "ap-1"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, pub, ca.key)
+	if err != nil {
+		t.Fatalf("Unable to sign a certificate. Error: %v.", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+// keyPair returns a PEM certificate signed by the CA and its PEM private key.
+func (ca *testCA) keyPair(t *testing.T, serial int64) ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Unable to generate a key. Error: %v.", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ca.sign(t, serial, &key.PublicKey), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+// testCertServer creates a CertServer with a certificate store in a temp folder, the gRPC server using
+// the "gnmi" certificate. It returns the CertServer, its store and a func removing the folder.
+func testCertServer(t *testing.T) (*CertServer, *certstore.Store, func()) {
+	dir, err := ioutil.TempDir("", "link022")
+	if err != nil {
+		t.Fatalf("Unable to create a temp folder.")
+	}
+	certs := certstore.New(dir, "")
+	return NewCertServer(certs, func() string { return "gnmi" }), certs, func() { os.RemoveAll(dir) }
+}
+
+func TestInstallWithCSR(t *testing.T) {
+	s, certs, cleanup := testCertServer(t)
+	defer cleanup()
+	ca := newTestCA(t)
+
+	var certPEM []byte
+	stream := &installStream{recv: func(responses []*certpb.InstallCertificateResponse) (*certpb.InstallCertificateRequest, error) {
+		if len(responses) == 0 {
+			return &certpb.InstallCertificateRequest{InstallRequest: &certpb.InstallCertificateRequest_GenerateCsr{GenerateCsr: &certpb.GenerateCSRRequest{
+				CertificateId: "gnmi",
+				CsrParams: &certpb.CSRParams{
+					Type:       certpb.CertificateType_CT_X509,
+					KeyType:    certpb.KeyType_KT_RSA,
+					CommonName: "ap-1",
+					IpAddress:  "192.168.11.1",
+				},
+			}}}, nil
+		}
+		block, _ := pem.Decode(responses[0].GetGeneratedCsr().Csr.Csr)
+		if block == nil {
+			t.Fatal("The generated CSR is not PEM encoded.")
+		}
+		csr, err := x509.ParseCertificateRequest(block.Bytes)
+		if err != nil {
+			t.Fatalf("Invalid CSR. Error: %v.", err)
+		}
+		if csr.Subject.CommonName != "ap-1" || len(csr.IPAddresses) != 1 || csr.IPAddresses[0].String() != "192.168.11.1" {
+			t.Errorf("Incorrect CSR subject %v, IP addresses %v.", csr.Subject, csr.IPAddresses)
+		}
+		certPEM = ca.sign(t, 2, csr.PublicKey)
+		return &certpb.InstallCertificateRequest{InstallRequest: &certpb.InstallCertificateRequest_LoadCertificate{LoadCertificate: &certpb.LoadCertificateRequest{
+			CertificateId:  "gnmi",
+			Certificate:    &certpb.Certificate{Type: certpb.CertificateType_CT_X509, Certificate: certPEM},
+			CaCertificates: []*certpb.Certificate{{Type: certpb.CertificateType_CT_X509, Certificate: ca.certPEM}},
+		}}}, nil
+	}}
+	if err := s.Install(stream); err != nil {
+		t.Fatalf("Installing a certificate failed. Error: %v.", err)
+	}
+	if len(stream.responses) != 2 {
+		t.Errorf("Expected a CSR and a load response, got %+v.", stream.responses)
+	}
+	if got, _, _, err := certs.Load("gnmi"); err != nil || !bytes.Equal(got, certPEM) {
+		t.Errorf("Incorrect installed certificate (error: %v).", err)
+	}
+	if got, err := certs.CABundle(); err != nil || !bytes.Equal(got, ca.certPEM) {
+		t.Errorf("Incorrect CA certificates (error: %v).", err)
+	}
+
+	// An installed certificate can only be rotated.
+	stream = &installStream{recv: func([]*certpb.InstallCertificateResponse) (*certpb.InstallCertificateRequest, error) {
+		return &certpb.InstallCertificateRequest{InstallRequest: &certpb.InstallCertificateRequest_GenerateCsr{GenerateCsr: &certpb.GenerateCSRRequest{CertificateId: "gnmi"}}}, nil
+	}}
+	if err := s.Install(stream); status.Code(err) != codes.AlreadyExists {
+		t.Errorf("Expected an existing certificate, got %v.", err)
+	}
+}
+
+func TestRotate(t *testing.T) {
+	s, certs, cleanup := testCertServer(t)
+	defer cleanup()
+	ca := newTestCA(t)
+	oldCert, oldKey := ca.keyPair(t, 2)
+	if err := certs.Save("gnmi", oldCert, oldKey); err != nil {
+		t.Fatalf("Unable to save a certificate. Error: %v.", err)
+	}
+	newCert, newKey := ca.keyPair(t, 3)
+	load := &certpb.RotateCertificateRequest{RotateRequest: &certpb.RotateCertificateRequest_LoadCertificate{LoadCertificate: &certpb.LoadCertificateRequest{
+		CertificateId: "gnmi",
+		Certificate:   &certpb.Certificate{Type: certpb.CertificateType_CT_X509, Certificate: newCert},
+		KeyPair:       &certpb.KeyPair{PrivateKey: newKey},
+	}}}
+
+	// The previous certificate is restored when the stream ends before the rotation is finalized.
+	stream := &rotateStream{requests: []*certpb.RotateCertificateRequest{load}}
+	if err := s.Rotate(stream); status.Code(err) != codes.Aborted {
+		t.Errorf("Expected an aborted rotation, got %v.", err)
+	}
+	if got, _, _, err := certs.Load("gnmi"); err != nil || !bytes.Equal(got, oldCert) {
+		t.Errorf("The previous certificate was not restored (error: %v).", err)
+	}
+
+	stream = &rotateStream{requests: []*certpb.RotateCertificateRequest{
+		load,
+		{RotateRequest: &certpb.RotateCertificateRequest_FinalizeRotation{FinalizeRotation: &certpb.FinalizeRequest{}}},
+	}}
+	if err := s.Rotate(stream); err != nil {
+		t.Fatalf("Rotating the certificate failed. Error: %v.", err)
+	}
+	if got, _, _, err := certs.Load("gnmi"); err != nil || !bytes.Equal(got, newCert) {
+		t.Errorf("The certificate was not rotated (error: %v).", err)
+	}
+
+	// Only installed certificates can be rotated.
+	stream = &rotateStream{requests: []*certpb.RotateCertificateRequest{
+		{RotateRequest: &certpb.RotateCertificateRequest_GenerateCsr{GenerateCsr: &certpb.GenerateCSRRequest{CertificateId: "other"}}},
+	}}
+	if err := s.Rotate(stream); status.Code(err) != codes.NotFound {
+		t.Errorf("Expected a missing certificate, got %v.", err)
+	}
+}
+
+func TestGetAndRevokeCertificates(t *testing.T) {
+	s, certs, cleanup := testCertServer(t)
+	defer cleanup()
+	ca := newTestCA(t)
+	for i, id := range []string{"gnmi", "old"} {
+		cert, key := ca.keyPair(t, int64(i+2))
+		if err := certs.Save(id, cert, key); err != nil {
+			t.Fatalf("Unable to save a certificate. Error: %v.", err)
+		}
+	}
+	c := ctx.Background()
+
+	resp, err := s.GetCertificates(c, &certpb.GetCertificatesRequest{})
+	if err != nil || len(resp.CertificateInfo) != 2 {
+		t.Fatalf("Incorrect certificates %+v (error: %v).", resp, err)
+	}
+	wantEndpoints := []*certpb.Endpoint{{Type: certpb.Endpoint_EP_DAEMON, Endpoint: "gnmi"}}
+	if info := resp.CertificateInfo[0]; info.CertificateId != "gnmi" || !reflect.DeepEqual(info.Endpoints, wantEndpoints) || info.ModificationTime == 0 {
+		t.Errorf("Incorrect certificate info %+v.", info)
+	}
+	if info := resp.CertificateInfo[1]; info.CertificateId != "old" || info.Endpoints != nil {
+		t.Errorf("Incorrect certificate info %+v.", info)
+	}
+
+	revoked, err := s.RevokeCertificates(c, &certpb.RevokeCertificatesRequest{CertificateId: []string{"gnmi", "old", "missing"}})
+	if err != nil {
+		t.Fatalf("Revoking certificates failed. Error: %v.", err)
+	}
+	if !reflect.DeepEqual(revoked.RevokedCertificateId, []string{"old"}) || len(revoked.CertificateRevocationError) != 2 {
+		t.Errorf("Incorrect revoked certificates %+v.", revoked)
+	}
+	if certs.Exists("old") || !certs.Exists("gnmi") {
+		t.Error("Incorrect certificates after revoking.")
+	}
+}
+
+func TestCanGenerateCSR(t *testing.T) {
+	s, _, cleanup := testCertServer(t)
+	defer cleanup()
+	tests := []struct {
+		req  *certpb.CanGenerateCSRRequest
+		want bool
+	}{
+		{&certpb.CanGenerateCSRRequest{CertificateType: certpb.CertificateType_CT_X509, KeyType: certpb.KeyType_KT_RSA, KeySize: 2048}, true},
+		{&certpb.CanGenerateCSRRequest{CertificateType: certpb.CertificateType_CT_X509, KeyType: certpb.KeyType_KT_RSA, KeySize: 8192}, false},
+		{&certpb.CanGenerateCSRRequest{CertificateType: certpb.CertificateType_CT_UNKNOWN, KeyType: certpb.KeyType_KT_RSA}, false},
+	}
+	for _, test := range tests {
+		if resp, err := s.CanGenerateCSR(ctx.Background(), test.req); err != nil || resp.CanGenerate != test.want {
+			t.Errorf("Incorrect result for %+v (got: %+v, want: %v, error: %v).", test.req, resp, test.want, err)
+		}
+	}
+}
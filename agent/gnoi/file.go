@@ -0,0 +1,383 @@
+/* Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gnoi
+
+import (
+	"bytes"
+	ctx "context"
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+
+	log "github.com/golang/glog"
+	filepb "github.com/openconfig/gnoi/file"
+	"github.com/openconfig/gnoi/types"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// fileChunkSize is the size of the chunks the files are sent in.
+	fileChunkSize = 64 * 1024
+	// defaultFilePermissions are the permissions of the files put without permissions.
+	defaultFilePermissions = 0644
+)
+
+// maxPutSize is the size of the largest file which can be put on the AP.
+var maxPutSize int64 = 64 * 1024 * 1024
+
+// FileServer is the gNOI File service of the AP. Only the files in a list of allowed folders can be accessed,
+// e.g. to pull the hostapd configurations and the logs of the agent. Files can only be put in a dedicated folder,
+// so that the files the agent generates and runs with, e.g. the firewall rules, can not be replaced.
+type FileServer struct {
+	dirs    []string
+	putDirs []string
+}
+
+// NewFileServer creates a FileServer giving access to the files in the given folders and their subfolders,
+// and putting files in putDir and its subfolders.
+func NewFileServer(dirs []string, putDir string) *FileServer {
+	s := &FileServer{}
+	for _, dir := range append(dirs, putDir) {
+		if dir != "" {
+			s.dirs = append(s.dirs, filepath.Clean(dir))
+		}
+	}
+	if putDir != "" {
+		s.putDirs = []string{filepath.Clean(putDir)}
+	}
+	return s
+}
+
+// checkPath resolves the given absolute path, and checks that it is in one of the given allowed folders.
+// The allowed folders themselves are only accepted if allowDir is set.
+//
+// The path may be changed after it is checked, e.g. replaced with a symbolic link. The files are then accessed with
+// openChecked, which checks the opened file again.
+func checkPath(p string, dirs []string, allowDir bool) (string, error) {
+	if !filepath.IsAbs(p) {
+		return "", status.Errorf(codes.InvalidArgument, "%q is not an absolute path", p)
+	}
+	resolved, err := resolvePath(filepath.Clean(p))
+	if err != nil {
+		return "", status.Errorf(codes.InvalidArgument, "unable to resolve %q: %v", p, err)
+	}
+	if !inDirs(resolved, dirs, allowDir) {
+		return "", status.Errorf(codes.PermissionDenied, "%q is not in an allowed folder", p)
+	}
+	return resolved, nil
+}
+
+// inDirs checks whether the given resolved path is in one of the given folders, or is one of them if allowDir is set.
+func inDirs(resolved string, dirs []string, allowDir bool) bool {
+	for _, dir := range dirs {
+		// The allowed folders may be symbolic links too, e.g. /var/run, and the put folder may not exist yet.
+		resolvedDir, err := resolvePath(dir)
+		if err != nil {
+			continue
+		}
+		rel, err := filepath.Rel(resolvedDir, resolved)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		if rel == "." && !allowDir {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+// openChecked opens a path resolved by checkPath without following symbolic links, and checks that the opened file
+// is still in one of the given folders. Files are accessed through the opened file or folder from then on,
+// so that the path can not be redirected to another file meanwhile.
+func openChecked(resolved string, flag int, dirs []string, allowDir bool) (*os.File, error) {
+	// Opening a FIFO must not block before it is found not to be a regular file.
+	f, err := os.OpenFile(resolved, flag|syscall.O_NOFOLLOW|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return nil, fileError(err)
+	}
+	opened, err := os.Readlink(fdPath(f, ""))
+	if err != nil {
+		f.Close()
+		return nil, fileError(err)
+	}
+	if !inDirs(opened, dirs, allowDir) {
+		f.Close()
+		return nil, status.Errorf(codes.PermissionDenied, "%q was moved out of the allowed folders", resolved)
+	}
+	return f, nil
+}
+
+// fdPath returns the path of a file in the given opened folder, or of the opened file itself if name is empty.
+// It does not depend on the path the folder was opened with.
+func fdPath(dir *os.File, name string) string {
+	return filepath.Join(fmt.Sprintf("/proc/self/fd/%d", dir.Fd()), name)
+}
+
+// resolvePath resolves the symbolic links of the given clean path. The end of the path may not exist yet.
+func resolvePath(p string) (string, error) {
+	resolved, err := filepath.EvalSymlinks(p)
+	if err == nil {
+		return resolved, nil
+	}
+	if !os.IsNotExist(err) || p == filepath.Dir(p) {
+		return "", err
+	}
+	dir, err := resolvePath(filepath.Dir(p))
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, filepath.Base(p)), nil
+}
+
+// fileError converts the error of a file operation to a gRPC status.
+func fileError(err error) error {
+	if pathErr, ok := err.(*os.PathError); ok && pathErr.Err == syscall.ELOOP {
+		return status.Errorf(codes.PermissionDenied, "%s is a symbolic link", pathErr.Path)
+	}
+	switch {
+	case os.IsNotExist(err):
+		return status.Error(codes.NotFound, err.Error())
+	case os.IsPermission(err):
+		return status.Error(codes.PermissionDenied, err.Error())
+	default:
+		return status.Error(codes.Internal, err.Error())
+	}
+}
+
+// Get streams the content of a file in chunks, followed by its SHA256 hash.
+func (s *FileServer) Get(req *filepb.GetRequest, stream filepb.File_GetServer) error {
+	p, err := checkPath(req.RemoteFile, s.dirs, false)
+	if err != nil {
+		return err
+	}
+	f, err := openChecked(p, os.O_RDONLY, s.dirs, false)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if info, err := f.Stat(); err != nil {
+		return fileError(err)
+	} else if !info.Mode().IsRegular() {
+		return status.Errorf(codes.InvalidArgument, "%q is not a regular file", req.RemoteFile)
+	}
+
+	h := sha256.New()
+	buf := make([]byte, fileChunkSize)
+	for {
+		n, err := f.Read(buf)
+		if n > 0 {
+			h.Write(buf[:n])
+			if err := stream.Send(&filepb.GetResponse{Response: &filepb.GetResponse_Contents{Contents: append([]byte(nil), buf[:n]...)}}); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fileError(err)
+		}
+	}
+	return stream.Send(&filepb.GetResponse{Response: &filepb.GetResponse_Hash{Hash: &types.HashType{
+		Method: types.HashType_SHA256,
+		Hash:   h.Sum(nil),
+	}}})
+}
+
+// Put writes a file from the streamed chunks. The file is only replaced once its hash is received and checked.
+// Files can only be put in the put folder.
+func (s *FileServer) Put(stream filepb.File_PutServer) error {
+	req, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	open := req.GetOpen()
+	if open == nil {
+		return status.Error(codes.InvalidArgument, "expected the file details first")
+	}
+	p, err := checkPath(open.RemoteFile, s.putDirs, false)
+	if err != nil {
+		return err
+	}
+	perm, err := fileMode(open.Permissions)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return fileError(err)
+	}
+	dir, err := openChecked(filepath.Dir(p), os.O_RDONLY|syscall.O_DIRECTORY, s.putDirs, true)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	tmp, err := ioutil.TempFile(fdPath(dir, ""), "."+filepath.Base(p))
+	if err != nil {
+		return fileError(err)
+	}
+	// The temp file is renamed once complete, removing it then fails harmlessly.
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	hashes := map[types.HashType_HashMethod]hash.Hash{
+		types.HashType_MD5:    md5.New(),
+		types.HashType_SHA256: sha256.New(),
+		types.HashType_SHA512: sha512.New(),
+	}
+	writers := []io.Writer{tmp}
+	for _, h := range hashes {
+		writers = append(writers, h)
+	}
+	w := io.MultiWriter(writers...)
+
+	var size int64
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			return status.Error(codes.InvalidArgument, "the file was sent without its hash")
+		}
+		if err != nil {
+			return err
+		}
+		if fileHash := req.GetHash(); fileHash != nil {
+			h, ok := hashes[fileHash.Method]
+			if !ok {
+				return status.Errorf(codes.InvalidArgument, "unsupported hash method %v", fileHash.Method)
+			}
+			if !bytes.Equal(h.Sum(nil), fileHash.Hash) {
+				return status.Error(codes.DataLoss, "the hash of the file does not match")
+			}
+			break
+		}
+		contents := req.GetContents()
+		if size += int64(len(contents)); size > maxPutSize {
+			return status.Errorf(codes.ResourceExhausted, "files are limited to %d bytes", maxPutSize)
+		}
+		if _, err := w.Write(contents); err != nil {
+			return fileError(err)
+		}
+	}
+
+	if err := tmp.Chmod(perm); err != nil {
+		return fileError(err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fileError(err)
+	}
+	if err := os.Rename(tmp.Name(), fdPath(dir, filepath.Base(p))); err != nil {
+		return fileError(err)
+	}
+	log.Infof("Put file %s (%d bytes).", p, size)
+	return stream.SendAndClose(&filepb.PutResponse{})
+}
+
+// Stat returns the information of a file, or of the files of a folder.
+func (s *FileServer) Stat(c ctx.Context, req *filepb.StatRequest) (*filepb.StatResponse, error) {
+	p, err := checkPath(req.Path, s.dirs, true)
+	if err != nil {
+		return nil, err
+	}
+	f, err := openChecked(p, os.O_RDONLY, s.dirs, true)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, fileError(err)
+	}
+	if !info.IsDir() {
+		return &filepb.StatResponse{Stats: []*filepb.StatInfo{statInfo(req.Path, info)}}, nil
+	}
+
+	infos, err := f.Readdir(-1)
+	if err != nil {
+		return nil, fileError(err)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name() < infos[j].Name() })
+	resp := &filepb.StatResponse{}
+	for _, info := range infos {
+		resp.Stats = append(resp.Stats, statInfo(filepath.Join(req.Path, info.Name()), info))
+	}
+	return resp, nil
+}
+
+// statInfo converts the information of a file.
+func statInfo(p string, info os.FileInfo) *filepb.StatInfo {
+	return &filepb.StatInfo{
+		Path:         p,
+		LastModified: uint64(info.ModTime().UnixNano()),
+		Permissions:  filePermissions(info.Mode()),
+		Size:         uint64(info.Size()),
+	}
+}
+
+// Remove removes a file. Folders can not be removed.
+func (s *FileServer) Remove(c ctx.Context, req *filepb.RemoveRequest) (*filepb.RemoveResponse, error) {
+	p, err := checkPath(req.RemoteFile, s.dirs, false)
+	if err != nil {
+		return nil, err
+	}
+	dir, err := openChecked(filepath.Dir(p), os.O_RDONLY|syscall.O_DIRECTORY, s.dirs, true)
+	if err != nil {
+		return nil, err
+	}
+	defer dir.Close()
+	name := fdPath(dir, filepath.Base(p))
+	info, err := os.Lstat(name)
+	if err != nil {
+		return nil, fileError(err)
+	}
+	if info.IsDir() {
+		return nil, status.Errorf(codes.InvalidArgument, "%q is a folder", req.RemoteFile)
+	}
+	if err := os.Remove(name); err != nil {
+		return nil, fileError(err)
+	}
+	log.Infof("Removed file %s.", p)
+	return &filepb.RemoveResponse{}, nil
+}
+
+// filePermissions returns the permissions of a file mode in the gNOI format, the octal digits as a decimal number,
+// e.g. 644 for rw-r--r--.
+func filePermissions(mode os.FileMode) uint32 {
+	perm, _ := strconv.ParseUint(strconv.FormatUint(uint64(mode.Perm()), 8), 10, 32)
+	return uint32(perm)
+}
+
+// fileMode parses permissions in the gNOI format. It returns the default permissions if they are not set.
+func fileMode(permissions uint32) (os.FileMode, error) {
+	if permissions == 0 {
+		return defaultFilePermissions, nil
+	}
+	perm, err := strconv.ParseUint(strconv.FormatUint(uint64(permissions), 10), 8, 32)
+	if err != nil || perm > 0777 {
+		return 0, status.Errorf(codes.InvalidArgument, "invalid permissions %d", permissions)
+	}
+	return os.FileMode(perm), nil
+}
@@ -0,0 +1,251 @@
+/* Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gnoi
+
+import (
+	"bytes"
+	ctx "context"
+	"crypto/sha256"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	filepb "github.com/openconfig/gnoi/file"
+	"github.com/openconfig/gnoi/types"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// getStream collects the responses of a Get RPC.
+type getStream struct {
+	grpc.ServerStream
+	responses []*filepb.GetResponse
+}
+
+func (s *getStream) Send(resp *filepb.GetResponse) error {
+	s.responses = append(s.responses, resp)
+	return nil
+}
+
+// putStream answers the Put RPC with the given requests, then with io.EOF.
+type putStream struct {
+	grpc.ServerStream
+	requests []*filepb.PutRequest
+	closed   bool
+}
+
+func (s *putStream) Recv() (*filepb.PutRequest, error) {
+	if len(s.requests) == 0 {
+		return nil, io.EOF
+	}
+	req := s.requests[0]
+	s.requests = s.requests[1:]
+	return req, nil
+}
+
+func (s *putStream) SendAndClose(*filepb.PutResponse) error {
+	s.closed = true
+	return nil
+}
+
+// testFileServer creates a FileServer giving access to a temp folder, next to a folder it can not access.
+// Files can be put in the "files" subfolder of the allowed folder.
+// It returns the FileServer, the allowed and the other folder, and a func removing them.
+func testFileServer(t *testing.T) (*FileServer, string, string, func()) {
+	tempFolder, err := ioutil.TempDir("", "link022")
+	if err != nil {
+		t.Fatalf("Unable to create a temp folder.")
+	}
+	allowed, other := path.Join(tempFolder, "run"), path.Join(tempFolder, "other")
+	for _, dir := range []string{allowed, other} {
+		if err := os.Mkdir(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return NewFileServer([]string{allowed}, path.Join(allowed, "files")), allowed, other, func() { os.RemoveAll(tempFolder) }
+}
+
+func TestCheckPath(t *testing.T) {
+	s, allowed, other, cleanup := testFileServer(t)
+	defer cleanup()
+	if err := os.Symlink(other, path.Join(allowed, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := checkPath(path.Join(allowed, "hostapd_wlan0.conf"), s.dirs, false); err != nil {
+		t.Errorf("Expected an allowed path. Error: %v.", err)
+	}
+	for _, p := range []string{
+		path.Join(other, "secret"),
+		path.Join(allowed, "..", "other", "secret"),
+		path.Join(allowed, "link", "secret"),
+		allowed,
+	} {
+		if _, err := checkPath(p, s.dirs, false); status.Code(err) != codes.PermissionDenied {
+			t.Errorf("Expected %s to be denied, got %v.", p, err)
+		}
+	}
+	if _, err := checkPath("run/hostapd_wlan0.conf", s.dirs, false); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected a relative path to be invalid, got %v.", err)
+	}
+}
+
+func TestOpenChecked(t *testing.T) {
+	s, allowed, other, cleanup := testFileServer(t)
+	defer cleanup()
+	if err := ioutil.WriteFile(path.Join(other, "secret"), []byte("secret"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path.Join(allowed, "hostapd_wlan0.conf"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := openChecked(path.Join(allowed, "hostapd_wlan0.conf"), os.O_RDONLY, s.dirs, false)
+	if err != nil {
+		t.Fatalf("Opening an allowed file failed. Error: %v.", err)
+	}
+	f.Close()
+
+	// Symbolic links swapped in after the path was checked are not followed.
+	if err := os.Symlink(path.Join(other, "secret"), path.Join(allowed, "secret")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(other, path.Join(allowed, "link")); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []string{path.Join(allowed, "secret"), path.Join(allowed, "link", "secret")} {
+		if f, err := openChecked(p, os.O_RDONLY, s.dirs, false); status.Code(err) != codes.PermissionDenied {
+			t.Errorf("Expected %s to be denied, got %v.", p, err)
+			if f != nil {
+				f.Close()
+			}
+		}
+	}
+}
+
+func TestGetFile(t *testing.T) {
+	s, allowed, _, cleanup := testFileServer(t)
+	defer cleanup()
+	content := bytes.Repeat([]byte("interface=wlan0\n"), fileChunkSize/8)
+	p := path.Join(allowed, "hostapd_wlan0.conf")
+	if err := ioutil.WriteFile(p, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	stream := &getStream{}
+	if err := s.Get(&filepb.GetRequest{RemoteFile: p}, stream); err != nil {
+		t.Fatalf("Getting the file failed. Error: %v.", err)
+	}
+	if len(stream.responses) != 3 {
+		t.Fatalf("Expected 2 chunks and a hash, got %d responses.", len(stream.responses))
+	}
+	var got []byte
+	for _, resp := range stream.responses[:2] {
+		got = append(got, resp.GetContents()...)
+	}
+	if !bytes.Equal(got, content) {
+		t.Error("Incorrect file content.")
+	}
+	sum := sha256.Sum256(content)
+	if h := stream.responses[2].GetHash(); h == nil || h.Method != types.HashType_SHA256 || !bytes.Equal(h.Hash, sum[:]) {
+		t.Errorf("Incorrect hash %+v.", h)
+	}
+
+	if err := s.Get(&filepb.GetRequest{RemoteFile: path.Join(allowed, "missing")}, &getStream{}); status.Code(err) != codes.NotFound {
+		t.Errorf("Expected a missing file, got %v.", err)
+	}
+}
+
+func TestPutFile(t *testing.T) {
+	s, allowed, _, cleanup := testFileServer(t)
+	defer cleanup()
+	p := path.Join(allowed, "files", "captures", "test.pcap")
+	content := []byte("some content")
+	sum := sha256.Sum256(content)
+	requests := func(h []byte) []*filepb.PutRequest {
+		return []*filepb.PutRequest{
+			{Request: &filepb.PutRequest_Open{Open: &filepb.PutRequest_Details{RemoteFile: p, Permissions: 600}}},
+			{Request: &filepb.PutRequest_Contents{Contents: content[:4]}},
+			{Request: &filepb.PutRequest_Contents{Contents: content[4:]}},
+			{Request: &filepb.PutRequest_Hash{Hash: &types.HashType{Method: types.HashType_SHA256, Hash: h}}},
+		}
+	}
+
+	// Files can only be put in the put folder.
+	outside := []*filepb.PutRequest{{Request: &filepb.PutRequest_Open{Open: &filepb.PutRequest_Details{RemoteFile: path.Join(allowed, "hostapd_wlan0.conf")}}}}
+	if err := s.Put(&putStream{requests: outside}); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("Expected a file outside the put folder to be denied, got %v.", err)
+	}
+
+	// The file is not written if its hash does not match.
+	if err := s.Put(&putStream{requests: requests([]byte("wrong"))}); status.Code(err) != codes.DataLoss {
+		t.Errorf("Expected a hash mismatch, got %v.", err)
+	}
+	if _, err := os.Stat(p); !os.IsNotExist(err) {
+		t.Errorf("Expected no file with a hash mismatch, got %v.", err)
+	}
+	// Nor if the stream ends before the hash.
+	if err := s.Put(&putStream{requests: requests(nil)[:3]}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected a missing hash, got %v.", err)
+	}
+
+	stream := &putStream{requests: requests(sum[:])}
+	if err := s.Put(stream); err != nil || !stream.closed {
+		t.Fatalf("Putting the file failed. Error: %v.", err)
+	}
+	if got, err := ioutil.ReadFile(p); err != nil || !bytes.Equal(got, content) {
+		t.Errorf("Incorrect file content %q (error: %v).", got, err)
+	}
+
+	resp, err := s.Stat(ctx.Background(), &filepb.StatRequest{Path: path.Dir(p)})
+	if err != nil || len(resp.Stats) != 1 {
+		t.Fatalf("Incorrect stats %+v (error: %v).", resp, err)
+	}
+	if info := resp.Stats[0]; info.Path != p || info.Permissions != 600 || info.Size != uint64(len(content)) || info.LastModified == 0 {
+		t.Errorf("Incorrect file info %+v.", info)
+	}
+
+	if _, err := s.Remove(ctx.Background(), &filepb.RemoveRequest{RemoteFile: p}); err != nil {
+		t.Fatalf("Removing the file failed. Error: %v.", err)
+	}
+	if _, err := os.Stat(p); !os.IsNotExist(err) {
+		t.Errorf("Expected the file to be removed, got %v.", err)
+	}
+	if _, err := s.Remove(ctx.Background(), &filepb.RemoveRequest{RemoteFile: path.Dir(p)}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected a folder not to be removed, got %v.", err)
+	}
+}
+
+func TestFileMode(t *testing.T) {
+	for _, test := range []struct {
+		permissions uint32
+		want        os.FileMode
+	}{{0, 0644}, {600, 0600}, {755, 0755}} {
+		if got, err := fileMode(test.permissions); err != nil || got != test.want {
+			t.Errorf("Incorrect mode for %d (got: %o, want: %o, error: %v).", test.permissions, got, test.want, err)
+		}
+		if test.permissions != 0 && filePermissions(test.want) != test.permissions {
+			t.Errorf("Incorrect permissions for %o: %d.", test.want, filePermissions(test.want))
+		}
+	}
+	for _, permissions := range []uint32{8, 1777} {
+		if _, err := fileMode(permissions); err == nil {
+			t.Errorf("Expected %d to be invalid.", permissions)
+		}
+	}
+}
@@ -0,0 +1,529 @@
+/* Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gnoi
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	ctx "context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/golang/glog"
+	"github.com/google/link022/agent/syscmd"
+	ospb "github.com/openconfig/gnoi/os"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// The OS folder has two slots, each with an agent version. The agent runs from the slot of the current link,
+// the previous link is the slot restored if an activated version does not start.
+const (
+	slotA        = "slot-a"
+	slotB        = "slot-b"
+	currentLink  = "current"
+	previousLink = "previous"
+	// activationFile contains the version being activated, until it reports healthy.
+	activationFile = "activation"
+	// failedActivationFile contains the last version rolled back, until another version is activated.
+	failedActivationFile = "failed-activation"
+
+	// The files of the packages, a gzipped tar archive.
+	versionFile   = "VERSION"
+	checksumsFile = "SHA256SUMS"
+	agentFile     = "link022_agent"
+	hostapdFile   = "hostapd"
+
+	// rollbackUnit is the systemd unit restoring the previous version if an activated one does not start.
+	rollbackUnit = "link022-rollback"
+	// activationDeadline is how long an activated version has to report healthy.
+	activationDeadline = 2 * time.Minute
+	// maxPackageSize is the size of the largest package which can be installed.
+	maxPackageSize = 100 * 1024 * 1024
+	// progressInterval is the number of bytes received between the progress responses.
+	progressInterval = 1024 * 1024
+)
+
+var (
+	// executable returns the path of the running agent.
+	executable = os.Executable
+	// restartDelay is how long the agent waits before restarting with an activated version,
+	// so that the Activate response is sent.
+	restartDelay = time.Second
+	// healthCheckInterval is how often the health of an activated version is checked.
+	healthCheckInterval = 5 * time.Second
+	// healthyPeriod is how long an activated version has to stay healthy to report healthy, so that a version failing
+	// shortly after it starts is rolled back.
+	healthyPeriod = 30 * time.Second
+)
+
+// OSServer is the gNOI OS service of the AP. It installs agent versions, with an optional hostapd,
+// in the inactive slot of the OS folder and activates them by restarting the agent. Activated versions which
+// do not report healthy in time are rolled back.
+type OSServer struct {
+	cmdRunner *syscmd.CommandRunner
+	dir       string
+	agentUnit string
+	// slot is the folder of the running agent, version is its version. Both are empty if the agent does not run
+	// from a slot.
+	slot    string
+	version string
+
+	mu         sync.Mutex
+	installing bool
+}
+
+// NewOSServer creates an OSServer managing the slots in the given folder. agentUnit is the systemd unit
+// of the agent, which runs the agent of the current slot.
+func NewOSServer(cmdRunner *syscmd.CommandRunner, dir, agentUnit string) *OSServer {
+	s := &OSServer{
+		cmdRunner: cmdRunner,
+		dir:       dir,
+		agentUnit: agentUnit,
+	}
+	exe, err := executable()
+	if err == nil {
+		exe, err = filepath.EvalSymlinks(exe)
+	}
+	if err != nil {
+		log.Errorf("Failed to find the running agent: %v", err)
+		return s
+	}
+	slot := filepath.Dir(exe)
+	for _, name := range []string{slotA, slotB} {
+		if slotDir, err := filepath.EvalSymlinks(filepath.Join(dir, name)); err == nil && slotDir == slot {
+			s.slot = slot
+			s.version = slotVersion(slot)
+		}
+	}
+	return s
+}
+
+// Version returns the version of the running agent, empty if it was not installed in a slot.
+func (s *OSServer) Version() string {
+	return s.version
+}
+
+// Hostapd returns the path of the hostapd installed with the running agent, empty if there is none.
+func (s *OSServer) Hostapd() string {
+	if s.slot == "" {
+		return ""
+	}
+	p := filepath.Join(s.slot, hostapdFile)
+	if _, err := os.Stat(p); err != nil {
+		return ""
+	}
+	return p
+}
+
+// ConfirmActivation keeps the running version if it is being activated, once it stays healthy for healthyPeriod:
+// healthy returns no error on each check during that period. Otherwise the previous version is restored when the
+// activation deadline expires. It returns once the activation is confirmed, when there is none, or when the context is done.
+func (s *OSServer) ConfirmActivation(c ctx.Context, healthy func() error) {
+	activation := filepath.Join(s.dir, activationFile)
+	content, err := ioutil.ReadFile(activation)
+	if err != nil || s.version == "" || strings.TrimSpace(string(content)) != s.version {
+		return
+	}
+	log.Infof("Checking the health of version %s.", s.version)
+	ticker := time.NewTicker(healthCheckInterval)
+	defer ticker.Stop()
+	var healthySince time.Time
+	for {
+		if err := healthy(); err != nil {
+			log.Warningf("Version %s is not healthy yet: %v", s.version, err)
+			healthySince = time.Time{}
+		} else if healthySince.IsZero() {
+			healthySince = time.Now()
+		}
+		if !healthySince.IsZero() && time.Since(healthySince) >= healthyPeriod {
+			break
+		}
+		select {
+		case <-c.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+
+	// Without the activation file, the rollback does nothing even if its timer can not be stopped.
+	if err := os.Remove(activation); err != nil {
+		log.Errorf("Failed to confirm the activation of version %s: %v", s.version, err)
+		return
+	}
+	if err := s.cmdRunner.StopTimer(rollbackUnit); err != nil {
+		log.Warningf("Failed to stop the rollback timer: %v", err)
+	}
+	log.Infof("Activation of version %s confirmed.", s.version)
+}
+
+// slotVersion returns the version installed in the given slot folder, empty if none.
+func slotVersion(slot string) string {
+	version, err := ioutil.ReadFile(filepath.Join(slot, versionFile))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(version))
+}
+
+// inactiveSlot returns the name of the slot the agent does not run from, or else the current link does not point to.
+func (s *OSServer) inactiveSlot() string {
+	running := filepath.Base(s.slot)
+	if s.slot == "" {
+		current, _ := os.Readlink(filepath.Join(s.dir, currentLink))
+		running = filepath.Base(current)
+	}
+	if running == slotA {
+		return slotB
+	}
+	return slotA
+}
+
+// Install receives a package and installs it in the inactive slot. A version already installed is not transferred.
+func (s *OSServer) Install(stream ospb.OS_InstallServer) error {
+	req, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	transfer := req.GetTransferRequest()
+	if transfer == nil {
+		return status.Error(codes.InvalidArgument, "expected a transfer request first")
+	}
+	if transfer.StandbySupervisor {
+		return sendInstallError(stream, ospb.InstallError_UNSUPPORTED_STANDBY, "the AP has no standby supervisor")
+	}
+	if transfer.Version == "" {
+		return status.Error(codes.InvalidArgument, "no version to install")
+	}
+	if !s.beginInstall() {
+		return sendInstallError(stream, ospb.InstallError_INSTALL_IN_PROGRESS, "another version is being installed")
+	}
+	defer s.endInstall()
+
+	slot := filepath.Join(s.dir, s.inactiveSlot())
+	if transfer.Version == s.version || transfer.Version == slotVersion(slot) {
+		return sendValidated(stream, transfer.Version, "already installed")
+	}
+	if err := stream.Send(&ospb.InstallResponse{Response: &ospb.InstallResponse_TransferReady{TransferReady: &ospb.TransferReady{}}}); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return status.Errorf(codes.Internal, "unable to create the OS folder: %v", err)
+	}
+	pkg, err := ioutil.TempFile(s.dir, ".package")
+	if err != nil {
+		return status.Errorf(codes.Internal, "unable to store the package: %v", err)
+	}
+	defer os.Remove(pkg.Name())
+	defer pkg.Close()
+	if err := receivePackage(stream, pkg); err != nil {
+		if pkgErr, ok := err.(*packageError); ok {
+			return sendInstallError(stream, pkgErr.errorType, pkgErr.detail)
+		}
+		return err
+	}
+
+	if _, err := pkg.Seek(0, io.SeekStart); err != nil {
+		return status.Errorf(codes.Internal, "unable to read the package: %v", err)
+	}
+	tmpSlot := filepath.Join(s.dir, "."+filepath.Base(slot))
+	os.RemoveAll(tmpSlot)
+	defer os.RemoveAll(tmpSlot)
+	description, err := extractPackage(pkg, tmpSlot, transfer.Version)
+	if err != nil {
+		if pkgErr, ok := err.(*packageError); ok {
+			return sendInstallError(stream, pkgErr.errorType, pkgErr.detail)
+		}
+		return status.Errorf(codes.Internal, "unable to extract the package: %v", err)
+	}
+	if err := os.RemoveAll(slot); err != nil {
+		return status.Errorf(codes.Internal, "unable to clear slot %s: %v", filepath.Base(slot), err)
+	}
+	if err := os.Rename(tmpSlot, slot); err != nil {
+		return status.Errorf(codes.Internal, "unable to install slot %s: %v", filepath.Base(slot), err)
+	}
+	log.Infof("Installed version %s in %s.", transfer.Version, slot)
+	return sendValidated(stream, transfer.Version, description)
+}
+
+func (s *OSServer) beginInstall() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.installing {
+		return false
+	}
+	s.installing = true
+	return true
+}
+
+func (s *OSServer) endInstall() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.installing = false
+}
+
+// receivePackage writes the transferred content to the given file until the end of the transfer.
+// It returns a packageError if the package is too large.
+func receivePackage(stream ospb.OS_InstallServer, w io.Writer) error {
+	var received, reported uint64
+	for {
+		req, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		if req.GetTransferEnd() != nil {
+			return nil
+		}
+		content := req.GetTransferContent()
+		if received += uint64(len(content)); received > maxPackageSize {
+			return &packageError{ospb.InstallError_TOO_LARGE, fmt.Sprintf("packages are limited to %d bytes", maxPackageSize)}
+		}
+		if _, err := w.Write(content); err != nil {
+			return status.Errorf(codes.Internal, "unable to store the package: %v", err)
+		}
+		if received-reported >= progressInterval {
+			reported = received
+			if err := stream.Send(&ospb.InstallResponse{Response: &ospb.InstallResponse_TransferProgress{
+				TransferProgress: &ospb.TransferProgress{BytesReceived: received},
+			}}); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// packageError is an invalid package.
+type packageError struct {
+	errorType ospb.InstallError_Type
+	detail    string
+}
+
+func (e *packageError) Error() string {
+	return e.detail
+}
+
+// extractPackage extracts the given package to the given folder, and checks its files and version.
+// It returns the description of the package. The package is a gzipped tar archive with the agent, an optional hostapd,
+// the version and the SHA256 checksums of the other files, in the format of sha256sum.
+func extractPackage(r io.Reader, dest, version string) (string, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return "", &packageError{ospb.InstallError_PARSE_FAIL, fmt.Sprintf("invalid package: %v", err)}
+	}
+	if err := os.MkdirAll(dest, 0755); err != nil {
+		return "", err
+	}
+
+	hashes := make(map[string]string)
+	archive := tar.NewReader(gz)
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", &packageError{ospb.InstallError_PARSE_FAIL, fmt.Sprintf("invalid package: %v", err)}
+		}
+		name := strings.TrimPrefix(header.Name, "./")
+		perm := os.FileMode(0644)
+		switch name {
+		case versionFile, checksumsFile:
+		case agentFile, hostapdFile:
+			perm = 0755
+		default:
+			return "", &packageError{ospb.InstallError_PARSE_FAIL, fmt.Sprintf("unexpected file %q in the package", header.Name)}
+		}
+		if header.Typeflag != tar.TypeReg && header.Typeflag != tar.TypeRegA {
+			return "", &packageError{ospb.InstallError_PARSE_FAIL, fmt.Sprintf("%q is not a regular file", header.Name)}
+		}
+		f, err := os.OpenFile(filepath.Join(dest, name), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+		if err != nil {
+			return "", err
+		}
+		h := sha256.New()
+		_, err = io.Copy(io.MultiWriter(f, h), archive)
+		f.Close()
+		if err != nil {
+			return "", &packageError{ospb.InstallError_PARSE_FAIL, fmt.Sprintf("invalid package: %v", err)}
+		}
+		hashes[name] = hex.EncodeToString(h.Sum(nil))
+	}
+
+	for _, name := range []string{versionFile, checksumsFile, agentFile} {
+		if _, ok := hashes[name]; !ok {
+			return "", &packageError{ospb.InstallError_PARSE_FAIL, fmt.Sprintf("%s is missing from the package", name)}
+		}
+	}
+	checksums, err := readChecksums(filepath.Join(dest, checksumsFile))
+	if err != nil {
+		return "", &packageError{ospb.InstallError_PARSE_FAIL, fmt.Sprintf("invalid %s: %v", checksumsFile, err)}
+	}
+	for name, hash := range hashes {
+		if name == checksumsFile {
+			continue
+		}
+		if checksum, ok := checksums[name]; !ok || checksum != hash {
+			return "", &packageError{ospb.InstallError_INTEGRITY_FAIL, fmt.Sprintf("the checksum of %s does not match", name)}
+		}
+	}
+	if packageVersion := slotVersion(dest); packageVersion != version {
+		return "", &packageError{ospb.InstallError_INCOMPATIBLE, fmt.Sprintf("the package has version %q, not %q", packageVersion, version)}
+	}
+
+	if _, ok := hashes[hostapdFile]; ok {
+		return "Link022 agent with hostapd", nil
+	}
+	return "Link022 agent", nil
+}
+
+// readChecksums reads a sha256sum file. It returns the checksums by file name.
+func readChecksums(p string) (map[string]string, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	// e.g. 9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08  link022_agent
+	checksums := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("invalid line %q", scanner.Text())
+		}
+		name := strings.TrimPrefix(strings.TrimPrefix(fields[1], "*"), "./")
+		checksums[name] = strings.ToLower(fields[0])
+	}
+	return checksums, scanner.Err()
+}
+
+func sendValidated(stream ospb.OS_InstallServer, version, description string) error {
+	return stream.Send(&ospb.InstallResponse{Response: &ospb.InstallResponse_Validated{Validated: &ospb.Validated{
+		Version:     version,
+		Description: description,
+	}}})
+}
+
+func sendInstallError(stream ospb.OS_InstallServer, errorType ospb.InstallError_Type, detail string) error {
+	log.Errorf("Failed to install a version: %s", detail)
+	return stream.Send(&ospb.InstallResponse{Response: &ospb.InstallResponse_InstallError{InstallError: &ospb.InstallError{
+		Type:   errorType,
+		Detail: detail,
+	}}})
+}
+
+// Activate switches the current slot to the one with the requested version, and restarts the agent.
+// The previous slot is restored unless the agent of the activated version reports healthy within activationDeadline.
+func (s *OSServer) Activate(c ctx.Context, req *ospb.ActivateRequest) (*ospb.ActivateResponse, error) {
+	if req.StandbySupervisor {
+		return activateError(ospb.ActivateError_UNKNOWN, "the AP has no standby supervisor"), nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.installing {
+		return activateError(ospb.ActivateError_UNKNOWN, "a version is being installed"), nil
+	}
+	if req.Version != "" && req.Version == s.version {
+		return &ospb.ActivateResponse{Response: &ospb.ActivateResponse_ActivateOk{ActivateOk: &ospb.ActivateOK{}}}, nil
+	}
+	slot := s.inactiveSlot()
+	if req.Version == "" || slotVersion(filepath.Join(s.dir, slot)) != req.Version {
+		return activateError(ospb.ActivateError_NON_EXISTENT_VERSION, fmt.Sprintf("version %q is not installed", req.Version)), nil
+	}
+	// The running version is the one restored if the activated one fails, it has to be in a slot.
+	if s.slot == "" {
+		return activateError(ospb.ActivateError_UNKNOWN, fmt.Sprintf("the running agent is not installed in %s, it could not be restored", s.dir)), nil
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(s.dir, activationFile), []byte(req.Version+"\n"), 0644); err != nil {
+		return activateError(ospb.ActivateError_UNKNOWN, err.Error()), nil
+	}
+	os.Remove(filepath.Join(s.dir, failedActivationFile))
+	if err := replaceLink(filepath.Base(s.slot), filepath.Join(s.dir, previousLink)); err != nil {
+		return activateError(ospb.ActivateError_UNKNOWN, err.Error()), nil
+	}
+	if err := s.cmdRunner.StartTimer(rollbackUnit, activationDeadline, RollbackScript(s.dir, s.agentUnit)); err != nil {
+		return activateError(ospb.ActivateError_UNKNOWN, fmt.Sprintf("unable to start the rollback timer: %v", err)), nil
+	}
+	if err := replaceLink(slot, filepath.Join(s.dir, currentLink)); err != nil {
+		s.cmdRunner.StopTimer(rollbackUnit)
+		return activateError(ospb.ActivateError_UNKNOWN, err.Error()), nil
+	}
+
+	log.Infof("Activated version %s, restarting the agent.", req.Version)
+	time.AfterFunc(restartDelay, func() {
+		if err := s.cmdRunner.RestartUnit(s.agentUnit); err != nil {
+			log.Errorf("Failed to restart the agent: %v", err)
+		}
+	})
+	return &ospb.ActivateResponse{Response: &ospb.ActivateResponse_ActivateOk{ActivateOk: &ospb.ActivateOK{}}}, nil
+}
+
+func activateError(errorType ospb.ActivateError_Type, detail string) *ospb.ActivateResponse {
+	log.Errorf("Failed to activate a version: %s", detail)
+	return &ospb.ActivateResponse{Response: &ospb.ActivateResponse_ActivateError{ActivateError: &ospb.ActivateError{
+		Type:   errorType,
+		Detail: detail,
+	}}}
+}
+
+// replaceLink atomically replaces the given symbolic link with a link to the given target.
+func replaceLink(target, link string) error {
+	tmp := filepath.Join(filepath.Dir(link), "."+filepath.Base(link))
+	os.Remove(tmp)
+	if err := os.Symlink(target, tmp); err != nil {
+		return err
+	}
+	return os.Rename(tmp, link)
+}
+
+// RollbackScript generates the shell script restoring the previous slot of the given OS folder, and restarting
+// the agent with the given systemd unit, if the activated version did not report healthy.
+func RollbackScript(dir, agentUnit string) string {
+	return fmt.Sprintf("cd %s && [ -f %s ] && [ -L %s ] && ln -sfn \"$(readlink %s)\" %s && mv %s %s && systemctl restart %s",
+		shellQuote(dir), activationFile, previousLink, previousLink, currentLink, activationFile, failedActivationFile, shellQuote(agentUnit))
+}
+
+// shellQuote quotes the given string for a shell.
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// Verify returns the running version, and the message of the last activation rolled back if any.
+func (s *OSServer) Verify(c ctx.Context, req *ospb.VerifyRequest) (*ospb.VerifyResponse, error) {
+	resp := &ospb.VerifyResponse{Version: s.version}
+	if failed, err := ioutil.ReadFile(filepath.Join(s.dir, failedActivationFile)); err == nil {
+		resp.ActivationFailMessage = fmt.Sprintf("version %s did not report healthy within %v, it was rolled back", strings.TrimSpace(string(failed)), activationDeadline)
+	}
+	return resp, nil
+}
@@ -0,0 +1,340 @@
+/* Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gnoi
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	ctx "context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/link022/agent/syscmd"
+	ospb "github.com/openconfig/gnoi/os"
+	"google.golang.org/grpc"
+)
+
+// osInstallStream answers the Install RPC of the OS service with the given requests, then with io.EOF.
+type osInstallStream struct {
+	grpc.ServerStream
+	requests  []*ospb.InstallRequest
+	responses []*ospb.InstallResponse
+}
+
+func (s *osInstallStream) Send(resp *ospb.InstallResponse) error {
+	s.responses = append(s.responses, resp)
+	return nil
+}
+
+func (s *osInstallStream) Recv() (*ospb.InstallRequest, error) {
+	if len(s.requests) == 0 {
+		return nil, io.EOF
+	}
+	req := s.requests[0]
+	s.requests = s.requests[1:]
+	return req, nil
+}
+
+// testPackage creates a package with the given files (name -> content). SHA256SUMS is generated from the files
+// unless it is given.
+func testPackage(t *testing.T, files map[string]string) []byte {
+	if _, ok := files[checksumsFile]; !ok {
+		checksums := ""
+		for name, content := range files {
+			sum := sha256.Sum256([]byte(content))
+			checksums += fmt.Sprintf("%s  %s\n", hex.EncodeToString(sum[:]), name)
+		}
+		files[checksumsFile] = checksums
+	}
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	archive := tar.NewWriter(gz)
+	for name, content := range files {
+		if err := archive.WriteHeader(&tar.Header{Name: "./" + name, Mode: 0755, Size: int64(len(content)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		archive.Write([]byte(content))
+	}
+	archive.Close()
+	gz.Close()
+	return buf.Bytes()
+}
+
+// installRequests returns the requests transferring the given package, in chunks of the given size.
+func installRequests(version string, pkg []byte, chunkSize int) []*ospb.InstallRequest {
+	requests := []*ospb.InstallRequest{{Request: &ospb.InstallRequest_TransferRequest{TransferRequest: &ospb.TransferRequest{Version: version}}}}
+	for len(pkg) > 0 {
+		n := chunkSize
+		if n > len(pkg) {
+			n = len(pkg)
+		}
+		requests = append(requests, &ospb.InstallRequest{Request: &ospb.InstallRequest_TransferContent{TransferContent: pkg[:n]}})
+		pkg = pkg[n:]
+	}
+	return append(requests, &ospb.InstallRequest{Request: &ospb.InstallRequest_TransferEnd{TransferEnd: &ospb.TransferEnd{}}})
+}
+
+// testOSServer creates an OSServer with an OS folder in a temp folder, the agent of version 1.0 running from slot-a.
+// It returns the OSServer, the commands it runs, and a func removing the folder.
+func testOSServer(t *testing.T) (*OSServer, chan string, func()) {
+	dir, err := ioutil.TempDir("", "link022")
+	if err != nil {
+		t.Fatalf("Unable to create a temp folder.")
+	}
+	if err := os.Mkdir(filepath.Join(dir, slotA), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{versionFile: "1.0\n", agentFile: "agent 1.0"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, slotA, name), []byte(content), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(slotA, filepath.Join(dir, currentLink)); err != nil {
+		t.Fatal(err)
+	}
+
+	originalExecutable, originalDelay, originalInterval, originalPeriod := executable, restartDelay, healthCheckInterval, healthyPeriod
+	executable = func() (string, error) { return filepath.Join(dir, currentLink, agentFile), nil }
+	restartDelay, healthCheckInterval, healthyPeriod = 0, time.Millisecond, 10*time.Millisecond
+	cmds := make(chan string, 10)
+	cmdRunner := &syscmd.CommandRunner{
+		ExecCommand: func(wait bool, cmd string, args ...string) (string, error) {
+			cmds <- cmd + " " + strings.Join(args, " ")
+			return "", nil
+		},
+	}
+	return NewOSServer(cmdRunner, dir, "link022"), cmds, func() {
+		executable, restartDelay, healthCheckInterval, healthyPeriod = originalExecutable, originalDelay, originalInterval, originalPeriod
+		os.RemoveAll(dir)
+	}
+}
+
+func TestInstall(t *testing.T) {
+	s, _, cleanup := testOSServer(t)
+	defer cleanup()
+	if s.Version() != "1.0" || s.Hostapd() != "" {
+		t.Errorf("Incorrect running version %q, hostapd %q.", s.Version(), s.Hostapd())
+	}
+
+	pkg := testPackage(t, map[string]string{versionFile: "1.1\n", agentFile: "agent 1.1", hostapdFile: "hostapd 2.7"})
+	stream := &osInstallStream{requests: installRequests("1.1", pkg, 100)}
+	if err := s.Install(stream); err != nil {
+		t.Fatalf("Installing version 1.1 failed. Error: %v.", err)
+	}
+	want := []*ospb.InstallResponse{
+		{Response: &ospb.InstallResponse_TransferReady{TransferReady: &ospb.TransferReady{}}},
+		{Response: &ospb.InstallResponse_Validated{Validated: &ospb.Validated{Version: "1.1", Description: "Link022 agent with hostapd"}}},
+	}
+	if !reflect.DeepEqual(stream.responses, want) {
+		t.Errorf("Incorrect install responses %+v, want %+v.", stream.responses, want)
+	}
+	if agent, err := ioutil.ReadFile(filepath.Join(s.dir, slotB, agentFile)); err != nil || string(agent) != "agent 1.1" {
+		t.Errorf("Incorrect installed agent %q (error: %v).", agent, err)
+	}
+
+	// An installed version is not transferred again.
+	stream = &osInstallStream{requests: installRequests("1.1", nil, 100)}
+	if err := s.Install(stream); err != nil || len(stream.responses) != 1 || stream.responses[0].GetValidated() == nil {
+		t.Errorf("Expected version 1.1 to be validated right away, got %+v (error: %v).", stream.responses, err)
+	}
+}
+
+func TestInstallInvalidPackage(t *testing.T) {
+	s, _, cleanup := testOSServer(t)
+	defer cleanup()
+
+	tests := []struct {
+		desc string
+		pkg  []byte
+		want ospb.InstallError_Type
+	}{{
+		desc: "not a package",
+		pkg:  []byte("link022"),
+		want: ospb.InstallError_PARSE_FAIL,
+	}, {
+		desc: "without agent",
+		pkg:  testPackage(t, map[string]string{versionFile: "1.1\n"}),
+		want: ospb.InstallError_PARSE_FAIL,
+	}, {
+		desc: "invalid checksum",
+		pkg: testPackage(t, map[string]string{
+			versionFile:   "1.1\n",
+			agentFile:     "agent 1.1",
+			checksumsFile: "0000000000000000000000000000000000000000000000000000000000000000  link022_agent\n",
+		}),
+		want: ospb.InstallError_INTEGRITY_FAIL,
+	}, {
+		desc: "another version",
+		pkg:  testPackage(t, map[string]string{versionFile: "1.2\n", agentFile: "agent 1.2"}),
+		want: ospb.InstallError_INCOMPATIBLE,
+	}}
+	for _, test := range tests {
+		stream := &osInstallStream{requests: installRequests("1.1", test.pkg, 1000)}
+		if err := s.Install(stream); err != nil {
+			t.Errorf("%s: unexpected error %v.", test.desc, err)
+			continue
+		}
+		last := stream.responses[len(stream.responses)-1]
+		if installErr := last.GetInstallError(); installErr == nil || installErr.Type != test.want {
+			t.Errorf("%s: incorrect response %+v, want error %v.", test.desc, last, test.want)
+		}
+		if _, err := os.Stat(filepath.Join(s.dir, slotB)); !os.IsNotExist(err) {
+			t.Errorf("%s: expected no version installed, got %v.", test.desc, err)
+		}
+	}
+}
+
+func TestActivate(t *testing.T) {
+	s, cmds, cleanup := testOSServer(t)
+	defer cleanup()
+	c := ctx.Background()
+
+	if resp, err := s.Activate(c, &ospb.ActivateRequest{Version: "1.1"}); err != nil || resp.GetActivateError() == nil || resp.GetActivateError().Type != ospb.ActivateError_NON_EXISTENT_VERSION {
+		t.Errorf("Expected version 1.1 not to exist, got %+v (error: %v).", resp, err)
+	}
+	pkg := testPackage(t, map[string]string{versionFile: "1.1\n", agentFile: "agent 1.1"})
+	if err := s.Install(&osInstallStream{requests: installRequests("1.1", pkg, 1000)}); err != nil {
+		t.Fatalf("Installing version 1.1 failed. Error: %v.", err)
+	}
+
+	if resp, err := s.Activate(c, &ospb.ActivateRequest{Version: "1.1"}); err != nil || resp.GetActivateOk() == nil {
+		t.Fatalf("Activating version 1.1 failed: %+v (error: %v).", resp, err)
+	}
+	for link, want := range map[string]string{currentLink: slotB, previousLink: slotA} {
+		if target, err := os.Readlink(filepath.Join(s.dir, link)); err != nil || target != want {
+			t.Errorf("Incorrect %s link %q (error: %v), want %q.", link, target, err, want)
+		}
+	}
+	var got []string
+	timeout := time.After(time.Second)
+	for len(got) < 4 {
+		select {
+		case cmd := <-cmds:
+			got = append(got, cmd)
+		case <-timeout:
+			t.Fatalf("The agent was not restarted, commands: %v.", got)
+		}
+	}
+	want := []string{
+		"systemctl stop link022-rollback.timer link022-rollback.service",
+		"systemctl reset-failed link022-rollback.service",
+		"systemd-run --unit=link022-rollback --on-active=120 /bin/sh -c " + RollbackScript(s.dir, "link022"),
+		"systemctl --no-block restart link022",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Incorrect commands activating the version (got: %v, want: %v).", got, want)
+	}
+
+	// The new agent reports healthy once its health checks pass for healthyPeriod.
+	s = NewOSServer(s.cmdRunner, s.dir, "link022")
+	if s.Version() != "1.1" {
+		t.Errorf("Incorrect running version %q after the activation.", s.Version())
+	}
+	cancelled, cancel := ctx.WithCancel(c)
+	cancel()
+	s.ConfirmActivation(cancelled, func() error { return errors.New("hostapd is not running") })
+	if _, err := os.Stat(filepath.Join(s.dir, activationFile)); err != nil {
+		t.Errorf("Expected the activation of an unhealthy version not to be confirmed, got %v.", err)
+	}
+	checks := 0
+	s.ConfirmActivation(c, func() error {
+		if checks++; checks < 3 {
+			return errors.New("hostapd is not running")
+		}
+		return nil
+	})
+	if checks < 4 {
+		t.Errorf("Expected the version to stay healthy before it is confirmed, got %d checks.", checks)
+	}
+	if cmd := <-cmds; cmd != "systemctl stop link022-rollback.timer" {
+		t.Errorf("Incorrect command confirming the activation %q.", cmd)
+	}
+	if _, err := os.Stat(filepath.Join(s.dir, activationFile)); !os.IsNotExist(err) {
+		t.Errorf("Expected the activation to be confirmed, got %v.", err)
+	}
+}
+
+func TestActivateWithoutSlot(t *testing.T) {
+	s, _, cleanup := testOSServer(t)
+	defer cleanup()
+	pkg := testPackage(t, map[string]string{versionFile: "1.1\n", agentFile: "agent 1.1"})
+	if err := s.Install(&osInstallStream{requests: installRequests("1.1", pkg, 1000)}); err != nil {
+		t.Fatalf("Installing version 1.1 failed. Error: %v.", err)
+	}
+
+	// The agent started from elsewhere could not be restored.
+	executable = func() (string, error) { return "/usr/local/bin/link022_agent", nil }
+	s = NewOSServer(s.cmdRunner, s.dir, "link022")
+	resp, err := s.Activate(ctx.Background(), &ospb.ActivateRequest{Version: "1.1"})
+	if err != nil || resp.GetActivateError() == nil {
+		t.Errorf("Expected the activation to be refused, got %+v (error: %v).", resp, err)
+	}
+	if target, err := os.Readlink(filepath.Join(s.dir, currentLink)); err != nil || target != slotA {
+		t.Errorf("Incorrect current link %q (error: %v).", target, err)
+	}
+}
+
+func TestRollback(t *testing.T) {
+	s, _, cleanup := testOSServer(t)
+	defer cleanup()
+	if err := os.Mkdir(filepath.Join(s.dir, slotB), 0755); err != nil {
+		t.Fatal(err)
+	}
+	// The activation of slot-b, from slot-a, was not confirmed.
+	for link, target := range map[string]string{currentLink: slotB, previousLink: slotA} {
+		if err := replaceLink(target, filepath.Join(s.dir, link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(s.dir, activationFile), []byte("1.1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// systemctl records its arguments.
+	bin := filepath.Join(s.dir, "bin")
+	os.Mkdir(bin, 0755)
+	if err := ioutil.WriteFile(filepath.Join(bin, "systemctl"), []byte("#!/bin/sh\necho \"$@\" > "+filepath.Join(s.dir, "systemctl.args")+"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command("/bin/sh", "-c", RollbackScript(s.dir, "link022"))
+	cmd.Env = []string{"PATH=" + bin + ":/usr/bin:/bin"}
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("The rollback failed. Error: %v, output:\n%s", err, output)
+	}
+	if target, err := os.Readlink(filepath.Join(s.dir, currentLink)); err != nil || target != slotA {
+		t.Errorf("Incorrect current link %q after the rollback (error: %v).", target, err)
+	}
+	if args, err := ioutil.ReadFile(filepath.Join(s.dir, "systemctl.args")); err != nil || string(args) != "restart link022\n" {
+		t.Errorf("Incorrect systemctl arguments %q (error: %v).", args, err)
+	}
+
+	resp, err := s.Verify(ctx.Background(), &ospb.VerifyRequest{})
+	if err != nil || resp.Version != "1.0" || !strings.Contains(resp.ActivationFailMessage, "version 1.1 did not report healthy") {
+		t.Errorf("Incorrect verify response %+v (error: %v).", resp, err)
+	}
+}
@@ -0,0 +1,549 @@
+/* Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package gnoi contains the gNOI services of the agent, served by its gRPC server next to GNMI.
+package gnoi
+
+import (
+	"bufio"
+	"bytes"
+	ctx "context"
+	"fmt"
+	"io"
+	"math"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/golang/glog"
+	"github.com/google/link022/agent/syscmd"
+	syspb "github.com/openconfig/gnoi/system"
+	"github.com/openconfig/gnoi/types"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// defaultPingCount is the number of pings sent if the request does not set it.
+	defaultPingCount = 5
+	// maxPingCount is the maximum number of pings sent by a request.
+	maxPingCount = 100
+	// maxTracerouteTTL is the maximum TTL of a traceroute request.
+	maxTracerouteTTL = 64
+	// maxCommandDuration is the maximum duration of ping and traceroute. ping stops by itself after it,
+	// and prints its summary. The commands are killed a bit later if they still run.
+	maxCommandDuration = time.Minute
+	commandKillDelay   = 5 * time.Second
+)
+
+var (
+	// startCommand starts the given command, it is killed once the context is done.
+	// It returns the stdout of the command, and a func waiting for it to exit.
+	startCommand = func(c ctx.Context, name string, args ...string) (io.ReadCloser, func() error, error) {
+		cmd := exec.CommandContext(c, name, args...)
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		stdout, err := cmd.StdoutPipe()
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := cmd.Start(); err != nil {
+			return nil, nil, err
+		}
+		return stdout, func() error {
+			if err := cmd.Wait(); err != nil {
+				return fmt.Errorf("%v: %s", err, strings.TrimSpace(stderr.String()))
+			}
+			return nil
+		}, nil
+	}
+
+	// e.g. PING google.com (172.217.6.46) 56(84) bytes of data.
+	pingHeaderRegex = regexp.MustCompile(`^PING (\S+?) ?\(([^)]+)\)`)
+	// e.g. 64 bytes from sfo07s17-in-f14.1e100.net (172.217.6.46): icmp_seq=1 ttl=55 time=1.67 ms
+	pingReplyRegex = regexp.MustCompile(`^(\d+) bytes from (\S+?)(?: \(([^)]+)\))?: icmp_seq=(\d+) ttl=(\d+) time=([\d.]+) ms`)
+	// e.g. 5 packets transmitted, 5 received, 0% packet loss, time 4006ms
+	pingStatsRegex = regexp.MustCompile(`^(\d+) packets transmitted, (\d+) received.*, time (\d+)ms`)
+	// e.g. rtt min/avg/max/mdev = 1.590/1.634/1.670/0.029 ms
+	pingRTTRegex = regexp.MustCompile(`= ([\d.]+)/([\d.]+)/([\d.]+)/([\d.]+) ms`)
+	// e.g. traceroute to google.com (172.217.6.46), 30 hops max, 60 byte packets
+	tracerouteHeaderRegex = regexp.MustCompile(`^traceroute to (\S+) \(([^)]+)\), (\d+) hops max, (\d+) byte packets`)
+	// e.g.  2  10.0.0.1 (10.0.0.1)  5.123 ms !H
+	tracerouteHopRegex = regexp.MustCompile(`^\s*(\d+)\s+(.*)$`)
+
+	// tracerouteStates maps the annotations of traceroute to the state of hops.
+	tracerouteStates = map[string]syspb.TracerouteResponse_State{
+		"!H": syspb.TracerouteResponse_HOST_UNREACHABLE,
+		"!N": syspb.TracerouteResponse_NETWORK_UNREACHABLE,
+		"!P": syspb.TracerouteResponse_PROTOCOL_UNREACHABLE,
+		"!S": syspb.TracerouteResponse_SOURCE_ROUTE_FAILED,
+		"!F": syspb.TracerouteResponse_FRAGMENTATION_NEEDED,
+		"!X": syspb.TracerouteResponse_PROHIBITED,
+		"!V": syspb.TracerouteResponse_PRECEDENCE_VIOLATION,
+		"!C": syspb.TracerouteResponse_PRECEDENCE_CUTOFF,
+	}
+)
+
+// pendingReboot is a reboot scheduled by a Reboot request.
+type pendingReboot struct {
+	method syspb.RebootMethod
+	when   time.Time
+	reason string
+	timer  *time.Timer
+}
+
+// SystemServer is the gNOI System service of the AP. It reboots the AP, and runs ping and traceroute from it.
+// Switching the control processor and installing packages are not supported.
+type SystemServer struct {
+	cmdRunner *syscmd.CommandRunner
+	now       func() time.Time
+
+	mu     sync.Mutex
+	reboot *pendingReboot
+}
+
+// NewSystemServer creates a SystemServer running its commands with the given runner.
+func NewSystemServer(cmdRunner *syscmd.CommandRunner) *SystemServer {
+	return &SystemServer{
+		cmdRunner: cmdRunner,
+		now:       time.Now,
+	}
+}
+
+// Reboot schedules a reboot, power off or halt of the AP after the delay of the request.
+// Only one reboot can be pending at a time.
+func (s *SystemServer) Reboot(c ctx.Context, req *syspb.RebootRequest) (*syspb.RebootResponse, error) {
+	if len(req.Subcomponents) != 0 {
+		return nil, status.Error(codes.Unimplemented, "rebooting subcomponents is not supported, only the AP can be rebooted")
+	}
+	switch req.Method {
+	case syspb.RebootMethod_COLD, syspb.RebootMethod_POWERDOWN, syspb.RebootMethod_HALT:
+	default:
+		return nil, status.Errorf(codes.InvalidArgument, "unsupported reboot method %v", req.Method)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.reboot != nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "a reboot is already pending at %v", s.reboot.when)
+	}
+	delay := time.Duration(req.Delay)
+	reboot := &pendingReboot{
+		method: req.Method,
+		when:   s.now().Add(delay),
+		reason: req.Message,
+	}
+	reboot.timer = time.AfterFunc(delay, func() { s.executeReboot(reboot) })
+	s.reboot = reboot
+	log.Infof("Scheduled a %v reboot in %v: %q.", req.Method, delay, req.Message)
+	return &syspb.RebootResponse{}, nil
+}
+
+// executeReboot runs the given reboot, unless it was cancelled.
+func (s *SystemServer) executeReboot(reboot *pendingReboot) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.reboot != reboot {
+		return
+	}
+	var err error
+	switch reboot.method {
+	case syspb.RebootMethod_POWERDOWN:
+		err = s.cmdRunner.PowerOff()
+	case syspb.RebootMethod_HALT:
+		err = s.cmdRunner.Halt()
+	default:
+		err = s.cmdRunner.Reboot()
+	}
+	if err != nil {
+		// The reboot is not pending anymore, it can be requested again.
+		log.Errorf("Failed to reboot the AP: %v", err)
+		s.reboot = nil
+	}
+}
+
+// RebootStatus returns the status of the pending reboot.
+func (s *SystemServer) RebootStatus(c ctx.Context, req *syspb.RebootStatusRequest) (*syspb.RebootStatusResponse, error) {
+	if len(req.Subcomponents) != 0 {
+		return nil, status.Error(codes.Unimplemented, "rebooting subcomponents is not supported, only the AP can be rebooted")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.reboot == nil {
+		return &syspb.RebootStatusResponse{}, nil
+	}
+	resp := &syspb.RebootStatusResponse{
+		Active: true,
+		When:   uint64(s.reboot.when.UnixNano()),
+		Reason: s.reboot.reason,
+	}
+	if wait := s.reboot.when.Sub(s.now()); wait > 0 {
+		resp.Wait = uint64(wait)
+	}
+	return resp, nil
+}
+
+// CancelReboot cancels the pending reboot. It succeeds without pending reboot.
+func (s *SystemServer) CancelReboot(c ctx.Context, req *syspb.CancelRebootRequest) (*syspb.CancelRebootResponse, error) {
+	if len(req.Subcomponents) != 0 {
+		return nil, status.Error(codes.Unimplemented, "rebooting subcomponents is not supported, only the AP can be rebooted")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.reboot != nil {
+		s.reboot.timer.Stop()
+		s.reboot = nil
+		log.Infof("Cancelled the pending reboot: %q.", req.Message)
+	}
+	return &syspb.CancelRebootResponse{}, nil
+}
+
+// Time returns the current time on the AP.
+func (s *SystemServer) Time(c ctx.Context, req *syspb.TimeRequest) (*syspb.TimeResponse, error) {
+	return &syspb.TimeResponse{Time: uint64(s.now().UnixNano())}, nil
+}
+
+// runCommand runs the given command until it exits, the context is done or maxCommandDuration elapsed.
+// handleLine is called with each line of its output as soon as it is printed, the command is killed if it fails.
+func runCommand(c ctx.Context, handleLine func(string) error, name string, args ...string) error {
+	commandContext, cancel := ctx.WithTimeout(c, maxCommandDuration+commandKillDelay)
+	defer cancel()
+	stdout, wait, err := startCommand(commandContext, name, args...)
+	if err != nil {
+		return status.Errorf(codes.Internal, "unable to run %s: %v", name, err)
+	}
+
+	var handleErr error
+	scanner := bufio.NewScanner(stdout)
+	for handleErr == nil && scanner.Scan() {
+		handleErr = handleLine(scanner.Text())
+	}
+	timedOut := commandContext.Err() == ctx.DeadlineExceeded
+	// The command is killed if it still runs, e.g. the response could not be sent.
+	cancel()
+	waitErr := wait()
+	switch {
+	case handleErr != nil:
+		return handleErr
+	case c.Err() != nil:
+		return status.FromContextError(c.Err()).Err()
+	case timedOut:
+		return status.Errorf(codes.DeadlineExceeded, "%s did not complete in %v", name, maxCommandDuration)
+	case waitErr != nil:
+		return status.Errorf(codes.Unknown, "%s failed: %v", name, waitErr)
+	}
+	return nil
+}
+
+// Ping pings the destination of the request from the AP. It streams a response per reply as soon as it is
+// received, followed by a summary once ping completes.
+func (s *SystemServer) Ping(req *syspb.PingRequest, stream syspb.System_PingServer) error {
+	if req.Destination == "" {
+		return status.Error(codes.InvalidArgument, "no destination to ping")
+	}
+	if err := checkAddresses(req.Destination, req.Source); err != nil {
+		return err
+	}
+	options, err := pingOptions(req)
+	if err != nil {
+		return err
+	}
+	parser := &pingParser{}
+	err = runCommand(stream.Context(), func(line string) error {
+		if resp := parser.parseLine(line); resp != nil {
+			return stream.Send(resp)
+		}
+		return nil
+	}, "ping", append(options, req.Destination)...)
+	// ping fails if some pings are lost, the summary is still sent.
+	if summary := parser.summary(); summary != nil {
+		return stream.Send(summary)
+	}
+	if err == nil {
+		err = status.Error(codes.Unknown, "ping did not report its statistics")
+	}
+	return err
+}
+
+// pingOptions returns the ping options of the given request.
+func pingOptions(req *syspb.PingRequest) ([]string, error) {
+	count := req.Count
+	if count < 0 {
+		return nil, status.Error(codes.InvalidArgument, "continuous ping is not supported")
+	}
+	if count > maxPingCount {
+		return nil, status.Errorf(codes.InvalidArgument, "at most %d pings can be sent", maxPingCount)
+	}
+	if count == 0 {
+		count = defaultPingCount
+	}
+	options := []string{"-c", strconv.Itoa(int(count)), "-w", strconv.Itoa(int(maxCommandDuration.Seconds()))}
+	if req.Interval > 0 {
+		options = append(options, "-i", formatSeconds(req.Interval))
+	}
+	if req.Wait > 0 {
+		// ping only supports whole seconds.
+		options = append(options, "-W", strconv.Itoa(int(math.Ceil(time.Duration(req.Wait).Seconds()))))
+	}
+	if req.Size > 0 {
+		options = append(options, "-s", strconv.Itoa(int(req.Size)))
+	}
+	if req.DoNotFragment {
+		options = append(options, "-M", "do")
+	}
+	if req.DoNotResolve {
+		options = append(options, "-n")
+	}
+	if req.Source != "" {
+		options = append(options, "-I", req.Source)
+	}
+	return append(options, l3Option(req.L3Protocol)...), nil
+}
+
+// pingParser parses the output of ping line by line.
+type pingParser struct {
+	destination string
+	stats       syspb.PingResponse
+}
+
+// parseLine parses a line of the output of ping. It returns the response of a reply, nil for other lines.
+func (p *pingParser) parseLine(line string) *syspb.PingResponse {
+	line = strings.TrimSpace(line)
+	if match := pingHeaderRegex.FindStringSubmatch(line); match != nil {
+		p.destination = match[2]
+		return nil
+	}
+	if match := pingReplyRegex.FindStringSubmatch(line); match != nil {
+		source := match[2]
+		if match[3] != "" {
+			source = match[3]
+		}
+		return &syspb.PingResponse{
+			Source:   source,
+			Bytes:    int32(atoi(match[1])),
+			Sequence: int32(atoi(match[4])),
+			Ttl:      int32(atoi(match[5])),
+			Time:     msToNanos(match[6]),
+		}
+	}
+	if match := pingStatsRegex.FindStringSubmatch(line); match != nil {
+		p.stats.Sent = int32(atoi(match[1]))
+		p.stats.Received = int32(atoi(match[2]))
+		p.stats.Time = int64(atoi(match[3])) * int64(time.Millisecond)
+		return nil
+	}
+	if match := pingRTTRegex.FindStringSubmatch(line); match != nil {
+		p.stats.MinTime = msToNanos(match[1])
+		p.stats.AvgTime = msToNanos(match[2])
+		p.stats.MaxTime = msToNanos(match[3])
+		p.stats.StdDev = msToNanos(match[4])
+	}
+	return nil
+}
+
+// summary returns the summary of the parsed output, nil if ping did not complete.
+func (p *pingParser) summary() *syspb.PingResponse {
+	if p.stats.Sent == 0 {
+		return nil
+	}
+	return &syspb.PingResponse{
+		Source:   p.destination,
+		Time:     p.stats.Time,
+		Sent:     p.stats.Sent,
+		Received: p.stats.Received,
+		MinTime:  p.stats.MinTime,
+		AvgTime:  p.stats.AvgTime,
+		MaxTime:  p.stats.MaxTime,
+		StdDev:   p.stats.StdDev,
+	}
+}
+
+// Traceroute traces the route to the destination of the request from the AP. It streams a response
+// with the destination, followed by a response per hop as soon as it is probed.
+func (s *SystemServer) Traceroute(req *syspb.TracerouteRequest, stream syspb.System_TracerouteServer) error {
+	if req.Destination == "" {
+		return status.Error(codes.InvalidArgument, "no destination to trace")
+	}
+	if err := checkAddresses(req.Destination, req.Source); err != nil {
+		return err
+	}
+	options, err := tracerouteOptions(req)
+	if err != nil {
+		return err
+	}
+	parser := &tracerouteParser{}
+	err = runCommand(stream.Context(), func(line string) error {
+		if resp := parser.parseLine(line); resp != nil {
+			return stream.Send(resp)
+		}
+		return nil
+	}, "traceroute", append(options, req.Destination)...)
+	if err == nil && !parser.started {
+		err = status.Error(codes.Unknown, "traceroute did not report its destination")
+	}
+	return err
+}
+
+// tracerouteOptions returns the traceroute options of the given request. A single probe is sent per hop.
+func tracerouteOptions(req *syspb.TracerouteRequest) ([]string, error) {
+	if req.MaxTtl < 0 || req.MaxTtl > maxTracerouteTTL {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid max TTL %d, it is at most %d", req.MaxTtl, maxTracerouteTTL)
+	}
+	options := []string{"-q", "1"}
+	if req.InitialTtl > 0 {
+		options = append(options, "-f", strconv.Itoa(int(req.InitialTtl)))
+	}
+	if req.MaxTtl > 0 {
+		options = append(options, "-m", strconv.Itoa(int(req.MaxTtl)))
+	}
+	if req.Wait > 0 {
+		options = append(options, "-w", formatSeconds(req.Wait))
+	}
+	if req.DoNotFragment {
+		options = append(options, "-F")
+	}
+	if req.DoNotResolve {
+		options = append(options, "-n")
+	}
+	if req.Source != "" {
+		options = append(options, "-s", req.Source)
+	}
+	switch req.L4Protocol {
+	case syspb.TracerouteRequest_ICMP:
+		options = append(options, "-I")
+	case syspb.TracerouteRequest_TCP:
+		options = append(options, "-T")
+	}
+	return append(options, l3Option(req.L3Protocol)...), nil
+}
+
+// tracerouteParser parses the output of traceroute with a single probe per hop, line by line.
+type tracerouteParser struct {
+	// started indicates the destination was parsed.
+	started bool
+}
+
+// parseLine parses a line of the output of traceroute. It returns the response with the destination,
+// or the response of a hop once the destination was parsed, and nil for other lines.
+func (p *tracerouteParser) parseLine(line string) *syspb.TracerouteResponse {
+	if match := tracerouteHeaderRegex.FindStringSubmatch(line); match != nil {
+		p.started = true
+		return &syspb.TracerouteResponse{
+			DestinationName:    match[1],
+			DestinationAddress: match[2],
+			Hops:               int32(atoi(match[3])),
+			PacketSize:         int32(atoi(match[4])),
+		}
+	}
+	if !p.started {
+		return nil
+	}
+	if match := tracerouteHopRegex.FindStringSubmatch(line); match != nil {
+		return parseHop(int32(atoi(match[1])), strings.Fields(match[2]))
+	}
+	return nil
+}
+
+// parseHop parses the result of a probe, e.g. "router.lan (192.168.1.1) 0.512 ms !H".
+func parseHop(hop int32, fields []string) *syspb.TracerouteResponse {
+	resp := &syspb.TracerouteResponse{Hop: hop}
+	if len(fields) == 0 || fields[0] == "*" {
+		resp.State = syspb.TracerouteResponse_NONE
+		return resp
+	}
+	resp.Address = fields[0]
+	fields = fields[1:]
+	if len(fields) != 0 && strings.HasPrefix(fields[0], "(") {
+		resp.Name = resp.Address
+		resp.Address = strings.Trim(fields[0], "()")
+		fields = fields[1:]
+	}
+	if len(fields) >= 2 && fields[1] == "ms" {
+		resp.Rtt = msToNanos(fields[0])
+		fields = fields[2:]
+	}
+	for _, annotation := range fields {
+		if !strings.HasPrefix(annotation, "!") || len(annotation) < 2 {
+			continue
+		}
+		// e.g. !F-1500 carries the MTU.
+		if state, ok := tracerouteStates[annotation[:2]]; ok {
+			resp.State = state
+		} else if code, err := strconv.Atoi(annotation[1:]); err == nil {
+			resp.State = syspb.TracerouteResponse_ICMP
+			resp.IcmpCode = int32(code)
+		} else {
+			resp.State = syspb.TracerouteResponse_UNKNOWN
+		}
+	}
+	return resp
+}
+
+// SwitchControlProcessor is not supported, the AP has a single control processor.
+func (s *SystemServer) SwitchControlProcessor(c ctx.Context, req *syspb.SwitchControlProcessorRequest) (*syspb.SwitchControlProcessorResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "the AP has a single control processor")
+}
+
+// SetPackage is not supported.
+func (s *SystemServer) SetPackage(stream syspb.System_SetPackageServer) error {
+	return status.Error(codes.Unimplemented, "installing packages is not supported")
+}
+
+// checkAddresses checks that the given destination and source are not taken as options by ping and traceroute.
+func checkAddresses(destination, source string) error {
+	if strings.HasPrefix(destination, "-") || strings.HasPrefix(source, "-") {
+		return status.Errorf(codes.InvalidArgument, "invalid destination %q or source %q", destination, source)
+	}
+	return nil
+}
+
+// l3Option returns the option of ping and traceroute forcing the given IP version, if any.
+func l3Option(protocol types.L3Protocol) []string {
+	switch protocol {
+	case types.L3Protocol_IPV4:
+		return []string{"-4"}
+	case types.L3Protocol_IPV6:
+		return []string{"-6"}
+	}
+	return nil
+}
+
+// formatSeconds formats a duration in nanoseconds as seconds.
+func formatSeconds(nanos int64) string {
+	return strconv.FormatFloat(time.Duration(nanos).Seconds(), 'f', -1, 64)
+}
+
+// msToNanos converts a duration in milliseconds to nanoseconds, 0 if invalid.
+func msToNanos(ms string) int64 {
+	value, err := strconv.ParseFloat(ms, 64)
+	if err != nil {
+		return 0
+	}
+	return int64(value * float64(time.Millisecond))
+}
+
+func atoi(s string) int {
+	value, _ := strconv.Atoi(s)
+	return value
+}
@@ -0,0 +1,254 @@
+/* Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gnoi
+
+import (
+	ctx "context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/link022/agent/syscmd"
+	syspb "github.com/openconfig/gnoi/system"
+	"github.com/openconfig/gnoi/types"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const testPingOutput = `PING google.com (172.217.6.46) 56(84) bytes of data.
+64 bytes from sfo07s17-in-f14.1e100.net (172.217.6.46): icmp_seq=1 ttl=55 time=1.67 ms
+64 bytes from sfo07s17-in-f14.1e100.net (172.217.6.46): icmp_seq=3 ttl=55 time=1.59 ms
+
+--- google.com ping statistics ---
+3 packets transmitted, 2 received, 33% packet loss, time 2003ms
+rtt min/avg/max/mdev = 1.590/1.630/1.670/0.040 ms
+`
+
+const testTracerouteOutput = `traceroute to 8.8.8.8 (8.8.8.8), 30 hops max, 60 byte packets
+ 1  router.lan (192.168.1.1)  0.512 ms
+ 2  *
+ 3  10.0.0.1 (10.0.0.1)  5.123 ms !H
+`
+
+// pingStream collects the responses of a Ping RPC, it fails once maxResponses were sent if it is set.
+type pingStream struct {
+	grpc.ServerStream
+	responses    []*syspb.PingResponse
+	maxResponses int
+}
+
+func (s *pingStream) Context() ctx.Context {
+	return ctx.Background()
+}
+
+func (s *pingStream) Send(resp *syspb.PingResponse) error {
+	if s.maxResponses != 0 && len(s.responses) == s.maxResponses {
+		return status.Error(codes.Unavailable, "stream closed")
+	}
+	s.responses = append(s.responses, resp)
+	return nil
+}
+
+// tracerouteStream collects the responses of a Traceroute RPC.
+type tracerouteStream struct {
+	grpc.ServerStream
+	responses []*syspb.TracerouteResponse
+}
+
+func (s *tracerouteStream) Context() ctx.Context {
+	return ctx.Background()
+}
+
+func (s *tracerouteStream) Send(resp *syspb.TracerouteResponse) error {
+	s.responses = append(s.responses, resp)
+	return nil
+}
+
+// fakeCommand makes the commands started print the given output, then exit with the given error once their output
+// is read. The commands started are sent to the returned channel, and whether they were killed before exiting
+// to the second one. The returned func restores the commands.
+func fakeCommand(output string, exitErr error) (chan string, chan bool, func()) {
+	originalStart := startCommand
+	cmds, killed := make(chan string, 10), make(chan bool, 10)
+	startCommand = func(c ctx.Context, name string, args ...string) (io.ReadCloser, func() error, error) {
+		cmds <- name + " " + strings.Join(args, " ")
+		return ioutil.NopCloser(strings.NewReader(output)), func() error {
+			killed <- c.Err() != nil
+			return exitErr
+		}, nil
+	}
+	return cmds, killed, func() { startCommand = originalStart }
+}
+
+// testServer creates a SystemServer running its commands with a fake runner, which answers with the given outputs
+// (command -> output). The commands run are sent to the returned channel.
+func testServer(outputs map[string]string) (*SystemServer, chan string) {
+	cmds := make(chan string, 10)
+	cmdRunner := &syscmd.CommandRunner{
+		ExecCommand: func(wait bool, cmd string, args ...string) (string, error) {
+			cmds <- cmd + " " + strings.Join(args, " ")
+			output, ok := outputs[cmd]
+			if !ok {
+				return "", nil
+			}
+			return output, errors.New("exit status 1")
+		},
+	}
+	s := NewSystemServer(cmdRunner)
+	s.now = func() time.Time { return time.Unix(1538755500, 0) }
+	return s, cmds
+}
+
+func TestReboot(t *testing.T) {
+	s, cmds := testServer(nil)
+	c := ctx.Background()
+
+	if _, err := s.Reboot(c, &syspb.RebootRequest{Method: syspb.RebootMethod_NSF}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected an unsupported reboot method, got %v.", err)
+	}
+	if _, err := s.Reboot(c, &syspb.RebootRequest{Method: syspb.RebootMethod_COLD, Delay: uint64(time.Hour), Message: "maintenance"}); err != nil {
+		t.Fatalf("Scheduling a reboot failed. Error: %v.", err)
+	}
+	if _, err := s.Reboot(c, &syspb.RebootRequest{Method: syspb.RebootMethod_COLD}); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("Expected an error with a pending reboot, got %v.", err)
+	}
+	want := &syspb.RebootStatusResponse{
+		Active: true,
+		Wait:   uint64(time.Hour),
+		When:   uint64(time.Unix(1538755500, 0).Add(time.Hour).UnixNano()),
+		Reason: "maintenance",
+	}
+	if got, err := s.RebootStatus(c, &syspb.RebootStatusRequest{}); err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("Incorrect reboot status %+v (error: %v), want %+v.", got, err, want)
+	}
+
+	if _, err := s.CancelReboot(c, &syspb.CancelRebootRequest{}); err != nil {
+		t.Fatalf("Cancelling the reboot failed. Error: %v.", err)
+	}
+	if got, err := s.RebootStatus(c, &syspb.RebootStatusRequest{}); err != nil || got.Active {
+		t.Errorf("Expected no pending reboot, got %+v (error: %v).", got, err)
+	}
+
+	// A reboot without delay runs right away.
+	if _, err := s.Reboot(c, &syspb.RebootRequest{Method: syspb.RebootMethod_POWERDOWN}); err != nil {
+		t.Fatalf("Powering off failed. Error: %v.", err)
+	}
+	select {
+	case cmd := <-cmds:
+		if cmd != "systemctl poweroff" {
+			t.Errorf("Incorrect power off command %q.", cmd)
+		}
+	case <-time.After(time.Second):
+		t.Error("The AP was not powered off.")
+	}
+}
+
+func TestTime(t *testing.T) {
+	s, _ := testServer(nil)
+	if resp, err := s.Time(ctx.Background(), &syspb.TimeRequest{}); err != nil || resp.Time != 1538755500000000000 {
+		t.Errorf("Incorrect time %+v (error: %v).", resp, err)
+	}
+}
+
+func TestPing(t *testing.T) {
+	s, _ := testServer(nil)
+	cmds, _, restore := fakeCommand(testPingOutput, errors.New("exit status 1"))
+	defer restore()
+	stream := &pingStream{}
+	req := &syspb.PingRequest{
+		Destination:   "google.com",
+		Count:         3,
+		Interval:      int64(500 * time.Millisecond),
+		Wait:          int64(1500 * time.Millisecond),
+		DoNotFragment: true,
+		L3Protocol:    types.L3Protocol_IPV4,
+	}
+	// Lost pings make ping fail, the replies and the summary are still sent.
+	if err := s.Ping(req, stream); err != nil {
+		t.Fatalf("Ping failed. Error: %v.", err)
+	}
+	if cmd, want := <-cmds, "ping -c 3 -w 60 -i 0.5 -W 2 -M do -4 google.com"; cmd != want {
+		t.Errorf("Incorrect ping command (got: %q, want: %q).", cmd, want)
+	}
+	want := []*syspb.PingResponse{
+		{Source: "172.217.6.46", Bytes: 64, Sequence: 1, Ttl: 55, Time: 1670000},
+		{Source: "172.217.6.46", Bytes: 64, Sequence: 3, Ttl: 55, Time: 1590000},
+		{Source: "172.217.6.46", Time: 2003000000, Sent: 3, Received: 2, MinTime: 1590000, AvgTime: 1630000, MaxTime: 1670000, StdDev: 40000},
+	}
+	if !reflect.DeepEqual(stream.responses, want) {
+		t.Errorf("Incorrect ping responses (got: %+v, want: %+v).", stream.responses, want)
+	}
+
+	for _, req := range []*syspb.PingRequest{
+		{Destination: "-f"},
+		{Destination: "google.com", Count: -1},
+		{Destination: "google.com", Count: maxPingCount + 1},
+	} {
+		if err := s.Ping(req, stream); status.Code(err) != codes.InvalidArgument {
+			t.Errorf("Expected %+v to be invalid, got %v.", req, err)
+		}
+	}
+}
+
+func TestPingStreamClosed(t *testing.T) {
+	s, _ := testServer(nil)
+	_, killed, restore := fakeCommand(testPingOutput, nil)
+	defer restore()
+
+	// ping is killed once a reply can not be sent.
+	stream := &pingStream{maxResponses: 1}
+	if err := s.Ping(&syspb.PingRequest{Destination: "google.com"}, stream); status.Code(err) != codes.Unavailable {
+		t.Errorf("Expected a closed stream, got %v.", err)
+	}
+	if !<-killed {
+		t.Error("Expected ping to be killed.")
+	}
+	if len(stream.responses) != 1 {
+		t.Errorf("Incorrect ping responses %+v.", stream.responses)
+	}
+}
+
+func TestTraceroute(t *testing.T) {
+	s, _ := testServer(nil)
+	cmds, _, restore := fakeCommand(testTracerouteOutput, nil)
+	defer restore()
+	stream := &tracerouteStream{}
+	req := &syspb.TracerouteRequest{Destination: "8.8.8.8", MaxTtl: 3, L4Protocol: syspb.TracerouteRequest_UDP}
+	if err := s.Traceroute(req, stream); err != nil {
+		t.Fatalf("Traceroute failed. Error: %v.", err)
+	}
+	if cmd, want := <-cmds, "traceroute -q 1 -m 3 8.8.8.8"; cmd != want {
+		t.Errorf("Incorrect traceroute command (got: %q, want: %q).", cmd, want)
+	}
+	want := []*syspb.TracerouteResponse{
+		{DestinationName: "8.8.8.8", DestinationAddress: "8.8.8.8", Hops: 30, PacketSize: 60},
+		{Hop: 1, Name: "router.lan", Address: "192.168.1.1", Rtt: 512000},
+		{Hop: 2, State: syspb.TracerouteResponse_NONE},
+		{Hop: 3, Name: "10.0.0.1", Address: "10.0.0.1", Rtt: 5123000, State: syspb.TracerouteResponse_HOST_UNREACHABLE},
+	}
+	if !reflect.DeepEqual(stream.responses, want) {
+		t.Errorf("Incorrect traceroute responses (got: %+v, want: %+v).", stream.responses, want)
+	}
+
+	if err := s.Traceroute(&syspb.TracerouteRequest{Destination: "8.8.8.8", MaxTtl: maxTracerouteTTL + 1}, stream); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected an invalid max TTL, got %v.", err)
+	}
+}
@@ -0,0 +1,446 @@
+/* Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package grpcserver runs the gRPC server of the agent as configured by the grpc-server settings of the AP.
+//
+// Without settings, the server listens on the management address of the device with the credentials of the agent flags.
+// With settings, it listens on the configured addresses and port, and uses TLS with a certificate of the certificate store
+// if transport security is enabled. Clients are always authenticated with their certificates, so transport security can
+// not be disabled. Connections accepted before a change keep being served, and a replaced server
+// finishes its RPCs before it stops.
+package grpcserver
+
+import (
+	ctx "context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/golang/glog"
+	"github.com/google/link022/agent/certstore"
+	"github.com/google/link022/agent/gnmi"
+	"github.com/google/link022/agent/util/ocutil"
+	"github.com/google/link022/generated/ocstruct"
+	"github.com/openconfig/ygot/ygot"
+)
+
+const (
+	// refreshInterval is how often the gRPC server settings are loaded.
+	refreshInterval = 5 * time.Second
+	// stopTimeout is how long a replaced server has to finish its RPCs before its connections are closed.
+	stopTimeout = 30 * time.Second
+)
+
+// Server serves connections accepted on listeners, e.g. a grpc.Server.
+type Server interface {
+	Serve(listener net.Listener) error
+	GracefulStop()
+	Stop()
+}
+
+// Manager runs the gRPC server of the agent on the listeners required by its settings.
+type Manager struct {
+	hostName string
+	// port is the port of the server without configured port.
+	port  int
+	certs *certstore.Store
+	// newServer creates a server. It uses the credentials of the agent flags if defaultCredentials is true,
+	// otherwise the connections it serves are already secured as configured.
+	newServer func(defaultCredentials bool) Server
+	errs      chan error
+
+	mu           sync.Mutex
+	managementIP string
+	started      bool
+	settings     *ocutil.GRPCServer // The applied settings, nil for the default ones.
+	rejected     *ocutil.GRPCServer // Settings disabling the server, refused until they change.
+	server       Server
+	defaultCreds bool
+	tlsConfig    *tls.Config             // The TLS configuration of the listeners, nil without TLS.
+	listeners    map[string]net.Listener // address -> listener
+	// loaded indicates the settings were loaded from the configuration, updateErr is the error applying them.
+	loaded    bool
+	updateErr error
+}
+
+// NewManager creates a Manager serving the servers created by newServer, on the given port by default.
+// The TLS certificates are loaded from the given store.
+func NewManager(hostName string, port int, certs *certstore.Store, newServer func(defaultCredentials bool) Server) *Manager {
+	return &Manager{
+		hostName:  hostName,
+		port:      port,
+		certs:     certs,
+		newServer: newServer,
+		errs:      make(chan error, 1),
+		listeners: make(map[string]net.Listener),
+	}
+}
+
+// SetManagementIP sets the IP address of the management interface. The server moves to the new address
+// unless it listens on configured addresses.
+func (m *Manager) SetManagementIP(ipAddress string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if ipAddress == m.managementIP {
+		return nil
+	}
+	m.managementIP = ipAddress
+	if !m.started {
+		return nil
+	}
+	return m.apply(m.settings)
+}
+
+// Update applies the given settings if they changed. Nil settings restore the default ones.
+// Settings failing to apply leave the server as it is, and are applied again on the next update.
+func (m *Manager) Update(settings *ocutil.GRPCServer) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.started && reflect.DeepEqual(settings, m.settings) {
+		return nil
+	}
+	if m.rejected != nil && reflect.DeepEqual(settings, m.rejected) {
+		return nil
+	}
+	m.rejected = nil
+	if settings != nil && !settings.Enable {
+		m.rejected = settings
+		return errors.New("the gRPC server cannot be disabled, the configuration of the AP is only received through it")
+	}
+	if settings != nil && settings.TransportSecurity != nil && !*settings.TransportSecurity {
+		m.rejected = settings
+		return errors.New("transport security cannot be disabled, the clients of the gRPC server are authenticated with TLS")
+	}
+	if err := m.apply(settings); err != nil {
+		return err
+	}
+	m.started = true
+	m.settings = settings
+	return nil
+}
+
+// apply serves the server required by the given settings on their addresses. Listeners which do not change are kept.
+func (m *Manager) apply(settings *ocutil.GRPCServer) error {
+	defaultCreds := settings == nil || settings.TransportSecurity == nil
+	var tlsConfig *tls.Config
+	if settings != nil && settings.TransportSecurity != nil && *settings.TransportSecurity {
+		var err error
+		if tlsConfig, err = m.certs.ServerTLSConfig(settings.CertificateID); err != nil {
+			return err
+		}
+	}
+	addrs := m.addresses(settings)
+	if len(addrs) == 0 {
+		return errors.New("no address to listen on")
+	}
+
+	server := m.server
+	replaced := server == nil || defaultCreds != m.defaultCreds
+	if replaced {
+		server = m.newServer(defaultCreds)
+	}
+	// The listeners on the same addresses are kept if they serve the same server with the same security.
+	sameListeners := !replaced && sameSecurity(settings, m.settings)
+
+	// Accepted connections are not closed with their listener.
+	var previousAddrs []string
+	listeners := make(map[string]net.Listener)
+	for addr, listener := range m.listeners {
+		previousAddrs = append(previousAddrs, addr)
+		if sameListeners && containsAddr(addrs, addr) {
+			listeners[addr] = listener
+			continue
+		}
+		if err := listener.Close(); err != nil {
+			log.Warningf("Failed to close the listener on %s: %v", addr, err)
+		}
+	}
+	m.listeners = listeners
+	created := m.listen(server, addrs, tlsConfig)
+	if len(m.listeners) == 0 {
+		// Restore the previous listeners, the server stays reachable as before.
+		if m.server != nil {
+			m.listen(m.server, previousAddrs, m.tlsConfig)
+		}
+		if replaced {
+			server.Stop()
+		}
+		return fmt.Errorf("unable to listen on any of %v", addrs)
+	}
+	if replaced && m.server != nil {
+		go stopGracefully(m.server)
+	}
+	m.server = server
+	m.defaultCreds = defaultCreds
+	m.tlsConfig = tlsConfig
+	if created {
+		log.Infof("gRPC server listening on %v (TLS: %v).", addrs, tlsConfig != nil)
+	}
+	return nil
+}
+
+// listen serves the server on each of the given addresses without listener. It returns whether a listener was created.
+func (m *Manager) listen(server Server, addrs []string, tlsConfig *tls.Config) bool {
+	created := false
+	for _, addr := range addrs {
+		if _, ok := m.listeners[addr]; ok {
+			continue
+		}
+		listener, err := net.Listen("tcp", addr)
+		if err != nil {
+			log.Errorf("Failed to listen on %s: %v", addr, err)
+			continue
+		}
+		if tlsConfig != nil {
+			listener = tls.NewListener(listener, tlsConfig)
+		}
+		m.listeners[addr] = listener
+		go m.serve(server, addr, listener)
+		created = true
+	}
+	return created
+}
+
+// serve runs the server on a listener. The error of the server is reported unless the listener was closed by the Manager.
+func (m *Manager) serve(server Server, addr string, listener net.Listener) {
+	err := server.Serve(listener)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.listeners[addr] != listener {
+		return
+	}
+	select {
+	case m.errs <- fmt.Errorf("serving on %s failed: %v", addr, err):
+	default:
+	}
+}
+
+// stopGracefully stops a replaced server once its RPCs are finished, or when it runs out of time.
+func stopGracefully(server Server) {
+	stopped := make(chan struct{})
+	go func() {
+		server.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(stopTimeout):
+		log.Warning("The replaced gRPC server did not finish its RPCs in time, closing its connections.")
+		server.Stop()
+	}
+}
+
+// addresses returns the addresses to listen on with the given settings.
+func (m *Manager) addresses(settings *ocutil.GRPCServer) []string {
+	port := m.port
+	if settings != nil && settings.Port != 0 {
+		port = int(settings.Port)
+	}
+	ips := []string{m.managementIP}
+	if settings != nil && len(settings.ListenAddresses) != 0 {
+		ips = settings.ListenAddresses
+	}
+	var addrs []string
+	for _, ip := range ips {
+		if ip != "" {
+			addrs = append(addrs, net.JoinHostPort(ip, strconv.Itoa(port)))
+		}
+	}
+	return addrs
+}
+
+// sameSecurity checks whether two settings secure the connections the same way.
+func sameSecurity(settingsA, settingsB *ocutil.GRPCServer) bool {
+	if settingsA == nil || settingsB == nil {
+		return settingsA == settingsB
+	}
+	return reflect.DeepEqual(settingsA.TransportSecurity, settingsB.TransportSecurity) && settingsA.CertificateID == settingsB.CertificateID
+}
+
+func containsAddr(addrs []string, addr string) bool {
+	for _, a := range addrs {
+		if a == addr {
+			return true
+		}
+	}
+	return false
+}
+
+// listenAddrs returns the addresses the server listens on, in ascending order.
+func (m *Manager) listenAddrs() []string {
+	var addrs []string
+	for addr := range m.listeners {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+	return addrs
+}
+
+// Addr returns the address the controller reaches the server at, empty if the server does not listen yet.
+// It is the management address if the server listens on it, otherwise the first address it listens on.
+func (m *Manager) Addr() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	addrs := m.listenAddrs()
+	for _, addr := range addrs {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil || m.managementIP == "" {
+			continue
+		}
+		if host == m.managementIP || host == ocutil.AnyAddress {
+			return net.JoinHostPort(m.managementIP, port)
+		}
+	}
+	if len(addrs) == 0 {
+		return ""
+	}
+	return addrs[0]
+}
+
+// CertificateID returns the ID of the certificate the server uses, empty if it does not use one of the certificate store.
+func (m *Manager) CertificateID() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.tlsConfig == nil {
+		return ""
+	}
+	return m.settings.CertificateID
+}
+
+// Err returns why the settings of the configuration are not applied by Run, nil once they are.
+func (m *Manager) Err() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	switch {
+	case !m.loaded:
+		return errors.New("the gRPC server settings are not loaded yet")
+	case m.updateErr != nil:
+		return m.updateErr
+	case m.rejected != nil:
+		return errors.New("the gRPC server settings are rejected")
+	}
+	return nil
+}
+
+// Wait blocks until the server fails on one of its listeners, and returns the error.
+func (m *Manager) Wait() error {
+	return <-m.errs
+}
+
+// Run applies the gRPC server settings periodically until the context is done, and publishes the applied ones.
+// The settings are loaded from the GNMI server. addrChanged is called with the new address of the server when it changes.
+func (m *Manager) Run(bkgdContext ctx.Context, gnmiServer *gnmi.Server, addrChanged func(addr string)) {
+	addr := m.Addr()
+	update := func() {
+		err := m.Update(grpcServerSettings(gnmiServer, m.hostName))
+		if err != nil {
+			log.Errorf("Error in updating the gRPC server: %v", err)
+		}
+		m.mu.Lock()
+		m.loaded = true
+		m.updateErr = err
+		m.mu.Unlock()
+		if newAddr := m.Addr(); newAddr != addr {
+			addr = newAddr
+			addrChanged(addr)
+		}
+		m.publishState(gnmiServer)
+	}
+
+	update()
+	refresh := time.NewTicker(refreshInterval)
+	defer refresh.Stop()
+	for {
+		select {
+		case <-bkgdContext.Done():
+			return
+		case <-refresh.C:
+			update()
+		}
+	}
+}
+
+// publishState updates the gRPC server state of the AP with the applied settings.
+func (m *Manager) publishState(gnmiServer *gnmi.Server) {
+	m.mu.Lock()
+	port := m.port
+	if m.settings != nil && m.settings.Port != 0 {
+		port = int(m.settings.Port)
+	}
+	state := &ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System_GrpcServer_State{
+		Enable: ygot.Bool(true),
+		Port:   ygot.Uint16(uint16(port)),
+	}
+	if m.settings != nil && m.settings.TransportSecurity != nil {
+		state.TransportSecurity = ygot.Bool(*m.settings.TransportSecurity)
+	}
+	if m.tlsConfig != nil {
+		state.CertificateId = ygot.String(m.settings.CertificateID)
+	}
+	for _, addr := range m.listenAddrs() {
+		host, _, _ := net.SplitHostPort(addr)
+		if host == ocutil.AnyAddress {
+			state.ListenAddresses = append(state.ListenAddresses, &ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System_GrpcServer_State_ListenAddresses_Union_E_OpenconfigAccessPoints_AccessPoints_AccessPoint_System_GrpcServer_Config_ListenAddresses{
+				E_OpenconfigAccessPoints_AccessPoints_AccessPoint_System_GrpcServer_Config_ListenAddresses: ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System_GrpcServer_Config_ListenAddresses_ANY,
+			})
+			continue
+		}
+		state.ListenAddresses = append(state.ListenAddresses, &ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System_GrpcServer_State_ListenAddresses_Union_String{String: host})
+	}
+	m.mu.Unlock()
+
+	err := gnmiServer.InternalUpdate(func(config ygot.ValidatedGoStruct) error {
+		device, ok := config.(*ocstruct.Device)
+		if !ok {
+			return errors.New("configuration has invalid type")
+		}
+		apConfig := ocutil.FindAPConfig(device, m.hostName)
+		if apConfig == nil || apConfig.System == nil || apConfig.System.GrpcServer == nil {
+			return nil
+		}
+		apConfig.System.GrpcServer.State = state
+		return nil
+	})
+	if err != nil {
+		log.Errorf("Error in updating the gRPC server state: %v", err)
+	}
+}
+
+// grpcServerSettings loads the gRPC server settings of the AP with the given hostname.
+func grpcServerSettings(gnmiServer *gnmi.Server, hostName string) *ocutil.GRPCServer {
+	var settings *ocutil.GRPCServer
+	gnmiServer.InternalUpdate(func(config ygot.ValidatedGoStruct) error {
+		device, ok := config.(*ocstruct.Device)
+		if !ok {
+			return errors.New("configuration has invalid type")
+		}
+		settings = ocutil.GRPCServerSettings(ocutil.FindAPConfig(device, hostName))
+		return nil
+	})
+	return settings
+}
@@ -0,0 +1,283 @@
+/* Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package grpcserver
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/google/link022/agent/certstore"
+	"github.com/google/link022/agent/util/ocutil"
+	"github.com/openconfig/ygot/ygot"
+)
+
+// echoServer writes a greeting on every accepted connection.
+type echoServer struct {
+	stopped chan bool
+}
+
+func newEchoServer() *echoServer {
+	return &echoServer{stopped: make(chan bool, 1)}
+}
+
+func (s *echoServer) Serve(listener net.Listener) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		conn.Write([]byte("hello"))
+		conn.Close()
+	}
+}
+
+func (s *echoServer) GracefulStop() {
+	s.stopped <- true
+}
+
+func (s *echoServer) Stop() {}
+
+// freePort returns a TCP port available on the loopback interface.
+func freePort(t *testing.T) int {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unable to find a free port. Error: %v.", err)
+	}
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+func greeting(addr string, tlsConfig *tls.Config) (string, error) {
+	conn, err := net.DialTimeout("tcp", addr, time.Second)
+	if err != nil {
+		return "", err
+	}
+	if tlsConfig != nil {
+		conn = tls.Client(conn, tlsConfig)
+	}
+	defer conn.Close()
+	buf := make([]byte, 5)
+	n, err := conn.Read(buf)
+	return string(buf[:n]), err
+}
+
+// writeCert writes a self-signed certificate for 127.0.0.1 and its key to the given certificate store folder.
+// The certificate can also authenticate a client.
+func writeCert(t *testing.T, dir, id string) *x509.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Unable to generate a key. Error: %v.", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "test-pi-1"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Unable to create a certificate. Error: %v.", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("Unable to marshal the key. Error: %v.", err)
+	}
+	if err := ioutil.WriteFile(path.Join(dir, id+".crt"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path.Join(dir, id+".key"), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+// testManager creates a Manager on the loopback interface, which records the servers it creates.
+func testManager(t *testing.T, port int, certs *certstore.Store) (*Manager, *[]*echoServer) {
+	var servers []*echoServer
+	m := NewManager("test-pi-1", port, certs, func(defaultCredentials bool) Server {
+		server := newEchoServer()
+		servers = append(servers, server)
+		return server
+	})
+	if err := m.SetManagementIP("127.0.0.1"); err != nil {
+		t.Fatalf("Setting the management IP failed. Error: %v.", err)
+	}
+	return m, &servers
+}
+
+func TestManagementIP(t *testing.T) {
+	port := freePort(t)
+	m, _ := testManager(t, port, nil)
+
+	if err := m.Update(nil); err != nil {
+		t.Fatalf("Starting the server failed. Error: %v.", err)
+	}
+	firstAddr := net.JoinHostPort("127.0.0.1", strconv.Itoa(port))
+	if m.Addr() != firstAddr {
+		t.Errorf("Incorrect address (got: %s, want: %s).", m.Addr(), firstAddr)
+	}
+	if got, err := greeting(firstAddr, nil); err != nil || got != "hello" {
+		t.Errorf("Server not reachable on %s: %q (error: %v).", firstAddr, got, err)
+	}
+
+	// The whole 127.0.0.0/8 subnet is on the loopback interface.
+	if err := m.SetManagementIP("127.0.0.2"); err != nil {
+		t.Fatalf("Moving to 127.0.0.2 failed. Error: %v.", err)
+	}
+	secondAddr := net.JoinHostPort("127.0.0.2", strconv.Itoa(port))
+	if got, err := greeting(secondAddr, nil); err != nil || got != "hello" {
+		t.Errorf("Server not reachable on %s: %q (error: %v).", secondAddr, got, err)
+	}
+	if _, err := greeting(firstAddr, nil); err == nil {
+		t.Errorf("Server still reachable on the previous address %s.", firstAddr)
+	}
+
+	// Closed listeners are not reported as failures.
+	select {
+	case err := <-m.errs:
+		t.Errorf("Unexpected server failure: %v.", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	port := freePort(t)
+	m, servers := testManager(t, port, nil)
+	if err := m.Update(nil); err != nil {
+		t.Fatalf("Starting the server failed. Error: %v.", err)
+	}
+
+	// Configured addresses and port.
+	newPort := freePort(t)
+	settings := &ocutil.GRPCServer{
+		Enable:          true,
+		Port:            uint16(newPort),
+		ListenAddresses: []string{"127.0.0.2", "127.0.0.3"},
+	}
+	if err := m.Update(settings); err != nil {
+		t.Fatalf("Applying the settings failed. Error: %v.", err)
+	}
+	for _, ip := range settings.ListenAddresses {
+		addr := net.JoinHostPort(ip, strconv.Itoa(newPort))
+		if got, err := greeting(addr, nil); err != nil || got != "hello" {
+			t.Errorf("Server not reachable on %s: %q (error: %v).", addr, got, err)
+		}
+	}
+	if want := net.JoinHostPort("127.0.0.2", strconv.Itoa(newPort)); m.Addr() != want {
+		t.Errorf("Incorrect address (got: %s, want: %s).", m.Addr(), want)
+	}
+	// The server using the credentials of the flags is kept on the new listeners.
+	if len(*servers) != 1 {
+		t.Errorf("Expected the server to be kept, got %d servers.", len(*servers))
+	}
+
+	// The server and its transport security cannot be disabled.
+	for _, rejected := range []*ocutil.GRPCServer{
+		{Enable: false},
+		{Enable: true, TransportSecurity: ygot.Bool(false)},
+	} {
+		if err := m.Update(rejected); err == nil {
+			t.Errorf("Expected an error applying %+v.", rejected)
+		}
+		if err := m.Update(rejected); err != nil {
+			t.Errorf("Expected rejected settings to be ignored until they change. Error: %v.", err)
+		}
+	}
+	addr := net.JoinHostPort("127.0.0.3", strconv.Itoa(newPort))
+	if got, err := greeting(addr, nil); err != nil || got != "hello" {
+		t.Errorf("Server not reachable on %s after rejected settings: %q (error: %v).", addr, got, err)
+	}
+}
+
+func TestTransportSecurity(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certstore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	port := freePort(t)
+	m, servers := testManager(t, port, certstore.New(dir, ""))
+	addr := net.JoinHostPort("127.0.0.1", strconv.Itoa(port))
+
+	settings := &ocutil.GRPCServer{
+		Enable:            true,
+		TransportSecurity: ygot.Bool(true),
+		CertificateID:     "gnmi",
+	}
+	// Settings with a missing certificate are not applied until the certificate is stored.
+	if err := m.Update(settings); err == nil {
+		t.Error("Expected an error for a missing certificate.")
+	}
+	if m.Addr() != "" {
+		t.Errorf("Expected no listener, got %s.", m.Addr())
+	}
+
+	cert := writeCert(t, dir, "gnmi")
+	// Nor until there is a CA certificate to authenticate the clients.
+	if err := m.Update(settings); err == nil {
+		t.Error("Expected an error without CA certificates.")
+	}
+
+	// The certificate of the server also authenticates the client.
+	if err := os.Link(path.Join(dir, "gnmi.crt"), path.Join(dir, "ca.crt")); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Update(settings); err != nil {
+		t.Fatalf("Applying the settings failed. Error: %v.", err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+	clientCert, err := tls.LoadX509KeyPair(path.Join(dir, "gnmi.crt"), path.Join(dir, "gnmi.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := greeting(addr, &tls.Config{RootCAs: roots, ServerName: "127.0.0.1", Certificates: []tls.Certificate{clientCert}}); err != nil || got != "hello" {
+		t.Errorf("Server not reachable over TLS on %s: %q (error: %v).", addr, got, err)
+	}
+	if got, err := greeting(addr, &tls.Config{RootCAs: roots, ServerName: "127.0.0.1"}); err == nil {
+		t.Errorf("Server reachable over TLS without client certificate: %q.", got)
+	}
+
+	// Back to the credentials of the flags, the TLS server is replaced and stopped gracefully.
+	if err := m.Update(nil); err != nil {
+		t.Fatalf("Restoring the default settings failed. Error: %v.", err)
+	}
+	select {
+	case <-(*servers)[0].stopped:
+	case <-time.After(time.Second):
+		t.Error("The replaced server was not stopped.")
+	}
+}
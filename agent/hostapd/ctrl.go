@@ -0,0 +1,235 @@
+/* Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package hostapd contains methods that listen to hostapd events through its control interface.
+package hostapd
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	log "github.com/golang/glog"
+)
+
+const (
+	// CtrlInterfaceDir is the folder of hostapd control interface sockets.
+	CtrlInterfaceDir = "/var/run/hostapd"
+	// GlobalCtrlInterface is the hostapd global control interface socket, used to add and remove BSSs.
+	GlobalCtrlInterface = "/var/run/hostapd-global"
+
+	attachTimeout = 5 * time.Second
+	// monitorRetryDelay is the delay before re-attaching to a BSS after its monitor stopped.
+	monitorRetryDelay = 5 * time.Second
+	readTimeout       = 1 * time.Second
+	maxEventSize      = 4096
+)
+
+// monitorCount numbers the monitors, so that monitors of the same BSS get different local sockets.
+var monitorCount uint64
+
+// Event is an unsolicited message hostapd sends to attached monitors.
+type Event struct {
+	// IntfName is the BSS interface the event comes from.
+	IntfName string
+	// Name is the event name, e.g. "AP-STA-CONNECTED".
+	Name string
+	// Args contains the positional arguments of the event.
+	Args []string
+	// Params contains the "key=value" arguments of the event.
+	Params map[string]string
+	// Level is the message level of hostapd, e.g. 3 for info. -1 if the message has no level prefix.
+	Level int
+	// Msg is the message without its level prefix.
+	Msg string
+}
+
+// ParseEvent parses a message received from hostapd control interface.
+// A message looks like "<3>AP-STA-CONNECTED 12:34:56:78:9a:bc".
+func ParseEvent(intfName, msg string) *Event {
+	msg = strings.TrimSpace(msg)
+	// Remove the message level prefix.
+	level := -1
+	if strings.HasPrefix(msg, "<") {
+		if end := strings.Index(msg, ">"); end >= 0 {
+			if l, err := strconv.Atoi(msg[1:end]); err == nil {
+				level = l
+			}
+			msg = msg[end+1:]
+		}
+	}
+
+	fields := strings.Fields(msg)
+	if len(fields) == 0 {
+		return nil
+	}
+
+	event := &Event{
+		IntfName: intfName,
+		Name:     fields[0],
+		Params:   make(map[string]string),
+		Level:    level,
+		Msg:      msg,
+	}
+	for _, field := range fields[1:] {
+		if sep := strings.Index(field, "="); sep > 0 {
+			event.Params[field[:sep]] = field[sep+1:]
+		} else {
+			event.Args = append(event.Args, field)
+		}
+	}
+	return event
+}
+
+// Monitor attaches to the hostapd control interface of the given BSS interface,
+// and sends the received events to the given channel. With probeEvents, hostapd also
+// reports each probe request received as a RX-PROBE-REQUEST event.
+// It returns when the context is done or the control interface is not reachable.
+func Monitor(ctx context.Context, ctrlDir, intfName string, probeEvents bool, events chan<- *Event) error {
+	localAddr := &net.UnixAddr{
+		Name: path.Join(os.TempDir(), fmt.Sprintf("link022_%s_%d_%d", intfName, os.Getpid(), atomic.AddUint64(&monitorCount, 1))),
+		Net:  "unixgram",
+	}
+	remoteAddr := &net.UnixAddr{
+		Name: path.Join(ctrlDir, intfName),
+		Net:  "unixgram",
+	}
+
+	// Clean up the socket left by a previous monitor.
+	os.Remove(localAddr.Name)
+	conn, err := net.DialUnix("unixgram", localAddr, remoteAddr)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(localAddr.Name)
+	defer conn.Close()
+
+	attachCmd := "ATTACH"
+	if probeEvents {
+		attachCmd += " probe_rx_events=1"
+	}
+	if err := attach(conn, attachCmd); err != nil {
+		return fmt.Errorf("failed to attach to hostapd on %s: %v", intfName, err)
+	}
+	log.Infof("Attached to hostapd control interface of %s.", intfName)
+	defer conn.Write([]byte("DETACH"))
+
+	buf := make([]byte, maxEventSize)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		default:
+		}
+
+		conn.SetReadDeadline(time.Now().Add(readTimeout))
+		n, err := conn.Read(buf)
+		if err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				continue
+			}
+			return err
+		}
+
+		if event := ParseEvent(intfName, string(buf[:n])); event != nil {
+			select {
+			case events <- event:
+			case <-ctx.Done():
+				return nil
+			}
+		}
+	}
+}
+
+// attach sends the attach command and waits for the reply.
+func attach(conn *net.UnixConn, cmd string) error {
+	if _, err := conn.Write([]byte(cmd)); err != nil {
+		return err
+	}
+
+	buf := make([]byte, maxEventSize)
+	conn.SetReadDeadline(time.Now().Add(attachTimeout))
+	n, err := conn.Read(buf)
+	if err != nil {
+		return err
+	}
+	if reply := strings.TrimSpace(string(buf[:n])); reply != "OK" {
+		return fmt.Errorf("unexpected reply %q", reply)
+	}
+	return nil
+}
+
+// Monitors keeps a monitor running on each of a set of BSS interfaces.
+type Monitors struct {
+	ctx         context.Context
+	ctrlDir     string
+	probeEvents bool
+	events      chan<- *Event
+	cancels     map[string]context.CancelFunc // BSS interface name -> monitor cancel function
+}
+
+// NewMonitors creates Monitors sending events of all monitored BSSs to the given channel,
+// including the probe requests with probeEvents. All monitors stop when the context is done.
+func NewMonitors(ctx context.Context, ctrlDir string, probeEvents bool, events chan<- *Event) *Monitors {
+	return &Monitors{
+		ctx:         ctx,
+		ctrlDir:     ctrlDir,
+		probeEvents: probeEvents,
+		events:      events,
+		cancels:     make(map[string]context.CancelFunc),
+	}
+}
+
+// Update attaches to the new BSS interfaces, and detaches from those not in the given set.
+func (m *Monitors) Update(intfNames map[string]bool) {
+	for intfName, cancel := range m.cancels {
+		if !intfNames[intfName] {
+			cancel()
+			delete(m.cancels, intfName)
+		}
+	}
+	for intfName := range intfNames {
+		if _, ok := m.cancels[intfName]; !ok {
+			monitorCtx, cancel := context.WithCancel(m.ctx)
+			m.cancels[intfName] = cancel
+			go m.keepMonitoring(monitorCtx, intfName)
+		}
+	}
+}
+
+// Stop detaches from all BSS interfaces.
+func (m *Monitors) Stop() {
+	m.Update(nil)
+}
+
+// keepMonitoring re-attaches to the BSS interface until the context is done.
+func (m *Monitors) keepMonitoring(ctx context.Context, intfName string) {
+	for {
+		if err := Monitor(ctx, m.ctrlDir, intfName, m.probeEvents, m.events); err != nil {
+			log.Errorf("Monitoring hostapd events on %s failed: %v", intfName, err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(monitorRetryDelay):
+		}
+	}
+}
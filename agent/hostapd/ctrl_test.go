@@ -0,0 +1,168 @@
+/* Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hostapd
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+	"time"
+)
+
+const testIntf = "wlan0_1"
+
+func TestParseEvent(t *testing.T) {
+	tests := []struct {
+		msg   string
+		event *Event
+	}{{
+		msg: "<3>AP-STA-CONNECTED 12:34:56:78:9a:bc",
+		event: &Event{
+			IntfName: testIntf,
+			Name:     "AP-STA-CONNECTED",
+			Args:     []string{"12:34:56:78:9a:bc"},
+			Params:   map[string]string{},
+			Level:    3,
+			Msg:      "AP-STA-CONNECTED 12:34:56:78:9a:bc",
+		},
+	}, {
+		msg: "<2>RX-PROBE-REQUEST sa=12:34:56:78:9a:bc signal=-55\n",
+		event: &Event{
+			IntfName: testIntf,
+			Name:     "RX-PROBE-REQUEST",
+			Params:   map[string]string{"sa": "12:34:56:78:9a:bc", "signal": "-55"},
+			Level:    2,
+			Msg:      "RX-PROBE-REQUEST sa=12:34:56:78:9a:bc signal=-55",
+		},
+	}, {
+		msg: "AP-ENABLED",
+		event: &Event{
+			IntfName: testIntf,
+			Name:     "AP-ENABLED",
+			Params:   map[string]string{},
+			Level:    -1,
+			Msg:      "AP-ENABLED",
+		},
+	}, {
+		msg:   "<3>",
+		event: nil,
+	}}
+
+	for _, test := range tests {
+		got := ParseEvent(testIntf, test.msg)
+		if !reflect.DeepEqual(got, test.event) {
+			t.Errorf("Incorrect event of %q (got: %v, want: %v).", test.msg, got, test.event)
+		}
+	}
+}
+
+// fakeHostapd serves a hostapd control interface socket, which accepts the given attach command and sends the given events.
+func fakeHostapd(t *testing.T, ctrlDir, attachCmd string, events []string) {
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path.Join(ctrlDir, testIntf), Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("Failed to create the fake control interface. Error: %v.", err)
+	}
+
+	go func() {
+		defer conn.Close()
+		buf := make([]byte, maxEventSize)
+		n, monitorAddr, err := conn.ReadFromUnix(buf)
+		if err != nil || string(buf[:n]) != attachCmd {
+			return
+		}
+		conn.WriteToUnix([]byte("OK\n"), monitorAddr)
+		for _, event := range events {
+			conn.WriteToUnix([]byte(event), monitorAddr)
+		}
+		// Wait for DETACH.
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		conn.ReadFromUnix(buf)
+	}()
+}
+
+func TestMonitor(t *testing.T) {
+	ctrlDir, err := ioutil.TempDir("", "hostapd")
+	if err != nil {
+		t.Fatalf("Unable to create a temp control interface folder.")
+	}
+	defer os.RemoveAll(ctrlDir)
+
+	fakeHostapd(t, ctrlDir, "ATTACH", []string{
+		"<3>AP-STA-CONNECTED 12:34:56:78:9a:bc",
+		"<3>AP-STA-DISCONNECTED 12:34:56:78:9a:bc",
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	events := make(chan *Event)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- Monitor(ctx, ctrlDir, testIntf, false, events)
+	}()
+
+	for _, want := range []string{"AP-STA-CONNECTED", "AP-STA-DISCONNECTED"} {
+		select {
+		case event := <-events:
+			if event.Name != want || event.IntfName != testIntf {
+				t.Errorf("Incorrect event (got: %v, want: %s).", event, want)
+			}
+		case err := <-errCh:
+			t.Fatalf("Monitor stopped unexpectedly. Error: %v.", err)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for event %s.", want)
+		}
+	}
+
+	cancel()
+	if err := <-errCh; err != nil {
+		t.Errorf("Monitor returned error after cancel. Error: %v.", err)
+	}
+}
+
+func TestMonitors(t *testing.T) {
+	ctrlDir, err := ioutil.TempDir("", "hostapd")
+	if err != nil {
+		t.Fatalf("Unable to create a temp control interface folder.")
+	}
+	defer os.RemoveAll(ctrlDir)
+
+	fakeHostapd(t, ctrlDir, "ATTACH probe_rx_events=1", []string{"<2>RX-PROBE-REQUEST sa=12:34:56:78:9a:bc signal=-55"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := make(chan *Event)
+	monitors := NewMonitors(ctx, ctrlDir, true, events)
+	monitors.Update(map[string]bool{testIntf: true})
+	// Updating with the same BSSs does not attach again.
+	monitors.Update(map[string]bool{testIntf: true})
+
+	select {
+	case event := <-events:
+		if event.Name != "RX-PROBE-REQUEST" || event.IntfName != testIntf {
+			t.Errorf("Incorrect event: %v.", event)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for event.")
+	}
+
+	monitors.Stop()
+	if len(monitors.cancels) != 0 {
+		t.Errorf("Monitors still running after stop: %v.", monitors.cancels)
+	}
+}
@@ -0,0 +1,328 @@
+/* Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package logging ships the logs of the agent and the events of hostapd to remote syslog servers,
+// and filters the logs written on the console.
+//
+// The logs of the agent are captured from glog, which also writes them to stderr. The console selectors
+// of the AP select the logs written to the original stderr, and a debug selector raises the glog verbosity.
+// The selectors of each remote syslog server select the messages sent to it.
+package logging
+
+import (
+	"bufio"
+	ctx "context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"regexp"
+	"sync"
+	"time"
+
+	log "github.com/golang/glog"
+	"github.com/google/link022/agent/gnmi"
+	"github.com/google/link022/agent/hostapd"
+	"github.com/google/link022/agent/monitoring"
+	"github.com/google/link022/agent/util/ocutil"
+	"github.com/google/link022/generated/ocstruct"
+	"github.com/openconfig/ygot/ygot"
+)
+
+const (
+	// refreshInterval is how often the logging settings and the monitored BSSs are refreshed.
+	refreshInterval = 10 * time.Second
+
+	agentAppName   = "link022-agent"
+	hostapdAppName = "hostapd"
+
+	// debugVerbosity is the glog verbosity of the agent when the console selects debug messages.
+	debugVerbosity = "2"
+)
+
+// glogHeader matches the header of a glog line, e.g. "I1018 16:05:00.123456    1234 agent.go:70] ".
+var glogHeader = regexp.MustCompile(`^([IWEF])\d{4} \d{2}:\d{2}:\d{2}\.\d{6}\s+\d+ [^\]]*\] `)
+
+// glogSeverities maps the glog severities to syslog severity codes.
+var glogSeverities = map[string]int{
+	"I": severityInformational,
+	"W": severityWarning,
+	"E": severityError,
+	"F": severityCritical,
+}
+
+// defaultConsoleSelectors select the logs glog writes to stderr by default, errors and higher severities.
+var defaultConsoleSelectors = []ocutil.SyslogSelector{{Facility: -1, Severity: severityError}}
+
+// Logger sends the logs of the agent and the events of hostapd to the remote syslog servers,
+// and writes the logs of the agent selected by the console to stderr.
+type Logger struct {
+	hostName string
+	now      func() time.Time
+	// stderr is where the logs selected by the console are written.
+	stderr io.Writer
+	// setVerbosity sets the glog verbosity, e.g. "2".
+	setVerbosity func(verbosity string) error
+	// defaultVerbosity is the glog verbosity the agent started with.
+	defaultVerbosity string
+	// defaultConsole are the console selectors without console settings.
+	defaultConsole []ocutil.SyslogSelector
+
+	mu      sync.Mutex
+	console []ocutil.SyslogSelector
+	remotes map[string]*remote // host -> remote server
+}
+
+// NewLogger creates a Logger sending messages from the device with the given hostname.
+func NewLogger(hostName string) *Logger {
+	defaultVerbosity := ""
+	if v := flag.Lookup("v"); v != nil {
+		defaultVerbosity = v.Value.String()
+	}
+	return &Logger{
+		hostName: hostName,
+		now:      time.Now,
+		stderr:   os.Stderr,
+		setVerbosity: func(verbosity string) error {
+			return flag.Set("v", verbosity)
+		},
+		defaultVerbosity: defaultVerbosity,
+		defaultConsole:   defaultConsoleSelectors,
+		console:          defaultConsoleSelectors,
+		remotes:          make(map[string]*remote),
+	}
+}
+
+// CaptureGlog captures the logs of the agent. glog writes all logs to stderr, which is replaced by a pipe read by the Logger.
+// If glog was started with logtostderr or alsologtostderr, all logs are written to the console by default.
+func (l *Logger) CaptureGlog() error {
+	for _, name := range []string{"logtostderr", "alsologtostderr"} {
+		if f := flag.Lookup(name); f != nil && f.Value.String() == "true" {
+			l.defaultConsole = []ocutil.SyslogSelector{{Facility: -1, Severity: severityDebug}}
+			l.console = l.defaultConsole
+		}
+	}
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	if err := flag.Set("alsologtostderr", "true"); err != nil {
+		reader.Close()
+		writer.Close()
+		return err
+	}
+	l.stderr = os.Stderr
+	os.Stderr = writer
+	go l.readGlog(reader)
+	return nil
+}
+
+// readGlog logs the glog lines read from the given reader, until it is closed.
+func (l *Logger) readGlog(reader io.Reader) {
+	scanner := bufio.NewScanner(reader)
+	// Lines without a header continue the previous message, e.g. the output of a command.
+	severity := severityInformational
+	for scanner.Scan() {
+		line := scanner.Text()
+		if match := glogHeader.FindStringSubmatch(line); match != nil {
+			severity = glogSeverities[match[1]]
+			line = line[len(match[0]):]
+		}
+		l.LogAgent(severity, line)
+	}
+}
+
+// LogAgent writes a log line of the agent with the given severity to the console, if selected, and sends it to the remote servers.
+func (l *Logger) LogAgent(severity int, line string) {
+	l.mu.Lock()
+	console := l.console
+	l.mu.Unlock()
+	for _, selector := range console {
+		if selector.Match(facilityDaemon, severity) {
+			fmt.Fprintln(l.stderr, line)
+			break
+		}
+	}
+
+	l.Log(&Message{
+		Time:     l.now(),
+		Facility: facilityDaemon,
+		Severity: severity,
+		Hostname: l.hostName,
+		AppName:  agentAppName,
+		ProcID:   os.Getpid(),
+		Text:     line,
+	})
+}
+
+// LogHostapdEvent sends a hostapd event to the remote servers.
+func (l *Logger) LogHostapdEvent(event *hostapd.Event) {
+	l.Log(&Message{
+		Time:     l.now(),
+		Facility: facilityDaemon,
+		Severity: hostapdSeverity(event.Level),
+		Hostname: l.hostName,
+		AppName:  hostapdAppName,
+		Text:     fmt.Sprintf("%s: %s", event.IntfName, event.Msg),
+	})
+}
+
+// hostapdSeverity maps the message level of hostapd to a syslog severity code.
+func hostapdSeverity(level int) int {
+	switch {
+	case level >= 5:
+		return severityError
+	case level == 4:
+		return severityWarning
+	case level == 3 || level < 0:
+		return severityInformational
+	default:
+		return severityDebug
+	}
+}
+
+// Log sends a message to the remote servers selecting it.
+func (l *Logger) Log(m *Message) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var msg string
+	for _, r := range l.remotes {
+		if !r.selects(m.Facility, m.Severity) {
+			continue
+		}
+		if msg == "" {
+			msg = m.Format()
+		}
+		r.send(msg)
+	}
+}
+
+// UpdateSettings replaces the console selectors and the remote servers. Nil console selectors restore the default ones.
+// The glog verbosity is raised if the console selects debug messages of the agent.
+func (l *Logger) UpdateSettings(console []ocutil.SyslogSelector, servers []*ocutil.RemoteSyslogServer) {
+	verbosity := l.defaultVerbosity
+	if console == nil {
+		console = l.defaultConsole
+	} else {
+		for _, selector := range console {
+			if selector.Match(facilityDaemon, severityDebug) {
+				verbosity = debugVerbosity
+			}
+		}
+	}
+
+	l.mu.Lock()
+	consoleChanged := !reflect.DeepEqual(console, l.console)
+	l.console = console
+
+	updated := make(map[string]*ocutil.RemoteSyslogServer)
+	for _, server := range servers {
+		updated[server.Host] = server
+	}
+	var stopped, started []string
+	for host, r := range l.remotes {
+		if server, ok := updated[host]; !ok || !reflect.DeepEqual(server, r.settings) {
+			r.stop()
+			delete(l.remotes, host)
+			stopped = append(stopped, host)
+		}
+	}
+	for host, server := range updated {
+		if _, ok := l.remotes[host]; ok {
+			continue
+		}
+		r := newRemote(server)
+		go r.run()
+		l.remotes[host] = r
+		started = append(started, r.addr())
+	}
+	l.mu.Unlock()
+
+	// Logging while holding the lock would dead lock on the captured logs.
+	if consoleChanged && verbosity != "" {
+		if err := l.setVerbosity(verbosity); err != nil {
+			log.Errorf("Failed to set the log verbosity to %s: %v", verbosity, err)
+		}
+	}
+	for _, host := range stopped {
+		log.Infof("Stopped sending logs to syslog server %s.", host)
+	}
+	for _, addr := range started {
+		log.Infof("Sending logs to syslog server %s.", addr)
+	}
+}
+
+// remoteServers returns whether logs are sent to remote servers.
+func (l *Logger) remoteServers() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return len(l.remotes) != 0
+}
+
+// Run applies the logging settings and sends the hostapd events to the remote servers until the context is done.
+// The settings are loaded from the GNMI server periodically.
+func (l *Logger) Run(bkgdContext ctx.Context, gnmiServer *gnmi.Server) {
+	events := make(chan *hostapd.Event)
+	monitors := hostapd.NewMonitors(bkgdContext, hostapd.CtrlInterfaceDir, false, events)
+	defer monitors.Stop()
+
+	apply := func() {
+		l.UpdateSettings(loggingSettings(gnmiServer, l.hostName))
+		// hostapd is only monitored while its events are sent somewhere.
+		intfNames := make(map[string]bool)
+		if l.remoteServers() {
+			for _, bss := range monitoring.BSSs() {
+				intfNames[bss.IntfName] = true
+			}
+		}
+		monitors.Update(intfNames)
+	}
+
+	refresh := time.NewTicker(refreshInterval)
+	defer refresh.Stop()
+	defer l.UpdateSettings(nil, nil)
+	apply()
+	for {
+		select {
+		case <-bkgdContext.Done():
+			return
+		case event := <-events:
+			l.LogHostapdEvent(event)
+		case <-refresh.C:
+			apply()
+		}
+	}
+}
+
+// loggingSettings returns the console selectors and the remote syslog servers of the AP with the given hostname.
+func loggingSettings(gnmiServer *gnmi.Server, hostName string) ([]ocutil.SyslogSelector, []*ocutil.RemoteSyslogServer) {
+	var console []ocutil.SyslogSelector
+	var servers []*ocutil.RemoteSyslogServer
+	gnmiServer.InternalUpdate(func(config ygot.ValidatedGoStruct) error {
+		device, ok := config.(*ocstruct.Device)
+		if !ok {
+			return errors.New("configuration has invalid type")
+		}
+		apConfig := ocutil.FindAPConfig(device, hostName)
+		console = ocutil.ConsoleSyslogSelectors(apConfig)
+		servers = ocutil.RemoteSyslogServers(apConfig)
+		return nil
+	})
+	return console, servers
+}
@@ -0,0 +1,141 @@
+/* Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package logging
+
+import (
+	"bytes"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/link022/agent/hostapd"
+	"github.com/google/link022/agent/util/ocutil"
+)
+
+// testLogger creates a Logger writing the console logs to a buffer, and recording the verbosity changes.
+func testLogger() (*Logger, *bytes.Buffer, *[]string) {
+	console := &bytes.Buffer{}
+	var verbosities []string
+	l := NewLogger("test-pi-1")
+	l.now = func() time.Time { return testTime }
+	l.stderr = console
+	l.defaultVerbosity = "0"
+	l.setVerbosity = func(verbosity string) error {
+		verbosities = append(verbosities, verbosity)
+		return nil
+	}
+	return l, console, &verbosities
+}
+
+// listenUDP listens for syslog messages on a UDP port of the given loopback address, and returns a remote server
+// receiving the given severities.
+func listenUDP(t *testing.T, host string, severity int) (net.PacketConn, *ocutil.RemoteSyslogServer) {
+	conn, err := net.ListenPacket("udp", net.JoinHostPort(host, "0"))
+	if err != nil {
+		t.Fatalf("Unable to listen on UDP. Error: %v.", err)
+	}
+	return conn, &ocutil.RemoteSyslogServer{
+		Host:      host,
+		Port:      uint16(conn.LocalAddr().(*net.UDPAddr).Port),
+		Transport: "udp",
+		Selectors: []ocutil.SyslogSelector{{Facility: facilityDaemon, Severity: severity}},
+	}
+}
+
+// receive returns the messages received on the given connection until none comes for a while.
+func receive(conn net.PacketConn) []string {
+	var msgs []string
+	buf := make([]byte, 1024)
+	for {
+		conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			return msgs
+		}
+		msgs = append(msgs, string(buf[:n]))
+	}
+}
+
+func TestReadGlog(t *testing.T) {
+	l, console, _ := testLogger()
+	conn, server := listenUDP(t, "127.0.0.1", severityDebug)
+	defer conn.Close()
+	l.UpdateSettings(nil, []*ocutil.RemoteSyslogServer{server})
+	defer l.UpdateSettings(nil, nil)
+
+	l.readGlog(strings.NewReader(`I1005 16:05:00.123456    1234 agent.go:70] Hostname = test-pi-1.
+E1005 16:05:01.000000    1234 base.go:56] Command (hostapd [-B]) failed.
+Output:
+W1005 16:05:02.000000    1234 steering.go:99] Steering disabled.
+`))
+
+	// Only errors are written to the console by default.
+	if got, want := console.String(), "Command (hostapd [-B]) failed.\nOutput:\n"; got != want {
+		t.Errorf("Incorrect console logs (got: %q, want: %q).", got, want)
+	}
+	msgs := receive(conn)
+	if len(msgs) != 4 {
+		t.Fatalf("Incorrect number of syslog messages: %q.", msgs)
+	}
+	for i, prefix := range []string{"<30>1", "<27>1", "<27>1", "<28>1"} {
+		if !strings.HasPrefix(msgs[i], prefix) || !strings.Contains(msgs[i], " test-pi-1 link022-agent ") {
+			t.Errorf("Incorrect syslog message %q, want prefix %s.", msgs[i], prefix)
+		}
+	}
+	if !strings.HasSuffix(msgs[0], " - - Hostname = test-pi-1.") {
+		t.Errorf("Incorrect text of syslog message %q.", msgs[0])
+	}
+}
+
+func TestUpdateSettings(t *testing.T) {
+	l, console, verbosities := testLogger()
+	errorConn, errorServer := listenUDP(t, "127.0.0.1", severityError)
+	defer errorConn.Close()
+	// The whole 127.0.0.0/8 subnet is on the loopback interface.
+	infoConn, infoServer := listenUDP(t, "127.0.0.2", severityInformational)
+	defer infoConn.Close()
+
+	debugConsole := []ocutil.SyslogSelector{{Facility: -1, Severity: severityDebug}}
+	l.UpdateSettings(debugConsole, []*ocutil.RemoteSyslogServer{errorServer, infoServer})
+	defer l.UpdateSettings(nil, nil)
+	if !reflect.DeepEqual(*verbosities, []string{debugVerbosity}) {
+		t.Errorf("Incorrect verbosity changes %v.", *verbosities)
+	}
+
+	l.LogAgent(severityWarning, "Steering disabled.")
+	l.LogHostapdEvent(hostapd.ParseEvent("wlan0", "<3>AP-STA-CONNECTED 12:34:56:78:9a:bc"))
+	l.LogHostapdEvent(hostapd.ParseEvent("wlan0", "<5>Failed to set beacon parameters"))
+	if console.String() != "Steering disabled.\n" {
+		t.Errorf("Incorrect console logs %q.", console.String())
+	}
+	if msgs := receive(errorConn); len(msgs) != 1 || !strings.HasSuffix(msgs[0], "hostapd - - - wlan0: Failed to set beacon parameters") {
+		t.Errorf("Incorrect messages of the error server: %q.", msgs)
+	}
+	if msgs := receive(infoConn); len(msgs) != 3 {
+		t.Errorf("Incorrect messages of the info server: %q.", msgs)
+	}
+
+	// Unchanged servers keep running, the console settings are dropped.
+	l.UpdateSettings(nil, []*ocutil.RemoteSyslogServer{errorServer})
+	if _, ok := l.remotes["127.0.0.1"]; !ok || len(l.remotes) != 1 {
+		t.Errorf("Incorrect remote servers %v.", l.remotes)
+	}
+	if !reflect.DeepEqual(*verbosities, []string{debugVerbosity, "0"}) {
+		t.Errorf("Incorrect verbosity changes %v.", *verbosities)
+	}
+}
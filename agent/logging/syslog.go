@@ -0,0 +1,196 @@
+/* Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package logging
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	log "github.com/golang/glog"
+	"github.com/google/link022/agent/util/ocutil"
+)
+
+const (
+	// rfc5424Time is the timestamp format of RFC 5424 messages.
+	rfc5424Time = "2006-01-02T15:04:05.000000Z07:00"
+
+	dialTimeout  = 5 * time.Second
+	writeTimeout = 5 * time.Second
+	// retryDelay is how long messages are dropped after a remote server failed, before connecting again.
+	retryDelay = 10 * time.Second
+	// queueSize is how many messages wait to be sent to a remote server. Messages are dropped when the queue is full.
+	queueSize = 1024
+)
+
+// Syslog facility and severity codes of the messages sent by the agent.
+const (
+	facilityDaemon = 3
+
+	severityCritical      = 2
+	severityError         = 3
+	severityWarning       = 4
+	severityInformational = 6
+	severityDebug         = 7
+)
+
+// Message is a syslog message.
+type Message struct {
+	Time     time.Time
+	Facility int
+	Severity int
+	Hostname string
+	AppName  string
+	// ProcID is the ID of the process sending the message, 0 if unknown.
+	ProcID int
+	Text   string
+}
+
+// Format formats the message as defined by RFC 5424, without structured data.
+func (m *Message) Format() string {
+	procID := "-"
+	if m.ProcID != 0 {
+		procID = strconv.Itoa(m.ProcID)
+	}
+	return fmt.Sprintf("<%d>1 %s %s %s %s - - %s", m.Facility*8+m.Severity, m.Time.Format(rfc5424Time),
+		nilValue(m.Hostname), nilValue(m.AppName), procID, m.Text)
+}
+
+// nilValue returns the NILVALUE of RFC 5424 for empty header fields.
+func nilValue(field string) string {
+	if field == "" {
+		return "-"
+	}
+	return field
+}
+
+// remote sends messages to a remote syslog server.
+// Messages are sent one per datagram over UDP, and framed by octet counting over TCP and TLS (RFC 6587 and RFC 5425).
+type remote struct {
+	settings *ocutil.RemoteSyslogServer
+	messages chan string
+	done     chan struct{}
+	// tlsConfig is used to connect to servers using TLS.
+	tlsConfig *tls.Config
+}
+
+func newRemote(settings *ocutil.RemoteSyslogServer) *remote {
+	return &remote{
+		settings:  settings,
+		messages:  make(chan string, queueSize),
+		done:      make(chan struct{}),
+		tlsConfig: &tls.Config{ServerName: settings.Host},
+	}
+}
+
+// selects checks whether the server receives the messages of the given facility and severity.
+func (r *remote) selects(facility, severity int) bool {
+	for _, selector := range r.settings.Selectors {
+		if selector.Match(facility, severity) {
+			return true
+		}
+	}
+	return false
+}
+
+// send queues a formatted message. The message is dropped if the queue is full.
+func (r *remote) send(msg string) {
+	select {
+	case r.messages <- msg:
+	default:
+	}
+}
+
+// run sends the queued messages until the remote is stopped.
+// Errors are only logged when the connection fails, since they are logged through the remote itself.
+func (r *remote) run() {
+	var conn net.Conn
+	var retryTime time.Time
+	defer func() {
+		if conn != nil {
+			conn.Close()
+		}
+	}()
+
+	for {
+		var msg string
+		select {
+		case <-r.done:
+			return
+		case msg = <-r.messages:
+		}
+
+		if conn == nil {
+			if time.Now().Before(retryTime) {
+				continue
+			}
+			var err error
+			if conn, err = r.dial(); err != nil {
+				log.Warningf("Failed to connect to syslog server %s: %v", r.addr(), err)
+				retryTime = time.Now().Add(retryDelay)
+				continue
+			}
+		}
+
+		conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+		if _, err := conn.Write([]byte(r.frame(msg))); err != nil {
+			conn.Close()
+			conn = nil
+			log.Warningf("Failed to send logs to syslog server %s: %v", r.addr(), err)
+			retryTime = time.Now().Add(retryDelay)
+		}
+	}
+}
+
+// stop stops sending messages. Queued messages are dropped.
+func (r *remote) stop() {
+	close(r.done)
+}
+
+func (r *remote) addr() string {
+	return net.JoinHostPort(r.settings.Host, strconv.Itoa(int(r.settings.Port)))
+}
+
+// dial connects to the server from its source address.
+func (r *remote) dial() (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: dialTimeout}
+	network := "tcp"
+	if r.settings.Transport == "udp" {
+		network = "udp"
+	}
+	if r.settings.SourceAddress != "" {
+		ip := net.ParseIP(r.settings.SourceAddress)
+		if network == "udp" {
+			dialer.LocalAddr = &net.UDPAddr{IP: ip}
+		} else {
+			dialer.LocalAddr = &net.TCPAddr{IP: ip}
+		}
+	}
+	if r.settings.Transport == "tls" {
+		return tls.DialWithDialer(dialer, network, r.addr(), r.tlsConfig)
+	}
+	return dialer.Dial(network, r.addr())
+}
+
+// frame frames a message for the transport of the server.
+func (r *remote) frame(msg string) string {
+	if r.settings.Transport == "udp" {
+		return msg
+	}
+	return fmt.Sprintf("%d %s", len(msg), msg)
+}
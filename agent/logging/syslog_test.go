@@ -0,0 +1,119 @@
+/* Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package logging
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/link022/agent/util/ocutil"
+)
+
+var testTime = time.Date(2018, 10, 5, 16, 5, 0, 123456000, time.UTC)
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		msg  *Message
+		want string
+	}{{
+		msg: &Message{
+			Time:     testTime,
+			Facility: facilityDaemon,
+			Severity: severityError,
+			Hostname: "test-pi-1",
+			AppName:  agentAppName,
+			ProcID:   1234,
+			Text:     "Failed to start hostapd.",
+		},
+		want: "<27>1 2018-10-05T16:05:00.123456Z test-pi-1 link022-agent 1234 - - Failed to start hostapd.",
+	}, {
+		msg: &Message{
+			Time:     testTime,
+			Facility: facilityDaemon,
+			Severity: severityInformational,
+			AppName:  hostapdAppName,
+			Text:     "wlan0: AP-STA-CONNECTED 12:34:56:78:9a:bc",
+		},
+		want: "<30>1 2018-10-05T16:05:00.123456Z - hostapd - - - wlan0: AP-STA-CONNECTED 12:34:56:78:9a:bc",
+	}}
+
+	for _, test := range tests {
+		if got := test.msg.Format(); got != test.want {
+			t.Errorf("Incorrect syslog message (got: %q, want: %q).", got, test.want)
+		}
+	}
+}
+
+func TestRemoteUDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unable to listen on UDP. Error: %v.", err)
+	}
+	defer conn.Close()
+
+	r := newRemote(&ocutil.RemoteSyslogServer{
+		Host:          "127.0.0.1",
+		Port:          uint16(conn.LocalAddr().(*net.UDPAddr).Port),
+		Transport:     "udp",
+		SourceAddress: "127.0.0.1",
+	})
+	go r.run()
+	defer r.stop()
+	r.send("<30>1 - - - - - - hello")
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 1024)
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil || string(buf[:n]) != "<30>1 - - - - - - hello" {
+		t.Errorf("Incorrect datagram %q (error: %v).", buf[:n], err)
+	}
+}
+
+func TestRemoteTCP(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unable to listen on TCP. Error: %v.", err)
+	}
+	defer listener.Close()
+
+	r := newRemote(&ocutil.RemoteSyslogServer{
+		Host:      "127.0.0.1",
+		Port:      uint16(listener.Addr().(*net.TCPAddr).Port),
+		Transport: "tcp",
+	})
+	go r.run()
+	defer r.stop()
+	r.send("<30>1 - - - - - - hello")
+	r.send("<30>1 - - - - - - world")
+
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatalf("Unable to accept the connection. Error: %v.", err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	reader := bufio.NewReader(conn)
+	// Messages are framed by octet counting.
+	for _, want := range []string{"23 <30>1 - - - - - - hello", "23 <30>1 - - - - - - world"} {
+		buf := make([]byte, len(want))
+		if _, err := io.ReadFull(reader, buf); err != nil || string(buf) != want {
+			t.Errorf("Incorrect frame %q (error: %v), want %q.", buf, err, want)
+		}
+	}
+}
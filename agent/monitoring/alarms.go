@@ -0,0 +1,305 @@
+/* Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package monitoring
+
+import (
+	ctx "context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/golang/glog"
+	"github.com/google/link022/agent/alarm"
+	"github.com/google/link022/agent/context"
+	"github.com/google/link022/agent/gnmi"
+	"github.com/google/link022/agent/hostapd"
+	"github.com/google/link022/agent/util/ocutil"
+	"github.com/google/link022/generated/ocstruct"
+	"github.com/openconfig/ygot/ygot"
+)
+
+const (
+	// highCPUUsage and highMemoryUsage are the usage percentages raising an alarm.
+	highCPUUsage    = 90
+	highMemoryUsage = 90
+)
+
+var memInfoRegex = regexp.MustCompile(`(?m)^(MemTotal|MemAvailable):\s+(\d+)`)
+
+// alarmChecker checks the conditions of the alarms not raised by the agent features themselves.
+// Conditions are only raised when found in two checks in a row, since they are transient while the AP is reconfigured.
+type alarmChecker struct {
+	manager *alarm.Manager
+	found   map[string]map[string]bool // alarm type -> resources found in the last check
+	// lastCPUBusy and lastCPUTotal are the CPU times of the last check, in clock ticks.
+	lastCPUBusy  uint64
+	lastCPUTotal uint64
+}
+
+func newAlarmChecker(manager *alarm.Manager) *alarmChecker {
+	return &alarmChecker{
+		manager: manager,
+		found:   make(map[string]map[string]bool),
+	}
+}
+
+// set raises the conditions (resource -> alarm text) of the given alarm type also found in the last check,
+// and clears the other alarms of the type.
+func (c *alarmChecker) set(typeID string, conditions map[string]string) {
+	raised := make(map[string]string)
+	found := make(map[string]bool)
+	for resource, text := range conditions {
+		if c.found[typeID][resource] {
+			raised[resource] = text
+		}
+		found[resource] = true
+	}
+	c.found[typeID] = found
+	c.manager.Set(typeID, raised)
+}
+
+// checkHostapd checks that hostapd runs the enabled SSIDs of the AP.
+// Running BSSs whose SSID is not enabled, or enabled SSIDs without BSS, are a drift from the configuration.
+func (c *alarmChecker) checkHostapd(enabledSSIDs []string, hostapdRunning bool, bssList []*BSS) {
+	down := make(map[string]string)
+	drift := make(map[string]string)
+	if len(enabledSSIDs) != 0 && !hostapdRunning {
+		down[""] = "hostapd is not running."
+	}
+	if hostapdRunning {
+		running := make(map[string]bool)
+		for _, bss := range bssList {
+			running[bss.SSID] = true
+		}
+		for _, ssid := range enabledSSIDs {
+			if !running[ssid] {
+				drift[ssid] = fmt.Sprintf("SSID %s is enabled but not running.", ssid)
+			}
+			delete(running, ssid)
+		}
+		for ssid := range running {
+			drift[ssid] = fmt.Sprintf("SSID %s is running but not enabled.", ssid)
+		}
+	}
+	c.set(alarm.HostapdDown, down)
+	c.set(alarm.ConfigDrift, drift)
+}
+
+// checkCPU checks the CPU usage since the last check, from the content of /proc/stat.
+func (c *alarmChecker) checkCPU(stat string) error {
+	busy, total, err := parseCPUTimes(stat)
+	if err != nil {
+		return err
+	}
+	conditions := make(map[string]string)
+	if c.lastCPUTotal != 0 && total > c.lastCPUTotal && busy >= c.lastCPUBusy {
+		usage := (busy - c.lastCPUBusy) * 100 / (total - c.lastCPUTotal)
+		if usage >= highCPUUsage {
+			conditions[""] = fmt.Sprintf("CPU usage is %d%%.", usage)
+		}
+	}
+	c.lastCPUBusy, c.lastCPUTotal = busy, total
+	c.set(alarm.HighCPU, conditions)
+	return nil
+}
+
+// checkMemory checks the memory usage, from the content of /proc/meminfo.
+func (c *alarmChecker) checkMemory(memInfo string) error {
+	usage, err := parseMemoryUsage(memInfo)
+	if err != nil {
+		return err
+	}
+	conditions := make(map[string]string)
+	if usage >= highMemoryUsage {
+		conditions[""] = fmt.Sprintf("Memory usage is %d%%.", usage)
+	}
+	c.set(alarm.HighMemory, conditions)
+	return nil
+}
+
+// handleEvent raises an alarm when a radar is detected on a DFS channel, until the channel is available again.
+func (c *alarmChecker) handleEvent(event *hostapd.Event) {
+	freq, ok := event.Params["freq"]
+	if !ok {
+		return
+	}
+	switch event.Name {
+	case "DFS-RADAR-DETECTED":
+		c.manager.Raise(alarm.RadarDetected, freq,
+			fmt.Sprintf("Radar detected by %s on %s MHz, the channel is unavailable.", event.IntfName, freq))
+	case "DFS-NOP-FINISHED":
+		c.manager.Clear(alarm.RadarDetected, freq)
+	}
+}
+
+// parseCPUTimes parses the total CPU times in /proc/stat. It returns the busy and total times, in clock ticks.
+func parseCPUTimes(stat string) (uint64, uint64, error) {
+	// e.g. "cpu  4705 356 584 3699176 23 23 0 0 0 0", idle and iowait are the 4th and 5th times.
+	for _, line := range strings.Split(stat, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 6 || fields[0] != "cpu" {
+			continue
+		}
+		var busy, total uint64
+		for i, field := range fields[1:] {
+			ticks, err := strconv.ParseUint(field, 10, 64)
+			if err != nil {
+				return 0, 0, fmt.Errorf("invalid CPU time %q", field)
+			}
+			total += ticks
+			if i != 3 && i != 4 {
+				busy += ticks
+			}
+		}
+		return busy, total, nil
+	}
+	return 0, 0, errors.New("no CPU times in /proc/stat")
+}
+
+// parseMemoryUsage parses /proc/meminfo. It returns the percentage of the memory which is not available.
+func parseMemoryUsage(memInfo string) (uint64, error) {
+	values := make(map[string]uint64)
+	for _, match := range memInfoRegex.FindAllStringSubmatch(memInfo, -1) {
+		value, err := strconv.ParseUint(match[2], 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		values[match[1]] = value
+	}
+	total := values["MemTotal"]
+	available, ok := values["MemAvailable"]
+	if !ok || total == 0 || available > total {
+		return 0, errors.New("no memory info in /proc/meminfo")
+	}
+	return (total - available) * 100 / total, nil
+}
+
+// UpdateAlarms periodically checks the conditions of the AP alarms, and publishes the active alarms
+// in OpenConfig Model tree.
+func UpdateAlarms(bkgdContext ctx.Context, gnmiServer *gnmi.Server) {
+	hostName := context.GetDeviceConfig().Hostname
+	checker := newAlarmChecker(alarm.Default())
+
+	events := make(chan *hostapd.Event)
+	monitors := hostapd.NewMonitors(bkgdContext, hostapd.CtrlInterfaceDir, false, events)
+	defer monitors.Stop()
+
+	// Events of all BSSs are received, a ticker keeps them from delaying the checks.
+	ticker := time.NewTicker(statesUpdateDelay)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-bkgdContext.Done():
+			return
+		case event := <-events:
+			checker.handleEvent(event)
+			continue
+		case <-ticker.C:
+		}
+
+		bssList := BSSs()
+		intfNames := make(map[string]bool)
+		for _, bss := range bssList {
+			intfNames[bss.IntfName] = true
+		}
+		monitors.Update(intfNames)
+
+		checker.checkHostapd(enabledSSIDs(gnmiServer, hostName), cmdRunner.HostapdRunning(), bssList)
+		if stat, err := ioutil.ReadFile("/proc/stat"); err != nil {
+			log.Errorf("Error in reading CPU times: %v", err)
+		} else if err := checker.checkCPU(string(stat)); err != nil {
+			log.Errorf("Error in checking CPU usage: %v", err)
+		}
+		if memInfo, err := ioutil.ReadFile("/proc/meminfo"); err != nil {
+			log.Errorf("Error in reading memory info: %v", err)
+		} else if err := checker.checkMemory(string(memInfo)); err != nil {
+			log.Errorf("Error in checking memory usage: %v", err)
+		}
+
+		if err := publishAlarms(gnmiServer, hostName, alarm.Default()); err != nil {
+			log.Errorf("Error in updating alarms: %v", err)
+		}
+	}
+}
+
+// enabledSSIDs returns the enabled SSIDs of the AP, in ascending order.
+func enabledSSIDs(s *gnmi.Server, hostName string) []string {
+	var ssids []string
+	s.InternalUpdate(func(config ygot.ValidatedGoStruct) error {
+		device, ok := config.(*ocstruct.Device)
+		if !ok {
+			return errors.New("configuration has invalid type")
+		}
+		apConfig := ocutil.FindAPConfig(device, hostName)
+		if apConfig == nil || apConfig.Ssids == nil {
+			return nil
+		}
+		for ssidName, ssid := range apConfig.Ssids.Ssid {
+			if ssid.Config != nil && ocutil.SSIDEnabled(ssid.Config) {
+				ssids = append(ssids, ssidName)
+			}
+		}
+		return nil
+	})
+	sort.Strings(ssids)
+	return ssids
+}
+
+// publishAlarms replaces the alarms of the AP with the active alarms of the given manager.
+func publishAlarms(s *gnmi.Server, hostName string, manager *alarm.Manager) error {
+	return s.InternalUpdate(func(config ygot.ValidatedGoStruct) error {
+		device, ok := config.(*ocstruct.Device)
+		if !ok {
+			return errors.New("configuration has invalid type")
+		}
+		apConfig := ocutil.FindAPConfig(device, hostName)
+		if apConfig == nil {
+			return nil
+		}
+		return manager.UpdateState(apConfig)
+	})
+}
+
+// CheckHealth checks that the AP runs as configured: the last configuration was applied, and hostapd runs its enabled SSIDs.
+func CheckHealth(gnmiServer *gnmi.Server) error {
+	for _, active := range alarm.Default().Alarms() {
+		if active.TypeID == alarm.ApplyFailed {
+			return errors.New(active.Text)
+		}
+	}
+	ssids := enabledSSIDs(gnmiServer, context.GetDeviceConfig().Hostname)
+	if len(ssids) == 0 {
+		return nil
+	}
+	if !cmdRunner.HostapdRunning() {
+		return errors.New("hostapd is not running")
+	}
+	running := make(map[string]bool)
+	for _, bss := range BSSs() {
+		running[bss.SSID] = true
+	}
+	for _, ssid := range ssids {
+		if !running[ssid] {
+			return fmt.Errorf("SSID %s is not running", ssid)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+/* Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package monitoring
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/google/link022/agent/alarm"
+	"github.com/google/link022/agent/hostapd"
+)
+
+const testMemInfo = `MemTotal:         948304 kB
+MemFree:           20480 kB
+MemAvailable:      37416 kB
+Buffers:           20480 kB
+`
+
+// alarmIDs returns the IDs of the active alarms of the given manager.
+func alarmIDs(manager *alarm.Manager) []string {
+	var ids []string
+	for _, a := range manager.Alarms() {
+		ids = append(ids, a.ID)
+	}
+	return ids
+}
+
+func TestCheckHostapd(t *testing.T) {
+	manager := alarm.New()
+	c := newAlarmChecker(manager)
+	bssList := []*BSS{{IntfName: "wlan0", SSID: "Guest"}, {IntfName: "wlan0_0", SSID: "Old"}}
+
+	steps := []struct {
+		running bool
+		want    []string
+	}{
+		// Conditions found once are transient.
+		{running: false, want: nil},
+		{running: false, want: []string{"HOSTAPD_DOWN"}},
+		{running: true, want: nil},
+		{running: true, want: []string{"CONFIG_DRIFT:Auth", "CONFIG_DRIFT:Old"}},
+	}
+	for i, step := range steps {
+		c.checkHostapd([]string{"Auth", "Guest"}, step.running, bssList)
+		if got := alarmIDs(manager); !reflect.DeepEqual(got, step.want) {
+			t.Errorf("Incorrect alarms at step %d (got: %v, want: %v).", i, got, step.want)
+		}
+	}
+}
+
+func TestCheckCPUAndMemory(t *testing.T) {
+	manager := alarm.New()
+	c := newAlarmChecker(manager)
+	stats := []string{
+		"cpu  100 0 100 800 0 0 0 0 0 0\ncpu0 100 0 100 800 0 0 0 0 0 0\n",
+		"cpu  1050 0 100 850 0 0 0 0 0 0\n",
+		"cpu  2000 0 100 900 0 0 0 0 0 0\n",
+	}
+	for _, stat := range stats {
+		if err := c.checkCPU(stat); err != nil {
+			t.Errorf("Checking CPU usage failed. Error: %v.", err)
+		}
+		if err := c.checkMemory(testMemInfo); err != nil {
+			t.Errorf("Checking memory usage failed. Error: %v.", err)
+		}
+	}
+
+	want := []string{"HIGH_CPU_USAGE", "HIGH_MEMORY_USAGE"}
+	if got := alarmIDs(manager); !reflect.DeepEqual(got, want) {
+		t.Errorf("Incorrect alarms (got: %v, want: %v).", got, want)
+	}
+	if text := manager.Alarms()[0].Text; text != "CPU usage is 95%." {
+		t.Errorf("Incorrect CPU alarm text %q.", text)
+	}
+	if err := c.checkCPU("intr 1234"); err == nil {
+		t.Error("Expected an error for /proc/stat without CPU times.")
+	}
+}
+
+func TestParseMemoryUsage(t *testing.T) {
+	if usage, err := parseMemoryUsage(testMemInfo); err != nil || usage != 96 {
+		t.Errorf("Incorrect memory usage %d (error: %v).", usage, err)
+	}
+	if _, err := parseMemoryUsage("MemTotal: 948304 kB\n"); err == nil {
+		t.Error("Expected an error for memory info without available memory.")
+	}
+}
+
+func TestRadarAlarm(t *testing.T) {
+	manager := alarm.New()
+	c := newAlarmChecker(manager)
+
+	c.handleEvent(hostapd.ParseEvent("wlan0", "<3>DFS-RADAR-DETECTED freq=5260 ht_enabled=1 chan_offset=0 chan_width=1 cf1=5260 cf2=0"))
+	if got, want := alarmIDs(manager), []string{"DFS_RADAR_DETECTED:5260"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Incorrect alarms after radar detection (got: %v, want: %v).", got, want)
+	}
+	c.handleEvent(hostapd.ParseEvent("wlan0", "<3>DFS-NOP-FINISHED freq=5260 ht_enabled=1 chan_offset=0 chan_width=1 cf1=5260 cf2=0"))
+	if got := alarmIDs(manager); len(got) != 0 {
+		t.Errorf("Expected no alarm after the non-occupancy period, got %v.", got)
+	}
+}
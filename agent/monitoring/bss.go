@@ -0,0 +1,242 @@
+/* Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package monitoring
+
+import (
+	ctx "context"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/golang/glog"
+	"github.com/google/link022/agent/context"
+	"github.com/google/link022/agent/gnmi"
+	"github.com/google/link022/agent/util/ocutil"
+	"github.com/google/link022/generated/ocstruct"
+	"github.com/openconfig/ygot/ygot"
+)
+
+// BSS contains the information of a BSS brought up by hostapd.
+type BSS struct {
+	IntfName  string
+	MAC       string
+	SSID      string
+	Frequency int // MHz
+	RadioID   uint8
+}
+
+// bssTable keeps the BSSs found in the last collection.
+type bssTable struct {
+	mu      sync.RWMutex
+	bssList []*BSS
+}
+
+var bsss = &bssTable{}
+
+// BSSs returns the BSSs currently running on the AP radios.
+func BSSs() []*BSS {
+	bsss.mu.RLock()
+	defer bsss.mu.RUnlock()
+
+	var bssList []*BSS
+	for _, bss := range bsss.bssList {
+		bssCopy := *bss
+		bssList = append(bssList, &bssCopy)
+	}
+	return bssList
+}
+
+// bssCounters contains the counters of a BSS.
+// They are read from the statistics of the BSS interface, which count the data frames it
+// passes, and from the station aggregates of nl80211, which only count the retries sent to
+// the stations. Neither hostapd nor nl80211 count per BSS the management and control frames,
+// the received retries, the retried subframes, the MCS, WMM and frame size distributions or
+// the channel utilization of the BSS, these counters are not published.
+type bssCounters struct {
+	rxBytes     uint64
+	txBytes     uint64
+	txRetries   uint64
+	numStations uint8
+}
+
+// UpdateBSSCounters periodically collects the state of each BSS on the AP radios,
+// and updates the BSSID list of each SSID in OpenConfig Model tree.
+func UpdateBSSCounters(bkgdContext ctx.Context, gnmiServer *gnmi.Server) {
+	deviceConfig := context.GetDeviceConfig()
+	hostName := deviceConfig.Hostname
+	wLANINTFNames := deviceConfig.WLANINTFNames
+
+	for {
+		select {
+		case <-bkgdContext.Done():
+			return
+		case <-time.After(statesUpdateDelay):
+		}
+
+		if err := updateBSSInfo(gnmiServer, hostName, wLANINTFNames); err != nil {
+			log.Errorf("Error in updating BSS info: %v", err)
+		}
+	}
+}
+
+// updateBSSInfo collects the BSSs of the radios configured on the AP.
+// Radios run on the given WLAN interfaces in ascending radio ID order.
+func updateBSSInfo(s *gnmi.Server, hostName string, wLANINTFNames []string) error {
+	wlanRadios := make(map[string]uint8) // WLAN interface name -> radio ID
+	for _, radio := range configuredRadioIntfs(s, hostName, wLANINTFNames) {
+		wlanRadios[radio.wlanINTFName] = radio.radioID
+	}
+	if len(wlanRadios) == 0 {
+		return nil
+	}
+
+	iwDevInfo, err := cmdRunner.GetAPStates()
+	if err != nil {
+		return err
+	}
+	bssList := parseIWDev(iwDevInfo, wlanRadios)
+	bsss.mu.Lock()
+	bsss.bssList = bssList
+	bsss.mu.Unlock()
+
+	bssCounterMap := make(map[string]*bssCounters) // BSS interface name -> counters
+	for _, bss := range bssList {
+		counters, err := collectBSSCounters(bss.IntfName)
+		if err != nil {
+			log.Errorf("Failed to collect counters of BSS %s: %v", bss.IntfName, err)
+			continue
+		}
+		bssCounterMap[bss.IntfName] = counters
+	}
+
+	return s.InternalUpdate(func(config ygot.ValidatedGoStruct) error {
+		device, ok := config.(*ocstruct.Device)
+		if !ok {
+			return errors.New("configuration has invalid type")
+		}
+		apConfig := ocutil.FindAPConfig(device, hostName)
+		if apConfig == nil || apConfig.Ssids == nil {
+			return nil
+		}
+
+		for ssidName, ssid := range apConfig.Ssids.Ssid {
+			ssid.Bssids = &ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_Ssids_Ssid_Bssids{}
+			for _, bss := range bssList {
+				counters, ok := bssCounterMap[bss.IntfName]
+				if bss.SSID != ssidName || !ok {
+					continue
+				}
+				bssNode, err := ssid.Bssids.NewBssid(bss.RadioID, bss.MAC)
+				if err != nil {
+					return err
+				}
+				bssNode.State = &ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_Ssids_Ssid_Bssids_Bssid_State{
+					Bssid:                ygot.String(bss.MAC),
+					RadioId:              ygot.Uint8(bss.RadioID),
+					NumAssociatedClients: ygot.Uint8(counters.numStations),
+					Counters: &ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_Ssids_Ssid_Bssids_Bssid_State_Counters{
+						RxBytesData:   ygot.Uint64(counters.rxBytes),
+						TxBytesData:   ygot.Uint64(counters.txBytes),
+						TxRetries:     ygot.Uint64(counters.txRetries),
+						TxRetriesData: ygot.Uint64(counters.txRetries),
+					},
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// collectBSSCounters reads the interface statistics of a BSS interface,
+// and aggregates the statistics of stations associated with it.
+func collectBSSCounters(bssIntfName string) (*bssCounters, error) {
+	rxBytes, err := cmdRunner.IntfStatistic(bssIntfName, "rx_bytes")
+	if err != nil {
+		return nil, err
+	}
+	txBytes, err := cmdRunner.IntfStatistic(bssIntfName, "tx_bytes")
+	if err != nil {
+		return nil, err
+	}
+	stationDump, err := cmdRunner.StationDump(bssIntfName)
+	if err != nil {
+		return nil, err
+	}
+
+	counters := &bssCounters{
+		rxBytes: rxBytes,
+		txBytes: txBytes,
+	}
+	for _, station := range parseStationDump(stationDump) {
+		counters.txRetries += station.txRetries
+		counters.numStations++
+	}
+	return counters, nil
+}
+
+// parseIWDev parses the output of "iw dev", and returns the BSSs running on the given WLAN interfaces
+// (WLAN interface name -> radio ID). The first BSS of a radio uses the WLAN interface itself, other BSSs
+// use "<wlan>_N" interfaces.
+func parseIWDev(iwDevInfo string, wlanRadios map[string]uint8) []*BSS {
+	var bssList []*BSS
+	var current *BSS
+	isAP := false
+
+	addCurrent := func() {
+		if current != nil && isAP && current.SSID != "" {
+			bssList = append(bssList, current)
+		}
+	}
+
+	for _, line := range strings.Split(iwDevInfo, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+
+		switch fields[0] {
+		case "Interface":
+			addCurrent()
+			current = nil
+			isAP = false
+			intfName := fields[1]
+			for wLANINTFName, radioID := range wlanRadios {
+				if intfName == wLANINTFName || strings.HasPrefix(intfName, wLANINTFName+"_") {
+					current = &BSS{IntfName: intfName, RadioID: radioID}
+				}
+			}
+		case "addr":
+			if current != nil {
+				current.MAC = strings.ToLower(fields[1])
+			}
+		case "ssid":
+			if current != nil {
+				current.SSID = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line), "ssid"))
+			}
+		case "channel":
+			// The channel line looks like "channel 8 (2447 MHz), width: 20 MHz, center1: 2447 MHz".
+			if current != nil && len(fields) > 2 {
+				current.Frequency, _ = strconv.Atoi(strings.TrimPrefix(fields[2], "("))
+			}
+		case "type":
+			isAP = fields[1] == "AP"
+		}
+	}
+	addCurrent()
+	return bssList
+}
@@ -0,0 +1,68 @@
+/* Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package monitoring
+
+import (
+	"reflect"
+	"testing"
+)
+
+const testIWDevInfo = `phy#2
+	Interface wlan2
+		ifindex 7
+		wdev 0x200000001
+		addr 02:c0:ca:90:2f:60
+		type managed
+phy#1
+	Interface wlan1
+		ifindex 6
+		wdev 0x100000001
+		addr 02:c0:ca:90:2f:50
+		ssid Guest-Emu
+		type AP
+		channel 36 (5180 MHz), width: 20 MHz, center1: 5180 MHz
+		txpower 20.00 dBm
+phy#0
+	Interface wlan0_1
+		ifindex 5
+		wdev 0x2
+		addr 02:27:EB:BA:1B:E1
+		ssid Guest-Emu
+		type AP
+		channel 8 (2447 MHz), width: 20 MHz, center1: 2447 MHz
+		txpower 20.00 dBm
+	Interface wlan0
+		ifindex 3
+		wdev 0x1
+		addr 02:27:eb:ba:1b:e0
+		ssid Auth Emu
+		type AP
+		channel 8 (2447 MHz), width: 20 MHz, center1: 2447 MHz
+		txpower 20.00 dBm
+`
+
+func TestParseIWDev(t *testing.T) {
+	want := []*BSS{
+		{IntfName: "wlan1", MAC: "02:c0:ca:90:2f:50", SSID: "Guest-Emu", Frequency: 5180, RadioID: 2},
+		{IntfName: "wlan0_1", MAC: "02:27:eb:ba:1b:e1", SSID: "Guest-Emu", Frequency: 2447, RadioID: 1},
+		{IntfName: "wlan0", MAC: "02:27:eb:ba:1b:e0", SSID: "Auth Emu", Frequency: 2447, RadioID: 1},
+	}
+
+	got := parseIWDev(testIWDevInfo, map[string]uint8{"wlan0": 1, "wlan1": 2})
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Incorrect BSS list (got: %v, want: %v).", got, want)
+	}
+}
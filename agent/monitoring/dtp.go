@@ -0,0 +1,339 @@
+/* Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package monitoring
+
+import (
+	ctx "context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	log "github.com/golang/glog"
+	"github.com/google/link022/agent/context"
+	"github.com/google/link022/agent/gnmi"
+	"github.com/google/link022/agent/service"
+	"github.com/google/link022/agent/util/ocutil"
+	"github.com/google/link022/generated/ocstruct"
+	"github.com/openconfig/ygot/ygot"
+)
+
+const (
+	dtpInterval = 60 * time.Second
+	// dtpStep is the transmit power change (in dBm) applied in one control cycle.
+	dtpStep = 1
+	// Managed neighbors heard above dtpNeighborHighRSSI indicate too much overlap,
+	// the transmit power is reduced.
+	dtpNeighborHighRSSI = -65
+	// Managed neighbors heard below dtpNeighborLowRSSI indicate a coverage gap,
+	// the transmit power is increased. Without managed neighbors heard, the transmit power is kept.
+	dtpNeighborLowRSSI = -75
+	// Clients heard below dtpClientLowRSSI are at the coverage edge,
+	// the transmit power is increased regardless of neighbors.
+	dtpClientLowRSSI = -75
+)
+
+// hostapdStarts returns the number of hostapd processes started by the agent.
+var hostapdStarts = service.HostapdStarts
+
+// dtpSettings contains the DTP related configuration of a radio.
+type dtpSettings struct {
+	radioID            uint8
+	wlanINTFName       string
+	operatingFrequency ocstruct.E_OpenconfigWifiTypes_OPERATING_FREQUENCY
+	minPower           uint8
+	maxPower           uint8
+	initialPower       uint8
+	managedSSIDs       map[string]bool
+}
+
+// dtpRadioState is the transmit power DTP chose for a radio.
+type dtpRadioState struct {
+	radioID uint8
+	// txPower is the transmit power chosen by DTP, 0 before the first control cycle.
+	txPower uint8
+	// applied indicates txPower is applied to the radio. It is reset once hostapd restarts.
+	applied bool
+}
+
+// dtpController runs the DTP control cycles of the AP radios.
+type dtpController struct {
+	// radios contains the state of the radios DTP runs on, by WLAN interface name.
+	radios map[string]*dtpRadioState
+	// hostapdStarts is the number of hostapd processes started when the transmit powers were applied.
+	hostapdStarts uint64
+}
+
+func newDTPController() *dtpController {
+	return &dtpController{
+		radios:        make(map[string]*dtpRadioState),
+		hostapdStarts: hostapdStarts(),
+	}
+}
+
+// UpdateTransmitPower periodically adjusts the transmit power of the AP radios
+// with dynamic transmit power control (DTP) enabled.
+// The chosen transmit power is reported in the radio state.
+func UpdateTransmitPower(bkgdContext ctx.Context, gnmiServer *gnmi.Server) {
+	deviceConfig := context.GetDeviceConfig()
+	hostName := deviceConfig.Hostname
+	wLANINTFNames := deviceConfig.WLANINTFNames
+	dtp := newDTPController()
+
+	for {
+		select {
+		case <-bkgdContext.Done():
+			return
+		case <-time.After(dtpInterval):
+		}
+
+		settings := dtpRadios(gnmiServer, hostName, wLANINTFNames)
+		disabledRadioIDs := dtp.update(settings)
+		if err := publishTransmitPower(gnmiServer, hostName, settings, dtp.radios, disabledRadioIDs); err != nil {
+			log.Errorf("Error in updating transmit power state: %v", err)
+		}
+	}
+}
+
+// dtpRadios returns the DTP settings of the radios with DTP enabled, in ascending radio ID order.
+// Radios run on the given WLAN interfaces in ascending radio ID order.
+func dtpRadios(s *gnmi.Server, hostName string, wLANINTFNames []string) []*dtpSettings {
+	var settings []*dtpSettings
+	s.InternalUpdate(func(config ygot.ValidatedGoStruct) error {
+		device, ok := config.(*ocstruct.Device)
+		if !ok {
+			return errors.New("configuration has invalid type")
+		}
+		settings = dtpRadioSettings(ocutil.FindAPConfig(device, hostName), wLANINTFNames)
+		return nil
+	})
+	return settings
+}
+
+// dtpRadioSettings returns the DTP settings of the radios of the given AP with DTP enabled, in ascending radio ID order.
+func dtpRadioSettings(apConfig *ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint, wLANINTFNames []string) []*dtpSettings {
+	var settings []*dtpSettings
+	for _, radio := range radioIntfs(apConfig, wLANINTFNames) {
+		id, radioConfig := radio.radioID, radio.config
+		if radioConfig.Dtp == nil || !*radioConfig.Dtp {
+			continue
+		}
+		if radioConfig.DtpMin == nil || radioConfig.DtpMax == nil || *radioConfig.DtpMin > *radioConfig.DtpMax {
+			log.Errorf("Invalid DTP range on radio %d, DTP skipped.", id)
+			continue
+		}
+
+		radioSettings := &dtpSettings{
+			radioID:            id,
+			wlanINTFName:       radio.wlanINTFName,
+			operatingFrequency: radioConfig.OperatingFrequency,
+			minPower:           *radioConfig.DtpMin,
+			maxPower:           *radioConfig.DtpMax,
+			initialPower:       *radioConfig.DtpMax,
+			managedSSIDs:       make(map[string]bool),
+		}
+		if radioConfig.TransmitPower != nil {
+			radioSettings.initialPower = *radioConfig.TransmitPower
+		}
+		if apConfig.Ssids != nil {
+			for ssidName := range apConfig.Ssids.Ssid {
+				radioSettings.managedSSIDs[ssidName] = true
+			}
+		}
+		settings = append(settings, radioSettings)
+	}
+	return settings
+}
+
+// update runs one DTP control cycle on the radios with the given settings. The radios DTP is no longer enabled on
+// get the transmit power chosen by their driver back, their IDs are returned in ascending order.
+func (d *dtpController) update(settings []*dtpSettings) []uint8 {
+	// hostapd brings the radios up again when it restarts, the transmit powers are applied again.
+	if starts := hostapdStarts(); starts != d.hostapdStarts {
+		d.hostapdStarts = starts
+		for _, radio := range d.radios {
+			radio.applied = false
+		}
+	}
+
+	enabled := make(map[string]bool)
+	for _, radioSettings := range settings {
+		enabled[radioSettings.wlanINTFName] = true
+		radio, ok := d.radios[radioSettings.wlanINTFName]
+		if !ok || radio.radioID != radioSettings.radioID {
+			radio = &dtpRadioState{radioID: radioSettings.radioID}
+			d.radios[radioSettings.wlanINTFName] = radio
+		}
+		if err := updateTransmitPower(radioSettings, radio); err != nil {
+			log.Errorf("Error in updating transmit power of radio %d: %v", radioSettings.radioID, err)
+		}
+	}
+
+	var disabledRadioIDs []uint8
+	for wlanINTFName, radio := range d.radios {
+		if enabled[wlanINTFName] {
+			continue
+		}
+		if err := cmdRunner.SetAutoTxPower(wlanINTFName); err != nil {
+			log.Errorf("Error in restoring transmit power of radio %d: %v", radio.radioID, err)
+			continue
+		}
+		delete(d.radios, wlanINTFName)
+		disabledRadioIDs = append(disabledRadioIDs, radio.radioID)
+	}
+	sort.Slice(disabledRadioIDs, func(i, j int) bool { return disabledRadioIDs[i] < disabledRadioIDs[j] })
+	return disabledRadioIDs
+}
+
+// updateTransmitPower runs one DTP control cycle on a radio, and updates the transmit power chosen for it.
+func updateTransmitPower(settings *dtpSettings, radio *dtpRadioState) error {
+	txPower := radio.txPower
+	if txPower == 0 {
+		txPower = settings.initialPower
+	}
+
+	// Managed neighbors are the ones broadcasting SSIDs in our configuration on the band of the radio.
+	var neighborRSSIs []int8
+	neighborList, scanned := Neighbors(settings.radioID)
+	if !scanned {
+		log.V(1).Infof("DTP: no scan data on radio %d, only its clients are considered.", settings.radioID)
+	}
+	for _, neighbor := range neighborList {
+		if settings.managedSSIDs[neighbor.SSID] && onOperatingFrequency(neighbor.Channel, settings.operatingFrequency) {
+			neighborRSSIs = append(neighborRSSIs, neighbor.RSSI)
+		}
+	}
+
+	// The clients of all the BSSs of the radio are considered.
+	var clientRSSIs []int8
+	for _, bssINTFName := range radioBSSIntfNames(settings.radioID, settings.wlanINTFName) {
+		stationDump, err := cmdRunner.StationDump(bssINTFName)
+		if err != nil {
+			return err
+		}
+		for _, station := range parseStationDump(stationDump) {
+			clientRSSIs = append(clientRSSIs, station.signal)
+		}
+	}
+
+	updatedTxPower := nextTransmitPower(txPower, settings.minPower, settings.maxPower, neighborRSSIs, clientRSSIs)
+	if !radio.applied || updatedTxPower != txPower {
+		log.Infof("DTP: transmit power of radio %d %d -> %d dBm (managed neighbors: %v, clients: %v).", settings.radioID, txPower, updatedTxPower, neighborRSSIs, clientRSSIs)
+		if err := cmdRunner.SetTxPower(settings.wlanINTFName, int(updatedTxPower)); err != nil {
+			return err
+		}
+	}
+	radio.txPower = updatedTxPower
+	radio.applied = true
+	return nil
+}
+
+// radioBSSIntfNames returns the interfaces of the BSSs running on the given radio. It returns the WLAN interface
+// of the radio if no BSS was found yet.
+func radioBSSIntfNames(radioID uint8, wlanINTFName string) []string {
+	var bssINTFNames []string
+	for _, bss := range BSSs() {
+		if bss.RadioID == radioID {
+			bssINTFNames = append(bssINTFNames, bss.IntfName)
+		}
+	}
+	if len(bssINTFNames) == 0 {
+		return []string{wlanINTFName}
+	}
+	return bssINTFNames
+}
+
+// nextTransmitPower calculates the transmit power of the next control cycle.
+// Weak clients have priority over neighbor overlap, and the result always stays in [minPower, maxPower].
+// Without neighbors and weak clients, e.g. when the radio does not scan, the transmit power is kept.
+func nextTransmitPower(txPower, minPower, maxPower uint8, neighborRSSIs, clientRSSIs []int8) uint8 {
+	nextPower := int(txPower)
+
+	switch {
+	case len(clientRSSIs) > 0 && minRSSI(clientRSSIs) < dtpClientLowRSSI:
+		nextPower += dtpStep
+	case len(neighborRSSIs) > 0 && maxRSSI(neighborRSSIs) > dtpNeighborHighRSSI:
+		nextPower -= dtpStep
+	case len(neighborRSSIs) > 0 && maxRSSI(neighborRSSIs) < dtpNeighborLowRSSI:
+		nextPower += dtpStep
+	}
+
+	if nextPower < int(minPower) {
+		nextPower = int(minPower)
+	}
+	if nextPower > int(maxPower) {
+		nextPower = int(maxPower)
+	}
+	return uint8(nextPower)
+}
+
+func minRSSI(rssis []int8) int8 {
+	min := rssis[0]
+	for _, rssi := range rssis[1:] {
+		if rssi < min {
+			min = rssi
+		}
+	}
+	return min
+}
+
+func maxRSSI(rssis []int8) int8 {
+	max := rssis[0]
+	for _, rssi := range rssis[1:] {
+		if rssi > max {
+			max = rssi
+		}
+	}
+	return max
+}
+
+// publishTransmitPower updates the transmit power and DTP state of the radios with the given DTP settings,
+// with their state. The radios with the given IDs no longer run DTP.
+func publishTransmitPower(s *gnmi.Server, hostName string, settings []*dtpSettings, radios map[string]*dtpRadioState, disabledRadioIDs []uint8) error {
+	return s.InternalUpdate(func(config ygot.ValidatedGoStruct) error {
+		device, ok := config.(*ocstruct.Device)
+		if !ok {
+			return errors.New("configuration has invalid type")
+		}
+		for _, radioSettings := range settings {
+			radioState, ok := radios[radioSettings.wlanINTFName]
+			if !ok || !radioState.applied {
+				continue
+			}
+			radio := ocutil.FindRadio(device, hostName, radioSettings.radioID)
+			if radio == nil {
+				return fmt.Errorf("radio %d not found on AP %s", radioSettings.radioID, hostName)
+			}
+			if radio.State == nil {
+				radio.State = &ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_Radios_Radio_State{}
+			}
+			radio.State.Dtp = ygot.Bool(true)
+			radio.State.DtpMin = ygot.Uint8(radioSettings.minPower)
+			radio.State.DtpMax = ygot.Uint8(radioSettings.maxPower)
+			radio.State.TransmitPower = ygot.Uint8(radioState.txPower)
+		}
+		for _, radioID := range disabledRadioIDs {
+			radio := ocutil.FindRadio(device, hostName, radioID)
+			if radio == nil || radio.State == nil {
+				continue
+			}
+			radio.State.Dtp = ygot.Bool(false)
+			radio.State.DtpMin = nil
+			radio.State.DtpMax = nil
+		}
+		return nil
+	})
+}
@@ -0,0 +1,331 @@
+/* Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package monitoring
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/link022/agent/syscmd"
+	"github.com/google/link022/agent/util/mock"
+	"github.com/google/link022/generated/ocstruct"
+	"github.com/openconfig/ygot/ygot"
+)
+
+const (
+	testDTPMinPower = 5
+	testDTPMaxPower = 20
+	// testDTPCycles is large enough for the control loop to converge in all simulations.
+	testDTPCycles = 50
+)
+
+const testStationDump = `Station 12:34:56:78:9A:BC (on wlan0)
+	inactive time:	304 ms
+	rx bytes:	18816
+	tx bytes:	5695
+	tx retries:	7
+	signal:  	-42 [-42] dBm
+	signal avg:	-43 [-43] dBm
+Station 12:34:56:78:9a:bd (on wlan0)
+	signal:  	-78 [-78] dBm
+`
+
+func TestParseStationDump(t *testing.T) {
+	want := []*stationInfo{
+		{mac: "12:34:56:78:9a:bc", signal: -42, txRetries: 7},
+		{mac: "12:34:56:78:9a:bd", signal: -78},
+	}
+	got := parseStationDump(testStationDump)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Incorrect station dump result (got: %v, want: %v).", got, want)
+	}
+}
+
+// simulatedAP is an AP running DTP in the simulation.
+type simulatedAP struct {
+	txPower uint8
+	// Path loss (in dB) to each other AP, indexed by AP.
+	pathLoss []int
+	// RSSI of the associated clients, which does not depend on the AP transmit power.
+	clientRSSIs []int8
+}
+
+// simulateDTP runs DTP on all APs for the given number of cycles.
+// In each cycle, every AP hears the other APs at (transmit power - path loss).
+// It returns the transmit power history of each AP.
+func simulateDTP(aps []*simulatedAP, cycles int) [][]uint8 {
+	history := make([][]uint8, len(aps))
+	for cycle := 0; cycle < cycles; cycle++ {
+		nextPowers := make([]uint8, len(aps))
+		for i, ap := range aps {
+			var neighborRSSIs []int8
+			for j, other := range aps {
+				if i == j {
+					continue
+				}
+				neighborRSSIs = append(neighborRSSIs, int8(int(other.txPower)-ap.pathLoss[j]))
+			}
+			nextPowers[i] = nextTransmitPower(ap.txPower, testDTPMinPower, testDTPMaxPower, neighborRSSIs, ap.clientRSSIs)
+		}
+		for i, ap := range aps {
+			ap.txPower = nextPowers[i]
+			history[i] = append(history[i], ap.txPower)
+		}
+	}
+	return history
+}
+
+func TestDTPSimulation(t *testing.T) {
+	tests := []struct {
+		name       string
+		aps        []*simulatedAP
+		finalPower []uint8
+	}{{
+		name: "LonelyAPKeepsPower",
+		aps: []*simulatedAP{
+			{txPower: 10, pathLoss: []int{0}},
+		},
+		finalPower: []uint8{10},
+	}, {
+		name: "LonelyAPWithWeakClientGoesToMax",
+		aps: []*simulatedAP{
+			{txPower: 10, pathLoss: []int{0}, clientRSSIs: []int8{-80}},
+		},
+		finalPower: []uint8{testDTPMaxPower},
+	}, {
+		name: "CloseAPsBackOff",
+		aps: []*simulatedAP{
+			{txPower: 20, pathLoss: []int{0, 80}},
+			{txPower: 20, pathLoss: []int{80, 0}},
+		},
+		// Each AP hears the other at -65 dBm.
+		finalPower: []uint8{15, 15},
+	}, {
+		name: "VeryCloseAPsStopAtMin",
+		aps: []*simulatedAP{
+			{txPower: 20, pathLoss: []int{0, 40}},
+			{txPower: 20, pathLoss: []int{40, 0}},
+		},
+		finalPower: []uint8{testDTPMinPower, testDTPMinPower},
+	}, {
+		name: "FarAPsGoToMax",
+		aps: []*simulatedAP{
+			{txPower: 5, pathLoss: []int{0, 100}},
+			{txPower: 5, pathLoss: []int{100, 0}},
+		},
+		finalPower: []uint8{testDTPMaxPower, testDTPMaxPower},
+	}, {
+		name: "WeakClientKeepsPowerUp",
+		aps: []*simulatedAP{
+			{txPower: 20, pathLoss: []int{0, 40}, clientRSSIs: []int8{-50, -80}},
+			{txPower: 20, pathLoss: []int{40, 0}},
+		},
+		finalPower: []uint8{testDTPMaxPower, testDTPMinPower},
+	}, {
+		name: "StableBetweenThresholds",
+		aps: []*simulatedAP{
+			{txPower: 12, pathLoss: []int{0, 82}},
+			{txPower: 12, pathLoss: []int{82, 0}},
+		},
+		// Each AP hears the other at -70 dBm, no change required.
+		finalPower: []uint8{12, 12},
+	}}
+
+	for _, test := range tests {
+		history := simulateDTP(test.aps, testDTPCycles)
+		for i, powers := range history {
+			for _, power := range powers {
+				if power < testDTPMinPower || power > testDTPMaxPower {
+					t.Errorf("[%s] AP %d transmit power %d out of range [%d, %d].", test.name, i, power, testDTPMinPower, testDTPMaxPower)
+				}
+			}
+			// The transmit power must settle, no oscillation in the last cycles.
+			for _, power := range powers[len(powers)-5:] {
+				if power != test.finalPower[i] {
+					t.Errorf("[%s] AP %d does not converge (got: %v, want: %d).", test.name, i, powers, test.finalPower[i])
+					break
+				}
+			}
+		}
+	}
+}
+
+func TestDTPDeterministic(t *testing.T) {
+	newAPs := func() []*simulatedAP {
+		return []*simulatedAP{
+			{txPower: 20, pathLoss: []int{0, 78, 85}, clientRSSIs: []int8{-60}},
+			{txPower: 8, pathLoss: []int{78, 0, 90}},
+			{txPower: 14, pathLoss: []int{85, 90, 0}, clientRSSIs: []int8{-70, -72}},
+		}
+	}
+
+	first := simulateDTP(newAPs(), testDTPCycles)
+	second := simulateDTP(newAPs(), testDTPCycles)
+	if !reflect.DeepEqual(first, second) {
+		t.Errorf("DTP is not deterministic (first run: %v, second run: %v).", first, second)
+	}
+}
+
+func TestDTPRadioSettings(t *testing.T) {
+	apConfig := mock.GenerateAPConfig(false)
+	apConfig.Radios.Radio[2] = &ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_Radios_Radio{
+		Id: ygot.Uint8(2),
+		Config: &ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_Radios_Radio_Config{
+			Id:            ygot.Uint8(2),
+			Dtp:           ygot.Bool(true),
+			DtpMin:        ygot.Uint8(testDTPMinPower),
+			DtpMax:        ygot.Uint8(testDTPMaxPower),
+			TransmitPower: ygot.Uint8(10),
+		},
+	}
+	if settings := dtpRadioSettings(apConfig, []string{"wlan0"}); len(settings) != 0 {
+		t.Errorf("Expected no DTP radio without a WLAN interface, got %+v.", settings)
+	}
+
+	radio1 := apConfig.Radios.Radio[1].Config
+	radio1.Dtp, radio1.DtpMin, radio1.DtpMax = ygot.Bool(true), ygot.Uint8(testDTPMinPower), ygot.Uint8(testDTPMaxPower)
+	settings := dtpRadioSettings(apConfig, []string{"wlan0", "wlan1"})
+	var got []dtpSettings
+	for _, radioSettings := range settings {
+		radioSettings.managedSSIDs = nil
+		got = append(got, *radioSettings)
+	}
+	want := []dtpSettings{
+		{radioID: 1, wlanINTFName: "wlan0", operatingFrequency: ocstruct.OpenconfigWifiTypes_OPERATING_FREQUENCY_FREQ_2GHZ, minPower: testDTPMinPower, maxPower: testDTPMaxPower, initialPower: 5},
+		{radioID: 2, wlanINTFName: "wlan1", minPower: testDTPMinPower, maxPower: testDTPMaxPower, initialPower: 10},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Incorrect DTP settings (got: %+v, want: %+v).", got, want)
+	}
+}
+
+func TestDTPControllerUpdate(t *testing.T) {
+	originalRunner, originalStarts := cmdRunner, hostapdStarts
+	defer func() { cmdRunner, hostapdStarts = originalRunner, originalStarts }()
+	var cmds []string
+	cmdRunner = &syscmd.CommandRunner{
+		ExecCommand: func(wait bool, cmd string, args ...string) (string, error) {
+			cmdLine := cmd + " " + strings.Join(args, " ")
+			cmds = append(cmds, cmdLine)
+			if cmdLine == "iw dev wlan0 station dump" {
+				return testStationDump, nil
+			}
+			return "", nil
+		},
+	}
+	starts := uint64(1)
+	hostapdStarts = func() uint64 { return starts }
+
+	dtp := newDTPController()
+	settings := []*dtpSettings{{radioID: 1, wlanINTFName: "wlan0", minPower: testDTPMinPower, maxPower: testDTPMaxPower, initialPower: 12}}
+	// A client is heard below dtpClientLowRSSI, the transmit power is increased.
+	dtp.update(settings)
+	want := []string{"iw dev wlan0 station dump", "iw dev wlan0 set txpower fixed 1300"}
+	if !reflect.DeepEqual(cmds, want) {
+		t.Errorf("Incorrect commands of the first cycle (got: %v, want: %v).", cmds, want)
+	}
+
+	// The transmit power is applied again once hostapd restarted.
+	cmds = nil
+	starts++
+	settings[0].maxPower = 13
+	dtp.update(settings)
+	want = []string{"iw dev wlan0 station dump", "iw dev wlan0 set txpower fixed 1300"}
+	if !reflect.DeepEqual(cmds, want) {
+		t.Errorf("Incorrect commands after a hostapd restart (got: %v, want: %v).", cmds, want)
+	}
+	cmds = nil
+	dtp.update(settings)
+	if want := []string{"iw dev wlan0 station dump"}; !reflect.DeepEqual(cmds, want) {
+		t.Errorf("Incorrect commands with an unchanged transmit power (got: %v, want: %v).", cmds, want)
+	}
+
+	// The driver chooses the transmit power again once DTP is disabled.
+	cmds = nil
+	if disabled := dtp.update(nil); !reflect.DeepEqual(disabled, []uint8{1}) {
+		t.Errorf("Incorrect radios with DTP disabled %v.", disabled)
+	}
+	if want := []string{"iw dev wlan0 set txpower auto"}; !reflect.DeepEqual(cmds, want) {
+		t.Errorf("Incorrect commands disabling DTP (got: %v, want: %v).", cmds, want)
+	}
+	if len(dtp.radios) != 0 {
+		t.Errorf("Expected no DTP radio, got %+v.", dtp.radios)
+	}
+}
+
+func TestDTPNeighborsOnBand(t *testing.T) {
+	originalRunner, originalNeighbors := cmdRunner, neighbors
+	defer func() { cmdRunner, neighbors = originalRunner, originalNeighbors }()
+	var cmds []string
+	cmdRunner = &syscmd.CommandRunner{
+		ExecCommand: func(wait bool, cmd string, args ...string) (string, error) {
+			cmds = append(cmds, cmd+" "+strings.Join(args, " "))
+			return "", nil
+		},
+	}
+
+	settings := &dtpSettings{
+		radioID:            1,
+		wlanINTFName:       "wlan0",
+		operatingFrequency: ocstruct.OpenconfigWifiTypes_OPERATING_FREQUENCY_FREQ_2GHZ,
+		minPower:           testDTPMinPower,
+		maxPower:           testDTPMaxPower,
+		initialPower:       12,
+		managedSSIDs:       map[string]bool{mock.AuthWLANName: true},
+	}
+	tests := []struct {
+		name      string
+		neighbors []*NeighborBSS
+		want      string
+	}{{
+		name: "NoScanData",
+		want: "iw dev wlan0 set txpower fixed 1200",
+	}, {
+		// The close neighbor on 5 GHz does not overlap with the 2.4 GHz radio.
+		name: "NeighborsOnOtherBand",
+		neighbors: []*NeighborBSS{
+			{BSSID: "00:11:22:33:44:55", SSID: mock.AuthWLANName, Channel: 36, RSSI: -40},
+			{BSSID: "00:11:22:33:44:56", SSID: mock.AuthWLANName, Channel: 6, RSSI: -70},
+		},
+		want: "iw dev wlan0 set txpower fixed 1200",
+	}, {
+		name: "CloseNeighborOnBand",
+		neighbors: []*NeighborBSS{
+			{BSSID: "00:11:22:33:44:56", SSID: mock.AuthWLANName, Channel: 6, RSSI: -40},
+		},
+		want: "iw dev wlan0 set txpower fixed 1100",
+	}}
+
+	for _, test := range tests {
+		cmds = nil
+		neighbors = &radioNeighbors{tables: make(map[uint8]*neighborTable)}
+		if test.neighbors != nil {
+			now := time.Now()
+			for _, neighbor := range test.neighbors {
+				neighbor.LastSeen = now
+			}
+			neighbors.table(settings.radioID).update(test.neighbors, now, time.Minute)
+		}
+		if err := updateTransmitPower(settings, &dtpRadioState{radioID: settings.radioID}); err != nil {
+			t.Errorf("[%s] Updating the transmit power failed. Error: %v.", test.name, err)
+		}
+		if len(cmds) == 0 || cmds[len(cmds)-1] != test.want {
+			t.Errorf("[%s] Incorrect commands (got: %v, want last: %q).", test.name, cmds, test.want)
+		}
+	}
+}
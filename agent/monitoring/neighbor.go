@@ -0,0 +1,359 @@
+/* Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package monitoring
+
+import (
+	ctx "context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/golang/glog"
+	"github.com/google/link022/agent/context"
+	"github.com/google/link022/agent/gnmi"
+	"github.com/google/link022/agent/util/ocutil"
+	"github.com/google/link022/generated/ocstruct"
+	"github.com/openconfig/ygot/ygot"
+)
+
+const (
+	defaultScanInterval = 30 * time.Second
+	defaultNeighborTTL  = 5 * time.Minute
+)
+
+var neighbors = &radioNeighbors{
+	tables: make(map[uint8]*neighborTable),
+}
+
+// NeighborBSS contains the information of a neighbor BSS heard by an AP radio.
+type NeighborBSS struct {
+	BSSID          string
+	SSID           string
+	Channel        uint16
+	PrimaryChannel uint16
+	RSSI           int8
+	LastSeen       time.Time
+}
+
+// radioNeighbors keeps the neighbor tables of the AP radios with scanning enabled.
+type radioNeighbors struct {
+	mu     sync.RWMutex
+	tables map[uint8]*neighborTable // radio ID -> neighbors heard by the radio
+}
+
+// table returns the neighbor table of the given radio, a new one if the radio has none yet.
+func (n *radioNeighbors) table(radioID uint8) *neighborTable {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	table, ok := n.tables[radioID]
+	if !ok {
+		table = &neighborTable{neighbors: make(map[string]*NeighborBSS)}
+		n.tables[radioID] = table
+	}
+	return table
+}
+
+// list returns the neighbors heard by the given radio. The last returned value is false
+// if the radio has no scan data, e.g. scanning is disabled on it.
+func (n *radioNeighbors) list(radioID uint8) ([]*NeighborBSS, bool) {
+	n.mu.RLock()
+	table, ok := n.tables[radioID]
+	n.mu.RUnlock()
+	if !ok {
+		return nil, false
+	}
+	return table.list(), true
+}
+
+// retain drops the neighbor tables of the radios not in the given set.
+func (n *radioNeighbors) retain(radioIDs map[uint8]bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	for radioID := range n.tables {
+		if !radioIDs[radioID] {
+			delete(n.tables, radioID)
+		}
+	}
+}
+
+// neighborTable keeps the neighbor BSSs heard by an AP radio.
+type neighborTable struct {
+	mu        sync.RWMutex
+	neighbors map[string]*NeighborBSS // BSSID -> neighbor
+}
+
+// update merges the scanned neighbors into the table.
+// Neighbors not seen within the given TTL are dropped.
+func (t *neighborTable) update(scanned []*NeighborBSS, now time.Time, ttl time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, neighbor := range scanned {
+		if existing, ok := t.neighbors[neighbor.BSSID]; ok && existing.LastSeen.After(neighbor.LastSeen) {
+			continue
+		}
+		t.neighbors[neighbor.BSSID] = neighbor
+	}
+
+	for bssid, neighbor := range t.neighbors {
+		if now.Sub(neighbor.LastSeen) > ttl {
+			log.V(1).Infof("Neighbor %s (%s) aged out.", bssid, neighbor.SSID)
+			delete(t.neighbors, bssid)
+		}
+	}
+}
+
+// list returns a copy of all neighbors in the table, sorted by BSSID.
+func (t *neighborTable) list() []*NeighborBSS {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	var neighborList []*NeighborBSS
+	for _, neighbor := range t.neighbors {
+		neighborCopy := *neighbor
+		neighborList = append(neighborList, &neighborCopy)
+	}
+	sort.Slice(neighborList, func(i, j int) bool {
+		return neighborList[i].BSSID < neighborList[j].BSSID
+	})
+	return neighborList
+}
+
+// Neighbors returns the neighbor BSSs currently heard by the given AP radio.
+// The last returned value is false if the radio has no scan data, e.g. scanning is disabled on it.
+func Neighbors(radioID uint8) ([]*NeighborBSS, bool) {
+	return neighbors.list(radioID)
+}
+
+// UpdateNeighbors periodically scans the neighbor BSSs on each AP radio with scanning enabled
+// and updates the neighbor list of the radio in OpenConfig Model tree.
+// Each radio is scanned at the scanning interval of its configuration.
+func UpdateNeighbors(bkgdContext ctx.Context, gnmiServer *gnmi.Server) {
+	deviceConfig := context.GetDeviceConfig()
+	hostName := deviceConfig.Hostname
+	wLANINTFNames := deviceConfig.WLANINTFNames
+	ttl := deviceConfig.NeighborTTL
+	if ttl <= 0 {
+		ttl = defaultNeighborTTL
+	}
+	lastScans := make(map[uint8]time.Time)
+
+	for {
+		radios := scanningRadios(configuredRadioIntfs(gnmiServer, hostName, wLANINTFNames))
+		select {
+		case <-bkgdContext.Done():
+			return
+		case <-time.After(nextScanDelay(radios, lastScans, time.Now())):
+		}
+
+		// Radios no longer scanning have no scan data.
+		scanning := make(map[uint8]bool)
+		for _, radio := range radios {
+			scanning[radio.radioID] = true
+		}
+		neighbors.retain(scanning)
+		for radioID := range lastScans {
+			if !scanning[radioID] {
+				delete(lastScans, radioID)
+			}
+		}
+
+		now := time.Now()
+		for _, radio := range radios {
+			if lastScan, ok := lastScans[radio.radioID]; ok && now.Sub(lastScan) < radio.interval {
+				continue
+			}
+			lastScans[radio.radioID] = now
+			if err := updateNeighborInfo(gnmiServer, hostName, radio.wlanINTFName, radio.radioID, ttl); err != nil {
+				log.Errorf("Error in updating neighbor info of radio %d: %v", radio.radioID, err)
+			}
+		}
+	}
+}
+
+// scanRadio is a radio with scanning enabled, and its scanning interval.
+type scanRadio struct {
+	*radioIntf
+	interval time.Duration
+}
+
+// scanningRadios returns the radios with scanning enabled among the given radios, with their scanning interval.
+func scanningRadios(radios []*radioIntf) []*scanRadio {
+	var scanRadios []*scanRadio
+	for _, radio := range radios {
+		if radio.config.Scanning == nil || !*radio.config.Scanning {
+			continue
+		}
+		interval := defaultScanInterval
+		if radio.config.ScanningInterval != nil && *radio.config.ScanningInterval > 0 {
+			interval = time.Duration(*radio.config.ScanningInterval) * time.Second
+		}
+		scanRadios = append(scanRadios, &scanRadio{radioIntf: radio, interval: interval})
+	}
+	return scanRadios
+}
+
+// nextScanDelay returns the time until the next scan is due on any of the given radios,
+// based on the time of their last scan. A radio never scanned is due immediately.
+// The default scanning interval is returned if no radio has scanning enabled.
+func nextScanDelay(radios []*scanRadio, lastScans map[uint8]time.Time, now time.Time) time.Duration {
+	if len(radios) == 0 {
+		return defaultScanInterval
+	}
+	var delay time.Duration
+	for i, radio := range radios {
+		radioDelay := time.Duration(0)
+		if lastScan, ok := lastScans[radio.radioID]; ok {
+			radioDelay = lastScan.Add(radio.interval).Sub(now)
+		}
+		if i == 0 || radioDelay < delay {
+			delay = radioDelay
+		}
+	}
+	if delay < 0 {
+		return 0
+	}
+	return delay
+}
+
+func updateNeighborInfo(s *gnmi.Server, hostName, wLANINTFName string, radioID uint8, ttl time.Duration) error {
+	scanResult, err := cmdRunner.ScanNeighbors(wLANINTFName)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	table := neighbors.table(radioID)
+	table.update(parseScanResult(scanResult, now), now, ttl)
+	return publishNeighbors(s, hostName, radioID, table.list())
+}
+
+// publishNeighbors replaces the neighbor list of the given radio with the given neighbors.
+func publishNeighbors(s *gnmi.Server, hostName string, radioID uint8, neighborList []*NeighborBSS) error {
+	return s.InternalUpdate(func(config ygot.ValidatedGoStruct) error {
+		device, ok := config.(*ocstruct.Device)
+		if !ok {
+			return errors.New("configuration has invalid type")
+		}
+		radio := ocutil.FindRadio(device, hostName, radioID)
+		if radio == nil {
+			return fmt.Errorf("radio %d not found on AP %s", radioID, hostName)
+		}
+
+		radio.Neighbors = &ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_Radios_Radio_Neighbors{}
+		for _, neighbor := range neighborList {
+			neighborNode, err := radio.Neighbors.NewNeighbor(neighbor.BSSID)
+			if err != nil {
+				return err
+			}
+			neighborNode.State = &ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_Radios_Radio_Neighbors_Neighbor_State{
+				Bssid:          ygot.String(neighbor.BSSID),
+				Ssid:           ygot.String(neighbor.SSID),
+				Channel:        ygot.Uint16(neighbor.Channel),
+				PrimaryChannel: ygot.Uint16(neighbor.PrimaryChannel),
+				Rssi:           ygot.Int8(neighbor.RSSI),
+				LastSeen:       ygot.Uint64(uint64(neighbor.LastSeen.UnixNano())),
+			}
+		}
+		return nil
+	})
+}
+
+// parseScanResult parses the output of "iw dev <intf> scan".
+// The last seen time of each neighbor is calculated based on the given time.
+func parseScanResult(scanResult string, now time.Time) []*NeighborBSS {
+	var neighborList []*NeighborBSS
+	var current *NeighborBSS
+
+	for _, line := range strings.Split(scanResult, "\n") {
+		// Each BSS starts with a line like "BSS 00:11:22:33:44:55(on wlan0)".
+		if strings.HasPrefix(line, "BSS ") {
+			bssid := strings.TrimPrefix(line, "BSS ")
+			if i := strings.IndexAny(bssid, "( "); i >= 0 {
+				bssid = bssid[:i]
+			}
+			current = &NeighborBSS{
+				BSSID:    strings.ToLower(bssid),
+				LastSeen: now,
+			}
+			neighborList = append(neighborList, current)
+			continue
+		}
+		if current == nil {
+			continue
+		}
+
+		field := strings.TrimSpace(line)
+		field = strings.TrimPrefix(field, "* ")
+		switch {
+		case strings.HasPrefix(field, "freq:"):
+			if freq, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(field, "freq:"))); err == nil && current.Channel == 0 {
+				current.Channel = freqToChannel(freq)
+			}
+		case strings.HasPrefix(field, "signal:"):
+			signalFields := strings.Fields(strings.TrimPrefix(field, "signal:"))
+			if len(signalFields) > 0 {
+				if signal, err := strconv.ParseFloat(signalFields[0], 64); err == nil {
+					current.RSSI = int8(math.Round(signal))
+				}
+			}
+		case strings.HasPrefix(field, "last seen:"):
+			lastSeenFields := strings.Fields(strings.TrimPrefix(field, "last seen:"))
+			if len(lastSeenFields) > 0 {
+				if lastSeenMs, err := strconv.Atoi(lastSeenFields[0]); err == nil {
+					current.LastSeen = now.Add(-time.Duration(lastSeenMs) * time.Millisecond)
+				}
+			}
+		case strings.HasPrefix(field, "SSID:"):
+			current.SSID = strings.TrimSpace(strings.TrimPrefix(field, "SSID:"))
+		case strings.HasPrefix(field, "DS Parameter set: channel"):
+			if channel, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(field, "DS Parameter set: channel"))); err == nil {
+				current.Channel = uint16(channel)
+			}
+		case strings.HasPrefix(field, "primary channel:"):
+			if channel, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(field, "primary channel:"))); err == nil {
+				current.PrimaryChannel = uint16(channel)
+			}
+		}
+	}
+
+	for _, neighbor := range neighborList {
+		if neighbor.PrimaryChannel == 0 {
+			neighbor.PrimaryChannel = neighbor.Channel
+		}
+	}
+	return neighborList
+}
+
+// freqToChannel converts a center frequency (MHz) to the IEEE 802.11 channel number.
+func freqToChannel(freq int) uint16 {
+	switch {
+	case freq == 2484:
+		return 14
+	case freq >= 2412 && freq < 2484:
+		return uint16((freq - 2407) / 5)
+	case freq >= 5000 && freq < 5900:
+		return uint16((freq - 5000) / 5)
+	}
+	return 0
+}
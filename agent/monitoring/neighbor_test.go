@@ -0,0 +1,187 @@
+/* Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package monitoring
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/link022/generated/ocstruct"
+	"github.com/openconfig/ygot/ygot"
+)
+
+const testScanResult = `BSS 00:11:22:33:44:55(on wlan0)
+	TSF: 1234567890 usec (0d, 00:20:34)
+	freq: 2437
+	beacon interval: 100 TUs
+	capability: ESS Privacy ShortSlotTime (0x0411)
+	signal: -45.00 dBm
+	last seen: 200 ms ago
+	SSID: Auth-Emu
+	Supported rates: 1.0* 2.0* 5.5* 11.0* 6.0 9.0 12.0 18.0
+	DS Parameter set: channel 6
+	HT operation:
+		 * primary channel: 6
+		 * secondary channel offset: no secondary
+		 * STA channel width: 20 MHz
+BSS AA:BB:CC:DD:EE:FF(on wlan0)
+	freq: 5180
+	signal: -71.50 dBm
+	last seen: 1500 ms ago
+	SSID: Guest-Emu
+`
+
+func TestParseScanResult(t *testing.T) {
+	now := time.Unix(1000, 0)
+	want := []*NeighborBSS{{
+		BSSID:          "00:11:22:33:44:55",
+		SSID:           "Auth-Emu",
+		Channel:        6,
+		PrimaryChannel: 6,
+		RSSI:           -45,
+		LastSeen:       now.Add(-200 * time.Millisecond),
+	}, {
+		BSSID:          "aa:bb:cc:dd:ee:ff",
+		SSID:           "Guest-Emu",
+		Channel:        36,
+		PrimaryChannel: 36,
+		RSSI:           -72,
+		LastSeen:       now.Add(-1500 * time.Millisecond),
+	}}
+
+	got := parseScanResult(testScanResult, now)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Incorrect scan result (got: %v, want: %v).", got, want)
+	}
+}
+
+func TestNeighborTableAging(t *testing.T) {
+	ttl := time.Minute
+	start := time.Unix(1000, 0)
+	table := &neighborTable{neighbors: make(map[string]*NeighborBSS)}
+
+	table.update([]*NeighborBSS{
+		{BSSID: "00:11:22:33:44:55", LastSeen: start},
+		{BSSID: "aa:bb:cc:dd:ee:ff", LastSeen: start},
+	}, start, ttl)
+	if got := len(table.list()); got != 2 {
+		t.Fatalf("Incorrect number of neighbors (got: %d, want: 2).", got)
+	}
+
+	// Only one neighbor is heard again, the other one becomes stale.
+	later := start.Add(2 * ttl)
+	table.update([]*NeighborBSS{
+		{BSSID: "aa:bb:cc:dd:ee:ff", LastSeen: later},
+	}, later, ttl)
+	got := table.list()
+	if len(got) != 1 || got[0].BSSID != "aa:bb:cc:dd:ee:ff" {
+		t.Errorf("Stale neighbor is not dropped (got: %v).", got)
+	}
+}
+
+func TestScanningRadios(t *testing.T) {
+	newRadio := func(id uint8, scanning bool, interval uint8) *radioIntf {
+		return &radioIntf{
+			radioID: id,
+			config: &ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_Radios_Radio_Config{
+				Id:               ygot.Uint8(id),
+				Scanning:         ygot.Bool(scanning),
+				ScanningInterval: ygot.Uint8(interval),
+			},
+		}
+	}
+
+	tests := []struct {
+		name          string
+		radios        []*radioIntf
+		wantIntervals map[uint8]time.Duration
+	}{{
+		name:          "NoScanning",
+		radios:        []*radioIntf{newRadio(1, false, 10)},
+		wantIntervals: map[uint8]time.Duration{},
+	}, {
+		name:          "ScanningRadios",
+		radios:        []*radioIntf{newRadio(1, false, 10), newRadio(2, true, 20), newRadio(3, true, 30)},
+		wantIntervals: map[uint8]time.Duration{2: 20 * time.Second, 3: 30 * time.Second},
+	}, {
+		name:          "DefaultInterval",
+		radios:        []*radioIntf{newRadio(1, true, 0)},
+		wantIntervals: map[uint8]time.Duration{1: defaultScanInterval},
+	}}
+
+	for _, test := range tests {
+		got := make(map[uint8]time.Duration)
+		for _, radio := range scanningRadios(test.radios) {
+			got[radio.radioID] = radio.interval
+		}
+		if !reflect.DeepEqual(got, test.wantIntervals) {
+			t.Errorf("[%s] Incorrect scanning radios (got: %v, want: %v).", test.name, got, test.wantIntervals)
+		}
+	}
+}
+
+func TestNextScanDelay(t *testing.T) {
+	now := time.Unix(1000, 0)
+	radios := []*scanRadio{
+		{radioIntf: &radioIntf{radioID: 1}, interval: 20 * time.Second},
+		{radioIntf: &radioIntf{radioID: 2}, interval: 60 * time.Second},
+	}
+
+	tests := []struct {
+		name      string
+		radios    []*scanRadio
+		lastScans map[uint8]time.Time
+		want      time.Duration
+	}{{
+		name: "NoScanning",
+		want: defaultScanInterval,
+	}, {
+		name:      "NeverScanned",
+		radios:    radios,
+		lastScans: map[uint8]time.Time{1: now},
+	}, {
+		name:      "EarliestDue",
+		radios:    radios,
+		lastScans: map[uint8]time.Time{1: now.Add(-5 * time.Second), 2: now.Add(-50 * time.Second)},
+		want:      10 * time.Second,
+	}, {
+		name:      "Overdue",
+		radios:    radios,
+		lastScans: map[uint8]time.Time{1: now.Add(-time.Minute), 2: now},
+	}}
+
+	for _, test := range tests {
+		if got := nextScanDelay(test.radios, test.lastScans, now); got != test.want {
+			t.Errorf("[%s] Incorrect scan delay (got: %v, want: %v).", test.name, got, test.want)
+		}
+	}
+}
+
+func TestRadioNeighbors(t *testing.T) {
+	now := time.Unix(1000, 0)
+	radioNeighbors := &radioNeighbors{tables: make(map[uint8]*neighborTable)}
+	radioNeighbors.table(1).update([]*NeighborBSS{{BSSID: "00:11:22:33:44:55", LastSeen: now}}, now, time.Minute)
+	radioNeighbors.table(2).update([]*NeighborBSS{{BSSID: "aa:bb:cc:dd:ee:ff", LastSeen: now}}, now, time.Minute)
+
+	if got, ok := radioNeighbors.list(1); !ok || len(got) != 1 || got[0].BSSID != "00:11:22:33:44:55" {
+		t.Errorf("Incorrect neighbors of radio 1 (got: %v, scanned: %v).", got, ok)
+	}
+	radioNeighbors.retain(map[uint8]bool{1: true})
+	if got, ok := radioNeighbors.list(2); ok {
+		t.Errorf("Neighbors of radio 2 kept after it stopped scanning (got: %v).", got)
+	}
+}
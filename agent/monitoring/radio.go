@@ -0,0 +1,79 @@
+/* Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package monitoring
+
+import (
+	"errors"
+
+	log "github.com/golang/glog"
+	"github.com/google/link022/agent/gnmi"
+	"github.com/google/link022/agent/util/ocutil"
+	"github.com/google/link022/generated/ocstruct"
+	"github.com/openconfig/ygot/ygot"
+)
+
+// radioIntf is a radio configured on the AP, with the WLAN interface running it.
+type radioIntf struct {
+	radioID      uint8
+	wlanINTFName string
+	config       *ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_Radios_Radio_Config
+}
+
+// radioIntfs returns the radios of the given AP with their WLAN interface, in ascending radio ID order.
+// The agent runs the radios on the given WLAN interfaces in this order. Radios without
+// a WLAN interface or a configuration are skipped.
+func radioIntfs(apConfig *ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint, wLANINTFNames []string) []*radioIntf {
+	var radios []*radioIntf
+	for i, id := range ocutil.RadioIDs(apConfig) {
+		if i >= len(wLANINTFNames) {
+			log.Errorf("No WLAN interface runs radio %d.", id)
+			break
+		}
+		radioConfig := apConfig.Radios.Radio[id].Config
+		if radioConfig == nil {
+			continue
+		}
+		radios = append(radios, &radioIntf{radioID: id, wlanINTFName: wLANINTFNames[i], config: radioConfig})
+	}
+	return radios
+}
+
+// onOperatingFrequency checks whether a BSS on the given channel runs on the given operating frequency.
+// Any known channel matches a radio running on both bands.
+func onOperatingFrequency(channel uint16, opFrequency ocstruct.E_OpenconfigWifiTypes_OPERATING_FREQUENCY) bool {
+	switch opFrequency {
+	case ocstruct.OpenconfigWifiTypes_OPERATING_FREQUENCY_FREQ_2GHZ:
+		return channel >= 1 && channel <= 14
+	case ocstruct.OpenconfigWifiTypes_OPERATING_FREQUENCY_FREQ_5GHZ:
+		return channel > 14
+	}
+	return channel != 0
+}
+
+// configuredRadioIntfs returns the radios configured on this AP with their WLAN interface,
+// in ascending radio ID order.
+func configuredRadioIntfs(s *gnmi.Server, hostName string, wLANINTFNames []string) []*radioIntf {
+	var radios []*radioIntf
+	s.InternalUpdate(func(config ygot.ValidatedGoStruct) error {
+		device, ok := config.(*ocstruct.Device)
+		if !ok {
+			return errors.New("configuration has invalid type")
+		}
+		radios = radioIntfs(ocutil.FindAPConfig(device, hostName), wLANINTFNames)
+		return nil
+	})
+	return radios
+}
@@ -0,0 +1,51 @@
+/* Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package monitoring
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/google/link022/agent/util/mock"
+	"github.com/google/link022/generated/ocstruct"
+	"github.com/openconfig/ygot/ygot"
+)
+
+func TestRadioIntfs(t *testing.T) {
+	apConfig := mock.GenerateAPConfig(false)
+	for _, id := range []uint8{4, 3} {
+		apConfig.Radios.Radio[id] = &ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_Radios_Radio{
+			Id:     ygot.Uint8(id),
+			Config: &ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_Radios_Radio_Config{Id: ygot.Uint8(id)},
+		}
+	}
+	apConfig.Radios.Radio[2] = &ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_Radios_Radio{Id: ygot.Uint8(2)}
+
+	var got []radioIntf
+	for _, radio := range radioIntfs(apConfig, []string{"wlan0", "wlan1", "wlan2"}) {
+		radio.config = nil
+		got = append(got, *radio)
+	}
+	// Radio 2 has no configuration, radio 4 no WLAN interface.
+	want := []radioIntf{{radioID: 1, wlanINTFName: "wlan0"}, {radioID: 3, wlanINTFName: "wlan2"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Incorrect radio interfaces (got: %+v, want: %+v).", got, want)
+	}
+
+	if radios := radioIntfs(nil, []string{"wlan0"}); len(radios) != 0 {
+		t.Errorf("Expected no radio without AP configuration, got %+v.", radios)
+	}
+}
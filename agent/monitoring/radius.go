@@ -0,0 +1,220 @@
+/* Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package monitoring
+
+import (
+	ctx "context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/golang/glog"
+	"github.com/google/link022/agent/alarm"
+	"github.com/google/link022/agent/context"
+	"github.com/google/link022/agent/gnmi"
+	"github.com/google/link022/agent/util/ocutil"
+	"github.com/google/link022/generated/ocstruct"
+	"github.com/openconfig/ygot/ygot"
+)
+
+// radiusCounters contains the counters of a RADIUS authentication server.
+type radiusCounters struct {
+	accessAccepts         uint64
+	accessRejects         uint64
+	accessChallenges      uint64
+	timeoutAccessRequests uint64
+	retriedAccessRequests uint64
+}
+
+// responses returns the number of access requests the server answered.
+func (c *radiusCounters) responses() uint64 {
+	return c.accessAccepts + c.accessRejects + c.accessChallenges
+}
+
+// radiusReachability finds the RADIUS servers which stopped answering, from the changes of their counters.
+type radiusReachability struct {
+	last        map[string]*radiusCounters // server address -> counters of the last collection
+	unreachable map[string]bool
+}
+
+func newRadiusReachability() *radiusReachability {
+	return &radiusReachability{
+		last:        make(map[string]*radiusCounters),
+		unreachable: make(map[string]bool),
+	}
+}
+
+// update updates the reachability of the servers with their current counters (server address -> counters).
+// A server becomes unreachable when requests time out without any answer since the last collection,
+// and reachable again once it answers. It returns a server address -> alarm text map of the unreachable servers.
+func (r *radiusReachability) update(serverCounters map[string]*radiusCounters) map[string]string {
+	unreachable := make(map[string]bool)
+	for address, counters := range serverCounters {
+		last, ok := r.last[address]
+		if !ok || counters.responses() < last.responses() || counters.timeoutAccessRequests < last.timeoutAccessRequests {
+			// The counters restarted with hostapd.
+			last = &radiusCounters{}
+		}
+		switch {
+		case counters.responses() > last.responses():
+		case counters.timeoutAccessRequests > last.timeoutAccessRequests:
+			unreachable[address] = true
+		default:
+			unreachable[address] = r.unreachable[address]
+		}
+	}
+	r.last = serverCounters
+	r.unreachable = unreachable
+
+	alarms := make(map[string]string)
+	for address, down := range unreachable {
+		if down {
+			alarms[address] = fmt.Sprintf("RADIUS server %s does not answer the access requests.", address)
+		}
+	}
+	return alarms
+}
+
+// UpdateRadiusCounters periodically collects the RADIUS client MIB of each BSS,
+// and updates the counters of RADIUS servers in OpenConfig Model tree.
+// It raises an alarm for each RADIUS server which does not answer.
+func UpdateRadiusCounters(bkgdContext ctx.Context, gnmiServer *gnmi.Server) {
+	hostName := context.GetDeviceConfig().Hostname
+	reachability := newRadiusReachability()
+
+	for {
+		select {
+		case <-bkgdContext.Done():
+			return
+		case <-time.After(statesUpdateDelay):
+		}
+
+		if err := updateRadiusCounters(gnmiServer, hostName, reachability); err != nil {
+			log.Errorf("Error in updating RADIUS counters: %v", err)
+		}
+	}
+}
+
+func updateRadiusCounters(s *gnmi.Server, hostName string, reachability *radiusReachability) error {
+	// Each BSS has its own RADIUS client, sum up the counters of all BSSs.
+	serverCounters := make(map[string]*radiusCounters) // server address -> counters
+	for _, bss := range BSSs() {
+		mib, err := cmdRunner.HostapdMIB(bss.IntfName)
+		if err != nil {
+			log.Errorf("Failed to fetch MIB of BSS %s: %v", bss.IntfName, err)
+			continue
+		}
+		for address, counters := range parseRadiusMIB(mib) {
+			total, ok := serverCounters[address]
+			if !ok {
+				total = &radiusCounters{}
+				serverCounters[address] = total
+			}
+			total.accessAccepts += counters.accessAccepts
+			total.accessRejects += counters.accessRejects
+			total.accessChallenges += counters.accessChallenges
+			total.timeoutAccessRequests += counters.timeoutAccessRequests
+			total.retriedAccessRequests += counters.retriedAccessRequests
+		}
+	}
+	alarm.Default().Set(alarm.RadiusUnreachable, reachability.update(serverCounters))
+	if len(serverCounters) == 0 {
+		return nil
+	}
+
+	return s.InternalUpdate(func(config ygot.ValidatedGoStruct) error {
+		device, ok := config.(*ocstruct.Device)
+		if !ok {
+			return errors.New("configuration has invalid type")
+		}
+		apConfig := ocutil.FindAPConfig(device, hostName)
+		if apConfig == nil || apConfig.System == nil || apConfig.System.Aaa == nil || apConfig.System.Aaa.ServerGroups == nil {
+			return nil
+		}
+
+		for _, serverGP := range apConfig.System.Aaa.ServerGroups.ServerGroup {
+			if serverGP.Servers == nil {
+				continue
+			}
+			for address, server := range serverGP.Servers.Server {
+				counters, ok := serverCounters[address]
+				if !ok || server.Radius == nil {
+					continue
+				}
+				if server.Radius.State == nil {
+					server.Radius.State = &ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System_Aaa_ServerGroups_ServerGroup_Servers_Server_Radius_State{}
+				}
+				server.Radius.State.Counters = &ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System_Aaa_ServerGroups_ServerGroup_Servers_Server_Radius_State_Counters{
+					AccessAccepts:         ygot.Uint64(counters.accessAccepts),
+					AccessRejects:         ygot.Uint64(counters.accessRejects),
+					TimeoutAccessRequests: ygot.Uint64(counters.timeoutAccessRequests),
+					RetriedAccessRequests: ygot.Uint64(counters.retriedAccessRequests),
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// parseRadiusMIB parses the RADIUS authentication client variables in the hostapd MIB.
+// Each server section starts with "radiusAuthServerIndex=N", followed by "radiusAuthServerAddress=<IP>".
+// It returns a server address -> counters map.
+func parseRadiusMIB(mib string) map[string]*radiusCounters {
+	serverCounters := make(map[string]*radiusCounters)
+	var current *radiusCounters
+
+	for _, line := range strings.Split(mib, "\n") {
+		sep := strings.Index(line, "=")
+		if sep < 0 {
+			continue
+		}
+		key, value := strings.TrimSpace(line[:sep]), strings.TrimSpace(line[sep+1:])
+
+		if strings.HasSuffix(key, "ServerIndex") {
+			// A new authentication or accounting server section.
+			current = nil
+			continue
+		}
+		if key == "radiusAuthServerAddress" {
+			current = &radiusCounters{}
+			serverCounters[value] = current
+			continue
+		}
+		if current == nil {
+			continue
+		}
+
+		count, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			continue
+		}
+		switch key {
+		case "radiusAuthClientAccessAccepts":
+			current.accessAccepts = count
+		case "radiusAuthClientAccessRejects":
+			current.accessRejects = count
+		case "radiusAuthClientAccessChallenges":
+			current.accessChallenges = count
+		case "radiusAuthClientTimeouts":
+			current.timeoutAccessRequests = count
+		case "radiusAuthClientAccessRetransmissions":
+			current.retriedAccessRequests = count
+		}
+	}
+	return serverCounters
+}
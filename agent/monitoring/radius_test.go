@@ -0,0 +1,99 @@
+/* Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package monitoring
+
+import (
+	"reflect"
+	"testing"
+)
+
+const testHostapdMIB = `dot11RSNAOptionImplemented=TRUE
+dot11RSNAPreauthenticationImplemented=TRUE
+radiusAuthServerIndex=1
+radiusAuthServerAddress=192.168.11.250
+radiusAuthClientServerPortNumber=1812
+radiusAuthClientRoundTripTime=2
+radiusAuthClientAccessRequests=12
+radiusAuthClientAccessRetransmissions=3
+radiusAuthClientAccessAccepts=5
+radiusAuthClientAccessRejects=2
+radiusAuthClientAccessChallenges=4
+radiusAuthClientTimeouts=1
+radiusAuthServerIndex=2
+radiusAuthServerAddress=192.168.11.251
+radiusAuthClientServerPortNumber=1812
+radiusAuthClientAccessRetransmissions=0
+radiusAuthClientAccessAccepts=0
+radiusAuthClientAccessRejects=0
+radiusAuthClientTimeouts=0
+radiusAccServerIndex=1
+radiusAccServerAddress=192.168.11.250
+radiusAccClientServerPortNumber=1813
+radiusAccClientRetransmissions=7
+radiusAccClientTimeouts=7
+`
+
+func TestParseRadiusMIB(t *testing.T) {
+	want := map[string]*radiusCounters{
+		"192.168.11.250": {accessAccepts: 5, accessRejects: 2, accessChallenges: 4, timeoutAccessRequests: 1, retriedAccessRequests: 3},
+		"192.168.11.251": {},
+	}
+	got := parseRadiusMIB(testHostapdMIB)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Incorrect RADIUS counters (got: %v, want: %v).", got, want)
+	}
+}
+
+func TestRadiusReachability(t *testing.T) {
+	r := newRadiusReachability()
+	steps := []struct {
+		counters map[string]*radiusCounters
+		want     map[string]string
+	}{{
+		counters: map[string]*radiusCounters{
+			"192.168.11.250": {accessChallenges: 4},
+			"192.168.11.251": {timeoutAccessRequests: 2},
+		},
+		want: map[string]string{"192.168.11.251": "RADIUS server 192.168.11.251 does not answer the access requests."},
+	}, {
+		// Servers stay unreachable without new requests.
+		counters: map[string]*radiusCounters{
+			"192.168.11.250": {accessChallenges: 4, timeoutAccessRequests: 1},
+			"192.168.11.251": {timeoutAccessRequests: 2},
+		},
+		want: map[string]string{
+			"192.168.11.250": "RADIUS server 192.168.11.250 does not answer the access requests.",
+			"192.168.11.251": "RADIUS server 192.168.11.251 does not answer the access requests.",
+		},
+	}, {
+		counters: map[string]*radiusCounters{
+			"192.168.11.250": {accessChallenges: 6, accessAccepts: 1, timeoutAccessRequests: 2},
+		},
+		want: map[string]string{},
+	}, {
+		// The counters restart with hostapd.
+		counters: map[string]*radiusCounters{
+			"192.168.11.250": {timeoutAccessRequests: 1},
+		},
+		want: map[string]string{"192.168.11.250": "RADIUS server 192.168.11.250 does not answer the access requests."},
+	}}
+
+	for i, step := range steps {
+		if got := r.update(step.counters); !reflect.DeepEqual(got, step.want) {
+			t.Errorf("Incorrect unreachable servers at step %d (got: %v, want: %v).", i, got, step.want)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+/* Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package monitoring
+
+import (
+	"strconv"
+	"strings"
+)
+
+// stationInfo contains the statistics of a station associated with the AP.
+type stationInfo struct {
+	mac       string
+	signal    int8
+	txRetries uint64
+}
+
+// Stations returns the MAC address of the stations associated with a BSS interface.
+func Stations(bssIntfName string) ([]string, error) {
+	stationDump, err := cmdRunner.StationDump(bssIntfName)
+	if err != nil {
+		return nil, err
+	}
+	var macs []string
+	for _, station := range parseStationDump(stationDump) {
+		macs = append(macs, station.mac)
+	}
+	return macs, nil
+}
+
+// parseStationDump parses the output of "iw dev <intf> station dump".
+func parseStationDump(stationDump string) []*stationInfo {
+	var stations []*stationInfo
+	var current *stationInfo
+
+	for _, line := range strings.Split(stationDump, "\n") {
+		// Each station starts with a line like "Station 12:34:56:78:9a:bc (on wlan0)".
+		if strings.HasPrefix(line, "Station ") {
+			fields := strings.Fields(line)
+			if len(fields) < 2 {
+				current = nil
+				continue
+			}
+			current = &stationInfo{mac: strings.ToLower(fields[1])}
+			stations = append(stations, current)
+			continue
+		}
+		if current == nil {
+			continue
+		}
+
+		field := strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(field, "signal:"):
+			signalFields := strings.Fields(strings.TrimPrefix(field, "signal:"))
+			if len(signalFields) > 0 {
+				if signal, err := strconv.Atoi(signalFields[0]); err == nil {
+					current.signal = int8(signal)
+				}
+			}
+		case strings.HasPrefix(field, "tx retries:"):
+			current.txRetries, _ = strconv.ParseUint(strings.TrimSpace(strings.TrimPrefix(field, "tx retries:")), 10, 64)
+		}
+	}
+	return stations
+}
@@ -0,0 +1,228 @@
+/* Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package monitoring
+
+import (
+	ctx "context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/golang/glog"
+	"github.com/google/link022/agent/context"
+	"github.com/google/link022/agent/gnmi"
+	"github.com/google/link022/agent/util/ocutil"
+	"github.com/google/link022/generated/ocstruct"
+	"github.com/openconfig/ygot/ygot"
+)
+
+// surveySample contains the survey data of the channel in use.
+// All times are in milliseconds, accumulated since the channel was tuned.
+type surveySample struct {
+	frequency   int
+	noise       int8
+	activeTime  uint64
+	busyTime    uint64
+	receiveTime uint64
+	sendTime    uint64
+}
+
+// channelUtilization contains the channel utilization (in percent) between two survey samples.
+type channelUtilization struct {
+	total uint8
+	rx    uint8
+	tx    uint8
+	noise uint8
+}
+
+// UpdateRadioCounters periodically collects the channel survey data of the AP radios,
+// and updates the channel utilization and radio counters in OpenConfig Model tree.
+func UpdateRadioCounters(bkgdContext ctx.Context, gnmiServer *gnmi.Server) {
+	deviceConfig := context.GetDeviceConfig()
+	hostName := deviceConfig.Hostname
+	wLANINTFNames := deviceConfig.WLANINTFNames
+	lastSamples := make(map[uint8]*surveySample)
+
+	for {
+		select {
+		case <-bkgdContext.Done():
+			return
+		case <-time.After(statesUpdateDelay):
+		}
+
+		lastSamples = updateRadiosCounters(gnmiServer, hostName, wLANINTFNames, lastSamples)
+	}
+}
+
+// updateRadiosCounters collects a new survey sample on each radio configured on the AP and publishes its state.
+// It returns the new samples by radio ID, which are the base of the next calculation.
+func updateRadiosCounters(s *gnmi.Server, hostName string, wLANINTFNames []string, lastSamples map[uint8]*surveySample) map[uint8]*surveySample {
+	samples := make(map[uint8]*surveySample)
+	for _, radio := range configuredRadioIntfs(s, hostName, wLANINTFNames) {
+		sample, err := updateRadioCounters(s, hostName, radio, lastSamples[radio.radioID])
+		if err != nil {
+			log.Errorf("Error in updating counters of radio %d: %v", radio.radioID, err)
+		}
+		if sample != nil {
+			samples[radio.radioID] = sample
+		}
+	}
+	return samples
+}
+
+// updateRadioCounters collects a new survey sample of a radio and publishes the radio state.
+// It returns the new sample, which is the base of the next calculation.
+func updateRadioCounters(s *gnmi.Server, hostName string, radio *radioIntf, lastSample *surveySample) (*surveySample, error) {
+	radioID, wLANINTFName := radio.radioID, radio.wlanINTFName
+
+	surveyDump, err := cmdRunner.SurveyDump(wLANINTFName)
+	if err != nil {
+		return nil, err
+	}
+	sample, err := parseSurveyDump(surveyDump)
+	if err != nil {
+		return nil, err
+	}
+
+	var fcsErrorCount *uint64
+	if countStr, err := cmdRunner.FCSErrorCount(wLANINTFName); err == nil {
+		if count, err := strconv.ParseUint(countStr, 10, 64); err == nil {
+			fcsErrorCount = ygot.Uint64(count)
+		}
+	}
+
+	utilization, ok := calculateUtilization(lastSample, sample)
+	return sample, s.InternalUpdate(func(config ygot.ValidatedGoStruct) error {
+		device, isDevice := config.(*ocstruct.Device)
+		if !isDevice {
+			return errors.New("configuration has invalid type")
+		}
+		radio := ocutil.FindRadio(device, hostName, radioID)
+		if radio == nil {
+			return fmt.Errorf("radio %d not found on AP %s", radioID, hostName)
+		}
+
+		if radio.State == nil {
+			radio.State = &ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_Radios_Radio_State{}
+		}
+		if ok {
+			radio.State.TotalChannelUtilization = ygot.Uint8(utilization.total)
+			radio.State.RxDot11ChannelUtilization = ygot.Uint8(utilization.rx)
+			radio.State.TxDot11ChannelUtilization = ygot.Uint8(utilization.tx)
+			radio.State.RxNoiseChannelUtilization = ygot.Uint8(utilization.noise)
+		}
+		if radio.State.Counters == nil {
+			radio.State.Counters = &ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_Radios_Radio_State_Counters{}
+		}
+		radio.State.Counters.NoiseFloor = ygot.Int8(sample.noise)
+		if fcsErrorCount != nil {
+			radio.State.Counters.FailedFcsFrames = fcsErrorCount
+		}
+		return nil
+	})
+}
+
+// parseSurveyDump parses the output of "iw dev <intf> survey dump".
+// It returns the survey data of the channel in use.
+func parseSurveyDump(surveyDump string) (*surveySample, error) {
+	var current *surveySample
+	inUse := false
+
+	for _, line := range strings.Split(surveyDump, "\n") {
+		if strings.HasPrefix(line, "Survey data from") {
+			if inUse {
+				break
+			}
+			current = &surveySample{}
+			continue
+		}
+		if current == nil {
+			continue
+		}
+
+		field := strings.TrimSpace(line)
+		sep := strings.Index(field, ":")
+		if sep < 0 {
+			continue
+		}
+		name := field[:sep]
+		valueFields := strings.Fields(field[sep+1:])
+		if len(valueFields) == 0 {
+			continue
+		}
+
+		switch name {
+		case "frequency":
+			current.frequency, _ = strconv.Atoi(valueFields[0])
+			inUse = strings.Contains(field, "[in use]")
+		case "noise":
+			if noise, err := strconv.Atoi(valueFields[0]); err == nil {
+				current.noise = int8(noise)
+			}
+		case "channel active time":
+			current.activeTime, _ = strconv.ParseUint(valueFields[0], 10, 64)
+		case "channel busy time":
+			current.busyTime, _ = strconv.ParseUint(valueFields[0], 10, 64)
+		case "channel receive time":
+			current.receiveTime, _ = strconv.ParseUint(valueFields[0], 10, 64)
+		case "channel transmit time":
+			current.sendTime, _ = strconv.ParseUint(valueFields[0], 10, 64)
+		}
+	}
+
+	if !inUse {
+		return nil, errors.New("no survey data for the channel in use")
+	}
+	return current, nil
+}
+
+// calculateUtilization calculates the channel utilization between two survey samples.
+// The last returned value is false if the utilization cannot be calculated,
+// e.g. no previous sample, or the channel changed between samples.
+func calculateUtilization(previous, current *surveySample) (*channelUtilization, bool) {
+	if previous == nil || current == nil || previous.frequency != current.frequency ||
+		current.activeTime <= previous.activeTime || current.busyTime < previous.busyTime ||
+		current.receiveTime < previous.receiveTime || current.sendTime < previous.sendTime {
+		return nil, false
+	}
+
+	active := current.activeTime - previous.activeTime
+	busy := current.busyTime - previous.busyTime
+	rx := current.receiveTime - previous.receiveTime
+	tx := current.sendTime - previous.sendTime
+
+	// Busy time not spent receiving or sending 802.11 frames is counted as noise.
+	var noise uint64
+	if busy > rx+tx {
+		noise = busy - rx - tx
+	}
+
+	return &channelUtilization{
+		total: percentage(busy, active),
+		rx:    percentage(rx, active),
+		tx:    percentage(tx, active),
+		noise: percentage(noise, active),
+	}, true
+}
+
+func percentage(part, total uint64) uint8 {
+	if part >= total {
+		return 100
+	}
+	return uint8(part * 100 / total)
+}
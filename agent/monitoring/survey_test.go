@@ -0,0 +1,97 @@
+/* Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package monitoring
+
+import (
+	"reflect"
+	"testing"
+)
+
+const testSurveyDump = `Survey data from wlan0
+	frequency:			2412 MHz
+Survey data from wlan0
+	frequency:			2437 MHz [in use]
+	noise:				-92 dBm
+	channel active time:		10000 ms
+	channel busy time:		4000 ms
+	channel receive time:		2500 ms
+	channel transmit time:		500 ms
+Survey data from wlan0
+	frequency:			2462 MHz
+	noise:				-90 dBm
+`
+
+func TestParseSurveyDump(t *testing.T) {
+	want := &surveySample{
+		frequency:   2437,
+		noise:       -92,
+		activeTime:  10000,
+		busyTime:    4000,
+		receiveTime: 2500,
+		sendTime:    500,
+	}
+
+	got, err := parseSurveyDump(testSurveyDump)
+	if err != nil {
+		t.Fatalf("Parsing survey dump failed. Error: %v.", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Incorrect survey data (got: %v, want: %v).", got, want)
+	}
+
+	if _, err := parseSurveyDump("Survey data from wlan0\n\tfrequency:\t2412 MHz\n"); err == nil {
+		t.Error("Expected an error when no channel is in use.")
+	}
+}
+
+func TestCalculateUtilization(t *testing.T) {
+	previous := &surveySample{frequency: 2437, activeTime: 10000, busyTime: 4000, receiveTime: 2500, sendTime: 500}
+
+	tests := []struct {
+		name        string
+		current     *surveySample
+		utilization *channelUtilization
+		ok          bool
+	}{{
+		name:        "NormalSample",
+		current:     &surveySample{frequency: 2437, activeTime: 12000, busyTime: 5000, receiveTime: 3100, sendTime: 700},
+		utilization: &channelUtilization{total: 50, rx: 30, tx: 10, noise: 10},
+		ok:          true,
+	}, {
+		name:    "ChannelChanged",
+		current: &surveySample{frequency: 2462, activeTime: 12000, busyTime: 5000, receiveTime: 3100, sendTime: 700},
+		ok:      false,
+	}, {
+		name:    "CounterReset",
+		current: &surveySample{frequency: 2437, activeTime: 100, busyTime: 50, receiveTime: 30, sendTime: 10},
+		ok:      false,
+	}}
+
+	for _, test := range tests {
+		got, ok := calculateUtilization(previous, test.current)
+		if ok != test.ok {
+			t.Errorf("[%s] Incorrect result (got: %v, want: %v).", test.name, ok, test.ok)
+			continue
+		}
+		if ok && !reflect.DeepEqual(got, test.utilization) {
+			t.Errorf("[%s] Incorrect utilization (got: %v, want: %v).", test.name, got, test.utilization)
+		}
+	}
+
+	if _, ok := calculateUtilization(nil, previous); ok {
+		t.Error("Expected no utilization without a previous sample.")
+	}
+}
@@ -0,0 +1,123 @@
+/* Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package portal
+
+import (
+	"fmt"
+	"html/template"
+	"net"
+	"net/http"
+
+	log "github.com/golang/glog"
+)
+
+// authorizePath is where the splash page form is posted.
+const authorizePath = "/authorize"
+
+// splashPage is the page of the captive portal. It asks for a voucher if the portal requires one.
+var splashPage = template.Must(template.New("splash").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.SSID}}</title>
+</head>
+<body>
+<h1>{{.SSID}}</h1>
+{{if .Authorized}}<p>You are connected to the Internet.</p>
+{{else}}{{if .Error}}<p>{{.Error}}</p>
+{{end}}<form method="post" action="` + authorizePath + `">
+{{if .VoucherRequired}}<label>Voucher <input type="text" name="voucher" autocomplete="off"></label>
+{{end}}<button type="submit">Connect</button>
+</form>
+{{end}}</body>
+</html>
+`))
+
+// splashPageData contains the fields of the splash page.
+type splashPageData struct {
+	SSID            string
+	Authorized      bool
+	VoucherRequired bool
+	Error           string
+}
+
+// portalHandler serves the captive portal of an SSID.
+type portalHandler struct {
+	portal *Portal
+	ssid   string
+	addr   string // The host:port of the portal.
+}
+
+// Handler returns the HTTP handler of the captive portal of an SSID, listening on the given host:port.
+func (p *Portal) Handler(ssid, addr string) http.Handler {
+	return &portalHandler{portal: p, ssid: ssid, addr: addr}
+}
+
+func (h *portalHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Host != h.addr || (r.URL.Path != "/" && r.URL.Path != authorizePath) {
+		// Requests to other sites end up here through the redirect rules, send the client to the splash page.
+		http.Redirect(w, r, fmt.Sprintf("http://%s/", h.addr), http.StatusFound)
+		return
+	}
+
+	clientIP, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		http.Error(w, "Unknown client.", http.StatusBadRequest)
+		return
+	}
+	mac, err := h.portal.clientMAC(clientIP)
+	if err != nil {
+		log.Errorf("Failed to find the MAC address of captive portal client %s: %v", clientIP, err)
+		http.Error(w, "Unknown client.", http.StatusForbidden)
+		return
+	}
+
+	data := splashPageData{
+		SSID:            h.ssid,
+		Authorized:      h.portal.Authorized(h.ssid, mac),
+		VoucherRequired: h.portal.voucherRequired(h.ssid),
+	}
+	status := http.StatusOK
+	if r.URL.Path == authorizePath && r.Method == http.MethodPost && !data.Authorized {
+		switch err := h.portal.Authorize(h.ssid, mac, r.PostFormValue("voucher")); err {
+		case nil:
+			data.Authorized = true
+		case errInvalidVoucher:
+			data.Error = "The voucher is not valid."
+			status = http.StatusForbidden
+		default:
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := splashPage.Execute(w, data); err != nil {
+		log.Errorf("Failed to render the captive portal of SSID %s: %v", h.ssid, err)
+	}
+}
+
+// voucherRequired checks whether the captive portal of an SSID requires a voucher.
+func (p *Portal) voucherRequired(ssid string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	portal, ok := p.portals[ssid]
+	return ok && len(portal.Vouchers) != 0
+}
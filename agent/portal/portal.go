@@ -0,0 +1,444 @@
+/* Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package portal runs the captive portals of guest SSIDs.
+//
+// A captive portal listens on the gateway address of the local NAT subnet of its SSID.
+// Until a client is authorized on the portal, by clicking through the splash page or
+// entering a voucher, nftables rules redirect its DNS and HTTP traffic to the AP and
+// drop the rest of its forwarded traffic. Authorized clients pass until their session expires.
+package portal
+
+import (
+	ctx "context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/golang/glog"
+	"github.com/google/link022/agent/context"
+	"github.com/google/link022/agent/gnmi"
+	"github.com/google/link022/agent/monitoring"
+	"github.com/google/link022/agent/syscmd"
+	"github.com/google/link022/agent/util/ocutil"
+	"github.com/google/link022/generated/ocstruct"
+	"github.com/openconfig/ygot/ygot"
+)
+
+const (
+	// refreshInterval is how often the portal settings, sessions and client states are refreshed.
+	refreshInterval = 5 * time.Second
+
+	tableFamily   = "ip"
+	tableName     = "link022_portal"
+	rulesFileName = "captive_portal.nft"
+)
+
+var (
+	runFolder = "/var/run/link022"
+
+	// errNoPortal and errInvalidVoucher are returned when a client cannot be authorized.
+	errNoPortal       = errors.New("no captive portal on the SSID")
+	errInvalidVoucher = errors.New("invalid voucher")
+)
+
+// Portal serves the captive portals of SSIDs, and keeps the redirect rules in sync with the authorized clients.
+type Portal struct {
+	cmdRunner *syscmd.CommandRunner
+	now       func() time.Time
+	// clientMAC finds the MAC address of a client by its IPv4 address.
+	clientMAC func(ipAddress string) (string, error)
+
+	mu       sync.Mutex
+	portals  map[string]*ocutil.CaptivePortal // SSID -> portal settings
+	nats     map[string]*ocutil.NAT           // SSID -> local subnet
+	sessions map[string]map[string]time.Time  // SSID -> client MAC -> session expiration time
+	servers  map[string]*server               // SSID -> portal server
+
+	applied      bool
+	appliedRules string
+}
+
+// server is the HTTP server of the captive portal of an SSID.
+type server struct {
+	addr     string
+	listener net.Listener
+	http     *http.Server
+}
+
+// NewPortal creates a Portal updating nftables and looking up clients with the given runner.
+func NewPortal(cmdRunner *syscmd.CommandRunner) *Portal {
+	return &Portal{
+		cmdRunner: cmdRunner,
+		now:       time.Now,
+		clientMAC: cmdRunner.NeighborMAC,
+		portals:   make(map[string]*ocutil.CaptivePortal),
+		nats:      make(map[string]*ocutil.NAT),
+		sessions:  make(map[string]map[string]time.Time),
+		servers:   make(map[string]*server),
+	}
+}
+
+// Run serves the captive portals until the context is done.
+// The portal settings are loaded from the GNMI server periodically.
+func (p *Portal) Run(bkgdContext ctx.Context, gnmiServer *gnmi.Server) {
+	hostName := context.GetDeviceConfig().Hostname
+	refresh := time.NewTicker(refreshInterval)
+	defer refresh.Stop()
+	defer p.stop()
+	for {
+		select {
+		case <-bkgdContext.Done():
+			return
+		case <-refresh.C:
+			p.UpdateSettings(portalSettings(gnmiServer))
+			p.expire()
+			if err := p.applyRules(); err != nil {
+				log.Errorf("Error in updating captive portal rules: %v", err)
+			}
+			p.publishState(gnmiServer, hostName, p.portalStations(monitoring.BSSs()))
+		}
+	}
+}
+
+// UpdateSettings replaces the captive portals (SSID -> portal) and the local subnets of SSIDs (SSID -> NAT).
+// Portal servers are started and stopped accordingly. Sessions on SSIDs without a portal any more are dropped.
+func (p *Portal) UpdateSettings(portals map[string]*ocutil.CaptivePortal, nats map[string]*ocutil.NAT) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.portals = portals
+	p.nats = nats
+	for ssid := range p.sessions {
+		if _, ok := portals[ssid]; !ok {
+			delete(p.sessions, ssid)
+		}
+	}
+
+	for ssid, s := range p.servers {
+		if addr, ok := p.portalAddr(ssid); !ok || addr != s.addr {
+			s.http.Close()
+			delete(p.servers, ssid)
+			log.Infof("Stopped the captive portal of SSID %s on %s.", ssid, s.addr)
+		}
+	}
+	for ssid := range portals {
+		if _, ok := p.servers[ssid]; ok {
+			continue
+		}
+		addr, ok := p.portalAddr(ssid)
+		if !ok {
+			continue
+		}
+		// The listener fails until the local subnet is configured, it is retried on the next update.
+		listener, err := net.Listen("tcp", addr)
+		if err != nil {
+			log.Errorf("Failed to start the captive portal of SSID %s on %s: %v", ssid, addr, err)
+			continue
+		}
+		s := &server{
+			addr:     addr,
+			listener: listener,
+			http:     &http.Server{Handler: p.Handler(ssid, addr)},
+		}
+		go s.http.Serve(listener)
+		p.servers[ssid] = s
+		log.Infof("Started the captive portal of SSID %s on %s.", ssid, addr)
+	}
+}
+
+// stop stops all portal servers and removes the redirect rules.
+func (p *Portal) stop() {
+	p.UpdateSettings(nil, nil)
+	if err := p.applyRules(); err != nil {
+		log.Errorf("Error in removing captive portal rules: %v", err)
+	}
+}
+
+// portalAddr returns the address the portal of an SSID listens on, the gateway address of its local subnet.
+func (p *Portal) portalAddr(ssid string) (string, bool) {
+	portal, ok := p.portals[ssid]
+	if !ok {
+		return "", false
+	}
+	nat, ok := p.nats[ssid]
+	if !ok {
+		return "", false
+	}
+	return net.JoinHostPort(nat.Gateway.String(), fmt.Sprint(portal.Port)), true
+}
+
+// Authorize lets a client of an SSID through the captive portal until its session expires.
+// The voucher is only checked if the portal requires one.
+func (p *Portal) Authorize(ssid, mac, voucher string) error {
+	if err := p.authorize(ssid, mac, voucher); err != nil {
+		return err
+	}
+	// Let the client through right away instead of waiting for the next refresh.
+	if err := p.applyRules(); err != nil {
+		log.Errorf("Error in updating captive portal rules: %v", err)
+	}
+	return nil
+}
+
+func (p *Portal) authorize(ssid, mac, voucher string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	portal, ok := p.portals[ssid]
+	if !ok {
+		return errNoPortal
+	}
+	if len(portal.Vouchers) != 0 {
+		i := sort.SearchStrings(portal.Vouchers, voucher)
+		if i == len(portal.Vouchers) || portal.Vouchers[i] != voucher {
+			return errInvalidVoucher
+		}
+	}
+	if p.sessions[ssid] == nil {
+		p.sessions[ssid] = make(map[string]time.Time)
+	}
+	p.sessions[ssid][mac] = p.now().Add(portal.SessionTimeout)
+	log.Infof("Client %s authorized on the captive portal of SSID %s for %v.", mac, ssid, portal.SessionTimeout)
+	return nil
+}
+
+// Authorized checks whether a client is authorized on the captive portal of an SSID.
+func (p *Portal) Authorized(ssid, mac string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	expiration, ok := p.sessions[ssid][mac]
+	return ok && p.now().Before(expiration)
+}
+
+// expire removes the sessions that are over.
+func (p *Portal) expire() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for ssid, clients := range p.sessions {
+		for mac, expiration := range clients {
+			if !p.now().Before(expiration) {
+				delete(clients, mac)
+				log.Infof("Session of client %s expired on the captive portal of SSID %s.", mac, ssid)
+			}
+		}
+		if len(clients) == 0 {
+			delete(p.sessions, ssid)
+		}
+	}
+}
+
+// applyRules installs the redirect rules of the portals. nftables is only updated when the rules change.
+func (p *Portal) applyRules() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	authorized := make(map[string][]string)
+	for ssid, clients := range p.sessions {
+		for mac := range clients {
+			authorized[ssid] = append(authorized[ssid], mac)
+		}
+	}
+	rules := Ruleset(p.portals, p.nats, authorized)
+	if p.applied && rules == p.appliedRules {
+		return nil
+	}
+
+	if len(rules) == 0 {
+		// The table may not exist, e.g. no portal was installed before the agent started.
+		if err := p.cmdRunner.DeleteNftTable(tableFamily, tableName); err != nil && p.applied {
+			return err
+		}
+	} else {
+		if err := syscmd.SaveToFile(runFolder, rulesFileName, rules); err != nil {
+			return err
+		}
+		if err := p.cmdRunner.ApplyNftRules(path.Join(runFolder, rulesFileName)); err != nil {
+			return err
+		}
+	}
+	p.applied = true
+	p.appliedRules = rules
+	return nil
+}
+
+// Ruleset generates the nftables rules of the given portals (SSID -> portal) on the local subnets of SSIDs
+// (SSID -> NAT). authorized contains the MAC of the clients authorized on each SSID. The rules are generated
+// in a stable order. It returns an empty string if no SSID has a portal.
+func Ruleset(portals map[string]*ocutil.CaptivePortal, nats map[string]*ocutil.NAT, authorized map[string][]string) string {
+	var ssids []string
+	for ssid := range portals {
+		if _, ok := nats[ssid]; ok {
+			ssids = append(ssids, ssid)
+		}
+	}
+	if len(ssids) == 0 {
+		return ""
+	}
+	sort.Slice(ssids, func(i, j int) bool {
+		return nats[ssids[i]].Index < nats[ssids[j]].Index
+	})
+
+	sets := ""
+	redirectRules := ""
+	dropRules := ""
+	for _, ssid := range ssids {
+		nat := nats[ssid]
+		setName := fmt.Sprintf("authorized_%d", nat.Index)
+		sets += fmt.Sprintf("\tset %s {\n\t\ttype ether_addr\n", setName)
+		if len(authorized[ssid]) != 0 {
+			clients := make([]string, len(authorized[ssid]))
+			copy(clients, authorized[ssid])
+			sort.Strings(clients)
+			sets += fmt.Sprintf("\t\telements = { %s }\n", strings.Join(clients, ", "))
+		}
+		sets += "\t}\n\n"
+
+		unauthorized := fmt.Sprintf("iifname %q ether saddr != @%s", natBridgeName(nat.Index), setName)
+		redirectRules += fmt.Sprintf("\n\t\t# SSID %s on %s\n", ssid, natBridgeName(nat.Index))
+		redirectRules += fmt.Sprintf("\t\t%s udp dport 53 dnat to %s\n", unauthorized, nat.Gateway)
+		redirectRules += fmt.Sprintf("\t\t%s tcp dport 53 dnat to %s\n", unauthorized, nat.Gateway)
+		redirectRules += fmt.Sprintf("\t\t%s tcp dport 80 dnat to %s:%d\n", unauthorized, nat.Gateway, portals[ssid].Port)
+		dropRules += fmt.Sprintf("\t\t%s drop\n", unauthorized)
+	}
+
+	rules := fmt.Sprintf("table %s %s\ndelete table %s %s\n\n", tableFamily, tableName, tableFamily, tableName)
+	rules += fmt.Sprintf("table %s %s {\n", tableFamily, tableName)
+	rules += sets
+	rules += "\tchain prerouting {\n\t\ttype nat hook prerouting priority -100; policy accept;\n"
+	rules += redirectRules
+	rules += "\t}\n\n"
+	rules += "\tchain forward {\n\t\ttype filter hook forward priority 0; policy accept;\n"
+	rules += dropRules
+	rules += "\t}\n}\n"
+	return rules
+}
+
+// natBridgeName returns the local bridge of a NAT SSID, created by package service.
+func natBridgeName(index int) string {
+	return fmt.Sprintf("br_nat%d", index)
+}
+
+// portalStations returns the stations associated with the BSSs of SSIDs with a portal (SSID -> MACs).
+func (p *Portal) portalStations(bssList []*monitoring.BSS) map[string][]string {
+	p.mu.Lock()
+	portalSSIDs := make(map[string]bool)
+	for ssid := range p.portals {
+		portalSSIDs[ssid] = true
+	}
+	p.mu.Unlock()
+
+	stations := make(map[string][]string)
+	for _, bss := range bssList {
+		if !portalSSIDs[bss.SSID] {
+			continue
+		}
+		macs, err := monitoring.Stations(bss.IntfName)
+		if err != nil {
+			log.Errorf("Failed to fetch the stations of %s: %v", bss.IntfName, err)
+			continue
+		}
+		stations[bss.SSID] = append(stations[bss.SSID], macs...)
+	}
+	return stations
+}
+
+// publishState updates the client connection state of the stations of SSIDs with a portal.
+// Authorized clients are AUTHENTICATED, the others still require layer 3 authentication.
+// The SSIDs whose portal was removed no longer have client states.
+func (p *Portal) publishState(gnmiServer *gnmi.Server, hostName string, stations map[string][]string) {
+	p.mu.Lock()
+	var ssids []string
+	for ssid := range p.portals {
+		ssids = append(ssids, ssid)
+	}
+	p.mu.Unlock()
+	states := make(map[string]*ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_Ssids_Ssid_Clients)
+	for _, ssid := range ssids {
+		states[ssid] = p.clientStates(ssid, stations[ssid])
+	}
+
+	err := gnmiServer.InternalUpdate(func(config ygot.ValidatedGoStruct) error {
+		device, ok := config.(*ocstruct.Device)
+		if !ok {
+			return errors.New("configuration has invalid type")
+		}
+		setClientStates(ocutil.FindAPConfig(device, hostName), states)
+		return nil
+	})
+	if err != nil {
+		log.Errorf("Error in updating captive portal client states: %v", err)
+	}
+}
+
+// setClientStates replaces the client lists of the SSIDs of the given AP with the given ones (SSID -> clients).
+// Only the captive portals publish the clients of SSIDs, the other SSIDs have no client list.
+func setClientStates(apConfig *ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint, states map[string]*ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_Ssids_Ssid_Clients) {
+	if apConfig == nil || apConfig.Ssids == nil {
+		return
+	}
+	for ssidName, ssid := range apConfig.Ssids.Ssid {
+		ssid.Clients = states[ssidName]
+	}
+}
+
+// clientStates generates the client list of an SSID with the connection state of the given stations.
+func (p *Portal) clientStates(ssid string, macs []string) *ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_Ssids_Ssid_Clients {
+	clients := &ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_Ssids_Ssid_Clients{}
+	for _, mac := range macs {
+		client, err := clients.NewClient(mac)
+		if err != nil {
+			// The station is associated with multiple BSSs of the SSID.
+			continue
+		}
+		clientState := ocstruct.OpenconfigWifiTypes_CLIENT_STATE_L3AUTH_REQD
+		if p.Authorized(ssid, mac) {
+			clientState = ocstruct.OpenconfigWifiTypes_CLIENT_STATE_AUTHENTICATED
+		}
+		client.State = &ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_Ssids_Ssid_Clients_Client_State{
+			Mac: ygot.String(mac),
+		}
+		client.ClientConnection = &ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_Ssids_Ssid_Clients_Client_ClientConnection{
+			State: &ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_Ssids_Ssid_Clients_Client_ClientConnection_State{
+				ClientState: clientState,
+			},
+		}
+	}
+	return clients
+}
+
+// portalSettings returns the captive portals and the local subnets of SSIDs configured in gasket.
+func portalSettings(gnmiServer *gnmi.Server) (map[string]*ocutil.CaptivePortal, map[string]*ocutil.NAT) {
+	portals := make(map[string]*ocutil.CaptivePortal)
+	nats := make(map[string]*ocutil.NAT)
+	gnmiServer.InternalUpdate(func(config ygot.ValidatedGoStruct) error {
+		device, ok := config.(*ocstruct.Device)
+		if !ok {
+			return errors.New("configuration has invalid type")
+		}
+		portals = ocutil.CaptivePortalSSIDs(device.Gasket)
+		nats = ocutil.NATSSIDs(device.Gasket)
+		return nil
+	})
+	return portals, nats
+}
@@ -0,0 +1,409 @@
+/* Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package portal
+
+import (
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/link022/agent/syscmd"
+	"github.com/google/link022/agent/util/mock"
+	"github.com/google/link022/agent/util/ocutil"
+	"github.com/google/link022/generated/ocstruct"
+)
+
+const (
+	testGuestSSID  = "Guest-Emu"
+	testCafeSSID   = "Cafe-Emu"
+	testClientMAC  = "12:34:56:78:9a:bc"
+	testOtherMAC   = "02:00:00:00:00:01"
+	testPortalAddr = "192.168.100.1:8081"
+)
+
+var testNATs = map[string]*ocutil.NAT{
+	testCafeSSID: {
+		Index:   0,
+		Gateway: net.ParseIP("192.168.101.1"),
+		Subnet:  &net.IPNet{IP: net.ParseIP("192.168.101.0"), Mask: net.CIDRMask(24, 32)},
+	},
+	testGuestSSID: {
+		Index:   1,
+		Gateway: net.ParseIP("192.168.100.1"),
+		Subnet:  &net.IPNet{IP: net.ParseIP("192.168.100.0"), Mask: net.CIDRMask(24, 32)},
+	},
+}
+
+// testPortal creates a portal with the given settings, recording the commands it runs.
+// Listeners are not started, as the local subnets do not exist. The returned function restores the run folder.
+func testPortal(t *testing.T, portals map[string]*ocutil.CaptivePortal) (*Portal, *[]string, func()) {
+	tempRunFolder, err := ioutil.TempDir("", "link022")
+	if err != nil {
+		t.Fatalf("Unable to create a temp run time folder.")
+	}
+	originalRunFolder := runFolder
+	runFolder = tempRunFolder
+
+	var cmds []string
+	p := NewPortal(&syscmd.CommandRunner{
+		ExecCommand: func(wait bool, cmd string, args ...string) (string, error) {
+			cmds = append(cmds, cmd+" "+strings.Join(args, " "))
+			return "", nil
+		},
+	})
+	p.portals = portals
+	p.nats = testNATs
+	return p, &cmds, func() {
+		runFolder = originalRunFolder
+		os.RemoveAll(tempRunFolder)
+	}
+}
+
+func TestRuleset(t *testing.T) {
+	portals := map[string]*ocutil.CaptivePortal{
+		testGuestSSID: {Port: 8081, SessionTimeout: time.Hour},
+		testCafeSSID:  {Port: 8082, SessionTimeout: time.Hour},
+		"Other-SSID":  {Port: 8081, SessionTimeout: time.Hour},
+	}
+	want := `table ip link022_portal
+delete table ip link022_portal
+
+table ip link022_portal {
+	set authorized_0 {
+		type ether_addr
+	}
+
+	set authorized_1 {
+		type ether_addr
+		elements = { 02:00:00:00:00:01, 12:34:56:78:9a:bc }
+	}
+
+	chain prerouting {
+		type nat hook prerouting priority -100; policy accept;
+
+		# SSID Cafe-Emu on br_nat0
+		iifname "br_nat0" ether saddr != @authorized_0 udp dport 53 dnat to 192.168.101.1
+		iifname "br_nat0" ether saddr != @authorized_0 tcp dport 53 dnat to 192.168.101.1
+		iifname "br_nat0" ether saddr != @authorized_0 tcp dport 80 dnat to 192.168.101.1:8082
+
+		# SSID Guest-Emu on br_nat1
+		iifname "br_nat1" ether saddr != @authorized_1 udp dport 53 dnat to 192.168.100.1
+		iifname "br_nat1" ether saddr != @authorized_1 tcp dport 53 dnat to 192.168.100.1
+		iifname "br_nat1" ether saddr != @authorized_1 tcp dport 80 dnat to 192.168.100.1:8081
+	}
+
+	chain forward {
+		type filter hook forward priority 0; policy accept;
+		iifname "br_nat0" ether saddr != @authorized_0 drop
+		iifname "br_nat1" ether saddr != @authorized_1 drop
+	}
+}
+`
+	got := Ruleset(portals, testNATs, map[string][]string{testGuestSSID: {testClientMAC, testOtherMAC}})
+	if got != want {
+		t.Errorf("Incorrect ruleset (got:\n%s\nwant:\n%s).", got, want)
+	}
+
+	if got := Ruleset(map[string]*ocutil.CaptivePortal{"Other-SSID": {Port: 8081}}, testNATs, nil); got != "" {
+		t.Errorf("Unexpected ruleset without portals on NAT SSIDs:\n%s", got)
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	p, _, restore := testPortal(t, map[string]*ocutil.CaptivePortal{
+		testGuestSSID: {Port: 8081, SessionTimeout: time.Hour, Vouchers: []string{"alpha", "bravo"}},
+		testCafeSSID:  {Port: 8082, SessionTimeout: 2 * time.Hour},
+	})
+	defer restore()
+	now := time.Unix(1538000000, 0)
+	p.now = func() time.Time { return now }
+
+	tests := []struct {
+		name    string
+		ssid    string
+		voucher string
+		wantErr error
+	}{
+		{name: "InvalidVoucher", ssid: testGuestSSID, voucher: "charlie", wantErr: errInvalidVoucher},
+		{name: "EmptyVoucher", ssid: testGuestSSID, wantErr: errInvalidVoucher},
+		{name: "NoPortal", ssid: "Other-SSID", wantErr: errNoPortal},
+		{name: "Voucher", ssid: testGuestSSID, voucher: "bravo"},
+		{name: "ClickThrough", ssid: testCafeSSID, voucher: "ignored"},
+	}
+	for _, test := range tests {
+		if err := p.Authorize(test.ssid, testClientMAC, test.voucher); err != test.wantErr {
+			t.Errorf("[%s] Incorrect error (got: %v, want: %v).", test.name, err, test.wantErr)
+		}
+	}
+	if p.Authorized("Other-SSID", testClientMAC) || p.Authorized(testGuestSSID, testOtherMAC) {
+		t.Error("Unauthorized client is authorized.")
+	}
+
+	// The session on Guest-Emu expires before the one on Cafe-Emu.
+	now = now.Add(time.Hour)
+	p.expire()
+	if p.Authorized(testGuestSSID, testClientMAC) {
+		t.Error("Client still authorized after the session expired.")
+	}
+	if !p.Authorized(testCafeSSID, testClientMAC) {
+		t.Error("Client not authorized before the session expires.")
+	}
+	if _, ok := p.sessions[testGuestSSID]; ok {
+		t.Error("Expired sessions not removed.")
+	}
+}
+
+func TestApplyRules(t *testing.T) {
+	p, cmds, restore := testPortal(t, map[string]*ocutil.CaptivePortal{
+		testGuestSSID: {Port: 8081, SessionTimeout: time.Hour},
+	})
+	defer restore()
+	applyCmd := "nft -f " + runFolder + "/" + rulesFileName
+
+	steps := []struct {
+		name   string
+		update func()
+		cmds   []string
+	}{{
+		name: "Install",
+		cmds: []string{applyCmd},
+	}, {
+		name: "Unchanged",
+	}, {
+		name: "Authorized",
+		update: func() {
+			if err := p.authorize(testGuestSSID, testClientMAC, ""); err != nil {
+				t.Errorf("Authorizing the client failed. Error: %v.", err)
+			}
+		},
+		cmds: []string{applyCmd},
+	}, {
+		name:   "Remove",
+		update: func() { p.UpdateSettings(nil, nil) },
+		cmds:   []string{"nft delete table ip link022_portal"},
+	}, {
+		name: "StillRemoved",
+	}}
+
+	for _, step := range steps {
+		*cmds = nil
+		if step.update != nil {
+			step.update()
+		}
+		if err := p.applyRules(); err != nil {
+			t.Errorf("[%s] Applying rules failed. Error: %v.", step.name, err)
+		}
+		if !reflect.DeepEqual(*cmds, step.cmds) {
+			t.Errorf("[%s] Incorrect commands (got: %v, want: %v).", step.name, *cmds, step.cmds)
+		}
+	}
+}
+
+func TestHandler(t *testing.T) {
+	p, _, restore := testPortal(t, map[string]*ocutil.CaptivePortal{
+		testGuestSSID: {Port: 8081, SessionTimeout: time.Hour, Vouchers: []string{"alpha"}},
+	})
+	defer restore()
+	p.clientMAC = func(ipAddress string) (string, error) {
+		if ipAddress == "192.168.100.10" {
+			return testClientMAC, nil
+		}
+		return "", errors.New("unknown neighbor")
+	}
+	handler := p.Handler(testGuestSSID, testPortalAddr)
+
+	tests := []struct {
+		name       string
+		method     string
+		target     string
+		remoteAddr string
+		voucher    string
+		wantStatus int
+		wantBody   string
+	}{{
+		name:       "RedirectOtherSite",
+		method:     http.MethodGet,
+		target:     "http://example.com/index.html",
+		wantStatus: http.StatusFound,
+	}, {
+		name:       "RedirectOtherPath",
+		method:     http.MethodGet,
+		target:     "http://" + testPortalAddr + "/index.html",
+		wantStatus: http.StatusFound,
+	}, {
+		name:       "UnknownClient",
+		method:     http.MethodGet,
+		target:     "http://" + testPortalAddr + "/",
+		remoteAddr: "192.168.100.11:40000",
+		wantStatus: http.StatusForbidden,
+	}, {
+		name:       "SplashPage",
+		method:     http.MethodGet,
+		target:     "http://" + testPortalAddr + "/",
+		wantStatus: http.StatusOK,
+		wantBody:   `name="voucher"`,
+	}, {
+		name:       "InvalidVoucher",
+		method:     http.MethodPost,
+		target:     "http://" + testPortalAddr + authorizePath,
+		voucher:    "bravo",
+		wantStatus: http.StatusForbidden,
+		wantBody:   "The voucher is not valid.",
+	}, {
+		name:       "Authorize",
+		method:     http.MethodPost,
+		target:     "http://" + testPortalAddr + authorizePath,
+		voucher:    "alpha",
+		wantStatus: http.StatusOK,
+		wantBody:   "You are connected to the Internet.",
+	}, {
+		name:       "AlreadyAuthorized",
+		method:     http.MethodGet,
+		target:     "http://" + testPortalAddr + "/",
+		wantStatus: http.StatusOK,
+		wantBody:   "You are connected to the Internet.",
+	}}
+
+	for _, test := range tests {
+		req := httptest.NewRequest(test.method, test.target, strings.NewReader(url.Values{"voucher": {test.voucher}}.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.RemoteAddr = "192.168.100.10:40000"
+		if test.remoteAddr != "" {
+			req.RemoteAddr = test.remoteAddr
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != test.wantStatus {
+			t.Errorf("[%s] Incorrect status (got: %d, want: %d).", test.name, rec.Code, test.wantStatus)
+		}
+		if test.wantStatus == http.StatusFound && rec.Header().Get("Location") != "http://"+testPortalAddr+"/" {
+			t.Errorf("[%s] Incorrect redirect location %q.", test.name, rec.Header().Get("Location"))
+		}
+		if !strings.Contains(rec.Body.String(), test.wantBody) {
+			t.Errorf("[%s] Missing %q in the page:\n%s", test.name, test.wantBody, rec.Body.String())
+		}
+	}
+	if !p.Authorized(testGuestSSID, testClientMAC) {
+		t.Error("Client not authorized through the portal.")
+	}
+}
+
+func TestUpdateSettings(t *testing.T) {
+	p, _, restore := testPortal(t, nil)
+	defer restore()
+	p.clientMAC = func(ipAddress string) (string, error) {
+		return testClientMAC, nil
+	}
+
+	// Serve the portal on the loopback interface, on a free port.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unable to find a free port. Error: %v.", err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+	portalAddr := net.JoinHostPort("127.0.0.1", strconv.Itoa(port))
+	localNATs := map[string]*ocutil.NAT{testGuestSSID: {Gateway: net.ParseIP("127.0.0.1")}}
+	p.UpdateSettings(map[string]*ocutil.CaptivePortal{testGuestSSID: {Port: uint16(port), SessionTimeout: time.Hour}}, localNATs)
+	defer p.UpdateSettings(nil, nil)
+
+	if _, ok := p.servers[testGuestSSID]; !ok {
+		t.Fatal("Portal server not started.")
+	}
+	resp, err := http.PostForm("http://"+portalAddr+authorizePath, nil)
+	if err != nil {
+		t.Fatalf("Request to the portal failed. Error: %v.", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !p.Authorized(testGuestSSID, testClientMAC) {
+		t.Errorf("Client not authorized through the portal server (status: %d).", resp.StatusCode)
+	}
+
+	p.UpdateSettings(nil, localNATs)
+	if len(p.servers) != 0 {
+		t.Errorf("Portal servers not stopped: %v.", p.servers)
+	}
+	if _, err := http.Get("http://" + portalAddr + "/"); err == nil {
+		t.Error("Portal still serving after being removed.")
+	}
+}
+
+func TestClientStates(t *testing.T) {
+	p, _, restore := testPortal(t, map[string]*ocutil.CaptivePortal{
+		testGuestSSID: {Port: 8081, SessionTimeout: time.Hour},
+	})
+	defer restore()
+	if err := p.authorize(testGuestSSID, testClientMAC, ""); err != nil {
+		t.Fatalf("Authorizing the client failed. Error: %v.", err)
+	}
+
+	clients := p.clientStates(testGuestSSID, []string{testClientMAC, testOtherMAC, testClientMAC})
+	want := map[string]ocstruct.E_OpenconfigWifiTypes_CLIENT_STATE{
+		testClientMAC: ocstruct.OpenconfigWifiTypes_CLIENT_STATE_AUTHENTICATED,
+		testOtherMAC:  ocstruct.OpenconfigWifiTypes_CLIENT_STATE_L3AUTH_REQD,
+	}
+	if len(clients.Client) != len(want) {
+		t.Fatalf("Incorrect number of clients (got: %d, want: %d).", len(clients.Client), len(want))
+	}
+	for mac, state := range want {
+		client, ok := clients.Client[mac]
+		if !ok {
+			t.Errorf("Client %s missing.", mac)
+			continue
+		}
+		if got := client.ClientConnection.State.ClientState; got != state {
+			t.Errorf("Incorrect state of client %s (got: %v, want: %v).", mac, got, state)
+		}
+		if *client.State.Mac != mac {
+			t.Errorf("Incorrect MAC of client %s: %s.", mac, *client.State.Mac)
+		}
+	}
+}
+
+func TestSetClientStates(t *testing.T) {
+	p, _, restore := testPortal(t, map[string]*ocutil.CaptivePortal{
+		mock.GuestWLANName: {Port: 8081, SessionTimeout: time.Hour},
+	})
+	defer restore()
+	apConfig := mock.GenerateAPConfig(true)
+
+	guestClients := p.clientStates(mock.GuestWLANName, []string{testClientMAC})
+	setClientStates(apConfig, map[string]*ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_Ssids_Ssid_Clients{
+		mock.GuestWLANName: guestClients,
+	})
+	if clients := apConfig.Ssids.Ssid[mock.GuestWLANName].Clients; clients != guestClients {
+		t.Errorf("Incorrect clients of the portal SSID %+v.", clients)
+	}
+	if clients := apConfig.Ssids.Ssid[mock.AuthWLANName].Clients; clients != nil {
+		t.Errorf("Expected no clients on the SSID without portal, got %+v.", clients)
+	}
+
+	// The clients are removed once the portal of the SSID is removed.
+	setClientStates(apConfig, nil)
+	if clients := apConfig.Ssids.Ssid[mock.GuestWLANName].Clients; clients != nil {
+		t.Errorf("Expected no clients once the portal is removed, got %+v.", clients)
+	}
+}
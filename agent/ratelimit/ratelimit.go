@@ -0,0 +1,412 @@
+/* Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package ratelimit limits the bandwidth of SSIDs and their clients with tc/HTB.
+//
+// The limits of each SSID are configured in the gasket model. Downstream traffic is shaped
+// on the BSS interfaces, and on the "<BSS intf>.<VLAN ID>" interfaces hostapd creates for clients
+// assigned to a VLAN by RADIUS. Upstream traffic is shaped on the VLAN interfaces linking the
+// VLAN bridges of the SSID to the uplink, clients are told apart by their MAC address.
+// The SSID limits apply on each of these interfaces separately.
+package ratelimit
+
+import (
+	ctx "context"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/golang/glog"
+	"github.com/google/link022/agent/context"
+	"github.com/google/link022/agent/gnmi"
+	"github.com/google/link022/agent/hostapd"
+	"github.com/google/link022/agent/monitoring"
+	"github.com/google/link022/agent/syscmd"
+	"github.com/google/link022/agent/util/ocutil"
+	"github.com/google/link022/generated/ocstruct"
+	"github.com/openconfig/ygot/ygot"
+)
+
+const (
+	// refreshInterval is how often the rate limits, BSSs and stations are refreshed.
+	refreshInterval = 15 * time.Second
+	// unlimitedRate is the rate of HTB classes without a limit.
+	unlimitedRate = "10gbit"
+	// defaultClassID is the minor ID of the class of unclassified traffic.
+	defaultClassID = 0xffff
+)
+
+var runFolder = "/var/run/link022"
+
+// Limiter keeps the traffic control configuration in sync with the rate limits and the clients.
+type Limiter struct {
+	cmdRunner   *syscmd.CommandRunner
+	ethINTFName string
+
+	limits    map[string]*ocutil.RateLimits // SSID -> rate limits
+	vlans     map[string][]int              // SSID -> VLAN IDs, default VLAN first
+	bsss      map[string]*monitoring.BSS    // BSS interface name -> BSS
+	vlanIntfs map[string]bool               // dynamic VLAN interface name -> true
+	stations  map[string]map[string]bool    // BSS interface name -> station MAC -> true
+	applied   map[string]string             // interface name -> applied traffic control commands
+}
+
+// NewLimiter creates a Limiter running commands with the given runner.
+// Upstream traffic is shaped on the VLAN interfaces of the given uplink interface.
+func NewLimiter(cmdRunner *syscmd.CommandRunner, ethINTFName string) *Limiter {
+	return &Limiter{
+		cmdRunner:   cmdRunner,
+		ethINTFName: ethINTFName,
+		limits:      make(map[string]*ocutil.RateLimits),
+		vlans:       make(map[string][]int),
+		bsss:        make(map[string]*monitoring.BSS),
+		vlanIntfs:   make(map[string]bool),
+		stations:    make(map[string]map[string]bool),
+		applied:     make(map[string]string),
+	}
+}
+
+// Run enforces the rate limits configured on the given GNMI server until the context is done.
+// The traffic control configuration is updated when clients join or leave.
+func (l *Limiter) Run(bkgdContext ctx.Context, gnmiServer *gnmi.Server) {
+	hostName := context.GetDeviceConfig().Hostname
+	events := make(chan *hostapd.Event)
+	monitors := hostapd.NewMonitors(bkgdContext, hostapd.CtrlInterfaceDir, false, events)
+	defer monitors.Stop()
+
+	refresh := time.NewTicker(refreshInterval)
+	defer refresh.Stop()
+	for {
+		select {
+		case <-bkgdContext.Done():
+			return
+		case event := <-events:
+			if l.HandleEvent(event) {
+				l.reconcile()
+			}
+		case <-refresh.C:
+			limits, vlans := ssidSettings(gnmiServer, hostName)
+			bssList := monitoring.BSSs()
+			l.UpdateSettings(limits, vlans, bssList, l.fetchVLANIntfs(limits, vlans, bssList), l.fetchStations(limits, bssList))
+			l.forgetLost()
+			l.reconcile()
+			monitors.Update(l.limitedBSSs())
+		}
+	}
+}
+
+// UpdateSettings replaces the rate limits (SSID -> limits), the VLANs of SSIDs (SSID -> VLAN IDs, default VLAN first),
+// the BSSs on the AP, their dynamic VLAN interfaces and their stations (BSS interface name -> station MACs).
+func (l *Limiter) UpdateSettings(limits map[string]*ocutil.RateLimits, vlans map[string][]int, bssList []*monitoring.BSS, vlanINTFNames []string, stations map[string][]string) {
+	l.limits = limits
+	l.vlans = vlans
+	l.bsss = make(map[string]*monitoring.BSS)
+	for _, bss := range bssList {
+		l.bsss[bss.IntfName] = bss
+	}
+	l.vlanIntfs = make(map[string]bool)
+	for _, intfName := range vlanINTFNames {
+		l.vlanIntfs[intfName] = true
+	}
+	l.stations = make(map[string]map[string]bool)
+	for intfName, macs := range stations {
+		l.stations[intfName] = make(map[string]bool)
+		for _, mac := range macs {
+			l.stations[intfName][mac] = true
+		}
+	}
+}
+
+// HandleEvent processes a hostapd event received from a BSS interface.
+// It returns true if the stations of a rate limited BSS changed.
+func (l *Limiter) HandleEvent(event *hostapd.Event) bool {
+	bss, ok := l.bsss[event.IntfName]
+	if !ok || len(event.Args) == 0 {
+		return false
+	}
+	if _, ok := l.limits[bss.SSID]; !ok {
+		return false
+	}
+	mac := event.Args[0]
+
+	switch event.Name {
+	case "AP-STA-CONNECTED":
+		if l.stations[bss.IntfName] == nil {
+			l.stations[bss.IntfName] = make(map[string]bool)
+		}
+		if l.stations[bss.IntfName][mac] {
+			return false
+		}
+		l.stations[bss.IntfName][mac] = true
+		return true
+	case "AP-STA-DISCONNECTED":
+		if !l.stations[bss.IntfName][mac] {
+			return false
+		}
+		delete(l.stations[bss.IntfName], mac)
+		return true
+	}
+	return false
+}
+
+// reconcile applies the traffic control commands of each interface whose configuration changed.
+func (l *Limiter) reconcile() {
+	commands := l.commands()
+	for intfName, intfCommands := range commands {
+		if l.applied[intfName] == intfCommands {
+			continue
+		}
+		batchFileName := fmt.Sprintf("tc_%s.batch", intfName)
+		if err := syscmd.SaveToFile(runFolder, batchFileName, intfCommands); err != nil {
+			log.Errorf("Failed to save traffic control commands of %s: %v", intfName, err)
+			continue
+		}
+		if err := l.cmdRunner.ApplyTCBatch(path.Join(runFolder, batchFileName)); err != nil {
+			log.Errorf("Failed to apply rate limits on %s: %v", intfName, err)
+			continue
+		}
+		l.applied[intfName] = intfCommands
+	}
+
+	for intfName := range l.applied {
+		if _, ok := commands[intfName]; ok {
+			continue
+		}
+		if err := l.cmdRunner.DeleteRootQdisc(intfName); err != nil {
+			log.Errorf("Failed to remove rate limits on %s: %v", intfName, err)
+		}
+		delete(l.applied, intfName)
+	}
+}
+
+// forgetLost forgets the configuration of interfaces that lost their HTB qdisc,
+// e.g. when hostapd recreates the BSS interfaces, so that it is applied again.
+func (l *Limiter) forgetLost() {
+	for intfName := range l.applied {
+		qdisc, err := l.cmdRunner.RootQdisc(intfName)
+		if err != nil || !strings.HasPrefix(strings.TrimSpace(qdisc), "qdisc htb 1:") {
+			delete(l.applied, intfName)
+		}
+	}
+}
+
+// commands generates the traffic control commands of each rate limited interface.
+func (l *Limiter) commands() map[string]string {
+	commands := make(map[string]string)
+	upstreamSSIDs := make(map[string][]*ssidClients) // uplink VLAN interface name -> SSIDs
+	var bssINTFNames []string
+	for intfName := range l.bsss {
+		bssINTFNames = append(bssINTFNames, intfName)
+	}
+	sort.Strings(bssINTFNames)
+
+	for _, intfName := range bssINTFNames {
+		bss := l.bsss[intfName]
+		limits, ok := l.limits[bss.SSID]
+		if !ok {
+			continue
+		}
+		clients := l.sortedStations(intfName)
+		// The clients assigned to a VLAN by RADIUS are reached through the dynamic VLAN interfaces of the BSS.
+		if limits.Down() {
+			commands[intfName] = DownstreamCommands(intfName, limits, clients)
+			for _, vlanID := range l.vlans[bss.SSID] {
+				if dynamicINTFName := vlanIntfName(intfName, vlanID); l.vlanIntfs[dynamicINTFName] {
+					commands[dynamicINTFName] = DownstreamCommands(dynamicINTFName, limits, clients)
+				}
+			}
+		}
+		if !limits.Up() {
+			continue
+		}
+		for _, vlanID := range l.vlans[bss.SSID] {
+			uplinkINTFName := vlanIntfName(l.ethINTFName, vlanID)
+			upstreamSSIDs[uplinkINTFName] = addClients(upstreamSSIDs[uplinkINTFName], bss.SSID, limits, clients)
+		}
+	}
+	for vlanINTFName, ssids := range upstreamSSIDs {
+		commands[vlanINTFName] = UpstreamCommands(vlanINTFName, ssids)
+	}
+	return commands
+}
+
+// ssidClients contains the upstream rate limits and the clients of an SSID.
+type ssidClients struct {
+	ssid    string
+	limits  *ocutil.RateLimits
+	clients []string
+}
+
+// addClients adds the clients of a BSS to its SSID, keeping SSIDs and clients in ascending order.
+func addClients(ssids []*ssidClients, ssid string, limits *ocutil.RateLimits, clients []string) []*ssidClients {
+	for _, existing := range ssids {
+		if existing.ssid == ssid {
+			existing.clients = append(existing.clients, clients...)
+			sort.Strings(existing.clients)
+			return ssids
+		}
+	}
+	ssids = append(ssids, &ssidClients{ssid: ssid, limits: limits, clients: clients})
+	sort.Slice(ssids, func(i, j int) bool {
+		return ssids[i].ssid < ssids[j].ssid
+	})
+	return ssids
+}
+
+// DownstreamCommands generates the traffic control commands of a BSS interface.
+// All traffic goes through the SSID class, traffic to each client through its own class.
+func DownstreamCommands(bssINTFName string, limits *ocutil.RateLimits, clients []string) string {
+	ssidRate := rate(limits.SSIDDownKbps)
+	commands := rootCommands(bssINTFName)
+	commands += fmt.Sprintf("class add dev %s parent 1: classid 1:1 htb rate %s\n", bssINTFName, ssidRate)
+	commands += fmt.Sprintf("class add dev %s parent 1:1 classid 1:%x htb rate %s\n", bssINTFName, defaultClassID, ssidRate)
+	if limits.ClientDownKbps == 0 {
+		return commands
+	}
+	for i, mac := range clients {
+		classID := i + 2
+		commands += fmt.Sprintf("class add dev %s parent 1:1 classid 1:%x htb rate %s ceil %s\n", bssINTFName, classID, rate(limits.ClientDownKbps), rate(limits.ClientDownKbps))
+		commands += fmt.Sprintf("filter add dev %s parent 1: protocol all prio 1 flower dst_mac %s classid 1:%x\n", bssINTFName, mac, classID)
+	}
+	return commands
+}
+
+// UpstreamCommands generates the traffic control commands of a VLAN interface shared by SSIDs.
+// Each SSID has a class, traffic from each client goes through its own class under it.
+func UpstreamCommands(vlanINTFName string, ssids []*ssidClients) string {
+	commands := rootCommands(vlanINTFName)
+	commands += fmt.Sprintf("class add dev %s parent 1: classid 1:%x htb rate %s\n", vlanINTFName, defaultClassID, unlimitedRate)
+	classID := 0
+	for _, ssid := range ssids {
+		classID++
+		ssidClassID := classID
+		commands += fmt.Sprintf("class add dev %s parent 1: classid 1:%x htb rate %s\n", vlanINTFName, ssidClassID, rate(ssid.limits.SSIDUpKbps))
+		for _, mac := range ssid.clients {
+			clientClassID := ssidClassID
+			if ssid.limits.ClientUpKbps != 0 {
+				classID++
+				clientClassID = classID
+				commands += fmt.Sprintf("class add dev %s parent 1:%x classid 1:%x htb rate %s ceil %s\n", vlanINTFName, ssidClassID, clientClassID, rate(ssid.limits.ClientUpKbps), rate(ssid.limits.ClientUpKbps))
+			}
+			commands += fmt.Sprintf("filter add dev %s parent 1: protocol all prio 1 flower src_mac %s classid 1:%x\n", vlanINTFName, mac, clientClassID)
+		}
+	}
+	return commands
+}
+
+// rootCommands replaces the root qdisc of an interface with an empty HTB qdisc.
+func rootCommands(intfName string) string {
+	return fmt.Sprintf("qdisc del dev %s root\nqdisc add dev %s root handle 1: htb default %x\n", intfName, intfName, defaultClassID)
+}
+
+func rate(kbps uint64) string {
+	if kbps == 0 {
+		return unlimitedRate
+	}
+	return fmt.Sprintf("%dkbit", kbps)
+}
+
+// vlanIntfName returns the name of the interface of the given VLAN on an interface.
+func vlanIntfName(intfName string, vlanID int) string {
+	return fmt.Sprintf("%s.%d", intfName, vlanID)
+}
+
+// fetchVLANIntfs fetches the dynamic VLAN interfaces hostapd created for the BSSs of rate limited SSIDs.
+func (l *Limiter) fetchVLANIntfs(limits map[string]*ocutil.RateLimits, vlans map[string][]int, bssList []*monitoring.BSS) []string {
+	var vlanINTFNames []string
+	for _, bss := range bssList {
+		// SSIDs without a VLAN list have no dynamic VLAN.
+		if _, ok := limits[bss.SSID]; !ok || len(vlans[bss.SSID]) < 2 {
+			continue
+		}
+		for _, vlanID := range vlans[bss.SSID] {
+			intfName := vlanIntfName(bss.IntfName, vlanID)
+			if _, err := l.cmdRunner.RootQdisc(intfName); err == nil {
+				vlanINTFNames = append(vlanINTFNames, intfName)
+			}
+		}
+	}
+	return vlanINTFNames
+}
+
+// fetchStations fetches the stations of each rate limited BSS.
+func (l *Limiter) fetchStations(limits map[string]*ocutil.RateLimits, bssList []*monitoring.BSS) map[string][]string {
+	stations := make(map[string][]string)
+	for _, bss := range bssList {
+		if _, ok := limits[bss.SSID]; !ok {
+			continue
+		}
+		macs, err := monitoring.Stations(bss.IntfName)
+		if err != nil {
+			log.Errorf("Failed to fetch the stations of %s: %v", bss.IntfName, err)
+			continue
+		}
+		stations[bss.IntfName] = macs
+	}
+	return stations
+}
+
+// limitedBSSs returns the interface names of all BSSs of rate limited SSIDs.
+func (l *Limiter) limitedBSSs() map[string]bool {
+	bssIntfs := make(map[string]bool)
+	for intfName, bss := range l.bsss {
+		if _, ok := l.limits[bss.SSID]; ok {
+			bssIntfs[intfName] = true
+		}
+	}
+	return bssIntfs
+}
+
+// sortedStations returns the stations of a BSS in ascending order.
+func (l *Limiter) sortedStations(bssINTFName string) []string {
+	var macs []string
+	for mac := range l.stations[bssINTFName] {
+		macs = append(macs, mac)
+	}
+	sort.Strings(macs)
+	return macs
+}
+
+// ssidSettings returns the rate limits (SSID -> limits) and the VLANs of each SSID (SSID -> VLAN IDs, default VLAN first).
+// SSIDs on a local NAT subnet have no uplink VLAN, only their downstream traffic is limited.
+func ssidSettings(gnmiServer *gnmi.Server, hostName string) (map[string]*ocutil.RateLimits, map[string][]int) {
+	limits := make(map[string]*ocutil.RateLimits)
+	vlans := make(map[string][]int)
+	gnmiServer.InternalUpdate(func(config ygot.ValidatedGoStruct) error {
+		device, ok := config.(*ocstruct.Device)
+		if !ok {
+			return errors.New("configuration has invalid type")
+		}
+		limits = ocutil.RateLimitSSIDs(device.Gasket)
+		apConfig := ocutil.FindAPConfig(device, hostName)
+		if apConfig == nil || apConfig.Ssids == nil {
+			return nil
+		}
+		natSSIDs := ocutil.NATSSIDs(device.Gasket)
+		for ssidName, ssid := range apConfig.Ssids.Ssid {
+			if _, ok := natSSIDs[ssidName]; ok {
+				continue
+			}
+			if ssid.Config != nil && ssid.Config.DefaultVlan != nil {
+				vlans[ssidName] = ocutil.SSIDVLANIDs(ssid.Config)
+			}
+		}
+		return nil
+	})
+	return limits, vlans
+}
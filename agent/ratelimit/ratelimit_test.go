@@ -0,0 +1,212 @@
+/* Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ratelimit
+
+import (
+	"errors"
+	"io/ioutil"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/google/link022/agent/hostapd"
+	"github.com/google/link022/agent/monitoring"
+	"github.com/google/link022/agent/syscmd"
+	"github.com/google/link022/agent/util/ocutil"
+)
+
+const (
+	testGuestSSID = "Guest-Emu"
+	testAuthSSID  = "Auth-Emu"
+	testClientA   = "02:00:00:00:00:0a"
+	testClientB   = "02:00:00:00:00:0b"
+)
+
+var testBSSList = []*monitoring.BSS{
+	{IntfName: "wlan0", SSID: testAuthSSID},
+	{IntfName: "wlan0_1", SSID: testGuestSSID},
+}
+
+func TestDownstreamCommands(t *testing.T) {
+	tests := []struct {
+		name     string
+		limits   *ocutil.RateLimits
+		commands string
+	}{{
+		name:   "SSIDAndClientLimits",
+		limits: &ocutil.RateLimits{SSIDDownKbps: 20000, ClientDownKbps: 2000},
+		commands: `qdisc del dev wlan0_1 root
+qdisc add dev wlan0_1 root handle 1: htb default ffff
+class add dev wlan0_1 parent 1: classid 1:1 htb rate 20000kbit
+class add dev wlan0_1 parent 1:1 classid 1:ffff htb rate 20000kbit
+class add dev wlan0_1 parent 1:1 classid 1:2 htb rate 2000kbit ceil 2000kbit
+filter add dev wlan0_1 parent 1: protocol all prio 1 flower dst_mac 02:00:00:00:00:0a classid 1:2
+class add dev wlan0_1 parent 1:1 classid 1:3 htb rate 2000kbit ceil 2000kbit
+filter add dev wlan0_1 parent 1: protocol all prio 1 flower dst_mac 02:00:00:00:00:0b classid 1:3
+`,
+	}, {
+		name:   "SSIDLimitOnly",
+		limits: &ocutil.RateLimits{SSIDDownKbps: 20000},
+		commands: `qdisc del dev wlan0_1 root
+qdisc add dev wlan0_1 root handle 1: htb default ffff
+class add dev wlan0_1 parent 1: classid 1:1 htb rate 20000kbit
+class add dev wlan0_1 parent 1:1 classid 1:ffff htb rate 20000kbit
+`,
+	}}
+
+	for _, test := range tests {
+		if got := DownstreamCommands("wlan0_1", test.limits, []string{testClientA, testClientB}); got != test.commands {
+			t.Errorf("[%s] Incorrect commands (got:\n%s\nwant:\n%s).", test.name, got, test.commands)
+		}
+	}
+}
+
+func TestUpstreamCommands(t *testing.T) {
+	ssids := []*ssidClients{
+		{ssid: testAuthSSID, limits: &ocutil.RateLimits{SSIDUpKbps: 50000}, clients: []string{testClientA}},
+		{ssid: testGuestSSID, limits: &ocutil.RateLimits{ClientUpKbps: 1000}, clients: []string{testClientB}},
+	}
+	want := `qdisc del dev eth0.666 root
+qdisc add dev eth0.666 root handle 1: htb default ffff
+class add dev eth0.666 parent 1: classid 1:ffff htb rate 10gbit
+class add dev eth0.666 parent 1: classid 1:1 htb rate 50000kbit
+filter add dev eth0.666 parent 1: protocol all prio 1 flower src_mac 02:00:00:00:00:0a classid 1:1
+class add dev eth0.666 parent 1: classid 1:2 htb rate 10gbit
+class add dev eth0.666 parent 1:2 classid 1:3 htb rate 1000kbit ceil 1000kbit
+filter add dev eth0.666 parent 1: protocol all prio 1 flower src_mac 02:00:00:00:00:0b classid 1:3
+`
+	if got := UpstreamCommands("eth0.666", ssids); got != want {
+		t.Errorf("Incorrect commands (got:\n%s\nwant:\n%s).", got, want)
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	tempRunFolder, err := ioutil.TempDir("", "link022")
+	if err != nil {
+		t.Fatalf("Unable to create a temp run time folder.")
+	}
+	originalRunFolder := runFolder
+	runFolder = tempRunFolder
+	defer func() {
+		runFolder = originalRunFolder
+	}()
+
+	var cmds []string
+	l := NewLimiter(&syscmd.CommandRunner{
+		ExecCommand: func(wait bool, cmd string, args ...string) (string, error) {
+			if args[1] == "-batch" {
+				cmds = append(cmds, "batch "+path.Base(args[2]))
+			} else {
+				cmds = append(cmds, cmd+" "+strings.Join(args, " "))
+			}
+			return "", nil
+		},
+	}, "eth0")
+	limits := map[string]*ocutil.RateLimits{testGuestSSID: {ClientDownKbps: 2000, ClientUpKbps: 1000}}
+	// Clients of the guest SSID assigned to VLAN 300 by RADIUS use the wlan0_1.300 interface.
+	vlans := map[string][]int{testGuestSSID: {666, 300}, testAuthSSID: {250}}
+	vlanINTFNames := []string{"wlan0_1.300"}
+
+	steps := []struct {
+		name   string
+		update func()
+		cmds   []string
+	}{{
+		name: "Install",
+		update: func() {
+			l.UpdateSettings(limits, vlans, testBSSList, vlanINTFNames, map[string][]string{"wlan0_1": {testClientA}})
+		},
+		cmds: []string{"batch tc_eth0.300.batch", "batch tc_eth0.666.batch", "batch tc_wlan0_1.300.batch", "batch tc_wlan0_1.batch"},
+	}, {
+		name: "Unchanged",
+		update: func() {
+			l.UpdateSettings(limits, vlans, testBSSList, vlanINTFNames, map[string][]string{"wlan0_1": {testClientA}})
+		},
+	}, {
+		name: "ClientJoins",
+		update: func() {
+			l.HandleEvent(&hostapd.Event{IntfName: "wlan0_1", Name: "AP-STA-CONNECTED", Args: []string{testClientB}})
+		},
+		cmds: []string{"batch tc_eth0.300.batch", "batch tc_eth0.666.batch", "batch tc_wlan0_1.300.batch", "batch tc_wlan0_1.batch"},
+	}, {
+		name: "ClientOfUnlimitedSSIDJoins",
+		update: func() {
+			l.HandleEvent(&hostapd.Event{IntfName: "wlan0", Name: "AP-STA-CONNECTED", Args: []string{testClientB}})
+		},
+	}, {
+		name: "ClientLeaves",
+		update: func() {
+			l.HandleEvent(&hostapd.Event{IntfName: "wlan0_1", Name: "AP-STA-DISCONNECTED", Args: []string{testClientA}})
+		},
+		cmds: []string{"batch tc_eth0.300.batch", "batch tc_eth0.666.batch", "batch tc_wlan0_1.300.batch", "batch tc_wlan0_1.batch"},
+	}, {
+		name: "RemoveLimits",
+		update: func() {
+			l.UpdateSettings(map[string]*ocutil.RateLimits{}, vlans, testBSSList, nil, nil)
+		},
+		cmds: []string{"tc qdisc del dev eth0.300 root", "tc qdisc del dev eth0.666 root", "tc qdisc del dev wlan0_1 root", "tc qdisc del dev wlan0_1.300 root"},
+	}}
+
+	for _, step := range steps {
+		cmds = nil
+		step.update()
+		l.reconcile()
+		sort.Strings(cmds)
+		if !reflect.DeepEqual(cmds, step.cmds) {
+			t.Errorf("[%s] Incorrect commands (got: %v, want: %v).", step.name, cmds, step.cmds)
+		}
+	}
+}
+
+func TestFetchVLANIntfs(t *testing.T) {
+	l := NewLimiter(&syscmd.CommandRunner{
+		ExecCommand: func(wait bool, cmd string, args ...string) (string, error) {
+			if args[3] == "wlan0_1.300" {
+				return "qdisc noqueue 0: root refcnt 2\n", nil
+			}
+			return "", errors.New("Cannot find device")
+		},
+	}, "eth0")
+	limits := map[string]*ocutil.RateLimits{testGuestSSID: {ClientDownKbps: 2000}, testAuthSSID: {ClientDownKbps: 2000}}
+	vlans := map[string][]int{testGuestSSID: {666, 300, 400}, testAuthSSID: {250}}
+
+	got := l.fetchVLANIntfs(limits, vlans, testBSSList)
+	if want := []string{"wlan0_1.300"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Incorrect dynamic VLAN interfaces (got: %v, want: %v).", got, want)
+	}
+}
+
+func TestForgetLost(t *testing.T) {
+	l := NewLimiter(&syscmd.CommandRunner{
+		ExecCommand: func(wait bool, cmd string, args ...string) (string, error) {
+			if args[3] == "wlan0_1" {
+				return "qdisc htb 1: root refcnt 2 r2q 10 default 0xffff direct_packets_stat 0\n", nil
+			}
+			return "qdisc noqueue 0: root refcnt 2\n", nil
+		},
+	}, "eth0")
+	l.applied = map[string]string{"wlan0_1": "commands", "eth0.666": "commands"}
+
+	l.forgetLost()
+	if _, ok := l.applied["eth0.666"]; ok {
+		t.Error("Interface without HTB qdisc still considered as configured.")
+	}
+	if _, ok := l.applied["wlan0_1"]; !ok {
+		t.Error("Interface with HTB qdisc no longer considered as configured.")
+	}
+}
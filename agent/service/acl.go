@@ -0,0 +1,200 @@
+/* Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	log "github.com/golang/glog"
+	"github.com/google/link022/agent/denylist"
+	"github.com/google/link022/agent/syscmd"
+	"github.com/google/link022/agent/util/ocutil"
+	"github.com/google/link022/generated/ocstruct"
+)
+
+// macACLOwner identifies the stations denied by MAC ACLs in the shared deny list.
+const macACLOwner = "mac_acl"
+
+var denyList = denylist.Default()
+
+// ApplyMACACLs updates the MAC ACLs of the running BSSs through the hostapd control interface,
+// without restarting the radios. The ACL files and the hostapd configuration files are updated as well,
+// so that hostapd restarts with the same ACLs.
+func ApplyMACACLs(apConfig *ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint, gasketConfig *ocstruct.OpenconfigGasket_Gasket, wlanINTFNames []string) error {
+	radios, err := radioIntfs(apConfig, wlanINTFNames)
+	if err != nil {
+		return err
+	}
+
+	macACLs := ocutil.MACACLSSIDs(gasketConfig)
+	radiusAttribute := radiusAttributeSetting(gasketConfig)
+	steeredBSSs := steeringBSSs(apConfig, radios)
+	for _, radio := range radios {
+		wlanConfigs := wlanWithOpFreq(apConfig, radio.config.OperatingFrequency)
+		bssINTFNames := bssIntfNames(wlanConfigs, radio.wlanINTFName)
+		for _, wlanConfig := range wlanConfigs {
+			bssINTFName, ok := bssINTFNames[*wlanConfig.Name]
+			if !ok {
+				continue
+			}
+			acl := ssidMACACL(macACLs, *wlanConfig.Name)
+			if err := saveMACACLFiles(acl, bssINTFName); err != nil {
+				return err
+			}
+			if err := updateAcceptList(bssINTFName, acl.Accept); err != nil {
+				return err
+			}
+			if err := updateDenyList(bssINTFName, acl.Deny); err != nil {
+				return err
+			}
+		}
+
+		hostapdConfig := hostapdConfigFile(radio.config, ocutil.RadiusServers(apConfig), ocutil.RadiusAccountingServers(apConfig),
+			ocutil.BandSteeringSSIDs(apConfig), steeredBSSs, ocutil.Dot1XBlacklistSSIDs(apConfig), macACLs, ocutil.NATSSIDs(gasketConfig), wlanConfigs, radio.wlanINTFName, *apConfig.Hostname, radiusAttribute)
+		if err := syscmd.SaveToFile(runFolder, hostapdConfFileName(radio.wlanINTFName), hostapdConfig); err != nil {
+			return err
+		}
+		log.Infof("Applied MAC ACLs on %s.", radio.wlanINTFName)
+	}
+	return nil
+}
+
+// updateAcceptList updates the accept list of a running BSS. If the list is not empty, the BSS only
+// accepts the stations in it, and other associated stations are disconnected.
+func updateAcceptList(bssINTFName string, acceptMACs []string) error {
+	accepted, err := cmdRunner.AcceptedStations(bssINTFName)
+	if err != nil {
+		return err
+	}
+	added, removed := diffMACs(accepted, acceptMACs)
+	for _, mac := range added {
+		if err := cmdRunner.AcceptStation(bssINTFName, mac); err != nil {
+			return err
+		}
+	}
+	acceptListOnly := len(acceptMACs) != 0
+	if err := cmdRunner.SetMACACLPolicy(bssINTFName, acceptListOnly); err != nil {
+		return err
+	}
+	for _, mac := range removed {
+		if err := cmdRunner.UnacceptStation(bssINTFName, mac); err != nil {
+			return err
+		}
+	}
+	if !acceptListOnly {
+		return nil
+	}
+
+	// Stations associated before the BSS switched to accepting listed stations only stay connected.
+	associated, err := cmdRunner.AssociatedStations(bssINTFName)
+	if err != nil {
+		return err
+	}
+	_, unaccepted := diffMACs(associated, acceptMACs)
+	for _, mac := range unaccepted {
+		if err := cmdRunner.DeauthenticateStation(bssINTFName, mac); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// updateDenyList updates the stations denied by the MAC ACL of a running BSS.
+// Stations also denied by other agent features stay denied.
+func updateDenyList(bssINTFName string, denyMACs []string) error {
+	added, removed := diffMACs(denyList.Denied(macACLOwner, bssINTFName), denyMACs)
+	for _, mac := range added {
+		if err := denyList.Deny(macACLOwner, bssINTFName, mac); err != nil {
+			return err
+		}
+	}
+	for _, mac := range removed {
+		if err := denyList.Allow(macACLOwner, bssINTFName, mac); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// diffMACs returns the MAC addresses only in the wanted list and the ones only in the current list,
+// in ascending order.
+func diffMACs(current, wanted []string) ([]string, []string) {
+	currentSet := make(map[string]bool)
+	for _, mac := range current {
+		currentSet[mac] = true
+	}
+	wantedSet := make(map[string]bool)
+	var added []string
+	for _, mac := range wanted {
+		wantedSet[mac] = true
+		if !currentSet[mac] {
+			added = append(added, mac)
+		}
+	}
+	var removed []string
+	for _, mac := range current {
+		if !wantedSet[mac] {
+			removed = append(removed, mac)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	return added, removed
+}
+
+// ssidMACACL returns the MAC ACL of the given SSID, an empty one if the SSID has no ACL.
+func ssidMACACL(macACLs map[string]*ocutil.MACACL, wlanName string) *ocutil.MACACL {
+	if acl, ok := macACLs[wlanName]; ok {
+		return acl
+	}
+	return &ocutil.MACACL{}
+}
+
+// macACLConfig generates the hostapd configuration of the MAC ACL of a BSS.
+func macACLConfig(acl *ocutil.MACACL, bssINTFName string) string {
+	policy := 0
+	if len(acl.Accept) != 0 {
+		policy = 1
+	}
+	return fmt.Sprintf(macACLConfigTemplate, policy, path.Join(runFolder, acceptMACFileName(bssINTFName)),
+		path.Join(runFolder, denyMACFileName(bssINTFName)))
+}
+
+// saveMACACLFiles saves the accept and deny lists of a BSS, one MAC address per line.
+func saveMACACLFiles(acl *ocutil.MACACL, bssINTFName string) error {
+	if err := syscmd.SaveToFile(runFolder, acceptMACFileName(bssINTFName), macListFile(acl.Accept)); err != nil {
+		return err
+	}
+	return syscmd.SaveToFile(runFolder, denyMACFileName(bssINTFName), macListFile(acl.Deny))
+}
+
+func macListFile(macs []string) string {
+	if len(macs) == 0 {
+		return ""
+	}
+	return strings.Join(macs, "\n") + "\n"
+}
+
+func acceptMACFileName(bssINTFName string) string {
+	return fmt.Sprintf("hostapd_%s.accept", bssINTFName)
+}
+
+func denyMACFileName(bssINTFName string) string {
+	return fmt.Sprintf("hostapd_%s.deny", bssINTFName)
+}
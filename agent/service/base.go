@@ -29,23 +29,40 @@ var (
 )
 
 // ApplyConfig configures this device to a Link022 AP based on the given configuration.
-func ApplyConfig(officeAP *ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint, gasketConfig *ocstruct.OpenconfigGasket_Gasket, setupIntf bool, ethIntfName, wlanINTFName string) error {
+// The radios run on the given WLAN interfaces, in ascending radio ID order.
+func ApplyConfig(officeAP *ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint, gasketConfig *ocstruct.OpenconfigGasket_Gasket, setupIntf bool, ethIntfName string, wlanINTFNames []string) error {
 	log.Infof("Configuring AP %s...", *officeAP.Hostname)
 
+	natSSIDs := ocutil.NATSSIDs(gasketConfig)
 	if setupIntf {
 		// Configure eth interface.
-		if err := configEthIntf(ethIntfName, ocutil.VLANIDs(officeAP)); err != nil {
+		if err := configEthIntf(ethIntfName, ocutil.UplinkVLANIDs(officeAP, natSSIDs)); err != nil {
 			return err
 		}
 
-		//Configure WLAN interface.
-		if err := configWLANIntf(wlanINTFName); err != nil {
+		//Configure the WLAN interfaces of the radios.
+		radios, err := radioIntfs(officeAP, wlanINTFNames)
+		if err != nil {
 			return err
 		}
+		for _, radio := range radios {
+			if err := configWLANIntf(radio.wlanINTFName); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Configure the local subnets of NAT SSIDs, masqueraded to the management interface.
+	mgmtIntfName := ethIntfName
+	if mgmtIntf := ocutil.ManagementIntfSettings(gasketConfig, officeAP); mgmtIntf != nil {
+		mgmtIntfName = mgmtIntf.IntfName(ethIntfName)
+	}
+	if err := configNAT(natSSIDs, ocutil.CaptivePortalSSIDs(gasketConfig), mgmtIntfName); err != nil {
+		return err
 	}
 
 	// Configure hostapd.
-	return configHostapd(officeAP, gasketConfig, wlanINTFName)
+	return configHostapd(officeAP, gasketConfig, wlanINTFNames)
 }
 
 // CleanupConfig cleans up the current AP configuration on this device.
@@ -58,6 +75,9 @@ func CleanupConfig(ethIntfName string, vlanIDs []int) []error {
 		errs = append(errs, err)
 	}
 
+	// Clean up the local subnets of NAT SSIDs.
+	errs = append(errs, cleanupNAT()...)
+
 	// Clean up eth interfaces.
 	if len(vlanIDs) > 0 {
 		errs = append(errs, cleanupEthIntf(ethIntfName, vlanIDs)...)
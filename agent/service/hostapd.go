@@ -18,7 +18,11 @@ package service
 import (
 	"errors"
 	"fmt"
+	"hash/fnv"
 	"path"
+	"reflect"
+	"sort"
+	"sync/atomic"
 
 	log "github.com/golang/glog"
 	"github.com/google/link022/agent/syscmd"
@@ -27,8 +31,6 @@ import (
 )
 
 const (
-	ctrlInterfaceConfigTemplate = `ctrl_interface=%s
-`
 	radiusAttributeSaveConfigTemplate = `radius_auth_access_accept_attr=%s
 `
 	commonConfigTemplate = `
@@ -42,15 +44,73 @@ ctrl_interface=/var/run/hostapd
 `
 
 	bssConfigTemplate = `
-# bssid for multiple wlans, the format is like "wlan0_1"
+# bssid for multiple wlans, the format is like "wlan0_9b2c", the suffix is a hash of the wlan name
 # For the first wlan, there should be no bssid field, otherwise hostapd
 # will fail to start.
-bss=%s_%d
+bss=%s
+`
+
+	// addedBSSConfigTemplate starts the configuration of a BSS added to a running hostapd.
+	addedBSSConfigTemplate = `interface=%s
+driver=nl80211
+ctrl_interface=/var/run/hostapd
 `
 
 	wlanConfigTemplate = `ssid=%s
 bridge=%s
 ap_isolate=%d
+`
+
+	// dynamicVLANConfigTemplate lets RADIUS assign clients to the VLANs listed in the VLAN file.
+	// Clients without a RADIUS assigned VLAN stay on the default VLAN, assignments to other VLANs are rejected.
+	dynamicVLANConfigTemplate = `dynamic_vlan=1
+vlan_file=%s
+`
+
+	// vlanFileEntryTemplate maps a VLAN to its per-BSS interface and bridge.
+	vlanFileEntryTemplate = "%d %s.%d %s\n"
+
+	// hiddenSSIDConfig sends beacons with an empty SSID and ignores probe requests without the SSID.
+	hiddenSSIDConfig = `ignore_broadcast_ssid=1
+`
+
+	// apNameConfigTemplate adds a vendor specific element to beacons and probe responses.
+	apNameConfigTemplate = `vendor_elements=%s
+`
+	// apNameOUI and apNameOUIType identify the vendor specific element carrying the AP hostname.
+	apNameOUI     = "001a11"
+	apNameOUIType = 0x01
+	// maxAPNameLength is the longest hostname fitting in a vendor specific element.
+	maxAPNameLength = 255 - 4
+
+	// proxyARPConfig lets hostapd answer ARP and neighbor solicitations on behalf of the clients.
+	// The client addresses are learned by snooping DHCP and neighbor discovery, and added as permanent
+	// neighbors of the bridge.
+	proxyARPConfig = `proxy_arp=1
+`
+
+	// macACLConfigTemplate sets the MAC ACL policy and the accept and deny lists of a BSS.
+	// With policy 0, all stations except the denied ones are accepted. With policy 1, only
+	// the stations in the accept list are accepted.
+	macACLConfigTemplate = `macaddr_acl=%d
+accept_mac_file=%s
+deny_mac_file=%s
+`
+
+	// bssTransitionConfig enables BSS transition management, used by band steering.
+	bssTransitionConfig = `bss_transition=1
+`
+
+	// staTrackConfigTemplate lets hostapd keep track of the stations heard on a 5 GHz radio,
+	// for the 2.4 GHz BSSs of band steering SSIDs.
+	staTrackConfigTemplate = `track_sta_max_num=%d
+track_sta_max_age=%d
+`
+
+	// steeringConfigTemplate hides a 2.4 GHz BSS from the stations recently heard on the 5 GHz BSS
+	// of the same SSID: probe requests are not answered and authentications are rejected.
+	steeringConfigTemplate = `no_probe_resp_if_seen_on=%s
+no_auth_if_seen_on=%s
 `
 
 	authConfigTemplate = `ieee8021x=1
@@ -58,64 +118,361 @@ auth_algs=1
 wpa=2
 rsn_pairwise=CCMP
 wpa_key_mgmt=WPA-EAP
-macaddr_acl=0
-auth_server_addr=%s
+nas_identifier=%s
+`
+
+	// Radius servers are listed in priority order, hostapd fails over to the next one
+	// when the current server stops responding.
+	authServerConfigTemplate = `auth_server_addr=%s
 auth_server_port=%d
 auth_server_shared_secret=%s
-nas_identifier=%s
 `
+
+	acctServerConfigTemplate = `acct_server_addr=%s
+acct_server_port=%d
+acct_server_shared_secret=%s
+`
+
+	// Rekeying intervals of the group and pairwise keys, in seconds.
+	gtkRekeyConfigTemplate = `wpa_group_rekey=%d
+`
+	ptkRekeyConfigTemplate = `wpa_ptk_rekey=%d
+`
+
+	// hostapd fails an EAP authentication taking more rounds than max_auth_rounds, 100 by default.
+	// SSIDs with a failure limit use a lower limit, so that clients stuck in an EAP exchange fail, and are
+	// blacklisted, sooner.
+	maxAuthRoundsConfigTemplate = `max_auth_rounds=%d
+`
+
+	// radiusRetryConfigTemplate sets how long hostapd waits before switching back to the primary server.
+	radiusRetryConfigTemplate = `radius_retry_primary_interval=%d
+`
+
+	// steeringTrackedStations and steeringTrackAge bound the stations tracked for band steering.
+	// A station is hidden the 2.4 GHz BSS for steeringTrackAge seconds after it was last heard on 5 GHz.
+	steeringTrackedStations = 1000
+	steeringTrackAge        = 30
+
+	defaultRadiusAcctPort = 1813
+	// blacklistMaxAuthRounds is the EAP round limit of SSIDs with a failure limit, enough for EAP-TLS
+	// with a fragmented certificate chain.
+	blacklistMaxAuthRounds = 30
 )
 
+// hostapdStarts counts the hostapd processes started by the agent.
+var hostapdStarts uint64
+
+// HostapdStarts returns the number of hostapd processes started by the agent. The settings applied to the
+// radios outside of hostapd, e.g. their transmit power, have to be applied again once it changes.
+func HostapdStarts() uint64 {
+	return atomic.LoadUint64(&hostapdStarts)
+}
+
 // configHostapd configures the hostapd program on this device based on the given AP configuration.
-func configHostapd(apConfig *ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint, gasketConfig *ocstruct.OpenconfigGasket_Gasket, wlanINTFName string) error {
+// Each radio runs on one of the given WLAN interfaces, and a single hostapd process drives all of them.
+func configHostapd(apConfig *ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint, gasketConfig *ocstruct.OpenconfigGasket_Gasket, wlanINTFNames []string) error {
 	hostname := *apConfig.Hostname
-	apRadios := apConfig.Radios
-	ctrlInterface := ""
-	if gasketConfig != nil && gasketConfig.CtrlInterface != nil {
-		ctrlInterface = *gasketConfig.CtrlInterface
-	}
-	radiusAttribute := ""
-	if gasketConfig != nil && gasketConfig.RadiusAttribute != nil {
-		radiusAttribute = *gasketConfig.RadiusAttribute
-	}
-	if apRadios == nil || len(apRadios.Radio) == 0 {
-		log.Error("No radio configuration found.")
-		return errors.New("no radio configuration found")
-	}
-
-	if len(apRadios.Radio) > 1 {
-		log.Errorf("Invalid radio number, expected: 1, actual: %d.", len(apRadios.Radio))
-		return errors.New("not supporting multiple radios")
+	radiusAttribute := radiusAttributeSetting(gasketConfig)
+	radios, err := radioIntfs(apConfig, wlanINTFNames)
+	if err != nil {
+		log.Error(err)
+		return err
 	}
 
 	authServerConfigs := ocutil.RadiusServers(apConfig)
-	for _, apRadio := range apRadios.Radio {
-		radioConfig := apRadio.Config
+	acctServerConfigs := ocutil.RadiusAccountingServers(apConfig)
+	steeringSSIDs := ocutil.BandSteeringSSIDs(apConfig)
+	steeredBSSs := steeringBSSs(apConfig, radios)
+	blacklistSSIDs := ocutil.Dot1XBlacklistSSIDs(apConfig)
+	macACLs := ocutil.MACACLSSIDs(gasketConfig)
+	natSSIDs := ocutil.NATSSIDs(gasketConfig)
+	var configFilePaths []string
+	for _, radio := range radios {
+		radioConfig := radio.config
+		wlanINTFName := radio.wlanINTFName
 		wlanConfigs := wlanWithOpFreq(apConfig, radioConfig.OperatingFrequency)
 
+		// Save the VLAN files of SSIDs with dynamic VLAN.
+		bssINTFNames := bssIntfNames(wlanConfigs, wlanINTFName)
+		if len(bssINTFNames) == 0 {
+			log.Infof("No enabled WLAN on radio %v, skipping it.", *radioConfig.Id)
+			continue
+		}
+		deniedStations := make(map[string][]string)
+		for _, wlanConfig := range wlanConfigs {
+			if bssINTFName, ok := bssINTFNames[*wlanConfig.Name]; ok {
+				if err := saveVLANFile(wlanConfig, natSSIDs, bssINTFName); err != nil {
+					return err
+				}
+				deniedStations[bssINTFName] = ssidMACACL(macACLs, *wlanConfig.Name).Deny
+			}
+		}
+
+		// Save the MAC ACL files. hostapd only denies the stations in the deny lists of its configuration,
+		// so they also list the stations denied by other agent features, e.g. band steering.
+		allDenied := denyList.Reset(macACLOwner, deniedStations)
+		for _, wlanConfig := range wlanConfigs {
+			if bssINTFName, ok := bssINTFNames[*wlanConfig.Name]; ok {
+				acl := &ocutil.MACACL{Accept: ssidMACACL(macACLs, *wlanConfig.Name).Accept, Deny: allDenied[bssINTFName]}
+				if err := saveMACACLFiles(acl, bssINTFName); err != nil {
+					return err
+				}
+			}
+		}
+
 		// Genearte hostapd configuration.
-		hostapdConfig := hostapdConfigFile(radioConfig, authServerConfigs, wlanConfigs, wlanINTFName, hostname, ctrlInterface, radiusAttribute)
+		hostapdConfig := hostapdConfigFile(radioConfig, authServerConfigs, acctServerConfigs, steeringSSIDs, steeredBSSs, blacklistSSIDs, macACLs, natSSIDs, wlanConfigs, wlanINTFName, hostname, radiusAttribute)
 
 		// Save the hostapd configuration file.
 		configFileName := hostapdConfFileName(wlanINTFName)
 		if err := syscmd.SaveToFile(runFolder, configFileName, hostapdConfig); err != nil {
 			return err
 		}
+		configFilePaths = append(configFilePaths, path.Join(runFolder, configFileName))
+	}
+	if len(configFilePaths) == 0 {
+		return nil
+	}
 
-		// Start hostapd.
-		if err := cmdRunner.StartHostapd(path.Join(runFolder, configFileName)); err != nil {
-			return err
+	// Start hostapd.
+	if err := cmdRunner.StartHostapd(configFilePaths...); err != nil {
+		return err
+	}
+	atomic.AddUint64(&hostapdStarts, 1)
+	return nil
+}
+
+// radioIntf is a radio of the AP with the WLAN interface running it.
+type radioIntf struct {
+	config       *ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_Radios_Radio_Config
+	wlanINTFName string
+}
+
+// radioIntfs assigns the given WLAN interfaces to the radios of the AP, in ascending radio ID order.
+// It fails if the AP has no radio, or more radios than WLAN interfaces.
+func radioIntfs(apConfig *ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint, wlanINTFNames []string) ([]*radioIntf, error) {
+	radioIDs := ocutil.RadioIDs(apConfig)
+	if len(radioIDs) == 0 {
+		return nil, errors.New("no radio configuration found")
+	}
+	if len(radioIDs) > len(wlanINTFNames) {
+		return nil, fmt.Errorf("%d radios configured, but only %d WLAN interfaces on this device", len(radioIDs), len(wlanINTFNames))
+	}
+
+	var radios []*radioIntf
+	for i, id := range radioIDs {
+		radioConfig := apConfig.Radios.Radio[id].Config
+		if radioConfig == nil {
+			return nil, fmt.Errorf("no configuration of radio %d", id)
 		}
+		radios = append(radios, &radioIntf{config: radioConfig, wlanINTFName: wlanINTFNames[i]})
 	}
+	return radios, nil
+}
 
-	return nil
+// steeringBSSs returns the 5 GHz BSS of each band steering SSID (SSID -> BSS interface name).
+// The 2.4 GHz BSS of the SSID is hidden from the stations recently seen on this BSS.
+func steeringBSSs(apConfig *ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint, radios []*radioIntf) map[string]string {
+	steeringSSIDs := ocutil.BandSteeringSSIDs(apConfig)
+	bssINTFNames := make(map[string]string)
+	for _, radio := range radios {
+		if radio.config.OperatingFrequency != ocstruct.OpenconfigWifiTypes_OPERATING_FREQUENCY_FREQ_5GHZ {
+			continue
+		}
+		for wlanName, bssINTFName := range bssIntfNames(wlanWithOpFreq(apConfig, radio.config.OperatingFrequency), radio.wlanINTFName) {
+			if _, ok := steeringSSIDs[wlanName]; !ok {
+				continue
+			}
+			// Radios are in ascending radio ID order, the first 5 GHz radio is used.
+			if _, ok := bssINTFNames[wlanName]; !ok {
+				bssINTFNames[wlanName] = bssINTFName
+			}
+		}
+	}
+	return bssINTFNames
+}
+
+// ToggleSSIDs enables and disables SSIDs on the running hostapd by adding and removing their BSSs,
+// without restarting the radios. The radios run on the given WLAN interfaces, in ascending radio ID order.
+// It only handles changes of the enabled flag of SSIDs that do not run on a WLAN interface itself,
+// and returns false if the configuration has any other change.
+func ToggleSSIDs(appliedAP, apConfig *ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint, gasketConfig *ocstruct.OpenconfigGasket_Gasket, wlanINTFNames []string) (bool, error) {
+	if !sameExceptSSIDEnabled(appliedAP, apConfig) {
+		return false, nil
+	}
+	radios, err := radioIntfs(apConfig, wlanINTFNames)
+	if err != nil {
+		return false, nil
+	}
+	// The 2.4 GHz BSSs of band steering SSIDs refer to the 5 GHz BSSs, they are only updated on restart.
+	if !reflect.DeepEqual(steeringBSSs(appliedAP, radios), steeringBSSs(apConfig, radios)) {
+		return false, nil
+	}
+
+	// Find the changes on every radio first, nothing is changed if any radio has to restart.
+	var toggles []*bssToggles
+	for _, radio := range radios {
+		toggle, ok := radioBSSToggles(appliedAP, apConfig, radio)
+		if !ok {
+			return false, nil
+		}
+		if len(toggle.removedBSSs) != 0 || len(toggle.addedWLANs) != 0 {
+			toggles = append(toggles, toggle)
+		}
+	}
+	if len(toggles) == 0 {
+		return false, nil
+	}
+
+	hostname := *apConfig.Hostname
+	radiusAttribute := radiusAttributeSetting(gasketConfig)
+	authServerConfigs := ocutil.RadiusServers(apConfig)
+	acctServerConfigs := ocutil.RadiusAccountingServers(apConfig)
+	steeringSSIDs := ocutil.BandSteeringSSIDs(apConfig)
+	steeredBSSs := steeringBSSs(apConfig, radios)
+	blacklistSSIDs := ocutil.Dot1XBlacklistSSIDs(apConfig)
+	macACLs := ocutil.MACACLSSIDs(gasketConfig)
+	natSSIDs := ocutil.NATSSIDs(gasketConfig)
+	for _, toggle := range toggles {
+		radio := toggle.radio
+		for _, bssINTFName := range toggle.removedBSSs {
+			if err := cmdRunner.RemoveBSS(bssINTFName); err != nil {
+				return false, err
+			}
+		}
+
+		is5GHz := radio.config.OperatingFrequency == ocstruct.OpenconfigWifiTypes_OPERATING_FREQUENCY_FREQ_5GHZ
+		for _, wlanConfig := range toggle.addedWLANs {
+			wlanName := *wlanConfig.Name
+			bssINTFName := toggle.bssINTFNames[wlanName]
+			if err := saveVLANFile(wlanConfig, natSSIDs, bssINTFName); err != nil {
+				return false, err
+			}
+			// hostapd only denies the stations in the deny list of the BSS configuration, so it also lists
+			// the stations still denied on the BSS by other agent features, e.g. the 802.1X blacklist.
+			acl := ssidMACACL(macACLs, wlanName)
+			allDenied := denyList.Reset(macACLOwner, map[string][]string{bssINTFName: acl.Deny})
+			acl = &ocutil.MACACL{Accept: acl.Accept, Deny: allDenied[bssINTFName]}
+			if err := saveMACACLFiles(acl, bssINTFName); err != nil {
+				return false, err
+			}
+
+			bssConfig := fmt.Sprintf(addedBSSConfigTemplate, bssINTFName)
+			if len(radiusAttribute) != 0 {
+				bssConfig += fmt.Sprintf(radiusAttributeSaveConfigTemplate, radiusAttribute)
+			}
+			seenOnBSS := ""
+			if !is5GHz {
+				seenOnBSS = steeredBSSs[wlanName]
+			}
+			bssConfig += wlanHostapdConfig(wlanConfig, bssINTFName, authServerConfigs, acctServerConfigs, steeringSSIDs, seenOnBSS, blacklistSSIDs, acl, natSSIDs[wlanName], hostname)
+
+			configFileName := hostapdConfFileName(bssINTFName)
+			if err := syscmd.SaveToFile(runFolder, configFileName, bssConfig); err != nil {
+				return false, err
+			}
+			if err := cmdRunner.AddBSS(radio.wlanINTFName, path.Join(runFolder, configFileName)); err != nil {
+				return false, err
+			}
+		}
+
+		// Keep the hostapd configuration file in sync, so that hostapd restarts with the same BSSs.
+		hostapdConfig := hostapdConfigFile(radio.config, authServerConfigs, acctServerConfigs, steeringSSIDs, steeredBSSs, blacklistSSIDs, macACLs, natSSIDs, toggle.wlanConfigs, radio.wlanINTFName, hostname, radiusAttribute)
+		if err := syscmd.SaveToFile(runFolder, hostapdConfFileName(radio.wlanINTFName), hostapdConfig); err != nil {
+			return false, err
+		}
+		log.Infof("Toggled SSIDs on %s. Removed BSSs: %v. Added WLANs: %d.", radio.wlanINTFName, toggle.removedBSSs, len(toggle.addedWLANs))
+	}
+	return true, nil
+}
+
+// bssToggles contains the BSSs to remove from and to add to a running radio.
+type bssToggles struct {
+	radio        *radioIntf
+	wlanConfigs  []*ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_Ssids_Ssid_Config
+	bssINTFNames map[string]string
+	removedBSSs  []string
+	addedWLANs   []*ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_Ssids_Ssid_Config
+}
+
+// radioBSSToggles finds the BSSs to remove and to add on a radio when SSIDs are enabled and disabled.
+// It returns false if the radio has to restart, i.e. the SSID running on the WLAN interface itself changes.
+func radioBSSToggles(appliedAP, apConfig *ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint, radio *radioIntf) (*bssToggles, bool) {
+	appliedBSSINTFNames := bssIntfNames(wlanWithOpFreq(appliedAP, radio.config.OperatingFrequency), radio.wlanINTFName)
+	wlanConfigs := wlanWithOpFreq(apConfig, radio.config.OperatingFrequency)
+	toggle := &bssToggles{
+		radio:        radio,
+		wlanConfigs:  wlanConfigs,
+		bssINTFNames: bssIntfNames(wlanConfigs, radio.wlanINTFName),
+	}
+	for _, wlanConfig := range wlanConfigs {
+		wlanName := *wlanConfig.Name
+		appliedBSSINTFName, wasEnabled := appliedBSSINTFNames[wlanName]
+		bssINTFName, enabled := toggle.bssINTFNames[wlanName]
+		if appliedBSSINTFName == radio.wlanINTFName || bssINTFName == radio.wlanINTFName {
+			if wasEnabled != enabled || appliedBSSINTFName != bssINTFName {
+				// The BSS on the WLAN interface itself cannot be removed, restart the radio instead.
+				return nil, false
+			}
+			continue
+		}
+		if wasEnabled && !enabled {
+			toggle.removedBSSs = append(toggle.removedBSSs, appliedBSSINTFName)
+		} else if !wasEnabled && enabled {
+			toggle.addedWLANs = append(toggle.addedWLANs, wlanConfig)
+		}
+	}
+	return toggle, true
+}
+
+// sameExceptSSIDEnabled checks whether two AP configurations only differ in the enabled flag of SSIDs.
+func sameExceptSSIDEnabled(apConfigA, apConfigB *ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint) bool {
+	if apConfigA == nil || apConfigB == nil {
+		return false
+	}
+	defer clearSSIDEnabled(apConfigA)()
+	defer clearSSIDEnabled(apConfigB)()
+	return reflect.DeepEqual(apConfigA, apConfigB)
+}
+
+// clearSSIDEnabled removes the enabled flag of all SSIDs in the AP configuration.
+// It returns a function restoring the flags.
+func clearSSIDEnabled(apConfig *ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint) func() {
+	enabledFlags := make(map[*ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_Ssids_Ssid_Config]*bool)
+	if apConfig.Ssids != nil {
+		for _, wlan := range apConfig.Ssids.Ssid {
+			if wlan.Config != nil {
+				enabledFlags[wlan.Config] = wlan.Config.Enabled
+				wlan.Config.Enabled = nil
+			}
+		}
+	}
+	return func() {
+		for wlanConfig, enabled := range enabledFlags {
+			wlanConfig.Enabled = enabled
+		}
+	}
+}
+
+// radiusAttributeSetting returns the saved RADIUS attribute configured in gasket.
+// The hostapd control interface configured in gasket is not rendered, hostapd always uses
+// the control interface of the agent, see ocutil.CheckCtrlInterface.
+func radiusAttributeSetting(gasketConfig *ocstruct.OpenconfigGasket_Gasket) string {
+	if gasketConfig != nil && gasketConfig.RadiusAttribute != nil {
+		return *gasketConfig.RadiusAttribute
+	}
+	return ""
 }
 
 // hostapdConfigFile generates the content of hostapd configuration file based on the given configuration.
 func hostapdConfigFile(radioConfig *ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_Radios_Radio_Config,
-	authServerConfigs map[string]*ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System_Aaa_ServerGroups_ServerGroup_Servers_Server,
+	authServerConfigs map[string][]*ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System_Aaa_ServerGroups_ServerGroup_Servers_Server,
+	acctServerConfigs []*ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System_Aaa_ServerGroups_ServerGroup_Servers_Server,
+	steeringSSIDs map[string]int8, steeredBSSs map[string]string, blacklistSSIDs map[string]ocutil.Dot1XBlacklist,
+	macACLs map[string]*ocutil.MACACL, natSSIDs map[string]*ocutil.NAT,
 	wlanConfigs []*ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_Ssids_Ssid_Config,
-	wlanINTFName string, hostname string, ctrlInterface string, radiusAttribute string) string {
+	wlanINTFName string, hostname string, radiusAttribute string) string {
 	log.Infof("Generating hostapd configuration for radio %v...", *radioConfig.Id)
 	hostapdConfig := ""
 
@@ -123,55 +480,164 @@ func hostapdConfigFile(radioConfig *ocstruct.OpenconfigAccessPoints_AccessPoints
 	radioHWMode := hostapdHardwareMode(radioConfig.OperatingFrequency)
 	commonConfig := fmt.Sprintf(commonConfigTemplate, wlanINTFName, radioHWMode, *radioConfig.Channel)
 
-	if len(ctrlInterface) != 0 {
-		commonConfig += fmt.Sprintf(ctrlInterfaceConfigTemplate, ctrlInterface)
-	}
 	if len(radiusAttribute) != 0 {
 		commonConfig += fmt.Sprintf(radiusAttributeSaveConfigTemplate, radiusAttribute)
 	}
 
-	hostapdConfig += commonConfig
-
 	// Generate wlan configuration.
-	for i, wlanConfig := range wlanConfigs {
+	bssINTFNames := bssIntfNames(wlanConfigs, wlanINTFName)
+	steering5GHz := radioConfig.OperatingFrequency == ocstruct.OpenconfigWifiTypes_OPERATING_FREQUENCY_FREQ_5GHZ
+	if steering5GHz {
+		for wlanName, bssINTFName := range bssINTFNames {
+			if steeredBSSs[wlanName] == bssINTFName {
+				commonConfig += fmt.Sprintf(staTrackConfigTemplate, steeringTrackedStations, steeringTrackAge)
+				break
+			}
+		}
+	}
+
+	hostapdConfig += commonConfig
+	for _, wlanConfig := range wlanConfigs {
 		wlanName := *wlanConfig.Name
+		bssINTFName, ok := bssINTFNames[wlanName]
+		if !ok {
+			log.Infof("Skipping disabled WLAN %v.", wlanName)
+			continue
+		}
 		log.Infof("Adding hostapd configuration for WLAN %v...", wlanName)
 
-		if i > 0 {
+		if bssINTFName != wlanINTFName {
 			// Add BSS configuration.
-			bssConfig := fmt.Sprintf(bssConfigTemplate, wlanINTFName, i)
-			hostapdConfig += bssConfig
+			hostapdConfig += fmt.Sprintf(bssConfigTemplate, bssINTFName)
 		}
 
-		// Add WLAN configuration.
-		wlanBridgeName := getBridgeName(int(*wlanConfig.DefaultVlan))
-
-		wlanStationIsolation := 0
-		if wlanConfig.StationIsolation != nil && *wlanConfig.StationIsolation {
-			wlanStationIsolation = 1
+		seenOnBSS := ""
+		if !steering5GHz {
+			seenOnBSS = steeredBSSs[wlanName]
 		}
+		hostapdConfig += wlanHostapdConfig(wlanConfig, bssINTFName, authServerConfigs, acctServerConfigs, steeringSSIDs, seenOnBSS, blacklistSSIDs, ssidMACACL(macACLs, wlanName), natSSIDs[wlanName], hostname)
+	}
+
+	log.Info("Generated hostapd configuration.")
+	return hostapdConfig
+}
+
+// wlanHostapdConfig generates the hostapd configuration of a WLAN running on the given BSS interface.
+// WLANs with NAT settings are bridged to their local subnet instead of their VLANs.
+// A 2.4 GHz BSS of a band steering SSID is hidden from the stations recently seen on seenOnBSS, if not empty.
+func wlanHostapdConfig(wlanConfig *ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_Ssids_Ssid_Config, bssINTFName string,
+	authServerConfigs map[string][]*ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System_Aaa_ServerGroups_ServerGroup_Servers_Server,
+	acctServerConfigs []*ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System_Aaa_ServerGroups_ServerGroup_Servers_Server,
+	steeringSSIDs map[string]int8, seenOnBSS string, blacklistSSIDs map[string]ocutil.Dot1XBlacklist, acl *ocutil.MACACL, nat *ocutil.NAT, hostname string) string {
+	wlanName := *wlanConfig.Name
+
+	// Add WLAN configuration.
+	wlanBridgeName := getBridgeName(int(*wlanConfig.DefaultVlan))
+	if nat != nil {
+		wlanBridgeName = natBridgeName(nat.Index)
+	}
+
+	l2Filter := ocutil.SSIDL2Filter(wlanConfig)
+	wlanStationIsolation := 0
+	if l2Filter.StationIsolation || l2Filter.ProxyARP() {
+		// hostapd only snoops the client traffic of isolated BSSs. Without station isolation, the
+		// clients still reach each other through the bridge, see package filter.
+		wlanStationIsolation = 1
+	}
+
+	hostapdConfig := fmt.Sprintf(wlanConfigTemplate, wlanName, wlanBridgeName, wlanStationIsolation)
+	if l2Filter.ProxyARP() {
+		hostapdConfig += proxyARPConfig
+	}
+
+	if wlanConfig.Hidden != nil && *wlanConfig.Hidden {
+		hostapdConfig += hiddenSSIDConfig
+	}
+	if wlanConfig.AdvertiseApname != nil && *wlanConfig.AdvertiseApname {
+		hostapdConfig += fmt.Sprintf(apNameConfigTemplate, apNameElement(hostname))
+	}
+
+	// Add MAC ACL configuration.
+	hostapdConfig += macACLConfig(acl, bssINTFName)
 
-		hostapdWLANConfig := fmt.Sprintf(wlanConfigTemplate, wlanName, wlanBridgeName, wlanStationIsolation)
-		hostapdConfig += hostapdWLANConfig
+	// Add dynamic VLAN configuration.
+	if len(wlanConfig.VlanList) != 0 && nat == nil {
+		hostapdConfig += fmt.Sprintf(dynamicVLANConfigTemplate, path.Join(runFolder, vlanFileName(bssINTFName)))
+	}
 
-		// Add AUTH configuration.
-		if wlanConfig.Opmode == ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_Ssids_Ssid_Config_Opmode_WPA2_ENTERPRISE {
-			// Add radius configuration.
-			authServerConfig := authServerConfigs[wlanName]
-			// TODO: Add validation to ensure authServerConfig exists.
-			radiusServerAddr := *authServerConfig.Address
-			radiusServerPort := *authServerConfig.Radius.Config.AuthPort
-			radiusSecret := *authServerConfig.Radius.Config.SecretKey
-			authConfig := fmt.Sprintf(authConfigTemplate, radiusServerAddr, radiusServerPort, radiusSecret, hostname)
-			hostapdConfig += authConfig
+	// Allow band steering to send BSS transition requests.
+	if _, ok := steeringSSIDs[wlanName]; ok {
+		hostapdConfig += bssTransitionConfig
+		if len(seenOnBSS) != 0 {
+			hostapdConfig += fmt.Sprintf(steeringConfigTemplate, seenOnBSS, seenOnBSS)
 		}
-		// TODO: Add validation to block WPA2_PERSONAL.
 	}
 
-	log.Info("Generated hostapd configuration.")
+	// Add AUTH configuration.
+	if wlanConfig.Opmode == ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_Ssids_Ssid_Config_Opmode_WPA2_ENTERPRISE {
+		// Add radius configuration.
+		authServers := authServerConfigs[wlanName]
+		// TODO: Add validation to ensure authServers exist.
+		hostapdConfig += fmt.Sprintf(authConfigTemplate, hostname)
+		if wlanConfig.GtkTimeout != nil {
+			hostapdConfig += fmt.Sprintf(gtkRekeyConfigTemplate, *wlanConfig.GtkTimeout)
+		}
+		if wlanConfig.PtkTimeout != nil {
+			hostapdConfig += fmt.Sprintf(ptkRekeyConfigTemplate, *wlanConfig.PtkTimeout)
+		}
+		if _, ok := blacklistSSIDs[wlanName]; ok {
+			hostapdConfig += fmt.Sprintf(maxAuthRoundsConfigTemplate, blacklistMaxAuthRounds)
+		}
+		hostapdConfig += radiusServersConfig(authServers, acctServerConfigs)
+	}
+	// TODO: Add validation to block WPA2_PERSONAL.
 	return hostapdConfig
 }
 
+// apNameElement generates the hex encoded vendor specific element advertising the AP hostname.
+func apNameElement(hostname string) string {
+	if len(hostname) > maxAPNameLength {
+		hostname = hostname[:maxAPNameLength]
+	}
+	return fmt.Sprintf("dd%02x%s%02x%x", 4+len(hostname), apNameOUI, apNameOUIType, hostname)
+}
+
+// radiusServersConfig generates the hostapd configuration of the given authentication and accounting servers.
+// The servers are listed in priority order, the first one is the primary server.
+func radiusServersConfig(authServers, acctServers []*ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System_Aaa_ServerGroups_ServerGroup_Servers_Server) string {
+	radiusConfig := ""
+	for _, authServer := range authServers {
+		radiusConfig += fmt.Sprintf(authServerConfigTemplate, *authServer.Address, *authServer.Radius.Config.AuthPort, *authServer.Radius.Config.SecretKey)
+	}
+	for _, acctServer := range acctServers {
+		acctPort := uint16(defaultRadiusAcctPort)
+		if acctServer.Radius.Config.AcctPort != nil {
+			acctPort = *acctServer.Radius.Config.AcctPort
+		}
+		radiusConfig += fmt.Sprintf(acctServerConfigTemplate, *acctServer.Address, acctPort, *acctServer.Radius.Config.SecretKey)
+	}
+
+	// hostapd has no per server timeout, the timeout of the primary server is used
+	// as the interval to switch back to it after a failover.
+	primaryTimeout := len(authServers) > 1 && authServers[0].Config != nil && authServers[0].Config.Timeout != nil
+	if primaryTimeout {
+		radiusConfig += fmt.Sprintf(radiusRetryConfigTemplate, *authServers[0].Config.Timeout)
+	}
+	for i, server := range authServers {
+		if server.Config != nil && server.Config.Timeout != nil && (i != 0 || !primaryTimeout) {
+			log.Warningf("Timeout of RADIUS server %s not applied, hostapd only uses the timeout of the primary server when there is a backup server.", *server.Address)
+		}
+	}
+	for _, servers := range [][]*ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System_Aaa_ServerGroups_ServerGroup_Servers_Server{authServers, acctServers} {
+		for _, server := range servers {
+			if server.Radius.Config.RetransmitAttempts != nil {
+				log.Warningf("Retransmit attempts of RADIUS server %s not applied, hostapd retransmits the requests on a fixed schedule.", *server.Address)
+			}
+		}
+	}
+	return radiusConfig
+}
+
 func hostapdHardwareMode(opFrequency ocstruct.E_OpenconfigWifiTypes_OPERATING_FREQUENCY) string {
 	if opFrequency == ocstruct.OpenconfigWifiTypes_OPERATING_FREQUENCY_FREQ_2GHZ ||
 		opFrequency == ocstruct.OpenconfigWifiTypes_OPERATING_FREQUENCY_FREQ_2_5_GHZ {
@@ -197,8 +663,70 @@ func wlanWithOpFreq(apConfig *ocstruct.OpenconfigAccessPoints_AccessPoints_Acces
 			matchedWLANs = append(matchedWLANs, wlanConfig)
 		}
 	}
+
+	// Keep the WLAN order stable, so each WLAN stays on the same BSS interface across configurations.
+	sort.Slice(matchedWLANs, func(i, j int) bool {
+		return *matchedWLANs[i].Name < *matchedWLANs[j].Name
+	})
 	return matchedWLANs
 }
 func hostapdConfFileName(wlanINTFName string) string {
 	return fmt.Sprintf("hostapd_%s.conf", wlanINTFName)
 }
+
+// bssIntfNames returns the BSS interface of each enabled WLAN (WLAN name -> BSS interface name).
+// The first enabled WLAN runs on the WLAN interface itself. The others are named after a hash of
+// their WLAN name, so adding, removing, enabling or disabling a WLAN keeps the interfaces of the
+// other WLANs unchanged. On a hash collision, the WLAN later in name order takes the next free name.
+func bssIntfNames(wlanConfigs []*ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_Ssids_Ssid_Config, wlanINTFName string) map[string]string {
+	bssINTFNames := make(map[string]string)
+	usedIDs := make(map[uint16]bool)
+	for _, wlanConfig := range wlanConfigs {
+		if !ocutil.SSIDEnabled(wlanConfig) {
+			continue
+		}
+		if len(bssINTFNames) == 0 {
+			bssINTFNames[*wlanConfig.Name] = wlanINTFName
+			continue
+		}
+		id := bssID(*wlanConfig.Name)
+		for usedIDs[id] {
+			id++
+		}
+		usedIDs[id] = true
+		bssINTFNames[*wlanConfig.Name] = fmt.Sprintf("%s_%04x", wlanINTFName, id)
+	}
+	return bssINTFNames
+}
+
+// bssID hashes a WLAN name into the suffix of its BSS interface name.
+// Interface names are limited to 15 characters, hence the 16-bit hash.
+func bssID(wlanName string) uint16 {
+	h := fnv.New32a()
+	h.Write([]byte(wlanName))
+	sum := h.Sum32()
+	return uint16(sum>>16) ^ uint16(sum)
+}
+
+func vlanFileName(bssINTFName string) string {
+	return fmt.Sprintf("hostapd_%s.vlan", bssINTFName)
+}
+
+// saveVLANFile saves the VLAN file of a BSS if its WLAN allows dynamic VLAN.
+// WLANs on a local NAT subnet have no dynamic VLAN.
+func saveVLANFile(wlanConfig *ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_Ssids_Ssid_Config, natSSIDs map[string]*ocutil.NAT, bssINTFName string) error {
+	if _, ok := natSSIDs[*wlanConfig.Name]; ok || len(wlanConfig.VlanList) == 0 {
+		return nil
+	}
+	return syscmd.SaveToFile(runFolder, vlanFileName(bssINTFName), vlanFile(wlanConfig, bssINTFName))
+}
+
+// vlanFile generates the content of hostapd VLAN file of a BSS.
+// Each VLAN the SSID allows gets a "<BSS intf>.<VLAN ID>" interface in the VLAN bridge.
+func vlanFile(wlanConfig *ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_Ssids_Ssid_Config, bssINTFName string) string {
+	content := ""
+	for _, vlanID := range ocutil.SSIDVLANIDs(wlanConfig) {
+		content += fmt.Sprintf(vlanFileEntryTemplate, vlanID, bssINTFName, vlanID, getBridgeName(vlanID))
+	}
+	return content
+}
@@ -34,8 +34,8 @@ func configEthIntf(ethIntfName string, vlanIDs []int) error {
 		vlanIntfNames[vlanID] = vlanIntfName
 	}
 
-	// Restart eth interface.
-	if err := cmdRunner.RestartIntf(ethIntfName); err != nil {
+	// Bring up eth interface. Restarting it would drop the routes of the management interface.
+	if err := cmdRunner.BringUpIntf(ethIntfName); err != nil {
 		return err
 	}
 
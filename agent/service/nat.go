@@ -0,0 +1,219 @@
+/* Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"fmt"
+	"net"
+	"path"
+	"sort"
+	"strings"
+
+	log "github.com/golang/glog"
+	"github.com/google/link022/agent/syscmd"
+	"github.com/google/link022/agent/util/ocutil"
+)
+
+const (
+	// natBridgePrefix starts the name of the local bridge of each NAT SSID, e.g. "br_nat0".
+	natBridgePrefix = "br_nat"
+
+	natTableFamily   = "ip"
+	natTableName     = "link022_nat"
+	natRulesFileName = "nat.nft"
+
+	dnsmasqConfFileName  = "dnsmasq.conf"
+	dnsmasqLeaseFileName = "dnsmasq.leases"
+
+	// dnsmasqConfigTemplate only serves DHCP and DNS on the local bridges of NAT SSIDs.
+	// Clients get the bridge address as their router and DNS server.
+	dnsmasqConfigTemplate = `bind-interfaces
+except-interface=lo
+dhcp-authoritative
+dhcp-leasefile=%s
+`
+	dnsmasqSubnetConfigTemplate = `interface=%s
+dhcp-range=%s,%s,%s,%d
+`
+)
+
+// privateSubnets are the destinations NAT clients cannot reach, which keeps them away from the management LAN.
+var privateSubnets = []string{"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "169.254.0.0/16"}
+
+// configNAT puts the NAT SSIDs on their local subnets. Each subnet gets a bridge holding the gateway address,
+// served by a dnsmasq process for DHCP and DNS. Client traffic is masqueraded to the management interface.
+// Clients can reach the captive portal of their SSID on the AP.
+func configNAT(natSSIDs map[string]*ocutil.NAT, portals map[string]*ocutil.CaptivePortal, ethIntfName string) error {
+	if len(natSSIDs) == 0 {
+		return nil
+	}
+	log.Infof("Configuring NAT of SSIDs %v.", natSSIDNames(natSSIDs))
+
+	for _, nat := range sortedNATs(natSSIDs) {
+		bridgeName := natBridgeName(nat.Index)
+		if err := cmdRunner.CreateBridge(bridgeName); err != nil {
+			return err
+		}
+		if err := cmdRunner.SetIntfIP(bridgeName, gatewayPrefix(nat)); err != nil {
+			return err
+		}
+		if err := cmdRunner.BringUpIntf(bridgeName); err != nil {
+			return err
+		}
+	}
+
+	if err := cmdRunner.EnableIPForwarding(); err != nil {
+		return err
+	}
+	if err := syscmd.SaveToFile(runFolder, natRulesFileName, natRuleset(natSSIDs, portals, ethIntfName)); err != nil {
+		return err
+	}
+	if err := cmdRunner.ApplyNftRules(path.Join(runFolder, natRulesFileName)); err != nil {
+		return err
+	}
+
+	if err := syscmd.SaveToFile(runFolder, dnsmasqConfFileName, dnsmasqConfig(natSSIDs)); err != nil {
+		return err
+	}
+	if err := cmdRunner.StartDnsmasq(path.Join(runFolder, dnsmasqConfFileName)); err != nil {
+		return err
+	}
+
+	log.Info("Configured NAT.")
+	return nil
+}
+
+// cleanupNAT stops dnsmasq and removes the NAT rules and the local bridges of NAT SSIDs.
+// It goes through all cleanup steps even if some failures are detected, and returns all errors.
+func cleanupNAT() []error {
+	bridges, err := cmdRunner.Bridges()
+	if err != nil {
+		return []error{err}
+	}
+	var natBridges []string
+	for _, bridgeName := range bridges {
+		if strings.HasPrefix(bridgeName, natBridgePrefix) {
+			natBridges = append(natBridges, bridgeName)
+		}
+	}
+	if len(natBridges) == 0 {
+		// NAT is not configured.
+		return nil
+	}
+
+	log.Infof("Cleaning up NAT. Bridges: %v.", natBridges)
+	var errs []error
+	if err := cmdRunner.StopDnsmasq(path.Join(runFolder, dnsmasqConfFileName)); err != nil {
+		errs = append(errs, err)
+	}
+	if err := cmdRunner.DeleteNftTable(natTableFamily, natTableName); err != nil {
+		errs = append(errs, err)
+	}
+	for _, bridgeName := range natBridges {
+		if err := cmdRunner.TurnDownIntf(bridgeName); err != nil {
+			errs = append(errs, err)
+		}
+		if err := cmdRunner.DeleteBridge(bridgeName); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	log.Infof("Cleaned up NAT. Number of errors = %d.", len(errs))
+	return errs
+}
+
+// dnsmasqConfig generates the dnsmasq configuration serving the local subnets of NAT SSIDs.
+func dnsmasqConfig(natSSIDs map[string]*ocutil.NAT) string {
+	config := fmt.Sprintf(dnsmasqConfigTemplate, path.Join(runFolder, dnsmasqLeaseFileName))
+	for _, nat := range sortedNATs(natSSIDs) {
+		first, last := nat.DHCPRange()
+		config += fmt.Sprintf(dnsmasqSubnetConfigTemplate, natBridgeName(nat.Index), first, last,
+			net.IP(nat.Subnet.Mask), int(nat.LeaseTime.Seconds()))
+	}
+	return config
+}
+
+// natRuleset generates the nftables rules of NAT SSIDs. Clients only reach the AP for DHCP, DNS, ping and
+// the captive portal of their SSID, and only reach public destinations through the management interface.
+// Their traffic is masqueraded.
+func natRuleset(natSSIDs map[string]*ocutil.NAT, portals map[string]*ocutil.CaptivePortal, ethIntfName string) string {
+	var bridgeNames, subnets, portalRules []string
+	for _, wlanName := range natSSIDNames(natSSIDs) {
+		nat := natSSIDs[wlanName]
+		if portal, ok := portals[wlanName]; ok {
+			portalRules = append(portalRules, fmt.Sprintf("\t\tiifname %q tcp dport %d accept\n", natBridgeName(nat.Index), portal.Port))
+		}
+	}
+	for _, nat := range sortedNATs(natSSIDs) {
+		bridgeNames = append(bridgeNames, fmt.Sprintf("%q", natBridgeName(nat.Index)))
+		subnets = append(subnets, nat.Subnet.String())
+	}
+	bridges := fmt.Sprintf("{ %s }", strings.Join(bridgeNames, ", "))
+
+	rules := fmt.Sprintf("table %s %s\ndelete table %s %s\n\n", natTableFamily, natTableName, natTableFamily, natTableName)
+	rules += fmt.Sprintf("table %s %s {\n", natTableFamily, natTableName)
+
+	rules += "\tchain input {\n\t\ttype filter hook input priority 0; policy accept;\n"
+	rules += fmt.Sprintf("\t\tiifname %s udp dport { 53, 67 } accept\n", bridges)
+	rules += fmt.Sprintf("\t\tiifname %s tcp dport 53 accept\n", bridges)
+	rules += fmt.Sprintf("\t\tiifname %s icmp type echo-request accept\n", bridges)
+	rules += strings.Join(portalRules, "")
+	rules += fmt.Sprintf("\t\tiifname %s drop\n", bridges)
+	rules += "\t}\n\n"
+
+	rules += "\tchain forward {\n\t\ttype filter hook forward priority 0; policy accept;\n"
+	rules += fmt.Sprintf("\t\tiifname %s oifname != %q drop\n", bridges, ethIntfName)
+	rules += fmt.Sprintf("\t\tiifname %s ip daddr { %s } drop\n", bridges, strings.Join(privateSubnets, ", "))
+	rules += fmt.Sprintf("\t\toifname %s ct state established,related accept\n", bridges)
+	rules += fmt.Sprintf("\t\toifname %s drop\n", bridges)
+	rules += "\t}\n\n"
+
+	rules += "\tchain postrouting {\n\t\ttype nat hook postrouting priority 100; policy accept;\n"
+	rules += fmt.Sprintf("\t\toifname %q ip saddr { %s } masquerade\n", ethIntfName, strings.Join(subnets, ", "))
+	rules += "\t}\n}\n"
+	return rules
+}
+
+// sortedNATs returns the NAT settings of SSIDs in index order.
+func sortedNATs(natSSIDs map[string]*ocutil.NAT) []*ocutil.NAT {
+	var nats []*ocutil.NAT
+	for _, nat := range natSSIDs {
+		nats = append(nats, nat)
+	}
+	sort.Slice(nats, func(i, j int) bool {
+		return nats[i].Index < nats[j].Index
+	})
+	return nats
+}
+
+func natSSIDNames(natSSIDs map[string]*ocutil.NAT) []string {
+	var names []string
+	for wlanName := range natSSIDs {
+		names = append(names, wlanName)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// gatewayPrefix returns the gateway address of a NAT subnet with its prefix length, e.g. "192.168.50.1/24".
+func gatewayPrefix(nat *ocutil.NAT) string {
+	ones, _ := nat.Subnet.Mask.Size()
+	return fmt.Sprintf("%s/%d", nat.Gateway, ones)
+}
+
+func natBridgeName(index int) string {
+	return fmt.Sprintf("%s%d", natBridgePrefix, index)
+}
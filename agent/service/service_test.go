@@ -20,17 +20,21 @@ import (
 	"io/ioutil"
 	"path"
 	"reflect"
+	"strings"
 	"testing"
 
+	"github.com/google/link022/agent/denylist"
 	"github.com/google/link022/agent/syscmd"
 	"github.com/google/link022/agent/util/mock"
 	"github.com/google/link022/agent/util/ocutil"
 	"github.com/google/link022/generated/ocstruct"
+	"github.com/openconfig/ygot/ygot"
 )
 
 var (
 	testETHIntf            = "eth0"
 	testWLANIntf           = "wlan0"
+	testWLAN2Intf          = "wlan1"
 	testWLANIntfOriginMAC  = "aa:bb:cc:dd:ee:ff"
 	testWLANIntfUpdatedMAC = "02:bb:cc:dd:ee:f0"
 
@@ -45,6 +49,7 @@ type systemState struct {
 	IntfMACs   map[string]string   // interface name -> MAC address
 	NetworkBRs map[string][]string // bridge name -> linked interfaces
 	Hostapds   map[string]bool     // hostapd config file path -> started
+	Dnsmasqs   map[string]bool     // dnsmasq config file path -> started
 }
 
 type commandError struct {
@@ -87,9 +92,23 @@ func executeMockCommand(wait bool, cmd string, args ...string) (string, error) {
 			}
 			delete(testSystemState.Intfs, vlanIntfName)
 			return "", nil
+		// Set the IP of an interface
+		case len(args) == 5 && args[0] == "addr" && args[1] == "replace" && args[3] == "dev":
+			intfName := args[4]
+			if _, ok := testSystemState.Intfs[intfName]; !ok {
+				return fmt.Sprintf("Interface %v not found.\n", intfName), &commandError{2}
+			}
+			return "", nil
 		}
 	case "brctl":
 		switch {
+		case len(args) == 1 && args[0] == "show":
+			// List bridges
+			bridgeInfo := "bridge name\tbridge id\t\tSTP enabled\tinterfaces\n"
+			for brName, intfs := range testSystemState.NetworkBRs {
+				bridgeInfo += fmt.Sprintf("%s\t\t8000.000000000000\tno\t\t%s\n", brName, strings.Join(intfs, "\n\t\t\t\t\t\t\t"))
+			}
+			return bridgeInfo, nil
 		case len(args) == 2 && args[0] == "addbr":
 			// Add bridge
 			brName := args[1]
@@ -146,19 +165,40 @@ func executeMockCommand(wait bool, cmd string, args ...string) (string, error) {
 			return "", nil
 		}
 	case "hostapd":
-		if len(args) == 1 {
-			hostapdConfigFile := args[0]
-			if _, ok := testSystemState.Hostapds[hostapdConfigFile]; ok {
-				return fmt.Sprintf("Hostapd with config file %v already started.\n", hostapdConfigFile), &commandError{2}
+		if len(args) >= 3 && args[0] == "-g" {
+			for _, hostapdConfigFile := range args[2:] {
+				if _, ok := testSystemState.Hostapds[hostapdConfigFile]; ok {
+					return fmt.Sprintf("Hostapd with config file %v already started.\n", hostapdConfigFile), &commandError{2}
+				}
+				testSystemState.Hostapds[hostapdConfigFile] = true
 			}
-			testSystemState.Hostapds[hostapdConfigFile] = true
 			return "", nil
 		}
 	case "cat":
-		if len(args) == 1 && args[0] == fmt.Sprintf("/sys/class/net/%s/address", testWLANIntf) {
-			return testWLANIntfOriginMAC + "\n", nil
+		for _, intfName := range []string{testWLANIntf, testWLAN2Intf} {
+			if len(args) == 1 && args[0] == fmt.Sprintf("/sys/class/net/%s/address", intfName) {
+				return testWLANIntfOriginMAC + "\n", nil
+			}
+		}
+	case "dnsmasq":
+		if len(args) == 5 && args[0] == "-k" && args[1] == "-C" && args[3] == "-x" {
+			dnsmasqConfigFile := args[2]
+			if _, ok := testSystemState.Dnsmasqs[dnsmasqConfigFile]; ok {
+				return fmt.Sprintf("dnsmasq with config file %v already started.\n", dnsmasqConfigFile), &commandError{2}
+			}
+			testSystemState.Dnsmasqs[dnsmasqConfigFile] = true
+			return "", nil
 		}
-	case "udhcpc":
+	case "pkill":
+		if len(args) == 4 && args[0] == "-F" && args[3] == "dnsmasq" {
+			dnsmasqConfigFile := strings.TrimSuffix(args[1], ".pid") + ".conf"
+			if _, ok := testSystemState.Dnsmasqs[dnsmasqConfigFile]; !ok {
+				return "", &commandError{1}
+			}
+			delete(testSystemState.Dnsmasqs, dnsmasqConfigFile)
+			return "", nil
+		}
+	case "sysctl", "nft", "udhcpc":
 		return "", nil
 	case "killall":
 		if len(args) == 2 && args[1] == "hostapd" {
@@ -177,6 +217,7 @@ func TestApplyConfig(t *testing.T) {
 	// Define test cases.
 	type testCase struct {
 		apConfig            *ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint
+		gasketConfig        *ocstruct.OpenconfigGasket_Gasket
 		expectedSystemState *systemState
 		expectedError       error
 	}
@@ -208,6 +249,59 @@ func TestApplyConfig(t *testing.T) {
 				Hostapds: map[string]bool{
 					testWLANHostapdConfigFile: true,
 				},
+				Dnsmasqs: map[string]bool{},
+			},
+			expectedError: nil,
+		},
+		"TestConfigWithDynamicVLAN": {
+			apConfig: dynamicVLANConfig(),
+			expectedSystemState: &systemState{
+				Intfs: map[string]bool{
+					testETHIntf:  true,
+					testWLANIntf: true,
+					"eth0.300":   true,
+					"eth0.666":   true,
+					"br_300":     true,
+					"br_666":     true,
+				},
+				IntfMACs: map[string]string{
+					testWLANIntf: testWLANIntfUpdatedMAC,
+				},
+				NetworkBRs: map[string][]string{
+					"br_300": {"eth0.300"},
+					"br_666": {"eth0.666"},
+				},
+				Hostapds: map[string]bool{
+					testWLANHostapdConfigFile: true,
+				},
+				Dnsmasqs: map[string]bool{},
+			},
+			expectedError: nil,
+		},
+		"TestConfigWithNATSSID": {
+			apConfig:     mock.GenerateAPConfig(true),
+			gasketConfig: natGasketConfig(t),
+			expectedSystemState: &systemState{
+				Intfs: map[string]bool{
+					testETHIntf:  true,
+					testWLANIntf: true,
+					"eth0.250":   true,
+					"br_250":     true,
+					"br_nat0":    true,
+				},
+				IntfMACs: map[string]string{
+					testWLANIntf: testWLANIntfUpdatedMAC,
+				},
+				NetworkBRs: map[string][]string{
+					"br_250":  {"eth0.250"},
+					"br_nat0": {},
+				},
+				Hostapds: map[string]bool{
+					testWLANHostapdConfigFile: true,
+				},
+				Dnsmasqs: map[string]bool{
+					path.Join(tempRunFolder, "dnsmasq.conf"): true,
+				},
 			},
 			expectedError: nil,
 		},
@@ -229,6 +323,7 @@ func TestApplyConfig(t *testing.T) {
 				Hostapds: map[string]bool{
 					testWLANHostapdConfigFile: true,
 				},
+				Dnsmasqs: map[string]bool{},
 			},
 			expectedError: nil,
 		},
@@ -247,22 +342,108 @@ func TestApplyConfig(t *testing.T) {
 		// Clean up the test system state.
 		testSystemState = cleanedSysteState()
 
-		err := ApplyConfig(test.apConfig, nil, true, testETHIntf, testWLANIntf)
+		err := ApplyConfig(test.apConfig, test.gasketConfig, true, testETHIntf, []string{testWLANIntf})
 		checkResult(t, testName, err, test.expectedError)
 		checkResult(t, testName, testSystemState, test.expectedSystemState)
 	}
 }
 
+func TestApplyConfigWithTwoRadios(t *testing.T) {
+	tempRunFolder, err := ioutil.TempDir("", "link022")
+	if err != nil {
+		t.Fatalf("Unable to create a temp run time folder. Skip all tests.")
+	}
+	cmdRunner = &syscmd.CommandRunner{ExecCommand: executeMockCommand}
+	originalRunFolder := runFolder
+	runFolder = tempRunFolder
+	defer func() {
+		cmdRunner = syscmd.Runner()
+		runFolder = originalRunFolder
+	}()
+
+	// The dual-band SSIDs run on both radios.
+	apConfig := mock.GenerateAPConfig(true)
+	radio, err := apConfig.Radios.NewRadio(2)
+	if err != nil {
+		t.Fatalf("Unable to add the second radio. Error: %v.", err)
+	}
+	radio.Config = &ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_Radios_Radio_Config{
+		Id:                 ygot.Uint8(2),
+		Enabled:            ygot.Bool(true),
+		OperatingFrequency: ocstruct.OpenconfigWifiTypes_OPERATING_FREQUENCY_FREQ_5GHZ,
+		Channel:            ygot.Uint8(36),
+	}
+
+	testSystemState = cleanedSysteState()
+	if err := ApplyConfig(apConfig, nil, true, testETHIntf, []string{testWLANIntf}); err == nil {
+		t.Error("Expected an error with a WLAN interface missing.")
+	}
+
+	testSystemState = cleanedSysteState()
+	testSystemState.Intfs[testWLAN2Intf] = true
+	if err := ApplyConfig(apConfig, nil, true, testETHIntf, []string{testWLANIntf, testWLAN2Intf}); err != nil {
+		t.Fatalf("Applying the configuration failed. Error: %v.", err)
+	}
+	wantHostapds := map[string]bool{
+		path.Join(tempRunFolder, "hostapd_wlan0.conf"): true,
+		path.Join(tempRunFolder, "hostapd_wlan1.conf"): true,
+	}
+	checkResult(t, "Hostapds", testSystemState.Hostapds, wantHostapds)
+	checkResult(t, "IntfMACs", testSystemState.IntfMACs[testWLAN2Intf], testWLANIntfUpdatedMAC)
+
+	config, err := ioutil.ReadFile(path.Join(tempRunFolder, "hostapd_wlan1.conf"))
+	if err != nil {
+		t.Fatalf("Unable to read the hostapd configuration of the second radio. Error: %v.", err)
+	}
+	for _, want := range []string{"interface=wlan1\n", "hw_mode=a\nchannel=36\n", "bss=wlan1_9b2c\n"} {
+		if !strings.Contains(string(config), want) {
+			t.Errorf("Missing %q in hostapd configuration:\n%s", want, config)
+		}
+	}
+}
+
+// dynamicVLANConfig generates an AP configuration with one SSID allowing dynamic VLANs.
+func dynamicVLANConfig() *ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint {
+	apConfig := mock.GenerateAPConfig(false)
+	apConfig.Ssids.Ssid[mock.GuestWLANName].Config.VlanList = []uint16{300}
+	return apConfig
+}
+
+// natGasketConfig generates a gasket configuration putting the guest SSID on a NAT subnet.
+func natGasketConfig(t *testing.T) *ocstruct.OpenconfigGasket_Gasket {
+	gasketConfig := &ocstruct.OpenconfigGasket_Gasket{NatSsids: &ocstruct.OpenconfigGasket_Gasket_NatSsids{}}
+	guestNAT, err := gasketConfig.NatSsids.NewSsid(mock.GuestWLANName)
+	if err != nil {
+		t.Fatalf("Unable to create the NAT settings of %s. Error: %v.", mock.GuestWLANName, err)
+	}
+	guestNAT.GatewayAddress = ygot.String("192.168.50.1/24")
+	return gasketConfig
+}
+
+func TestVLANFile(t *testing.T) {
+	wlanConfig := dynamicVLANConfig().Ssids.Ssid[mock.GuestWLANName].Config
+	want := "666 wlan0_9b2c.666 br_666\n300 wlan0_9b2c.300 br_300\n"
+	if got := vlanFile(wlanConfig, "wlan0_9b2c"); got != want {
+		t.Errorf("Incorrect VLAN file (got: %q, want: %q).", got, want)
+	}
+}
+
 func TestCleanupConfig(t *testing.T) {
 	// Define test cases.
 	tests := []struct {
 		apConfig       *ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint
+		gasketConfig   *ocstruct.OpenconfigGasket_Gasket
 		configRequired bool
 		succeeded      bool
 	}{{
 		apConfig:       mock.GenerateAPConfig(true),
 		configRequired: true,
 		succeeded:      true,
+	}, {
+		apConfig:       mock.GenerateAPConfig(true),
+		gasketConfig:   natGasketConfig(t),
+		configRequired: true,
+		succeeded:      true,
 	}, {
 		apConfig:       mock.GenerateAPConfig(false),
 		configRequired: true,
@@ -297,14 +478,14 @@ func TestCleanupConfig(t *testing.T) {
 		testName := fmt.Sprintf("TestCleanupConfig_%d", i)
 
 		if test.configRequired {
-			if err := ApplyConfig(test.apConfig, nil, true, testETHIntf, testWLANIntf); err != nil {
+			if err := ApplyConfig(test.apConfig, test.gasketConfig, true, testETHIntf, []string{testWLANIntf}); err != nil {
 				t.Errorf("[%s] Configuration failed. Error: %v.", testName, err)
 			}
 			// Clean up does not restore the MAC address.
 			cleanedSystemState.IntfMACs[testWLANIntf] = testWLANIntfUpdatedMAC
 		}
 
-		errs := CleanupConfig(testETHIntf, ocutil.VLANIDs(test.apConfig))
+		errs := CleanupConfig(testETHIntf, ocutil.UplinkVLANIDs(test.apConfig, ocutil.NATSSIDs(test.gasketConfig)))
 		checkResult(t, testName, len(errs) == 0, test.succeeded)
 		checkResult(t, testName, testSystemState, cleanedSystemState)
 	}
@@ -321,6 +502,7 @@ func cleanedSysteState() *systemState {
 		},
 		NetworkBRs: map[string][]string{},
 		Hostapds:   map[string]bool{},
+		Dnsmasqs:   map[string]bool{},
 	}
 }
 
@@ -329,3 +511,508 @@ func checkResult(t *testing.T, testName string, got, want interface{}) {
 		t.Errorf("[%v] the test result is not correct (got: %v, want: %v)", testName, got, want)
 	}
 }
+
+func TestRadiusServersConfig(t *testing.T) {
+	primary := mock.RadiusServer()
+	primary.Config.Timeout = ygot.Uint16(30)
+	backup := mock.RadiusServer()
+	backup.Address = ygot.String("10.0.0.2")
+	backup.Radius.Config.AcctPort = ygot.Uint16(1646)
+
+	// Define test cases.
+	tests := []struct {
+		authServers []*ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System_Aaa_ServerGroups_ServerGroup_Servers_Server
+		acctServers []*ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System_Aaa_ServerGroups_ServerGroup_Servers_Server
+		config      string
+	}{{
+		authServers: []*ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System_Aaa_ServerGroups_ServerGroup_Servers_Server{primary},
+		config:      fmt.Sprintf(authServerConfigTemplate, *primary.Address, 1812, "radiuspwd"),
+	}, {
+		authServers: []*ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System_Aaa_ServerGroups_ServerGroup_Servers_Server{primary, backup},
+		acctServers: []*ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System_Aaa_ServerGroups_ServerGroup_Servers_Server{primary, backup},
+		config: fmt.Sprintf(authServerConfigTemplate, *primary.Address, 1812, "radiuspwd") +
+			fmt.Sprintf(authServerConfigTemplate, "10.0.0.2", 1812, "radiuspwd") +
+			fmt.Sprintf(acctServerConfigTemplate, *primary.Address, defaultRadiusAcctPort, "radiuspwd") +
+			fmt.Sprintf(acctServerConfigTemplate, "10.0.0.2", 1646, "radiuspwd") +
+			fmt.Sprintf(radiusRetryConfigTemplate, 30),
+	}}
+
+	for _, test := range tests {
+		if got := radiusServersConfig(test.authServers, test.acctServers); got != test.config {
+			t.Errorf("Incorrect radius configuration (got: %q, want: %q).", got, test.config)
+		}
+	}
+}
+
+func TestRekeyConfig(t *testing.T) {
+	apConfig := mock.GenerateAPConfig(true)
+	authWLANConfig := apConfig.Ssids.Ssid[mock.AuthWLANName].Config
+	authWLANConfig.GtkTimeout = ygot.Uint16(3600)
+	authWLANConfig.PtkTimeout = ygot.Uint16(600)
+	radioConfig := apConfig.Radios.Radio[1].Config
+
+	config := hostapdConfigFile(radioConfig, ocutil.RadiusServers(apConfig), nil, nil, nil, nil, nil, nil,
+		wlanWithOpFreq(apConfig, radioConfig.OperatingFrequency), testWLANIntf, *apConfig.Hostname, "")
+	for _, want := range []string{"wpa_group_rekey=3600\n", "wpa_ptk_rekey=600\n"} {
+		if !strings.Contains(config, want) {
+			t.Errorf("Missing %q in hostapd configuration:\n%s", want, config)
+		}
+	}
+	if strings.Contains(config, "max_auth_rounds=") {
+		t.Errorf("Unexpected EAP round limit without failure limit:\n%s", config)
+	}
+}
+
+func TestMaxAuthRoundsConfig(t *testing.T) {
+	apConfig := mock.GenerateAPConfig(true)
+	authWLAN := apConfig.Ssids.Ssid[mock.AuthWLANName]
+	authWLAN.Dot1XTimers = &ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_Ssids_Ssid_Dot1XTimers{
+		Config: &ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_Ssids_Ssid_Dot1XTimers_Config{MaxAuthFailures: ygot.Uint8(3)},
+	}
+	radioConfig := apConfig.Radios.Radio[1].Config
+
+	config := hostapdConfigFile(radioConfig, ocutil.RadiusServers(apConfig), nil, nil, nil, ocutil.Dot1XBlacklistSSIDs(apConfig), nil, nil,
+		wlanWithOpFreq(apConfig, radioConfig.OperatingFrequency), testWLANIntf, *apConfig.Hostname, "")
+	authConfig := config[strings.Index(config, "ssid="+mock.AuthWLANName):]
+	if i := strings.Index(authConfig, "\nbss="); i != -1 {
+		authConfig = authConfig[:i]
+	}
+	if want := fmt.Sprintf("max_auth_rounds=%d\n", blacklistMaxAuthRounds); !strings.Contains(authConfig, want) {
+		t.Errorf("Missing %q in the hostapd configuration of %s:\n%s", want, mock.AuthWLANName, config)
+	}
+	if strings.Count(config, "max_auth_rounds=") != 1 {
+		t.Errorf("Expected the EAP round limit only on %s:\n%s", mock.AuthWLANName, config)
+	}
+}
+
+func TestBandSteeringConfig(t *testing.T) {
+	apConfig := mock.GenerateAPConfig(true)
+	apConfig.Ssids.Ssid[mock.GuestWLANName].BandSteering = &ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_Ssids_Ssid_BandSteering{
+		Config: &ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_Ssids_Ssid_BandSteering_Config{BandSteering: ygot.Bool(true)},
+	}
+	radio2Config := &ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_Radios_Radio_Config{
+		Id:                 ygot.Uint8(2),
+		OperatingFrequency: ocstruct.OpenconfigWifiTypes_OPERATING_FREQUENCY_FREQ_5GHZ,
+		Channel:            ygot.Uint8(36),
+	}
+	radios := []*radioIntf{
+		{config: apConfig.Radios.Radio[1].Config, wlanINTFName: testWLANIntf},
+		{config: radio2Config, wlanINTFName: testWLAN2Intf},
+	}
+
+	steeredBSSs := steeringBSSs(apConfig, radios)
+	want5GHzBSS := bssIntfNames(wlanWithOpFreq(apConfig, radio2Config.OperatingFrequency), testWLAN2Intf)[mock.GuestWLANName]
+	if got := steeredBSSs; !reflect.DeepEqual(got, map[string]string{mock.GuestWLANName: want5GHzBSS}) {
+		t.Fatalf("Incorrect band steering BSSs (got: %v, want: %s on %s).", got, mock.GuestWLANName, want5GHzBSS)
+	}
+
+	steeringSSIDs := ocutil.BandSteeringSSIDs(apConfig)
+	config24GHz := hostapdConfigFile(radios[0].config, ocutil.RadiusServers(apConfig), nil, steeringSSIDs, steeredBSSs, nil, nil, nil,
+		wlanWithOpFreq(apConfig, radios[0].config.OperatingFrequency), testWLANIntf, "ap", "")
+	guestConfig := config24GHz[strings.Index(config24GHz, "ssid="+mock.GuestWLANName):]
+	if i := strings.Index(guestConfig, "\nbss="); i != -1 {
+		guestConfig = guestConfig[:i]
+	}
+	if want := fmt.Sprintf("no_probe_resp_if_seen_on=%s\nno_auth_if_seen_on=%s\n", want5GHzBSS, want5GHzBSS); !strings.Contains(guestConfig, want) {
+		t.Errorf("Missing %q in the 2.4 GHz hostapd configuration of %s:\n%s", want, mock.GuestWLANName, config24GHz)
+	}
+	if strings.Count(config24GHz, "no_probe_resp_if_seen_on=") != 1 || strings.Contains(config24GHz, "track_sta_max_num=") {
+		t.Errorf("Expected the 2.4 GHz BSS of %s only to be hidden:\n%s", mock.GuestWLANName, config24GHz)
+	}
+
+	config5GHz := hostapdConfigFile(radio2Config, ocutil.RadiusServers(apConfig), nil, steeringSSIDs, steeredBSSs, nil, nil, nil,
+		wlanWithOpFreq(apConfig, radio2Config.OperatingFrequency), testWLAN2Intf, "ap", "")
+	if want := fmt.Sprintf("track_sta_max_num=%d\ntrack_sta_max_age=%d\n", steeringTrackedStations, steeringTrackAge); !strings.Contains(config5GHz, want) {
+		t.Errorf("Missing %q in the 5 GHz hostapd configuration:\n%s", want, config5GHz)
+	}
+	if strings.Contains(config5GHz, "no_probe_resp_if_seen_on=") {
+		t.Errorf("Unexpected hidden 5 GHz BSS:\n%s", config5GHz)
+	}
+}
+
+func TestSSIDFlagsConfig(t *testing.T) {
+	apConfig := mock.GenerateAPConfig(true)
+	authWLANConfig := apConfig.Ssids.Ssid[mock.AuthWLANName].Config
+	authWLANConfig.Enabled = ygot.Bool(false)
+	guestWLANConfig := apConfig.Ssids.Ssid[mock.GuestWLANName].Config
+	guestWLANConfig.Hidden = ygot.Bool(true)
+	guestWLANConfig.AdvertiseApname = ygot.Bool(true)
+	radioConfig := apConfig.Radios.Radio[1].Config
+
+	config := hostapdConfigFile(radioConfig, ocutil.RadiusServers(apConfig), nil, nil, nil, nil, nil, nil,
+		wlanWithOpFreq(apConfig, radioConfig.OperatingFrequency), testWLANIntf, "ap", "")
+	if strings.Contains(config, "ssid="+mock.AuthWLANName) {
+		t.Errorf("Disabled SSID in hostapd configuration:\n%s", config)
+	}
+	if strings.Contains(config, "bss=") {
+		t.Errorf("Unexpected BSS in hostapd configuration:\n%s", config)
+	}
+	// The vendor specific element carries the OUI, the OUI type and the hostname "ap".
+	// DHCP required relies on proxy ARP, which needs an isolated BSS.
+	for _, want := range []string{"ssid=" + mock.GuestWLANName + "\n", "ignore_broadcast_ssid=1\n", "vendor_elements=dd06001a11016170\n",
+		"ap_isolate=1\n", "proxy_arp=1\n"} {
+		if !strings.Contains(config, want) {
+			t.Errorf("Missing %q in hostapd configuration:\n%s", want, config)
+		}
+	}
+}
+
+func TestBSSIntfNames(t *testing.T) {
+	apConfig := mock.GenerateAPConfig(true)
+	radioConfig := apConfig.Radios.Radio[1].Config
+
+	// Define test cases.
+	tests := []struct {
+		disabledWLAN string
+		bssINTFNames map[string]string
+	}{{
+		bssINTFNames: map[string]string{mock.AuthWLANName: "wlan0", mock.GuestWLANName: "wlan0_9b2c"},
+	}, {
+		disabledWLAN: mock.AuthWLANName,
+		bssINTFNames: map[string]string{mock.GuestWLANName: "wlan0"},
+	}, {
+		disabledWLAN: mock.GuestWLANName,
+		bssINTFNames: map[string]string{mock.AuthWLANName: "wlan0"},
+	}}
+
+	for _, test := range tests {
+		for wlanName, wlan := range apConfig.Ssids.Ssid {
+			wlan.Config.Enabled = ygot.Bool(wlanName != test.disabledWLAN)
+		}
+		got := bssIntfNames(wlanWithOpFreq(apConfig, radioConfig.OperatingFrequency), testWLANIntf)
+		checkResult(t, "disabled "+test.disabledWLAN, got, test.bssINTFNames)
+	}
+
+	// Disabling an SSID keeps the BSS interfaces of the SSIDs after it.
+	for _, wlan := range apConfig.Ssids.Ssid {
+		wlan.Config.Enabled = ygot.Bool(true)
+	}
+	otherWLAN := *apConfig.Ssids.Ssid[mock.GuestWLANName].Config
+	otherWLAN.Name = ygot.String("Other-Emu")
+	wlanConfigs := append(wlanWithOpFreq(apConfig, radioConfig.OperatingFrequency), &otherWLAN)
+	want := bssIntfNames(wlanConfigs, testWLANIntf)["Other-Emu"]
+	apConfig.Ssids.Ssid[mock.GuestWLANName].Config.Enabled = ygot.Bool(false)
+	if got := bssIntfNames(wlanConfigs, testWLANIntf)["Other-Emu"]; got != want {
+		t.Errorf("BSS interface changed after disabling %s (got: %s, want: %s).", mock.GuestWLANName, got, want)
+	}
+}
+
+func TestToggleSSIDs(t *testing.T) {
+	tempRunFolder, err := ioutil.TempDir("", "link022")
+	if err != nil {
+		t.Fatalf("Unable to create a temp run time folder. Skip all tests.")
+	}
+
+	var cmds []string
+	cmdRunner = &syscmd.CommandRunner{
+		ExecCommand: func(wait bool, cmd string, args ...string) (string, error) {
+			if cmd == "cat" {
+				return "phy0\n", nil
+			}
+			// Skip the global control interface arguments: -p <dir> -i <name> raw.
+			cmds = append(cmds, strings.Join(args[5:], " "))
+			return "OK\n", nil
+		},
+	}
+	originalDenyList := denyList
+	denyList = denylist.New(&syscmd.CommandRunner{
+		ExecCommand: func(wait bool, cmd string, args ...string) (string, error) {
+			return "OK\n", nil
+		},
+	})
+	originalRunFolder := runFolder
+	runFolder = tempRunFolder
+	defer func() {
+		cmdRunner = syscmd.Runner()
+		denyList = originalDenyList
+		runFolder = originalRunFolder
+	}()
+
+	// A station denied while the BSS is down stays denied by the added BSS.
+	if err := denyList.Deny("steering", "wlan0_9b2c", "02:00:00:00:00:0f"); err != nil {
+		t.Fatalf("Denying the station failed. Error: %v.", err)
+	}
+
+	disabledGuest := mock.GenerateAPConfig(true)
+	disabledGuest.Ssids.Ssid[mock.GuestWLANName].Config.Enabled = ygot.Bool(false)
+	disabledAuth := mock.GenerateAPConfig(true)
+	disabledAuth.Ssids.Ssid[mock.AuthWLANName].Config.Enabled = ygot.Bool(false)
+	otherChange := mock.GenerateAPConfig(true)
+	otherChange.Ssids.Ssid[mock.GuestWLANName].Config.Enabled = ygot.Bool(false)
+	otherChange.Ssids.Ssid[mock.GuestWLANName].Config.Hidden = ygot.Bool(true)
+
+	// Define test cases.
+	tests := []struct {
+		name      string
+		appliedAP *ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint
+		apConfig  *ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint
+		toggled   bool
+		cmds      []string
+	}{{
+		name:      "DisableSSID",
+		appliedAP: mock.GenerateAPConfig(true),
+		apConfig:  disabledGuest,
+		toggled:   true,
+		cmds:      []string{"REMOVE wlan0_9b2c"},
+	}, {
+		name:      "EnableSSID",
+		appliedAP: disabledGuest,
+		apConfig:  mock.GenerateAPConfig(true),
+		toggled:   true,
+		cmds:      []string{"ADD bss_config=phy0:" + path.Join(tempRunFolder, "hostapd_wlan0_9b2c.conf")},
+	}, {
+		name:      "DisablePrimarySSID",
+		appliedAP: mock.GenerateAPConfig(true),
+		apConfig:  disabledAuth,
+	}, {
+		name:      "OtherChanges",
+		appliedAP: mock.GenerateAPConfig(true),
+		apConfig:  otherChange,
+	}, {
+		name:      "NoChange",
+		appliedAP: mock.GenerateAPConfig(true),
+		apConfig:  mock.GenerateAPConfig(true),
+	}}
+
+	for _, test := range tests {
+		cmds = nil
+		toggled, err := ToggleSSIDs(test.appliedAP, test.apConfig, nil, []string{testWLANIntf})
+		checkResult(t, test.name, err, nil)
+		checkResult(t, test.name, toggled, test.toggled)
+		checkResult(t, test.name, cmds, test.cmds)
+		if test.apConfig.Ssids.Ssid[mock.GuestWLANName].Config.Enabled == nil {
+			t.Errorf("[%v] enabled flag not restored after comparing configurations", test.name)
+		}
+	}
+
+	bssConfig, err := ioutil.ReadFile(path.Join(tempRunFolder, "hostapd_wlan0_9b2c.conf"))
+	if err != nil {
+		t.Fatalf("Unable to read the added BSS configuration. Error: %v.", err)
+	}
+	if !strings.HasPrefix(string(bssConfig), "interface=wlan0_9b2c\n") || !strings.Contains(string(bssConfig), "ssid="+mock.GuestWLANName+"\n") {
+		t.Errorf("Incorrect added BSS configuration:\n%s", bssConfig)
+	}
+	if content, err := ioutil.ReadFile(path.Join(tempRunFolder, "hostapd_wlan0_9b2c.deny")); err != nil || string(content) != "02:00:00:00:00:0f\n" {
+		t.Errorf("Incorrect deny file of the added BSS: %q (error: %v).", content, err)
+	}
+}
+
+func TestMACACLConfig(t *testing.T) {
+	apConfig := mock.GenerateAPConfig(true)
+	radioConfig := apConfig.Radios.Radio[1].Config
+	macACLs := map[string]*ocutil.MACACL{mock.GuestWLANName: {Accept: []string{"02:00:00:00:00:0a"}}}
+
+	config := hostapdConfigFile(radioConfig, ocutil.RadiusServers(apConfig), nil, nil, nil, nil, macACLs, nil,
+		wlanWithOpFreq(apConfig, radioConfig.OperatingFrequency), testWLANIntf, "ap", "")
+	for _, want := range []string{
+		"macaddr_acl=0\naccept_mac_file=" + path.Join(runFolder, "hostapd_wlan0.accept") + "\ndeny_mac_file=" + path.Join(runFolder, "hostapd_wlan0.deny") + "\n",
+		"macaddr_acl=1\naccept_mac_file=" + path.Join(runFolder, "hostapd_wlan0_9b2c.accept") + "\ndeny_mac_file=" + path.Join(runFolder, "hostapd_wlan0_9b2c.deny") + "\n",
+	} {
+		if !strings.Contains(config, want) {
+			t.Errorf("Missing %q in hostapd configuration:\n%s", want, config)
+		}
+	}
+}
+
+func TestApplyMACACLs(t *testing.T) {
+	tempRunFolder, err := ioutil.TempDir("", "link022")
+	if err != nil {
+		t.Fatalf("Unable to create a temp run time folder. Skip all tests.")
+	}
+
+	var cmds []string
+	cmdRunner = &syscmd.CommandRunner{
+		ExecCommand: func(wait bool, cmd string, args ...string) (string, error) {
+			// Skip the control interface arguments: -p <dir> -i <intf>.
+			cmd = args[3] + ": " + strings.Join(args[4:], " ")
+			cmds = append(cmds, cmd)
+			switch cmd {
+			case "wlan0_9b2c: accept_acl SHOW":
+				return "02:00:00:00:00:0a VLAN_ID=0\n02:00:00:00:00:0c VLAN_ID=0\n", nil
+			case "wlan0_9b2c: list_sta":
+				return "02:00:00:00:00:0a\n02:00:00:00:00:0d\n", nil
+			}
+			return "OK\n", nil
+		},
+	}
+	originalDenyList := denyList
+	denyList = denylist.New(cmdRunner)
+	originalRunFolder := runFolder
+	runFolder = tempRunFolder
+	defer func() {
+		cmdRunner = syscmd.Runner()
+		denyList = originalDenyList
+		runFolder = originalRunFolder
+	}()
+
+	gasketConfig := &ocstruct.OpenconfigGasket_Gasket{MacAcls: &ocstruct.OpenconfigGasket_Gasket_MacAcls{}}
+	guestACL, err := gasketConfig.MacAcls.NewSsid(mock.GuestWLANName)
+	if err != nil {
+		t.Fatalf("Unable to create the MAC ACL of %s. Error: %v.", mock.GuestWLANName, err)
+	}
+	guestACL.AcceptMac = []string{"02:00:00:00:00:0a", "02:00:00:00:00:0b"}
+	authACL, err := gasketConfig.MacAcls.NewSsid(mock.AuthWLANName)
+	if err != nil {
+		t.Fatalf("Unable to create the MAC ACL of %s. Error: %v.", mock.AuthWLANName, err)
+	}
+	authACL.DenyMac = []string{"02:00:00:00:00:0e"}
+
+	if err := ApplyMACACLs(mock.GenerateAPConfig(true), gasketConfig, []string{testWLANIntf}); err != nil {
+		t.Errorf("Applying MAC ACLs failed. Error: %v.", err)
+	}
+	want := []string{
+		"wlan0: accept_acl SHOW",
+		"wlan0: set macaddr_acl 0",
+		"wlan0: deny_acl ADD_MAC 02:00:00:00:00:0e",
+		"wlan0_9b2c: accept_acl SHOW",
+		"wlan0_9b2c: accept_acl ADD_MAC 02:00:00:00:00:0b",
+		"wlan0_9b2c: set macaddr_acl 1",
+		"wlan0_9b2c: accept_acl DEL_MAC 02:00:00:00:00:0c",
+		"wlan0_9b2c: list_sta",
+		"wlan0_9b2c: deauthenticate 02:00:00:00:00:0d",
+	}
+	checkResult(t, "ApplyMACACLs", cmds, want)
+
+	// Removing the ACLs allows the denied station again.
+	cmds = nil
+	if err := ApplyMACACLs(mock.GenerateAPConfig(true), nil, []string{testWLANIntf}); err != nil {
+		t.Errorf("Applying MAC ACLs failed. Error: %v.", err)
+	}
+	want = []string{
+		"wlan0: accept_acl SHOW",
+		"wlan0: set macaddr_acl 0",
+		"wlan0: deny_acl DEL_MAC 02:00:00:00:00:0e",
+		"wlan0_9b2c: accept_acl SHOW",
+		"wlan0_9b2c: set macaddr_acl 0",
+		"wlan0_9b2c: accept_acl DEL_MAC 02:00:00:00:00:0a",
+		"wlan0_9b2c: accept_acl DEL_MAC 02:00:00:00:00:0c",
+	}
+	checkResult(t, "RemoveMACACLs", cmds, want)
+
+	for fileName, want := range map[string]string{
+		"hostapd_wlan0.deny":        "",
+		"hostapd_wlan0_9b2c.accept": "",
+		"hostapd_wlan0.conf":        "macaddr_acl=0\n",
+	} {
+		content, err := ioutil.ReadFile(path.Join(tempRunFolder, fileName))
+		if err != nil {
+			t.Errorf("Unable to read %s. Error: %v.", fileName, err)
+			continue
+		}
+		if !strings.Contains(string(content), want) || (want == "" && len(content) != 0) {
+			t.Errorf("Incorrect content of %s:\n%s", fileName, content)
+		}
+	}
+}
+
+func TestDenyListOnRestart(t *testing.T) {
+	tempRunFolder, err := ioutil.TempDir("", "link022")
+	if err != nil {
+		t.Fatalf("Unable to create a temp run time folder. Skip all tests.")
+	}
+	cmdRunner = &syscmd.CommandRunner{ExecCommand: executeMockCommand}
+	originalDenyList := denyList
+	denyList = denylist.New(&syscmd.CommandRunner{
+		ExecCommand: func(wait bool, cmd string, args ...string) (string, error) {
+			return "OK\n", nil
+		},
+	})
+	originalRunFolder := runFolder
+	runFolder = tempRunFolder
+	defer func() {
+		cmdRunner = syscmd.Runner()
+		denyList = originalDenyList
+		runFolder = originalRunFolder
+	}()
+
+	// A station denied by band steering stays denied by the restarted hostapd.
+	if err := denyList.Deny("steering", "wlan0_9b2c", "02:00:00:00:00:0f"); err != nil {
+		t.Fatalf("Denying the station failed. Error: %v.", err)
+	}
+	gasketConfig := &ocstruct.OpenconfigGasket_Gasket{MacAcls: &ocstruct.OpenconfigGasket_Gasket_MacAcls{}}
+	authACL, err := gasketConfig.MacAcls.NewSsid(mock.AuthWLANName)
+	if err != nil {
+		t.Fatalf("Unable to create the MAC ACL of %s. Error: %v.", mock.AuthWLANName, err)
+	}
+	authACL.DenyMac = []string{"02:00:00:00:00:0e"}
+
+	testSystemState = cleanedSysteState()
+	if err := ApplyConfig(mock.GenerateAPConfig(true), gasketConfig, true, testETHIntf, []string{testWLANIntf}); err != nil {
+		t.Errorf("Applying the configuration failed. Error: %v.", err)
+	}
+	for fileName, want := range map[string]string{
+		"hostapd_wlan0.deny":      "02:00:00:00:00:0e\n",
+		"hostapd_wlan0_9b2c.deny": "02:00:00:00:00:0f\n",
+	} {
+		content, err := ioutil.ReadFile(path.Join(tempRunFolder, fileName))
+		if err != nil || string(content) != want {
+			t.Errorf("Incorrect content of %s: %q (error: %v).", fileName, content, err)
+		}
+	}
+	if got := denyList.Denied("steering", "wlan0_9b2c"); !reflect.DeepEqual(got, []string{"02:00:00:00:00:0f"}) {
+		t.Errorf("Steering denial forgotten after restart (got: %v).", got)
+	}
+}
+
+func TestNATConfig(t *testing.T) {
+	apConfig := mock.GenerateAPConfig(true)
+	apConfig.Ssids.Ssid[mock.GuestWLANName].Config.VlanList = []uint16{300}
+	radioConfig := apConfig.Radios.Radio[1].Config
+	natSSIDs := ocutil.NATSSIDs(natGasketConfig(t))
+
+	config := hostapdConfigFile(radioConfig, ocutil.RadiusServers(apConfig), nil, nil, nil, nil, nil, natSSIDs,
+		wlanWithOpFreq(apConfig, radioConfig.OperatingFrequency), testWLANIntf, "ap", "")
+	if !strings.Contains(config, "ssid="+mock.GuestWLANName+"\nbridge=br_nat0\n") {
+		t.Errorf("The NAT SSID is not bridged to its local subnet:\n%s", config)
+	}
+	if strings.Contains(config, "dynamic_vlan=1") {
+		t.Errorf("Unexpected dynamic VLAN on the NAT SSID:\n%s", config)
+	}
+
+	wantDnsmasqConfig := `bind-interfaces
+except-interface=lo
+dhcp-authoritative
+dhcp-leasefile=/var/run/link022/dnsmasq.leases
+interface=br_nat0
+dhcp-range=192.168.50.2,192.168.50.254,255.255.255.0,3600
+`
+	if got := dnsmasqConfig(natSSIDs); got != wantDnsmasqConfig {
+		t.Errorf("Incorrect dnsmasq configuration (got: %q, want: %q).", got, wantDnsmasqConfig)
+	}
+
+	wantRuleset := `table ip link022_nat
+delete table ip link022_nat
+
+table ip link022_nat {
+	chain input {
+		type filter hook input priority 0; policy accept;
+		iifname { "br_nat0" } udp dport { 53, 67 } accept
+		iifname { "br_nat0" } tcp dport 53 accept
+		iifname { "br_nat0" } icmp type echo-request accept
+		iifname { "br_nat0" } drop
+	}
+
+	chain forward {
+		type filter hook forward priority 0; policy accept;
+		iifname { "br_nat0" } oifname != "eth0" drop
+		iifname { "br_nat0" } ip daddr { 10.0.0.0/8, 172.16.0.0/12, 192.168.0.0/16, 169.254.0.0/16 } drop
+		oifname { "br_nat0" } ct state established,related accept
+		oifname { "br_nat0" } drop
+	}
+
+	chain postrouting {
+		type nat hook postrouting priority 100; policy accept;
+		oifname "eth0" ip saddr { 192.168.50.0/24 } masquerade
+	}
+}
+`
+	if got := natRuleset(natSSIDs, nil, testETHIntf); got != wantRuleset {
+		t.Errorf("Incorrect NAT rules (got:\n%s\nwant:\n%s).", got, wantRuleset)
+	}
+
+	portals := map[string]*ocutil.CaptivePortal{mock.GuestWLANName: {Port: 8081}}
+	portalRule := "icmp type echo-request accept\n\t\tiifname \"br_nat0\" tcp dport 8081 accept\n"
+	if got := natRuleset(natSSIDs, portals, testETHIntf); !strings.Contains(got, portalRule) {
+		t.Errorf("The captive portal is not reachable on the AP:\n%s", got)
+	}
+}
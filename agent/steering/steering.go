@@ -0,0 +1,404 @@
+/* Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package steering steers 5 GHz capable clients of dual-band SSIDs away from 2.4 GHz.
+//
+// hostapd hides the 2.4 GHz BSS of a steered SSID from the clients recently heard on its
+// 5 GHz BSS, see package service. A client heard on a 5 GHz BSS above the steering RSSI
+// of its SSID is also denied on the 2.4 GHz BSS of the same SSID for a while. A steerable
+// client already associated with the 2.4 GHz BSS is asked to move through a BSS transition
+// request. A client is counted as steered once it associates with the 5 GHz BSS.
+package steering
+
+import (
+	ctx "context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/golang/glog"
+	"github.com/google/link022/agent/context"
+	"github.com/google/link022/agent/denylist"
+	"github.com/google/link022/agent/gnmi"
+	"github.com/google/link022/agent/hostapd"
+	"github.com/google/link022/agent/monitoring"
+	"github.com/google/link022/agent/syscmd"
+	"github.com/google/link022/agent/util/ocutil"
+	"github.com/google/link022/generated/ocstruct"
+	"github.com/openconfig/ygot/ygot"
+)
+
+const (
+	// seenTimeout is how long a client heard on 5 GHz stays steerable.
+	seenTimeout = 30 * time.Second
+	// refreshInterval is how often the steering configuration and BSS list are refreshed.
+	refreshInterval = 15 * time.Second
+	// denyListOwner identifies the deny list entries made by band steering.
+	denyListOwner = "band-steering"
+)
+
+// Counters contains the band steering counters of an SSID.
+type Counters struct {
+	// AssociationsDenied is the number of times a client is denied on 2.4 GHz.
+	AssociationsDenied uint64
+	// TransitionRequests is the number of BSS transition requests sent to clients on 2.4 GHz.
+	TransitionRequests uint64
+	// TransitionsAccepted is the number of BSS transition requests accepted by clients.
+	TransitionsAccepted uint64
+	// ClientsSteered is the number of clients that associated on 5 GHz after being denied
+	// on 2.4 GHz or asked to move.
+	ClientsSteered uint64
+}
+
+// ssidSettings contains the band steering settings and counters of an SSID.
+type ssidSettings struct {
+	steeringRSSI int8
+	counters     Counters
+}
+
+// Steerer keeps the band steering state of the AP.
+type Steerer struct {
+	mu        sync.Mutex
+	cmdRunner *syscmd.CommandRunner
+	denyList  *denylist.DenyList
+	now       func() time.Time
+
+	ssids    map[string]*ssidSettings        // SSID -> steering settings
+	bsss     map[string]*monitoring.BSS      // BSS interface name -> BSS
+	seen     map[string]time.Time            // client MAC -> last time heard on 5 GHz
+	denied   map[string]map[string]time.Time // 2.4 GHz BSS interface name -> client MAC -> denied time
+	steering map[string]steeringAttempt      // client MAC -> last steering attempt
+}
+
+// steeringAttempt is a client denied on 2.4 GHz or asked to move, not associated on 5 GHz yet.
+type steeringAttempt struct {
+	ssid string
+	time time.Time
+}
+
+// NewSteerer creates a Steerer running commands with the given runner,
+// and denying stations through the given deny list.
+func NewSteerer(cmdRunner *syscmd.CommandRunner, denyList *denylist.DenyList) *Steerer {
+	return &Steerer{
+		cmdRunner: cmdRunner,
+		denyList:  denyList,
+		now:       time.Now,
+		ssids:     make(map[string]*ssidSettings),
+		bsss:      make(map[string]*monitoring.BSS),
+		seen:      make(map[string]time.Time),
+		denied:    make(map[string]map[string]time.Time),
+		steering:  make(map[string]steeringAttempt),
+	}
+}
+
+// Run steers clients based on hostapd events until the context is done.
+// The steering configuration is loaded from the GNMI server periodically.
+func (s *Steerer) Run(bkgdContext ctx.Context, gnmiServer *gnmi.Server) {
+	hostName := context.GetDeviceConfig().Hostname
+	events := make(chan *hostapd.Event)
+	// Steering decisions are based on the probe requests heard on each band.
+	monitors := hostapd.NewMonitors(bkgdContext, hostapd.CtrlInterfaceDir, true, events)
+	defer monitors.Stop()
+
+	refresh := time.NewTicker(refreshInterval)
+	defer refresh.Stop()
+	for {
+		select {
+		case <-bkgdContext.Done():
+			return
+		case event := <-events:
+			s.HandleEvent(event)
+		case <-refresh.C:
+			s.UpdateSettings(steeringSettings(gnmiServer, hostName), monitoring.BSSs())
+			s.expire()
+			s.publishState(gnmiServer, hostName)
+			monitors.Update(s.steeredBSSs())
+		}
+	}
+}
+
+// UpdateSettings replaces the steering settings (SSID -> steering RSSI) and the BSSs on the AP.
+// Clients denied on a BSS that is no longer steered are allowed again.
+func (s *Steerer) UpdateSettings(steeringRSSIs map[string]int8, bssList []*monitoring.BSS) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ssids := make(map[string]*ssidSettings)
+	for ssid, rssi := range steeringRSSIs {
+		settings := &ssidSettings{steeringRSSI: rssi}
+		if existing, ok := s.ssids[ssid]; ok {
+			settings.counters = existing.counters
+		}
+		ssids[ssid] = settings
+	}
+	s.ssids = ssids
+
+	s.bsss = make(map[string]*monitoring.BSS)
+	for _, bss := range bssList {
+		s.bsss[bss.IntfName] = bss
+	}
+
+	for intfName, deniedClients := range s.denied {
+		if bss, ok := s.bsss[intfName]; ok && s.ssids[bss.SSID] != nil {
+			continue
+		}
+		for mac := range deniedClients {
+			s.allow(intfName, mac)
+		}
+	}
+}
+
+// Counters returns the band steering counters of an SSID.
+func (s *Steerer) Counters(ssid string) Counters {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if settings, ok := s.ssids[ssid]; ok {
+		return settings.counters
+	}
+	return Counters{}
+}
+
+// HandleEvent processes a hostapd event received from a BSS interface.
+func (s *Steerer) HandleEvent(event *hostapd.Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	bss, ok := s.bsss[event.IntfName]
+	if !ok {
+		return
+	}
+	settings, ok := s.ssids[bss.SSID]
+	if !ok {
+		return
+	}
+
+	switch event.Name {
+	case "RX-PROBE-REQUEST":
+		mac := event.Params["sa"]
+		signal, err := strconv.Atoi(event.Params["signal"])
+		if mac == "" || err != nil {
+			return
+		}
+		if is5GHz(bss) {
+			if signal >= int(settings.steeringRSSI) {
+				s.seen[mac] = s.now()
+			}
+			return
+		}
+		if s.steerable(mac) {
+			s.deny(bss, mac, settings)
+		}
+	case "AP-STA-CONNECTED":
+		if len(event.Args) == 0 {
+			return
+		}
+		mac := event.Args[0]
+		if is5GHz(bss) {
+			// The client is associated on 5 GHz, keep it away from 2.4 GHz.
+			s.seen[mac] = s.now()
+			if attempt, ok := s.steering[mac]; ok && attempt.ssid == bss.SSID {
+				settings.counters.ClientsSteered++
+			}
+			delete(s.steering, mac)
+			return
+		}
+		if s.steerable(mac) {
+			s.requestTransition(bss, mac, settings)
+		}
+	case "BSS-TM-RESP":
+		if event.Params["status_code"] == "0" {
+			settings.counters.TransitionsAccepted++
+		}
+	}
+}
+
+// steerable checks whether the client has been heard on 5 GHz recently.
+func (s *Steerer) steerable(mac string) bool {
+	seenTime, ok := s.seen[mac]
+	return ok && s.now().Sub(seenTime) <= seenTimeout
+}
+
+func (s *Steerer) deny(bss *monitoring.BSS, mac string, settings *ssidSettings) {
+	if _, ok := s.denied[bss.IntfName][mac]; ok {
+		return
+	}
+	if err := s.denyList.Deny(denyListOwner, bss.IntfName, mac); err != nil {
+		log.Errorf("Band steering failed to deny %s on %s: %v", mac, bss.IntfName, err)
+		return
+	}
+	if s.denied[bss.IntfName] == nil {
+		s.denied[bss.IntfName] = make(map[string]time.Time)
+	}
+	s.denied[bss.IntfName][mac] = s.now()
+	s.steering[mac] = steeringAttempt{ssid: bss.SSID, time: s.now()}
+	settings.counters.AssociationsDenied++
+}
+
+func (s *Steerer) allow(intfName, mac string) {
+	if err := s.denyList.Allow(denyListOwner, intfName, mac); err != nil {
+		log.Errorf("Band steering failed to allow %s on %s: %v", mac, intfName, err)
+	}
+	delete(s.denied[intfName], mac)
+	if len(s.denied[intfName]) == 0 {
+		delete(s.denied, intfName)
+	}
+}
+
+func (s *Steerer) requestTransition(bss *monitoring.BSS, mac string, settings *ssidSettings) {
+	target := s.targetBSS(bss.SSID)
+	if target == nil {
+		return
+	}
+	if err := s.cmdRunner.SendBSSTransitionRequest(bss.IntfName, mac, neighborReport(target)); err != nil {
+		log.Errorf("Band steering failed to request transition of %s on %s: %v", mac, bss.IntfName, err)
+		return
+	}
+	s.steering[mac] = steeringAttempt{ssid: bss.SSID, time: s.now()}
+	settings.counters.TransitionRequests++
+}
+
+// targetBSS returns a 5 GHz BSS of the given SSID, or nil if not found.
+func (s *Steerer) targetBSS(ssid string) *monitoring.BSS {
+	var target *monitoring.BSS
+	for _, bss := range s.bsss {
+		// Pick the BSS with the lowest interface name to keep the choice stable.
+		if bss.SSID == ssid && is5GHz(bss) && (target == nil || bss.IntfName < target.IntfName) {
+			target = bss
+		}
+	}
+	return target
+}
+
+// expire forgets the clients not heard on 5 GHz recently, and allows them on 2.4 GHz again.
+func (s *Steerer) expire() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for mac := range s.seen {
+		if !s.steerable(mac) {
+			delete(s.seen, mac)
+		}
+	}
+	for mac, attempt := range s.steering {
+		if s.now().Sub(attempt.time) > seenTimeout {
+			delete(s.steering, mac)
+		}
+	}
+	for intfName, deniedClients := range s.denied {
+		for mac, deniedTime := range deniedClients {
+			if s.now().Sub(deniedTime) > seenTimeout && !s.steerable(mac) {
+				s.allow(intfName, mac)
+			}
+		}
+	}
+}
+
+// steeredBSSs returns the interface names of all BSSs of steered SSIDs.
+func (s *Steerer) steeredBSSs() map[string]bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	bssIntfs := make(map[string]bool)
+	for intfName, bss := range s.bsss {
+		if _, ok := s.ssids[bss.SSID]; ok {
+			bssIntfs[intfName] = true
+		}
+	}
+	return bssIntfs
+}
+
+// publishState updates the band steering state and counters of steered SSIDs.
+func (s *Steerer) publishState(gnmiServer *gnmi.Server, hostName string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := gnmiServer.InternalUpdate(func(config ygot.ValidatedGoStruct) error {
+		device, ok := config.(*ocstruct.Device)
+		if !ok {
+			return errors.New("configuration has invalid type")
+		}
+		apConfig := ocutil.FindAPConfig(device, hostName)
+		if apConfig == nil || apConfig.Ssids == nil {
+			return nil
+		}
+		for ssidName, ssid := range apConfig.Ssids.Ssid {
+			settings, ok := s.ssids[ssidName]
+			if !ok {
+				continue
+			}
+			if ssid.BandSteering == nil {
+				ssid.BandSteering = &ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_Ssids_Ssid_BandSteering{}
+			}
+			ssid.BandSteering.State = &ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_Ssids_Ssid_BandSteering_State{
+				BandSteering: ygot.Bool(true),
+				SteeringRssi: ygot.Int8(settings.steeringRSSI),
+				Counters: &ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_Ssids_Ssid_BandSteering_State_Counters{
+					AssociationsDenied:  ygot.Uint64(settings.counters.AssociationsDenied),
+					TransitionRequests:  ygot.Uint64(settings.counters.TransitionRequests),
+					TransitionsAccepted: ygot.Uint64(settings.counters.TransitionsAccepted),
+					ClientsSteered:      ygot.Uint64(settings.counters.ClientsSteered),
+				},
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Errorf("Error in updating band steering state: %v", err)
+	}
+}
+
+// steeringSettings returns the steering RSSI of each SSID with band steering enabled.
+func steeringSettings(gnmiServer *gnmi.Server, hostName string) map[string]int8 {
+	steeringRSSIs := make(map[string]int8)
+	gnmiServer.InternalUpdate(func(config ygot.ValidatedGoStruct) error {
+		device, ok := config.(*ocstruct.Device)
+		if !ok {
+			return errors.New("configuration has invalid type")
+		}
+		apConfig := ocutil.FindAPConfig(device, hostName)
+		if apConfig == nil {
+			return nil
+		}
+		for ssidName, rssi := range ocutil.BandSteeringSSIDs(apConfig) {
+			steeringRSSIs[ssidName] = rssi
+		}
+		return nil
+	})
+	return steeringRSSIs
+}
+
+func is5GHz(bss *monitoring.BSS) bool {
+	return bss.Frequency >= 5000
+}
+
+// neighborReport describes a 5 GHz BSS in the format of BSS_TM_REQ neighbor parameter:
+// <BSSID>,<BSSID Info>,<Operating Class>,<Channel>,<PHY Type>.
+func neighborReport(bss *monitoring.BSS) string {
+	channel := (bss.Frequency - 5000) / 5
+	opClass := 115
+	switch {
+	case channel >= 149:
+		opClass = 125
+	case channel >= 100:
+		opClass = 121
+	case channel >= 52:
+		opClass = 118
+	}
+	// PHY type 7 is HT.
+	return fmt.Sprintf("%s,0x0000,%d,%d,7", bss.MAC, opClass, channel)
+}
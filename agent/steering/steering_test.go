@@ -0,0 +1,231 @@
+/* Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package steering
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/link022/agent/denylist"
+	"github.com/google/link022/agent/hostapd"
+	"github.com/google/link022/agent/monitoring"
+	"github.com/google/link022/agent/syscmd"
+)
+
+const (
+	testSSID      = "Guest-Emu"
+	testClientMAC = "12:34:56:78:9a:bc"
+	test2GIntf    = "wlan0"
+	test5GIntf    = "wlan1"
+)
+
+var (
+	testBSSList = []*monitoring.BSS{
+		{IntfName: test2GIntf, MAC: "02:00:00:00:00:01", SSID: testSSID, Frequency: 2437},
+		{IntfName: test5GIntf, MAC: "02:00:00:00:00:02", SSID: testSSID, Frequency: 5180},
+	}
+	testStartTime = time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+)
+
+// newTestSteerer creates a Steerer with a fake clock, which records the executed hostapd_cli commands.
+func newTestSteerer() (*Steerer, *time.Time, *[]string) {
+	var cmds []string
+	now := testStartTime
+	cmdRunner := &syscmd.CommandRunner{
+		ExecCommand: func(wait bool, cmd string, args ...string) (string, error) {
+			// Skip the control interface arguments: -p <dir> -i <intf>.
+			cmds = append(cmds, args[3]+" "+strings.Join(args[4:], " "))
+			return "OK", nil
+		},
+	}
+	s := NewSteerer(cmdRunner, denylist.New(cmdRunner))
+	s.now = func() time.Time { return now }
+	s.UpdateSettings(map[string]int8{testSSID: -70}, testBSSList)
+	return s, &now, &cmds
+}
+
+func probe(intfName string, signal string) *hostapd.Event {
+	return &hostapd.Event{
+		IntfName: intfName,
+		Name:     "RX-PROBE-REQUEST",
+		Params:   map[string]string{"sa": testClientMAC, "signal": signal},
+	}
+}
+
+func TestDenyOn2GHz(t *testing.T) {
+	tests := []struct {
+		name   string
+		events []*hostapd.Event
+		cmds   []string
+	}{{
+		name:   "Steerable",
+		events: []*hostapd.Event{probe(test5GIntf, "-60"), probe(test2GIntf, "-50"), probe(test2GIntf, "-50")},
+		cmds:   []string{"wlan0 deny_acl ADD_MAC " + testClientMAC},
+	}, {
+		name:   "Weak5GHzSignal",
+		events: []*hostapd.Event{probe(test5GIntf, "-80"), probe(test2GIntf, "-50")},
+	}, {
+		name:   "Not5GHzCapable",
+		events: []*hostapd.Event{probe(test2GIntf, "-50")},
+	}, {
+		name:   "UnknownBSS",
+		events: []*hostapd.Event{probe("wlan2", "-60"), probe(test2GIntf, "-50")},
+	}}
+
+	for _, test := range tests {
+		s, _, cmds := newTestSteerer()
+		for _, event := range test.events {
+			s.HandleEvent(event)
+		}
+		if !reflect.DeepEqual(*cmds, test.cmds) {
+			t.Errorf("[%s] Incorrect commands (got: %v, want: %v).", test.name, *cmds, test.cmds)
+		}
+		if got := s.Counters(testSSID).AssociationsDenied; got != uint64(len(test.cmds)) {
+			t.Errorf("[%s] Incorrect denied counter (got: %d, want: %d).", test.name, got, len(test.cmds))
+		}
+	}
+}
+
+func TestTransitionRequest(t *testing.T) {
+	s, _, cmds := newTestSteerer()
+	s.HandleEvent(probe(test5GIntf, "-60"))
+	s.HandleEvent(&hostapd.Event{IntfName: test2GIntf, Name: "AP-STA-CONNECTED", Args: []string{testClientMAC}})
+	s.HandleEvent(&hostapd.Event{IntfName: test2GIntf, Name: "BSS-TM-RESP", Args: []string{testClientMAC}, Params: map[string]string{"status_code": "0"}})
+
+	wantCmds := []string{"wlan0 bss_tm_req " + testClientMAC + " pref=1 abridged=1 neighbor=02:00:00:00:00:02,0x0000,115,36,7"}
+	if !reflect.DeepEqual(*cmds, wantCmds) {
+		t.Errorf("Incorrect commands (got: %v, want: %v).", *cmds, wantCmds)
+	}
+	wantCounters := Counters{TransitionRequests: 1, TransitionsAccepted: 1}
+	if got := s.Counters(testSSID); got != wantCounters {
+		t.Errorf("Incorrect counters (got: %+v, want: %+v).", got, wantCounters)
+	}
+
+	// The client is only steered once it associates on 5 GHz.
+	s.HandleEvent(&hostapd.Event{IntfName: test5GIntf, Name: "AP-STA-CONNECTED", Args: []string{testClientMAC}})
+	wantCounters.ClientsSteered = 1
+	if got := s.Counters(testSSID); got != wantCounters {
+		t.Errorf("Incorrect counters after association on 5 GHz (got: %+v, want: %+v).", got, wantCounters)
+	}
+}
+
+func TestClientsSteered(t *testing.T) {
+	connected := func(intfName string) *hostapd.Event {
+		return &hostapd.Event{IntfName: intfName, Name: "AP-STA-CONNECTED", Args: []string{testClientMAC}}
+	}
+	tests := []struct {
+		name    string
+		events  []*hostapd.Event
+		wait    time.Duration
+		steered uint64
+	}{{
+		name:    "DeniedThenAssociatedOn5GHz",
+		events:  []*hostapd.Event{probe(test5GIntf, "-60"), probe(test2GIntf, "-50"), connected(test5GIntf)},
+		steered: 1,
+	}, {
+		name:   "AssociatedOn5GHzWithoutSteering",
+		events: []*hostapd.Event{probe(test5GIntf, "-60"), connected(test5GIntf)},
+	}, {
+		name:   "NotAssociated",
+		events: []*hostapd.Event{probe(test5GIntf, "-60"), probe(test2GIntf, "-50")},
+	}, {
+		name:   "AssociatedAfterExpiration",
+		events: []*hostapd.Event{probe(test5GIntf, "-60"), probe(test2GIntf, "-50")},
+		wait:   seenTimeout + time.Second,
+	}}
+
+	for _, test := range tests {
+		s, now, _ := newTestSteerer()
+		for _, event := range test.events {
+			s.HandleEvent(event)
+		}
+		if test.wait > 0 {
+			*now = now.Add(test.wait)
+			s.expire()
+			s.HandleEvent(connected(test5GIntf))
+		}
+		if got := s.Counters(testSSID).ClientsSteered; got != test.steered {
+			t.Errorf("[%s] Incorrect steered counter (got: %d, want: %d).", test.name, got, test.steered)
+		}
+	}
+}
+
+func TestExpire(t *testing.T) {
+	s, now, cmds := newTestSteerer()
+	s.HandleEvent(probe(test5GIntf, "-60"))
+	s.HandleEvent(probe(test2GIntf, "-50"))
+
+	// The client is still heard on 5 GHz, keep denying it.
+	*now = now.Add(seenTimeout)
+	s.HandleEvent(probe(test5GIntf, "-60"))
+	*now = now.Add(seenTimeout)
+	s.expire()
+	if len(*cmds) != 1 {
+		t.Errorf("Client allowed before expiration (commands: %v).", *cmds)
+	}
+
+	*now = now.Add(seenTimeout + time.Second)
+	s.expire()
+	wantCmds := []string{
+		"wlan0 deny_acl ADD_MAC " + testClientMAC,
+		"wlan0 deny_acl DEL_MAC " + testClientMAC,
+	}
+	if !reflect.DeepEqual(*cmds, wantCmds) {
+		t.Errorf("Incorrect commands (got: %v, want: %v).", *cmds, wantCmds)
+	}
+	if len(s.seen) != 0 || len(s.denied) != 0 || len(s.steering) != 0 {
+		t.Errorf("Client state not cleaned up (seen: %v, denied: %v, steering: %v).", s.seen, s.denied, s.steering)
+	}
+}
+
+func TestDisableSteering(t *testing.T) {
+	s, _, cmds := newTestSteerer()
+	s.HandleEvent(probe(test5GIntf, "-60"))
+	s.HandleEvent(probe(test2GIntf, "-50"))
+	s.UpdateSettings(map[string]int8{}, testBSSList)
+
+	wantCmds := []string{
+		"wlan0 deny_acl ADD_MAC " + testClientMAC,
+		"wlan0 deny_acl DEL_MAC " + testClientMAC,
+	}
+	if !reflect.DeepEqual(*cmds, wantCmds) {
+		t.Errorf("Incorrect commands (got: %v, want: %v).", *cmds, wantCmds)
+	}
+	if bssIntfs := s.steeredBSSs(); len(bssIntfs) != 0 {
+		t.Errorf("BSSs still steered after disabling band steering: %v.", bssIntfs)
+	}
+}
+
+func TestNeighborReport(t *testing.T) {
+	tests := []struct {
+		frequency int
+		report    string
+	}{
+		{frequency: 5180, report: "02:00:00:00:00:02,0x0000,115,36,7"},
+		{frequency: 5260, report: "02:00:00:00:00:02,0x0000,118,52,7"},
+		{frequency: 5500, report: "02:00:00:00:00:02,0x0000,121,100,7"},
+		{frequency: 5745, report: "02:00:00:00:00:02,0x0000,125,149,7"},
+	}
+
+	for _, test := range tests {
+		got := neighborReport(&monitoring.BSS{MAC: "02:00:00:00:00:02", Frequency: test.frequency})
+		if got != test.report {
+			t.Errorf("Incorrect neighbor report for %d MHz (got: %s, want: %s).", test.frequency, got, test.report)
+		}
+	}
+}
@@ -69,6 +69,16 @@ func vlanINTFName(intfName string, vlanID int) string {
 // SaveToFile saves the input string into a file in the file system.
 // It creates the file and all parent folder if not exist.
 func SaveToFile(folderPath, fileName, content string) error {
+	return saveToFile(folderPath, fileName, content, 0600)
+}
+
+// SaveToPublicFile saves the input string into a file readable by all users, e.g. a system configuration file.
+// It creates the file and all parent folder if not exist.
+func SaveToPublicFile(folderPath, fileName, content string) error {
+	return saveToFile(folderPath, fileName, content, 0644)
+}
+
+func saveToFile(folderPath, fileName, content string, perm os.FileMode) error {
 	fileFullPath := path.Join(folderPath, fileName)
 	log.Infof("Saving content to file %v...", fileFullPath)
 
@@ -85,7 +95,7 @@ func SaveToFile(folderPath, fileName, content string) error {
 		return fmt.Errorf("%s points an existing file", folderPath)
 	}
 
-	if err := ioutil.WriteFile(fileFullPath, []byte(content), 0600); err != nil {
+	if err := ioutil.WriteFile(fileFullPath, []byte(content), perm); err != nil {
 		log.Errorf("Saving content to file %v failed. Error: %v.", fileFullPath, err)
 		return err
 	}
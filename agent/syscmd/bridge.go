@@ -16,6 +16,8 @@ limitations under the License.
 package syscmd
 
 import (
+	"strings"
+
 	log "github.com/golang/glog"
 )
 
@@ -48,3 +50,46 @@ func (r *CommandRunner) AddBridgeIntf(bridgeName, intfName string) error {
 	log.Infof("Added interface %v to bridge %v.", intfName, bridgeName)
 	return nil
 }
+
+// Bridges returns the name of all network bridges on the device.
+func (r *CommandRunner) Bridges() ([]string, error) {
+	bridgeInfo, err := r.ExecCommand(true, "brctl", "show")
+	if err != nil {
+		return nil, err
+	}
+	return bridgeNames(bridgeInfo), nil
+}
+
+// BridgeOf returns the bridge the given interface is a port of, empty if it is not in a bridge.
+func (r *CommandRunner) BridgeOf(intfName string) (string, error) {
+	linkInfo, err := r.ExecCommand(true, "ip", "-o", "link", "show", "dev", intfName)
+	if err != nil {
+		return "", err
+	}
+	return linkMaster(linkInfo), nil
+}
+
+// linkMaster parses the output of "ip -o link show", e.g.
+// "5: wlan0: <BROADCAST,MULTICAST,UP,LOWER_UP> mtu 1500 qdisc mq master br_250 state UP ...".
+func linkMaster(linkInfo string) string {
+	fields := strings.Fields(linkInfo)
+	for i := 0; i+1 < len(fields); i++ {
+		if fields[i] == "master" {
+			return fields[i+1]
+		}
+	}
+	return ""
+}
+
+// bridgeNames parses the output of "brctl show". Each bridge starts a line after the header,
+// lines listing further interfaces of a bridge start with whitespace.
+func bridgeNames(bridgeInfo string) []string {
+	var names []string
+	for i, line := range strings.Split(bridgeInfo, "\n") {
+		if i == 0 || len(line) == 0 || line[0] == ' ' || line[0] == '\t' {
+			continue
+		}
+		names = append(names, strings.Fields(line)[0])
+	}
+	return names
+}
@@ -0,0 +1,59 @@
+/* Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package syscmd
+
+import (
+	"fmt"
+
+	log "github.com/golang/glog"
+)
+
+// StartChronyd starts a chronyd process in the foreground with the given configuration file.
+func (r *CommandRunner) StartChronyd(configFilePath string) error {
+	log.Infof("Starting chronyd process with config file: %v...", configFilePath)
+	if _, err := r.ExecCommand(false, "chronyd", "-d", "-f", configFilePath); err != nil {
+		return err
+	}
+	log.Infof("Started a chronyd with config file: %v.", configFilePath)
+	return nil
+}
+
+// StopChronyd kills the chronyd process started with the given configuration file.
+func (r *CommandRunner) StopChronyd(configFilePath string) error {
+	log.Infof("Stopping chronyd process with config file: %v...", configFilePath)
+	if _, err := r.ExecCommand(true, "pkill", "-f", chronydCmdLine(configFilePath)); err != nil {
+		return err
+	}
+	log.Infof("Stopped chronyd process with config file: %v.", configFilePath)
+	return nil
+}
+
+// ChronydRunning checks whether the chronyd process started with the given configuration file is running.
+func (r *CommandRunner) ChronydRunning(configFilePath string) bool {
+	// pgrep fails if no process matches.
+	_, err := r.ExecCommand(true, "pgrep", "-f", chronydCmdLine(configFilePath))
+	return err == nil
+}
+
+// NTPSources fetches the NTP sources of chronyd.
+// It returns the raw CSV output of the chronyc sources command, with numeric addresses.
+func (r *CommandRunner) NTPSources() (string, error) {
+	return r.ExecCommand(true, "chronyc", "-n", "-c", "sources")
+}
+
+func chronydCmdLine(configFilePath string) string {
+	return fmt.Sprintf("chronyd -d -f %s", configFilePath)
+}
@@ -17,8 +17,10 @@ package syscmd
 
 import (
 	"errors"
+	"fmt"
 	"net"
 	"strings"
+	"time"
 
 	log "github.com/golang/glog"
 )
@@ -41,3 +43,65 @@ func (r *CommandRunner) DeviceIPv4() (string, error) {
 	}
 	return "", errors.New("no IPv4 address found on this device")
 }
+
+// EnableIPForwarding lets the device route IPv4 traffic between its interfaces.
+func (r *CommandRunner) EnableIPForwarding() error {
+	if _, err := r.ExecCommand(true, "sysctl", "-w", "net.ipv4.ip_forward=1"); err != nil {
+		return err
+	}
+	log.Info("Enabled IPv4 forwarding.")
+	return nil
+}
+
+// SetLocaltime points the local time of the device to the given zoneinfo file.
+func (r *CommandRunner) SetLocaltime(zoneinfoPath string) error {
+	if _, err := r.ExecCommand(true, "ln", "-sf", zoneinfoPath, "/etc/localtime"); err != nil {
+		return err
+	}
+	log.Infof("Set the local time to %s.", zoneinfoPath)
+	return nil
+}
+
+// Reboot reboots the device.
+func (r *CommandRunner) Reboot() error {
+	log.Info("Rebooting the device...")
+	_, err := r.ExecCommand(true, "systemctl", "reboot")
+	return err
+}
+
+// PowerOff shuts the device down and powers it off.
+func (r *CommandRunner) PowerOff() error {
+	log.Info("Powering off the device...")
+	_, err := r.ExecCommand(true, "systemctl", "poweroff")
+	return err
+}
+
+// Halt shuts the device down without powering it off.
+func (r *CommandRunner) Halt() error {
+	log.Info("Halting the device...")
+	_, err := r.ExecCommand(true, "systemctl", "halt")
+	return err
+}
+
+// RestartUnit restarts the given systemd unit without waiting for it, e.g. the agent restarting itself.
+func (r *CommandRunner) RestartUnit(unit string) error {
+	log.Infof("Restarting %s...", unit)
+	_, err := r.ExecCommand(true, "systemctl", "--no-block", "restart", unit)
+	return err
+}
+
+// StartTimer runs the given shell script after the given delay, in a transient systemd unit with the given name.
+// The unit runs even if the agent restarts in the meantime. A previous unit with the same name is replaced.
+func (r *CommandRunner) StartTimer(unit string, delay time.Duration, script string) error {
+	// The previous unit may not exist.
+	r.ExecCommand(true, "systemctl", "stop", unit+".timer", unit+".service")
+	r.ExecCommand(true, "systemctl", "reset-failed", unit+".service")
+	_, err := r.ExecCommand(true, "systemd-run", "--unit="+unit, fmt.Sprintf("--on-active=%d", int(delay/time.Second)), "/bin/sh", "-c", script)
+	return err
+}
+
+// StopTimer stops the transient timer started with the given unit name, before it runs its script.
+func (r *CommandRunner) StopTimer(unit string) error {
+	_, err := r.ExecCommand(true, "systemctl", "stop", unit+".timer")
+	return err
+}
@@ -0,0 +1,51 @@
+/* Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package syscmd
+
+import (
+	"path"
+	"strings"
+
+	log "github.com/golang/glog"
+)
+
+// StartDnsmasq starts a dnsmasq process in the foreground with the given configuration file.
+// Its PID is saved next to the configuration file.
+func (r *CommandRunner) StartDnsmasq(configFilePath string) error {
+	log.Infof("Starting dnsmasq process with config file: %v...", configFilePath)
+	if _, err := r.ExecCommand(false, "dnsmasq", "-k", "-C", configFilePath, "-x", dnsmasqPIDFile(configFilePath)); err != nil {
+		return err
+	}
+	log.Infof("Started a dnsmasq with config file: %v.", configFilePath)
+	return nil
+}
+
+// StopDnsmasq kills the dnsmasq process started with the given configuration file, found by its PID file.
+// Other dnsmasq processes on the device are left running.
+func (r *CommandRunner) StopDnsmasq(configFilePath string) error {
+	log.Infof("Stopping dnsmasq process with config file: %v...", configFilePath)
+	// The process name is checked too, in case the PID was reused.
+	if _, err := r.ExecCommand(true, "pkill", "-F", dnsmasqPIDFile(configFilePath), "-x", "dnsmasq"); err != nil {
+		return err
+	}
+	log.Infof("Stopped dnsmasq process with config file: %v.", configFilePath)
+	return nil
+}
+
+// dnsmasqPIDFile returns the PID file of the dnsmasq process started with the given configuration file.
+func dnsmasqPIDFile(configFilePath string) string {
+	return strings.TrimSuffix(configFilePath, path.Ext(configFilePath)) + ".pid"
+}
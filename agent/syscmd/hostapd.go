@@ -16,16 +16,27 @@ limitations under the License.
 package syscmd
 
 import (
+	"fmt"
+	"net"
+	"path"
+	"strings"
+
 	log "github.com/golang/glog"
+	"github.com/google/link022/agent/hostapd"
 )
 
-// StartHostapd starts a hostapd process link to the given WLAN interface.
-func (r *CommandRunner) StartHostapd(configFilePath string) error {
-	log.Infof("Starting hostapd process with config file: %v...", configFilePath)
-	if _, err := r.ExecCommand(false, "hostapd", configFilePath); err != nil {
+// HostapdBinary is the hostapd executable, e.g. the one installed with the agent.
+var HostapdBinary = "hostapd"
+
+// StartHostapd starts a hostapd process running the given configuration files, one per WLAN interface.
+// The process listens on the global control interface, so that BSSs can be added and removed at runtime.
+func (r *CommandRunner) StartHostapd(configFilePaths ...string) error {
+	log.Infof("Starting hostapd process with config files: %v...", configFilePaths)
+	args := append([]string{"-g", hostapd.GlobalCtrlInterface}, configFilePaths...)
+	if _, err := r.ExecCommand(false, HostapdBinary, args...); err != nil {
 		return err
 	}
-	log.Infof("Started a hostapd with config file: %v.", configFilePath)
+	log.Infof("Started a hostapd with config files: %v.", configFilePaths)
 	return nil
 }
 
@@ -38,3 +49,165 @@ func (r *CommandRunner) StopAllHostapd() error {
 	log.Info("Stopped all hostapd processes.")
 	return nil
 }
+
+// HostapdRunning checks whether a hostapd process is running.
+func (r *CommandRunner) HostapdRunning() bool {
+	// pgrep fails if no process matches.
+	_, err := r.ExecCommand(true, "pgrep", "-x", "hostapd")
+	return err == nil
+}
+
+// HostapdCommand sends a command to the hostapd control interface of a certain BSS interface.
+// It returns the reply of hostapd.
+func (r *CommandRunner) HostapdCommand(intfName string, args ...string) (string, error) {
+	cmdArgs := append([]string{"-p", hostapd.CtrlInterfaceDir, "-i", intfName}, args...)
+	reply, err := r.ExecCommand(true, "hostapd_cli", cmdArgs...)
+	if err != nil {
+		return "", err
+	}
+	if strings.TrimSpace(reply) == "FAIL" {
+		return "", fmt.Errorf("hostapd command %v failed on %s", args, intfName)
+	}
+	return reply, nil
+}
+
+// HostapdGlobalCommand sends a command to the hostapd global control interface.
+// It returns the reply of hostapd.
+func (r *CommandRunner) HostapdGlobalCommand(args ...string) (string, error) {
+	ctrlDir, ctrlName := path.Split(hostapd.GlobalCtrlInterface)
+	cmdArgs := append([]string{"-p", ctrlDir, "-i", ctrlName, "raw"}, args...)
+	reply, err := r.ExecCommand(true, "hostapd_cli", cmdArgs...)
+	if err != nil {
+		return "", err
+	}
+	if strings.TrimSpace(reply) == "FAIL" {
+		return "", fmt.Errorf("hostapd global command %v failed", args)
+	}
+	return reply, nil
+}
+
+// AddBSS adds the BSS described in the given configuration file to the radio of a WLAN interface.
+func (r *CommandRunner) AddBSS(wlanINTFName, configFilePath string) error {
+	phyName, err := r.ExecCommand(true, "cat", fmt.Sprintf("/sys/class/net/%s/phy80211/name", wlanINTFName))
+	if err != nil {
+		return err
+	}
+	if _, err := r.HostapdGlobalCommand("ADD", fmt.Sprintf("bss_config=%s:%s", strings.TrimSpace(phyName), configFilePath)); err != nil {
+		return err
+	}
+	log.Infof("Added BSS with config file %v on %s.", configFilePath, wlanINTFName)
+	return nil
+}
+
+// RemoveBSS removes a BSS interface from hostapd, disconnecting its stations.
+func (r *CommandRunner) RemoveBSS(bssINTFName string) error {
+	if _, err := r.HostapdGlobalCommand("REMOVE", bssINTFName); err != nil {
+		return err
+	}
+	log.Infof("Removed BSS %s.", bssINTFName)
+	return nil
+}
+
+// DenyStation adds a station to the deny list of a certain BSS interface.
+func (r *CommandRunner) DenyStation(intfName, mac string) error {
+	if _, err := r.HostapdCommand(intfName, "deny_acl", "ADD_MAC", mac); err != nil {
+		return err
+	}
+	log.Infof("Station %s denied on %s.", mac, intfName)
+	return nil
+}
+
+// AllowStation removes a station from the deny list of a certain BSS interface.
+func (r *CommandRunner) AllowStation(intfName, mac string) error {
+	if _, err := r.HostapdCommand(intfName, "deny_acl", "DEL_MAC", mac); err != nil {
+		return err
+	}
+	log.Infof("Station %s removed from the deny list of %s.", mac, intfName)
+	return nil
+}
+
+// AcceptedStations returns the stations in the accept list of a certain BSS interface.
+func (r *CommandRunner) AcceptedStations(intfName string) ([]string, error) {
+	reply, err := r.HostapdCommand(intfName, "accept_acl", "SHOW")
+	if err != nil {
+		return nil, err
+	}
+	return replyMACs(reply), nil
+}
+
+// AcceptStation adds a station to the accept list of a certain BSS interface.
+func (r *CommandRunner) AcceptStation(intfName, mac string) error {
+	if _, err := r.HostapdCommand(intfName, "accept_acl", "ADD_MAC", mac); err != nil {
+		return err
+	}
+	log.Infof("Station %s accepted on %s.", mac, intfName)
+	return nil
+}
+
+// UnacceptStation removes a station from the accept list of a certain BSS interface.
+// hostapd disconnects the station if the BSS only accepts the stations in the list.
+func (r *CommandRunner) UnacceptStation(intfName, mac string) error {
+	if _, err := r.HostapdCommand(intfName, "accept_acl", "DEL_MAC", mac); err != nil {
+		return err
+	}
+	log.Infof("Station %s removed from the accept list of %s.", mac, intfName)
+	return nil
+}
+
+// SetMACACLPolicy sets whether a certain BSS interface only accepts the stations in its accept list.
+// Otherwise, it accepts all stations except the ones in its deny list.
+func (r *CommandRunner) SetMACACLPolicy(intfName string, acceptListOnly bool) error {
+	policy := "0"
+	if acceptListOnly {
+		policy = "1"
+	}
+	if _, err := r.HostapdCommand(intfName, "set", "macaddr_acl", policy); err != nil {
+		return err
+	}
+	log.Infof("Set MAC ACL policy of %s to %s.", intfName, policy)
+	return nil
+}
+
+// AssociatedStations returns the stations associated with a certain BSS interface.
+func (r *CommandRunner) AssociatedStations(intfName string) ([]string, error) {
+	reply, err := r.HostapdCommand(intfName, "list_sta")
+	if err != nil {
+		return nil, err
+	}
+	return replyMACs(reply), nil
+}
+
+// DeauthenticateStation disconnects a station from a certain BSS interface.
+func (r *CommandRunner) DeauthenticateStation(intfName, mac string) error {
+	if _, err := r.HostapdCommand(intfName, "deauthenticate", mac); err != nil {
+		return err
+	}
+	log.Infof("Deauthenticated station %s on %s.", mac, intfName)
+	return nil
+}
+
+// replyMACs parses the station MAC addresses starting each line of a hostapd reply,
+// e.g. "12:34:56:78:9a:bc VLAN_ID=0".
+func replyMACs(reply string) []string {
+	var macs []string
+	for _, line := range strings.Split(reply, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if _, err := net.ParseMAC(fields[0]); err == nil {
+			macs = append(macs, strings.ToLower(fields[0]))
+		}
+	}
+	return macs
+}
+
+// SendBSSTransitionRequest asks a station associated with a certain BSS interface to move to the target BSS.
+// The target BSS is described in the format of BSS_TM_REQ neighbor parameter.
+func (r *CommandRunner) SendBSSTransitionRequest(intfName, mac, targetBSS string) error {
+	if _, err := r.HostapdCommand(intfName, "bss_tm_req", mac, "pref=1", "abridged=1", fmt.Sprintf("neighbor=%s", targetBSS)); err != nil {
+		return err
+	}
+	log.Infof("Sent BSS transition request to station %s on %s, target BSS %s.", mac, intfName, targetBSS)
+	return nil
+}
@@ -17,6 +17,7 @@ package syscmd
 
 import (
 	"fmt"
+	"net"
 	"strconv"
 	"strings"
 
@@ -89,6 +90,94 @@ func (r *CommandRunner) WipeOutIntfIP(intfName string) error {
 	return nil
 }
 
+// SetIntfIP assigns an IPv4 or IPv6 address with prefix length (e.g. "192.168.50.1/24") to a certain network interface.
+func (r *CommandRunner) SetIntfIP(intfName, ipPrefix string) error {
+	if _, err := r.ExecCommand(true, "ip", "addr", "replace", ipPrefix, "dev", intfName); err != nil {
+		return err
+	}
+	log.Infof("Set the IP of interface %v to %v.", intfName, ipPrefix)
+	return nil
+}
+
+// FlushIntfIP removes the global IPv4 and IPv6 addresses of a certain network interface.
+func (r *CommandRunner) FlushIntfIP(intfName string) error {
+	if _, err := r.ExecCommand(true, "ip", "addr", "flush", "dev", intfName, "scope", "global"); err != nil {
+		return err
+	}
+	log.Infof("Flushed the IP addresses of interface %v.", intfName)
+	return nil
+}
+
+// IntfIPPrefixes returns the IPv4 and IPv6 addresses with prefix length of a certain network interface.
+func (r *CommandRunner) IntfIPPrefixes(intfName string) ([]string, error) {
+	addrInfo, err := r.ExecCommand(true, "ip", "-o", "addr", "show", "dev", intfName)
+	if err != nil {
+		return nil, err
+	}
+	return ipPrefixesInIPAddrResult(addrInfo), nil
+}
+
+// ipPrefixesInIPAddrResult parses the output of "ip -o addr show", e.g.
+// "2: eth0    inet 192.168.1.20/24 brd 192.168.1.255 scope global eth0\       valid_lft forever preferred_lft forever".
+func ipPrefixesInIPAddrResult(addrInfo string) []string {
+	var prefixes []string
+	for _, addr := range strings.Split(addrInfo, "\n") {
+		fields := strings.Fields(addr)
+		for i := 0; i+1 < len(fields); i++ {
+			if fields[i] == "inet" || fields[i] == "inet6" {
+				prefixes = append(prefixes, fields[i+1])
+				break
+			}
+		}
+	}
+	return prefixes
+}
+
+// SetDefaultRoute routes the IPv4 or IPv6 traffic to the given gateway through a certain network interface by default.
+func (r *CommandRunner) SetDefaultRoute(intfName, gateway string) error {
+	if _, err := r.ExecCommand(true, "ip", ipFamilyOption(gateway), "route", "replace", "default", "via", gateway, "dev", intfName); err != nil {
+		return err
+	}
+	log.Infof("Set the default gateway to %v on interface %v.", gateway, intfName)
+	return nil
+}
+
+// DefaultGateways returns the IPv4 and IPv6 default gateways through a certain network interface.
+func (r *CommandRunner) DefaultGateways(intfName string) ([]string, error) {
+	var gateways []string
+	for _, family := range []string{"-4", "-6"} {
+		routeInfo, err := r.ExecCommand(true, "ip", family, "route", "show", "default", "dev", intfName)
+		if err != nil {
+			return nil, err
+		}
+		gateways = append(gateways, gatewaysInIPRouteResult(routeInfo)...)
+	}
+	return gateways, nil
+}
+
+// gatewaysInIPRouteResult parses the output of "ip route show default", e.g. "default via 192.168.1.1 proto static".
+func gatewaysInIPRouteResult(routeInfo string) []string {
+	var gateways []string
+	for _, route := range strings.Split(routeInfo, "\n") {
+		fields := strings.Fields(route)
+		for i := 0; i+1 < len(fields); i++ {
+			if fields[i] == "via" {
+				gateways = append(gateways, fields[i+1])
+				break
+			}
+		}
+	}
+	return gateways
+}
+
+// ipFamilyOption returns the option of the ip command selecting the family of the given address.
+func ipFamilyOption(ipAddress string) string {
+	if ip := net.ParseIP(ipAddress); ip != nil && ip.To4() == nil {
+		return "-6"
+	}
+	return "-4"
+}
+
 // IntfMAC returns the MAC address of a certain interface.
 func (r *CommandRunner) IntfMAC(intfName string) (string, error) {
 	mac, err := r.ExecCommand(true, "cat", fmt.Sprintf("/sys/class/net/%s/address", intfName))
@@ -100,6 +189,15 @@ func (r *CommandRunner) IntfMAC(intfName string) (string, error) {
 	return mac, nil
 }
 
+// IntfStatistic returns a certain statistic counter (e.g. "rx_bytes") of a certain interface.
+func (r *CommandRunner) IntfStatistic(intfName, statName string) (uint64, error) {
+	stat, err := r.ExecCommand(true, "cat", fmt.Sprintf("/sys/class/net/%s/statistics/%s", intfName, statName))
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(strings.TrimSpace(stat), 10, 64)
+}
+
 // VLANOnIntf returns IDs of all VLAN on the given interface.
 func (r *CommandRunner) VLANOnIntf(intfName string) ([]int, error) {
 	// Fetch all interface information on the device.
@@ -158,3 +256,31 @@ func (r *CommandRunner) SendDHCPRequest(intfName, hostname string) error {
 	log.Infof("Send DHCP request on interface %s with hostname %s.", intfName, hostname)
 	return nil
 }
+
+// StopDHCPClient stops the DHCP client started by SendDHCPRequest on a certain network interface.
+func (r *CommandRunner) StopDHCPClient(intfName string) error {
+	if _, err := r.ExecCommand(true, "pkill", "-f", fmt.Sprintf("udhcpc -i %s -x", intfName)); err != nil {
+		return err
+	}
+	log.Infof("Stopped the DHCP client on interface %s.", intfName)
+	return nil
+}
+
+// SetTxPower sets the transmit power (in dBm) of a certain WLAN interface.
+func (r *CommandRunner) SetTxPower(intfName string, txPower int) error {
+	// iw takes the transmit power in mBm.
+	if _, err := r.ExecCommand(true, "iw", "dev", intfName, "set", "txpower", "fixed", strconv.Itoa(txPower*100)); err != nil {
+		return err
+	}
+	log.Infof("The transmit power of %v updated to %d dBm.", intfName, txPower)
+	return nil
+}
+
+// SetAutoTxPower lets the driver choose the transmit power of a certain WLAN interface.
+func (r *CommandRunner) SetAutoTxPower(intfName string) error {
+	if _, err := r.ExecCommand(true, "iw", "dev", intfName, "set", "txpower", "auto"); err != nil {
+		return err
+	}
+	log.Infof("The transmit power of %v is chosen by its driver.", intfName)
+	return nil
+}
@@ -0,0 +1,86 @@
+/* Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package syscmd
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	log "github.com/golang/glog"
+)
+
+// ApplyNftRules loads the nftables rules in the given file. The file is applied atomically.
+func (r *CommandRunner) ApplyNftRules(rulesFilePath string) error {
+	if _, err := r.ExecCommand(true, "nft", "-f", rulesFilePath); err != nil {
+		return err
+	}
+	log.Infof("Applied nftables rules in %v.", rulesFilePath)
+	return nil
+}
+
+// DeleteNftTable deletes a nftables table with all its rules.
+func (r *CommandRunner) DeleteNftTable(family, tableName string) error {
+	if _, err := r.ExecCommand(true, "nft", "delete", "table", family, tableName); err != nil {
+		return err
+	}
+	log.Infof("Deleted nftables table %v %v.", family, tableName)
+	return nil
+}
+
+// PermanentNeighborMACs returns the MAC address of the permanent IPv4 neighbors on the given interface,
+// in ascending order.
+func (r *CommandRunner) PermanentNeighborMACs(intfName string) ([]string, error) {
+	neighInfo, err := r.ExecCommand(true, "ip", "-4", "neigh", "show", "dev", intfName, "nud", "permanent")
+	if err != nil {
+		return nil, err
+	}
+	return neighborMACs(neighInfo), nil
+}
+
+// NeighborMAC returns the MAC address of the IPv4 neighbor with the given address.
+func (r *CommandRunner) NeighborMAC(ipAddress string) (string, error) {
+	neighInfo, err := r.ExecCommand(true, "ip", "-4", "neigh", "show", ipAddress)
+	if err != nil {
+		return "", err
+	}
+	macs := neighborMACs(neighInfo)
+	if len(macs) == 0 {
+		return "", fmt.Errorf("no neighbor found with address %s", ipAddress)
+	}
+	return macs[0], nil
+}
+
+// neighborMACs parses the output of "ip neigh show", e.g.
+// "192.168.1.10 dev br_666 lladdr 12:34:56:78:9a:bc PERMANENT".
+func neighborMACs(neighInfo string) []string {
+	found := make(map[string]bool)
+	for _, neigh := range strings.Split(neighInfo, "\n") {
+		fields := strings.Fields(neigh)
+		for i := 0; i+1 < len(fields); i++ {
+			if fields[i] == "lladdr" {
+				found[strings.ToLower(fields[i+1])] = true
+			}
+		}
+	}
+
+	var macs []string
+	for mac := range found {
+		macs = append(macs, mac)
+	}
+	sort.Strings(macs)
+	return macs
+}
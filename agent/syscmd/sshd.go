@@ -0,0 +1,74 @@
+/* Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package syscmd
+
+import (
+	log "github.com/golang/glog"
+)
+
+const (
+	// sshdServiceName is the systemd service of the SSH server.
+	sshdServiceName = "ssh"
+	// sshdSocketName is the systemd socket starting the SSH server on demand, it listens on the SSH port too.
+	sshdSocketName = "ssh.socket"
+	// sshdSessionProcesses matches the processes of the established SSH sessions, sshd-session since OpenSSH 9.8.
+	sshdSessionProcesses = "sshd(-session)?"
+)
+
+// SSHDActive checks whether the SSH server service is running.
+func (r *CommandRunner) SSHDActive() bool {
+	// systemctl is-active fails if the service is not active.
+	_, err := r.ExecCommand(true, "systemctl", "is-active", "--quiet", sshdServiceName)
+	return err == nil
+}
+
+// StartSSHD starts the SSH server service, and enables it to start on boot.
+func (r *CommandRunner) StartSSHD() error {
+	if _, err := r.ExecCommand(true, "systemctl", "enable", "--now", sshdServiceName); err != nil {
+		return err
+	}
+	log.Info("Started the SSH server.")
+	return nil
+}
+
+// StopSSHD stops the SSH server service and socket, and disables them so they do not start on boot.
+// The established sessions are closed.
+func (r *CommandRunner) StopSSHD() error {
+	if _, err := r.ExecCommand(true, "systemctl", "disable", "--now", sshdServiceName, sshdSocketName); err != nil {
+		return err
+	}
+	// The sessions are separate processes, which outlive the service. pkill fails if there is no session.
+	if _, err := r.ExecCommand(true, "pkill", "-x", sshdSessionProcesses); err == nil {
+		log.Info("Closed the SSH sessions.")
+	}
+	log.Info("Stopped the SSH server.")
+	return nil
+}
+
+// ReloadSSHD makes the SSH server reload its configuration.
+func (r *CommandRunner) ReloadSSHD() error {
+	if _, err := r.ExecCommand(true, "systemctl", "reload", sshdServiceName); err != nil {
+		return err
+	}
+	log.Info("Reloaded the SSH server configuration.")
+	return nil
+}
+
+// CheckSSHDConfig checks the validity of the given SSH server configuration file.
+func (r *CommandRunner) CheckSSHDConfig(configFilePath string) error {
+	_, err := r.ExecCommand(true, "sshd", "-t", "-f", configFilePath)
+	return err
+}
@@ -15,6 +15,11 @@ limitations under the License.
 
 package syscmd
 
+import (
+	"fmt"
+	"strings"
+)
+
 // GetAPStates get ap states on target
 func (r *CommandRunner) GetAPStates() (string, error) {
 	//log.Info("fetch latest AP states.")
@@ -24,3 +29,54 @@ func (r *CommandRunner) GetAPStates() (string, error) {
 	}
 	return wlanInfo, nil
 }
+
+// ScanNeighbors scans the neighbor BSSs on target WLAN interface.
+// It returns the raw output of the iw scan command.
+func (r *CommandRunner) ScanNeighbors(intfName string) (string, error) {
+	// "ap-force" is required to scan on an interface running in AP mode.
+	scanResult, err := r.ExecCommand(true, "iw", "dev", intfName, "scan", "ap-force")
+	if err != nil {
+		return "", err
+	}
+	return scanResult, nil
+}
+
+// StationDump fetches the statistics of all stations associated with the target WLAN interface.
+// It returns the raw output of the iw station dump command.
+func (r *CommandRunner) StationDump(intfName string) (string, error) {
+	stationInfo, err := r.ExecCommand(true, "iw", "dev", intfName, "station", "dump")
+	if err != nil {
+		return "", err
+	}
+	return stationInfo, nil
+}
+
+// SurveyDump fetches the channel survey data of the target WLAN interface.
+// It returns the raw output of the iw survey dump command.
+func (r *CommandRunner) SurveyDump(intfName string) (string, error) {
+	surveyInfo, err := r.ExecCommand(true, "iw", "dev", intfName, "survey", "dump")
+	if err != nil {
+		return "", err
+	}
+	return surveyInfo, nil
+}
+
+// FCSErrorCount returns the number of frames with FCS errors received by the target WLAN interface.
+// It requires debugfs mounted on the device.
+func (r *CommandRunner) FCSErrorCount(intfName string) (string, error) {
+	phyName, err := r.ExecCommand(true, "cat", fmt.Sprintf("/sys/class/net/%s/phy80211/name", intfName))
+	if err != nil {
+		return "", err
+	}
+	fcsErrorCount, err := r.ExecCommand(true, "cat", fmt.Sprintf("/sys/kernel/debug/ieee80211/%s/statistics/dot11FCSErrorCount", strings.TrimSpace(phyName)))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(fcsErrorCount), nil
+}
+
+// HostapdMIB fetches the MIB variables of the target BSS interface from hostapd,
+// including the RADIUS client MIB. It returns the raw "key=value" lines of the MIB.
+func (r *CommandRunner) HostapdMIB(intfName string) (string, error) {
+	return r.HostapdCommand(intfName, "mib")
+}
@@ -16,9 +16,11 @@ limitations under the License.
 package syscmd
 
 import (
+	"errors"
 	"fmt"
 	"reflect"
 	"sort"
+	"strings"
 	"testing"
 )
 
@@ -37,6 +39,8 @@ const (
 	testWLANIntf = "wlan0"
 	testVLANID   = 10
 
+	testStationMAC = "12:34:56:78:9a:bc"
+
 	bridgeName = "br_0"
 )
 
@@ -48,6 +52,9 @@ var (
 			if command == "ip" && reflect.DeepEqual(args, []string{"-o", "-d", "link", "show"}) {
 				return testIPLinkInfo, nil
 			}
+			if command == "cat" && len(args) == 1 && strings.HasSuffix(args[0], "/statistics/rx_bytes") {
+				return "1024\n", nil
+			}
 
 			// No ops.
 			return "", nil
@@ -89,6 +96,71 @@ func TestWipeOutIntfIP(t *testing.T) {
 	}
 }
 
+func TestSetIntfIP(t *testing.T) {
+	if err := runner.SetIntfIP(bridgeName, "192.168.50.1/24"); err != nil {
+		t.Errorf("Setting interface IP failed. Error: %v.", err)
+	}
+}
+
+func TestIPPrefixesInIPAddrResult(t *testing.T) {
+	addrInfo := `2: eth0    inet 192.168.1.20/24 brd 192.168.1.255 scope global eth0\       valid_lft forever preferred_lft forever
+2: eth0    inet6 2001:db8::20/64 scope global \       valid_lft forever preferred_lft forever
+2: eth0    inet6 fe80::ba27:ebff:feef:4eb6/64 scope link \       valid_lft forever preferred_lft forever
+`
+	want := []string{"192.168.1.20/24", "2001:db8::20/64", "fe80::ba27:ebff:feef:4eb6/64"}
+	if got := ipPrefixesInIPAddrResult(addrInfo); !reflect.DeepEqual(got, want) {
+		t.Errorf("Incorrect IP prefixes (got: %v, want: %v).", got, want)
+	}
+}
+
+func TestDefaultRoute(t *testing.T) {
+	var cmds []string
+	routeRunner := &CommandRunner{
+		ExecCommand: func(wait bool, command string, args ...string) (string, error) {
+			cmds = append(cmds, command+" "+strings.Join(args, " "))
+			if len(args) > 0 && args[0] == "-4" && args[1] == "route" && args[2] == "show" {
+				return "default via 192.168.1.1 proto static\n", nil
+			}
+			return "", nil
+		},
+	}
+
+	if err := routeRunner.SetDefaultRoute(testIntf, "192.168.1.1"); err != nil {
+		t.Errorf("Setting the IPv4 default route failed. Error: %v.", err)
+	}
+	if err := routeRunner.SetDefaultRoute(testIntf, "2001:db8::1"); err != nil {
+		t.Errorf("Setting the IPv6 default route failed. Error: %v.", err)
+	}
+	wantCmds := []string{
+		"ip -4 route replace default via 192.168.1.1 dev eth0",
+		"ip -6 route replace default via 2001:db8::1 dev eth0",
+	}
+	if !reflect.DeepEqual(cmds, wantCmds) {
+		t.Errorf("Incorrect commands (got: %v, want: %v).", cmds, wantCmds)
+	}
+
+	gateways, err := routeRunner.DefaultGateways(testIntf)
+	if err != nil {
+		t.Errorf("Fetching default gateways failed. Error: %v.", err)
+	} else if !reflect.DeepEqual(gateways, []string{"192.168.1.1"}) {
+		t.Errorf("Incorrect default gateways %v.", gateways)
+	}
+}
+
+func TestSetTxPower(t *testing.T) {
+	if err := runner.SetTxPower(testWLANIntf, 10); err != nil {
+		t.Errorf("Setting transmit power failed. Error: %v.", err)
+	}
+}
+
+func TestIntfStatistic(t *testing.T) {
+	if rxBytes, err := runner.IntfStatistic(testWLANIntf, "rx_bytes"); err != nil {
+		t.Errorf("Fetching interface statistic failed. Error: %v.", err)
+	} else if rxBytes != 1024 {
+		t.Errorf("Incorrect result of IntfStatistic, actual: %d, expected: 1024.", rxBytes)
+	}
+}
+
 func TestVLANOnIntf(t *testing.T) {
 	if vlanIDs, err := runner.VLANOnIntf(testIntf); err != nil {
 		t.Errorf("Fetching VLAN interface failed. Error: %v.", err)
@@ -120,6 +192,33 @@ func TestAddBridgeIntf(t *testing.T) {
 	}
 }
 
+func TestBridgeNames(t *testing.T) {
+	bridgeInfo := "bridge name\tbridge id\t\tSTP enabled\tinterfaces\n" +
+		"br_250\t\t8000.b827ebef4eb6\tno\t\teth0.250\n" +
+		"\t\t\t\t\t\t\twlan0\n" +
+		"br_nat0\t\t8000.000000000000\tno\t\t\n"
+	if got, want := bridgeNames(bridgeInfo), []string{"br_250", "br_nat0"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Incorrect bridge names (got: %v, want: %v).", got, want)
+	}
+}
+
+func TestLinkMaster(t *testing.T) {
+	tests := []struct {
+		linkInfo string
+		want     string
+	}{{
+		linkInfo: "5: wlan0: <BROADCAST,MULTICAST,UP,LOWER_UP> mtu 1500 qdisc mq master br_250 state UP mode DEFAULT group default qlen 1000\\    link/ether b8:27:eb:ef:4e:b6 brd ff:ff:ff:ff:ff:ff\n",
+		want:     "br_250",
+	}, {
+		linkInfo: "2: eth0: <BROADCAST,MULTICAST,UP,LOWER_UP> mtu 1500 qdisc mq state UP mode DEFAULT group default qlen 1000\\    link/ether b8:27:eb:ef:4e:b6 brd ff:ff:ff:ff:ff:ff\n",
+	}}
+	for _, test := range tests {
+		if got := linkMaster(test.linkInfo); got != test.want {
+			t.Errorf("Incorrect bridge of %q (got: %q, want: %q).", test.linkInfo, got, test.want)
+		}
+	}
+}
+
 // Test hostapd commands.
 
 func TestStartHostapd(t *testing.T) {
@@ -133,3 +232,274 @@ func TestStopAllHostapd(t *testing.T) {
 		t.Errorf("Stopping hostapd processes failed. Error: %v.", err)
 	}
 }
+
+func TestHostapdRunning(t *testing.T) {
+	if !runner.HostapdRunning() {
+		t.Error("hostapd reported not running while pgrep succeeded.")
+	}
+}
+
+func TestDenyStation(t *testing.T) {
+	if err := runner.DenyStation(testWLANIntf, testStationMAC); err != nil {
+		t.Errorf("Denying station failed. Error: %v.", err)
+	}
+}
+
+func TestAllowStation(t *testing.T) {
+	if err := runner.AllowStation(testWLANIntf, testStationMAC); err != nil {
+		t.Errorf("Allowing station failed. Error: %v.", err)
+	}
+}
+
+func TestSendBSSTransitionRequest(t *testing.T) {
+	if err := runner.SendBSSTransitionRequest(testWLANIntf, testStationMAC, "02:27:eb:ba:1b:e0,0x0000,115,36,7"); err != nil {
+		t.Errorf("Sending BSS transition request failed. Error: %v.", err)
+	}
+}
+
+func TestAddRemoveBSS(t *testing.T) {
+	var cmds []string
+	bssRunner := &CommandRunner{
+		ExecCommand: func(wait bool, command string, args ...string) (string, error) {
+			if command == "cat" {
+				return "phy0\n", nil
+			}
+			cmds = append(cmds, strings.Join(args[4:], " "))
+			return "OK\n", nil
+		},
+	}
+	if err := bssRunner.AddBSS(testWLANIntf, "/var/run/link022/hostapd_wlan0_1.conf"); err != nil {
+		t.Errorf("Adding BSS failed. Error: %v.", err)
+	}
+	if err := bssRunner.RemoveBSS("wlan0_1"); err != nil {
+		t.Errorf("Removing BSS failed. Error: %v.", err)
+	}
+
+	want := []string{
+		"raw ADD bss_config=phy0:/var/run/link022/hostapd_wlan0_1.conf",
+		"raw REMOVE wlan0_1",
+	}
+	if !reflect.DeepEqual(cmds, want) {
+		t.Errorf("Incorrect hostapd commands (got: %v, want: %v).", cmds, want)
+	}
+}
+
+func TestMACACLCommands(t *testing.T) {
+	var cmds []string
+	aclRunner := &CommandRunner{
+		ExecCommand: func(wait bool, command string, args ...string) (string, error) {
+			cmds = append(cmds, strings.Join(args[4:], " "))
+			switch args[4] {
+			case "accept_acl":
+				if args[5] == "SHOW" {
+					return "12:34:56:78:9A:BC VLAN_ID=0\n02:00:00:00:00:01 VLAN_ID=0\n", nil
+				}
+			case "list_sta":
+				return "Selected interface 'wlan0'\n12:34:56:78:9a:bc\n", nil
+			}
+			return "OK\n", nil
+		},
+	}
+
+	accepted, err := aclRunner.AcceptedStations(testWLANIntf)
+	if err != nil {
+		t.Errorf("Fetching accepted stations failed. Error: %v.", err)
+	}
+	if want := []string{testStationMAC, "02:00:00:00:00:01"}; !reflect.DeepEqual(accepted, want) {
+		t.Errorf("Incorrect accepted stations (got: %v, want: %v).", accepted, want)
+	}
+	associated, err := aclRunner.AssociatedStations(testWLANIntf)
+	if err != nil {
+		t.Errorf("Fetching associated stations failed. Error: %v.", err)
+	}
+	if want := []string{testStationMAC}; !reflect.DeepEqual(associated, want) {
+		t.Errorf("Incorrect associated stations (got: %v, want: %v).", associated, want)
+	}
+	if err := aclRunner.AcceptStation(testWLANIntf, testStationMAC); err != nil {
+		t.Errorf("Accepting station failed. Error: %v.", err)
+	}
+	if err := aclRunner.UnacceptStation(testWLANIntf, testStationMAC); err != nil {
+		t.Errorf("Removing accepted station failed. Error: %v.", err)
+	}
+	if err := aclRunner.SetMACACLPolicy(testWLANIntf, true); err != nil {
+		t.Errorf("Setting MAC ACL policy failed. Error: %v.", err)
+	}
+	if err := aclRunner.DeauthenticateStation(testWLANIntf, testStationMAC); err != nil {
+		t.Errorf("Deauthenticating station failed. Error: %v.", err)
+	}
+
+	want := []string{
+		"accept_acl SHOW",
+		"list_sta",
+		"accept_acl ADD_MAC " + testStationMAC,
+		"accept_acl DEL_MAC " + testStationMAC,
+		"set macaddr_acl 1",
+		"deauthenticate " + testStationMAC,
+	}
+	if !reflect.DeepEqual(cmds, want) {
+		t.Errorf("Incorrect hostapd commands (got: %v, want: %v).", cmds, want)
+	}
+}
+
+func TestHostapdCommandFailure(t *testing.T) {
+	failingRunner := &CommandRunner{
+		ExecCommand: func(wait bool, command string, args ...string) (string, error) {
+			return "FAIL\n", nil
+		},
+	}
+	if _, err := failingRunner.HostapdCommand(testWLANIntf, "status"); err == nil {
+		t.Error("Expected an error when hostapd replies FAIL.")
+	}
+}
+
+// Test dnsmasq commands.
+
+func TestDnsmasqCommands(t *testing.T) {
+	var cmds []string
+	dnsmasqRunner := &CommandRunner{
+		ExecCommand: func(wait bool, command string, args ...string) (string, error) {
+			cmds = append(cmds, fmt.Sprintf("%v %s %s", wait, command, strings.Join(args, " ")))
+			return "", nil
+		},
+	}
+	if err := dnsmasqRunner.StartDnsmasq("/var/run/link022/dnsmasq.conf"); err != nil {
+		t.Errorf("Starting dnsmasq process failed. Error: %v.", err)
+	}
+	if err := dnsmasqRunner.StopDnsmasq("/var/run/link022/dnsmasq.conf"); err != nil {
+		t.Errorf("Stopping dnsmasq process failed. Error: %v.", err)
+	}
+
+	want := []string{
+		"false dnsmasq -k -C /var/run/link022/dnsmasq.conf -x /var/run/link022/dnsmasq.pid",
+		"true pkill -F /var/run/link022/dnsmasq.pid -x dnsmasq",
+	}
+	if !reflect.DeepEqual(cmds, want) {
+		t.Errorf("Incorrect dnsmasq commands (got: %v, want: %v).", cmds, want)
+	}
+}
+
+// Test chronyd commands.
+
+func TestChronydCommands(t *testing.T) {
+	var cmds []string
+	chronyRunner := &CommandRunner{
+		ExecCommand: func(wait bool, command string, args ...string) (string, error) {
+			cmds = append(cmds, fmt.Sprintf("%v %s %s", wait, command, strings.Join(args, " ")))
+			if command == "pgrep" {
+				return "", errors.New("exit status 1")
+			}
+			return "", nil
+		},
+	}
+	if err := chronyRunner.StartChronyd("/var/run/link022/chrony.conf"); err != nil {
+		t.Errorf("Starting chronyd process failed. Error: %v.", err)
+	}
+	if chronyRunner.ChronydRunning("/var/run/link022/chrony.conf") {
+		t.Error("chronyd reported running while pgrep found no process.")
+	}
+	if err := chronyRunner.StopChronyd("/var/run/link022/chrony.conf"); err != nil {
+		t.Errorf("Stopping chronyd process failed. Error: %v.", err)
+	}
+
+	want := []string{
+		"false chronyd -d -f /var/run/link022/chrony.conf",
+		"true pgrep -f chronyd -d -f /var/run/link022/chrony.conf",
+		"true pkill -f chronyd -d -f /var/run/link022/chrony.conf",
+	}
+	if !reflect.DeepEqual(cmds, want) {
+		t.Errorf("Incorrect chronyd commands (got: %v, want: %v).", cmds, want)
+	}
+}
+
+// Test nftables commands.
+
+func TestApplyNftRules(t *testing.T) {
+	if err := runner.ApplyNftRules("/var/run/link022/l2filter.nft"); err != nil {
+		t.Errorf("Applying nftables rules failed. Error: %v.", err)
+	}
+}
+
+func TestDeleteNftTable(t *testing.T) {
+	if err := runner.DeleteNftTable("bridge", "link022"); err != nil {
+		t.Errorf("Deleting nftables table failed. Error: %v.", err)
+	}
+}
+
+func TestNeighborMACs(t *testing.T) {
+	neighInfo := `192.168.1.10 dev br_666 lladdr 12:34:56:78:9A:BC PERMANENT
+192.168.1.11 dev br_666 lladdr 02:00:00:00:00:01 PERMANENT
+10.0.0.10 dev br_250 lladdr 12:34:56:78:9a:bc PERMANENT
+10.0.0.1 dev br_250  FAILED
+`
+	want := []string{"02:00:00:00:00:01", "12:34:56:78:9a:bc"}
+	if got := neighborMACs(neighInfo); !reflect.DeepEqual(got, want) {
+		t.Errorf("Incorrect neighbor MACs (got: %v, want: %v).", got, want)
+	}
+}
+
+func TestNeighborMAC(t *testing.T) {
+	neighRunner := &CommandRunner{
+		ExecCommand: func(wait bool, command string, args ...string) (string, error) {
+			if args[len(args)-1] == "192.168.50.10" {
+				return "192.168.50.10 dev br_nat0 lladdr 12:34:56:78:9A:BC REACHABLE\n", nil
+			}
+			return "", nil
+		},
+	}
+	mac, err := neighRunner.NeighborMAC("192.168.50.10")
+	if err != nil {
+		t.Errorf("Fetching neighbor MAC failed. Error: %v.", err)
+	}
+	if mac != testStationMAC {
+		t.Errorf("Incorrect neighbor MAC (got: %v, want: %v).", mac, testStationMAC)
+	}
+	if _, err := neighRunner.NeighborMAC("192.168.50.11"); err == nil {
+		t.Error("Expected an error for an unknown neighbor.")
+	}
+}
+
+// Test traffic control commands.
+
+func TestApplyTCBatch(t *testing.T) {
+	if err := runner.ApplyTCBatch("/var/run/link022/tc_wlan0.batch"); err != nil {
+		t.Errorf("Applying traffic control commands failed. Error: %v.", err)
+	}
+}
+
+func TestRootQdisc(t *testing.T) {
+	if _, err := runner.RootQdisc(testWLANIntf); err != nil {
+		t.Errorf("Fetching root qdisc failed. Error: %v.", err)
+	}
+}
+
+func TestDeleteRootQdisc(t *testing.T) {
+	if err := runner.DeleteRootQdisc(testWLANIntf); err != nil {
+		t.Errorf("Deleting root qdisc failed. Error: %v.", err)
+	}
+}
+
+// Test state commands.
+
+func TestScanNeighbors(t *testing.T) {
+	if _, err := runner.ScanNeighbors(testWLANIntf); err != nil {
+		t.Errorf("Scanning neighbors failed. Error: %v.", err)
+	}
+}
+
+func TestStationDump(t *testing.T) {
+	if _, err := runner.StationDump(testWLANIntf); err != nil {
+		t.Errorf("Fetching station statistics failed. Error: %v.", err)
+	}
+}
+
+func TestHostapdMIB(t *testing.T) {
+	if _, err := runner.HostapdMIB(testWLANIntf); err != nil {
+		t.Errorf("Fetching hostapd MIB failed. Error: %v.", err)
+	}
+}
+
+func TestSurveyDump(t *testing.T) {
+	if _, err := runner.SurveyDump(testWLANIntf); err != nil {
+		t.Errorf("Fetching survey data failed. Error: %v.", err)
+	}
+}
@@ -0,0 +1,44 @@
+/* Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package syscmd
+
+import (
+	log "github.com/golang/glog"
+)
+
+// ApplyTCBatch runs the traffic control commands in the given file.
+// The remaining commands still run if one of them fails, e.g. deleting a qdisc that does not exist.
+func (r *CommandRunner) ApplyTCBatch(batchFilePath string) error {
+	if _, err := r.ExecCommand(true, "tc", "-force", "-batch", batchFilePath); err != nil {
+		return err
+	}
+	log.Infof("Applied traffic control commands in %v.", batchFilePath)
+	return nil
+}
+
+// DeleteRootQdisc removes the traffic control configuration of a certain network interface.
+func (r *CommandRunner) DeleteRootQdisc(intfName string) error {
+	if _, err := r.ExecCommand(true, "tc", "qdisc", "del", "dev", intfName, "root"); err != nil {
+		return err
+	}
+	log.Infof("Deleted the root qdisc of %v.", intfName)
+	return nil
+}
+
+// RootQdisc returns the root qdisc of a certain network interface, e.g. "qdisc htb 1: root refcnt 2 ...".
+func (r *CommandRunner) RootQdisc(intfName string) (string, error) {
+	return r.ExecCommand(true, "tc", "qdisc", "show", "dev", intfName, "root")
+}
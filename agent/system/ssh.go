@@ -0,0 +1,321 @@
+/* Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package system
+
+import (
+	ctx "context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/golang/glog"
+	"github.com/google/link022/agent/gnmi"
+	"github.com/google/link022/agent/syscmd"
+	"github.com/google/link022/agent/util/ocutil"
+	"github.com/google/link022/generated/ocstruct"
+	"github.com/openconfig/ygot/ygot"
+)
+
+const (
+	sshdConfigFolderName = "ssh"
+	sshdConfigFileName   = "sshd_config"
+	sshRulesFileName     = "ssh.nft"
+	sshTableFamily       = "inet"
+	sshTableName         = "link022_ssh"
+
+	// The settings of the agent are a block at the top of sshd_config, sshd uses the first value of each option.
+	sshdConfigBegin = "# BEGIN link022 agent settings"
+	sshdConfigEnd   = "# END link022 agent settings"
+
+	// defaultSSHPort is the port sshd listens on without a Port option.
+	defaultSSHPort = 22
+)
+
+// SSHServer applies the SSH server settings of the AP: it starts and stops sshd, sets its idle timeout
+// and limits its connections with nftables rules.
+type SSHServer struct {
+	cmdRunner *syscmd.CommandRunner
+	hostName  string
+
+	// applied contains the last settings applied, nil if none was applied.
+	applied *ocutil.SSHServer
+	// active indicates sshd was running after the last update.
+	active       bool
+	rulesApplied bool
+	appliedRules string
+}
+
+// NewSSHServer creates a SSHServer applying the settings of the AP with the given hostname.
+func NewSSHServer(cmdRunner *syscmd.CommandRunner, hostName string) *SSHServer {
+	return &SSHServer{
+		cmdRunner: cmdRunner,
+		hostName:  hostName,
+	}
+}
+
+// Run applies the SSH server settings periodically until the context is done.
+// The settings are loaded from the GNMI server, and the SSH server state is published there.
+func (s *SSHServer) Run(bkgdContext ctx.Context, gnmiServer *gnmi.Server) {
+	refresh := time.NewTicker(refreshInterval)
+	defer refresh.Stop()
+	for {
+		ssh := sshSettings(gnmiServer, s.hostName)
+		if err := s.Update(ssh); err != nil {
+			log.Errorf("Error in applying the SSH server settings: %v", err)
+		}
+		s.publishState(gnmiServer)
+
+		select {
+		case <-bkgdContext.Done():
+			return
+		case <-refresh.C:
+		}
+	}
+}
+
+// Update applies the given SSH server settings. Nil settings remove the settings of the agent
+// from sshd_config and nftables, and leave sshd running or stopped as it is.
+func (s *SSHServer) Update(ssh *ocutil.SSHServer) error {
+	if err := s.update(ssh); err != nil {
+		return err
+	}
+	s.applied = ssh
+	return nil
+}
+
+func (s *SSHServer) update(ssh *ocutil.SSHServer) error {
+	existing, err := ioutil.ReadFile(path.Join(etcFolder, sshdConfigFolderName, sshdConfigFileName))
+	if err != nil && ssh != nil {
+		return err
+	}
+	configChanged := false
+	if err == nil {
+		if configChanged, err = s.applySSHDConfig(ssh, string(existing)); err != nil {
+			return err
+		}
+	}
+	if err := s.applyRules(ssh, SSHPorts(string(existing))); err != nil {
+		return err
+	}
+
+	s.active = s.cmdRunner.SSHDActive()
+	switch {
+	case ssh == nil:
+		if configChanged && s.active {
+			return s.cmdRunner.ReloadSSHD()
+		}
+	case !ssh.Enable && s.active:
+		if err := s.cmdRunner.StopSSHD(); err != nil {
+			return err
+		}
+		s.active = false
+	case ssh.Enable && !s.active:
+		if err := s.cmdRunner.StartSSHD(); err != nil {
+			return err
+		}
+		s.active = true
+	case ssh.Enable && configChanged:
+		return s.cmdRunner.ReloadSSHD()
+	}
+	return nil
+}
+
+// applySSHDConfig updates the existing sshd_config if it differs from the settings. It returns whether it changed.
+func (s *SSHServer) applySSHDConfig(ssh *ocutil.SSHServer, existing string) (bool, error) {
+	config := SSHDConfig(ssh, existing)
+	if config == existing {
+		return false, nil
+	}
+
+	// sshd would not start again with an invalid configuration, it is checked before replacing the existing one.
+	if err := syscmd.SaveToFile(runFolder, sshdConfigFileName, config); err != nil {
+		return false, err
+	}
+	if err := s.cmdRunner.CheckSSHDConfig(path.Join(runFolder, sshdConfigFileName)); err != nil {
+		return false, fmt.Errorf("invalid sshd configuration: %v", err)
+	}
+	if err := syscmd.SaveToPublicFile(path.Join(etcFolder, sshdConfigFolderName), sshdConfigFileName, config); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// applyRules installs the nftables rules limiting the SSH connections to the given ports, if they changed.
+func (s *SSHServer) applyRules(ssh *ocutil.SSHServer, ports []int) error {
+	rules := SSHRuleset(ssh, ports)
+	if s.rulesApplied && rules == s.appliedRules {
+		return nil
+	}
+
+	if len(rules) == 0 {
+		// The table may not exist, e.g. no limit was installed before the agent started.
+		if err := s.cmdRunner.DeleteNftTable(sshTableFamily, sshTableName); err != nil && s.rulesApplied {
+			return err
+		}
+	} else {
+		if err := syscmd.SaveToFile(runFolder, sshRulesFileName, rules); err != nil {
+			return err
+		}
+		if err := s.cmdRunner.ApplyNftRules(path.Join(runFolder, sshRulesFileName)); err != nil {
+			return err
+		}
+	}
+	s.rulesApplied = true
+	s.appliedRules = rules
+	return nil
+}
+
+// SSHDConfig generates sshd_config with the given SSH server settings, from the existing sshd_config.
+// The settings of the agent replace the ones it previously added, other lines are kept as they are.
+func SSHDConfig(ssh *ocutil.SSHServer, existing string) string {
+	config := ""
+	if ssh != nil && ssh.Timeout != 0 {
+		config += sshdConfigBegin + "\n"
+		// sshd closes the sessions without traffic for the timeout, and the connections without any session.
+		config += fmt.Sprintf("ChannelTimeout session=%ds\n", ssh.Timeout)
+		config += fmt.Sprintf("UnusedConnectionTimeout %ds\n", ssh.Timeout)
+		config += sshdConfigEnd + "\n"
+	}
+
+	inBlock := false
+	for _, line := range strings.SplitAfter(existing, "\n") {
+		switch strings.TrimSpace(line) {
+		case sshdConfigBegin:
+			inBlock = true
+			continue
+		case sshdConfigEnd:
+			inBlock = false
+			continue
+		}
+		if !inBlock {
+			config += line
+		}
+	}
+	return config
+}
+
+// SSHPorts returns the ports sshd listens on with the given sshd_config, from its Port options.
+func SSHPorts(config string) []int {
+	var ports []int
+	for _, line := range strings.Split(config, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 || !strings.EqualFold(fields[0], "Port") {
+			continue
+		}
+		if port, err := strconv.Atoi(fields[1]); err == nil {
+			ports = append(ports, port)
+		}
+	}
+	if len(ports) == 0 {
+		return []int{defaultSSHPort}
+	}
+	return ports
+}
+
+// SSHRuleset generates the nftables rules limiting the connections to the SSH server listening on the given ports,
+// with the given settings. It returns an empty string if the connections are not limited.
+func SSHRuleset(ssh *ocutil.SSHServer, ports []int) string {
+	if ssh == nil || (ssh.RateLimit == 0 && ssh.SessionLimit == 0) {
+		return ""
+	}
+
+	var portList []string
+	for _, port := range ports {
+		portList = append(portList, strconv.Itoa(port))
+	}
+	dport := portList[0]
+	if len(portList) > 1 {
+		dport = "{ " + strings.Join(portList, ", ") + " }"
+	}
+
+	rules := fmt.Sprintf("table %s %s\ndelete table %s %s\n\n", sshTableFamily, sshTableName, sshTableFamily, sshTableName)
+	rules += fmt.Sprintf("table %s %s {\n", sshTableFamily, sshTableName)
+	rules += "\tchain input {\n\t\ttype filter hook input priority 0; policy accept;\n"
+	if ssh.RateLimit != 0 {
+		rules += fmt.Sprintf("\t\ttcp dport %s ct state new limit rate over %d/minute drop\n", dport, ssh.RateLimit)
+	}
+	if ssh.SessionLimit != 0 {
+		rules += fmt.Sprintf("\t\ttcp dport %s ct state new ct count over %d reject with tcp reset\n", dport, ssh.SessionLimit)
+	}
+	rules += "\t}\n}\n"
+	return rules
+}
+
+// publishState updates the SSH and telnet server states of the AP with the applied settings.
+// The telnet server is always disabled.
+func (s *SSHServer) publishState(gnmiServer *gnmi.Server) {
+	err := gnmiServer.InternalUpdate(func(config ygot.ValidatedGoStruct) error {
+		device, ok := config.(*ocstruct.Device)
+		if !ok {
+			return errors.New("configuration has invalid type")
+		}
+		apConfig := ocutil.FindAPConfig(device, s.hostName)
+		if apConfig == nil || apConfig.System == nil {
+			return nil
+		}
+		if apConfig.System.SshServer != nil {
+			apConfig.System.SshServer.State = SSHServerState(s.applied, s.active)
+		}
+		if apConfig.System.TelnetServer != nil {
+			apConfig.System.TelnetServer.State = &ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System_TelnetServer_State{
+				Enable: ygot.Bool(false),
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Errorf("Error in updating the SSH server state: %v", err)
+	}
+}
+
+// SSHServerState generates the SSH server state with the given settings, and whether sshd is running.
+func SSHServerState(ssh *ocutil.SSHServer, active bool) *ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System_SshServer_State {
+	state := &ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System_SshServer_State{
+		Enable:          ygot.Bool(active),
+		ProtocolVersion: ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System_SshServer_Config_ProtocolVersion_V2,
+	}
+	if ssh == nil {
+		return state
+	}
+	if ssh.RateLimit != 0 {
+		state.RateLimit = ygot.Uint16(ssh.RateLimit)
+	}
+	if ssh.SessionLimit != 0 {
+		state.SessionLimit = ygot.Uint16(ssh.SessionLimit)
+	}
+	if ssh.Timeout != 0 {
+		state.Timeout = ygot.Uint16(ssh.Timeout)
+	}
+	return state
+}
+
+// sshSettings returns the SSH server settings of the AP with the given hostname.
+func sshSettings(gnmiServer *gnmi.Server, hostName string) *ocutil.SSHServer {
+	var ssh *ocutil.SSHServer
+	gnmiServer.InternalUpdate(func(config ygot.ValidatedGoStruct) error {
+		device, ok := config.(*ocstruct.Device)
+		if !ok {
+			return errors.New("configuration has invalid type")
+		}
+		ssh = ocutil.SSHServerSettings(ocutil.FindAPConfig(device, hostName))
+		return nil
+	})
+	return ssh
+}
@@ -0,0 +1,184 @@
+/* Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package system
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/link022/agent/syscmd"
+	"github.com/google/link022/agent/util/ocutil"
+)
+
+const testSSHDConfig = `# See the sshd_config(5) manpage for details
+PermitRootLogin prohibit-password
+ClientAliveInterval 60
+`
+
+// testSSHServer creates a SSHServer writing its files to temp folders, with sshd running while sshdActive is set.
+// It returns the SSHServer, the commands it runs and a func restoring the folders.
+func testSSHServer(t *testing.T, sshdActive *bool) (*SSHServer, *[]string, func()) {
+	tempFolder, err := ioutil.TempDir("", "link022")
+	if err != nil {
+		t.Fatalf("Unable to create a temp folder.")
+	}
+	originalRunFolder, originalEtcFolder := runFolder, etcFolder
+	runFolder = path.Join(tempFolder, "run")
+	etcFolder = path.Join(tempFolder, "etc")
+	if err := syscmd.SaveToPublicFile(path.Join(etcFolder, sshdConfigFolderName), sshdConfigFileName, testSSHDConfig); err != nil {
+		t.Fatalf("Unable to create sshd_config. Error: %v.", err)
+	}
+
+	var cmds []string
+	cmdRunner := &syscmd.CommandRunner{
+		ExecCommand: func(wait bool, cmd string, args ...string) (string, error) {
+			cmdLine := cmd + " " + strings.Join(args, " ")
+			cmds = append(cmds, cmdLine)
+			if cmdLine == "systemctl is-active --quiet ssh" && !*sshdActive {
+				return "", errors.New("exit status 3")
+			}
+			return "", nil
+		},
+	}
+	return NewSSHServer(cmdRunner, testHostname), &cmds, func() {
+		runFolder, etcFolder = originalRunFolder, originalEtcFolder
+		os.RemoveAll(tempFolder)
+	}
+}
+
+func TestSSHDConfig(t *testing.T) {
+	config := SSHDConfig(&ocutil.SSHServer{Enable: true, Timeout: 600}, testSSHDConfig)
+	want := `# BEGIN link022 agent settings
+ChannelTimeout session=600s
+UnusedConnectionTimeout 600s
+# END link022 agent settings
+` + testSSHDConfig
+	if config != want {
+		t.Errorf("Incorrect sshd_config (got:\n%s\nwant:\n%s).", config, want)
+	}
+
+	// The settings of the agent are replaced.
+	if got := SSHDConfig(&ocutil.SSHServer{Enable: true, Timeout: 300}, config); !strings.HasPrefix(got, sshdConfigBegin+"\nChannelTimeout session=300s\n") || strings.Count(got, sshdConfigBegin) != 1 {
+		t.Errorf("Incorrect sshd_config with a new timeout:\n%s", got)
+	}
+	if got := SSHDConfig(nil, config); got != testSSHDConfig {
+		t.Errorf("Incorrect sshd_config without settings (got:\n%s\nwant:\n%s).", got, testSSHDConfig)
+	}
+}
+
+func TestSSHPorts(t *testing.T) {
+	if ports := SSHPorts(testSSHDConfig); !reflect.DeepEqual(ports, []int{22}) {
+		t.Errorf("Incorrect default ports %v.", ports)
+	}
+	config := testSSHDConfig + "Port 2222\n#Port 2022\nport 8022\n"
+	if ports, want := SSHPorts(config), []int{2222, 8022}; !reflect.DeepEqual(ports, want) {
+		t.Errorf("Incorrect ports %v, want %v.", ports, want)
+	}
+}
+
+func TestSSHRuleset(t *testing.T) {
+	if rules := SSHRuleset(&ocutil.SSHServer{Enable: true, Timeout: 600}, []int{22}); rules != "" {
+		t.Errorf("Expected no rules without limits, got:\n%s", rules)
+	}
+	rules := SSHRuleset(&ocutil.SSHServer{Enable: true, RateLimit: 10, SessionLimit: 4}, []int{22})
+	want := `table inet link022_ssh
+delete table inet link022_ssh
+
+table inet link022_ssh {
+	chain input {
+		type filter hook input priority 0; policy accept;
+		tcp dport 22 ct state new limit rate over 10/minute drop
+		tcp dport 22 ct state new ct count over 4 reject with tcp reset
+	}
+}
+`
+	if rules != want {
+		t.Errorf("Incorrect SSH rules (got:\n%s\nwant:\n%s).", rules, want)
+	}
+
+	rules = SSHRuleset(&ocutil.SSHServer{Enable: true, RateLimit: 10}, []int{22, 2222})
+	if want := "\t\ttcp dport { 22, 2222 } ct state new limit rate over 10/minute drop\n"; !strings.Contains(rules, want) {
+		t.Errorf("Incorrect SSH rules with two ports (got:\n%s\nwant:\n%s).", rules, want)
+	}
+}
+
+func TestUpdateSSHServer(t *testing.T) {
+	sshdActive := true
+	s, cmds, restore := testSSHServer(t, &sshdActive)
+	defer restore()
+	rulesPath := path.Join(runFolder, sshRulesFileName)
+
+	settings := &ocutil.SSHServer{Enable: true, RateLimit: 10, Timeout: 600}
+	if err := s.Update(settings); err != nil {
+		t.Fatalf("Applying the SSH server settings failed. Error: %v.", err)
+	}
+	wantCmds := []string{
+		"sshd -t -f " + path.Join(runFolder, sshdConfigFileName),
+		"nft -f " + rulesPath,
+		"systemctl is-active --quiet ssh",
+		"systemctl reload ssh",
+	}
+	if !reflect.DeepEqual(*cmds, wantCmds) {
+		t.Errorf("Incorrect commands applying the settings (got: %v, want: %v).", *cmds, wantCmds)
+	}
+	if config, err := ioutil.ReadFile(path.Join(etcFolder, sshdConfigFolderName, sshdConfigFileName)); err != nil || !strings.Contains(string(config), "ChannelTimeout session=600s\n") {
+		t.Errorf("Incorrect sshd_config (error: %v):\n%s", err, config)
+	}
+
+	// Nothing changes while the settings are unchanged.
+	*cmds = nil
+	s.Update(settings)
+	wantCmds = []string{"systemctl is-active --quiet ssh"}
+	if !reflect.DeepEqual(*cmds, wantCmds) {
+		t.Errorf("Incorrect commands with unchanged settings (got: %v, want: %v).", *cmds, wantCmds)
+	}
+
+	// Disabling the server stops sshd.
+	*cmds = nil
+	if err := s.Update(&ocutil.SSHServer{Enable: false}); err != nil {
+		t.Fatalf("Disabling the SSH server failed. Error: %v.", err)
+	}
+	wantCmds = []string{
+		"sshd -t -f " + path.Join(runFolder, sshdConfigFileName),
+		"nft delete table inet link022_ssh",
+		"systemctl is-active --quiet ssh",
+		"systemctl disable --now ssh ssh.socket",
+		"pkill -x sshd(-session)?",
+	}
+	if !reflect.DeepEqual(*cmds, wantCmds) {
+		t.Errorf("Incorrect commands disabling the server (got: %v, want: %v).", *cmds, wantCmds)
+	}
+	if state := SSHServerState(s.applied, s.active); *state.Enable {
+		t.Errorf("Expected a disabled SSH server state, got %+v.", state)
+	}
+
+	// sshd is started again once enabled.
+	sshdActive = false
+	*cmds = nil
+	s.Update(&ocutil.SSHServer{Enable: true})
+	wantCmds = []string{
+		"systemctl is-active --quiet ssh",
+		"systemctl enable --now ssh",
+	}
+	if !reflect.DeepEqual(*cmds, wantCmds) {
+		t.Errorf("Incorrect commands enabling the server (got: %v, want: %v).", *cmds, wantCmds)
+	}
+}
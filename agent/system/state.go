@@ -0,0 +1,197 @@
+/* Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package system
+
+import (
+	"errors"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+
+	log "github.com/golang/glog"
+	"github.com/google/link022/agent/gnmi"
+	"github.com/google/link022/agent/util/ocutil"
+	"github.com/google/link022/generated/ocstruct"
+	"github.com/openconfig/ygot/ygot"
+)
+
+// Source is an NTP source of chronyd.
+type Source struct {
+	Address string
+	Stratum uint8
+	// PollInterval is the interval between two polls, in seconds.
+	PollInterval uint32
+	// Offset is the absolute offset of the local clock to the source, in milliseconds.
+	Offset uint64
+	// Selected indicates the local clock is synchronized to the source.
+	Selected bool
+}
+
+// ParseSources parses the CSV output of the chronyc sources command, with numeric addresses.
+// It returns an address -> source map. Malformed lines are skipped.
+func ParseSources(sourcesResult string) map[string]*Source {
+	sources := make(map[string]*Source)
+	for _, line := range strings.Split(sourcesResult, "\n") {
+		// e.g. ^,*,192.168.1.1,2,6,377,35,-0.000012345,-0.000011000,0.000123000
+		fields := strings.Split(strings.TrimSpace(line), ",")
+		if len(fields) < 8 {
+			continue
+		}
+		stratum, err := strconv.ParseUint(fields[3], 10, 8)
+		if err != nil {
+			continue
+		}
+		poll, err := strconv.ParseInt(fields[4], 10, 8)
+		if err != nil || poll < 0 || poll > 31 {
+			continue
+		}
+		offset, err := strconv.ParseFloat(fields[7], 64)
+		if err != nil {
+			continue
+		}
+		sources[fields[2]] = &Source{
+			Address:      fields[2],
+			Stratum:      uint8(stratum),
+			PollInterval: 1 << uint(poll),
+			Offset:       uint64(math.Abs(offset) * 1000),
+			Selected:     fields[1] == "*",
+		}
+	}
+	return sources
+}
+
+// serverSource finds the source of an NTP server, by its address or the addresses its name resolves to.
+func (s *Services) serverSource(address string, sources map[string]*Source) *Source {
+	if ip := net.ParseIP(address); ip != nil {
+		return sources[ip.String()]
+	}
+	addrs, err := s.lookupHost(address)
+	if err != nil {
+		return nil
+	}
+	var found *Source
+	for _, addr := range addrs {
+		if source, ok := sources[addr]; ok && (found == nil || source.Selected) {
+			found = source
+		}
+	}
+	return found
+}
+
+// logSynchronization logs when the local clock gets synchronized to another source, or loses its synchronization.
+func (s *Services) logSynchronization(sources map[string]*Source) {
+	synchronizedTo := ""
+	for address, source := range sources {
+		if source.Selected {
+			synchronizedTo = address
+		}
+	}
+	if synchronizedTo == s.synchronizedTo {
+		return
+	}
+	if synchronizedTo == "" {
+		log.Warningf("The clock is not synchronized to %s any more.", s.synchronizedTo)
+	} else {
+		log.Infof("The clock is synchronized to %s.", synchronizedTo)
+	}
+	s.synchronizedTo = synchronizedTo
+}
+
+// publishState updates the system and NTP state of the AP, and the state of its NTP servers with the status of their sources.
+func (s *Services) publishState(gnmiServer *gnmi.Server, ntp *ocutil.NTP) {
+	sources := make(map[string]*Source)
+	if ntp != nil {
+		sourcesResult, err := s.cmdRunner.NTPSources()
+		if err != nil {
+			log.Errorf("Failed to fetch the NTP sources: %v", err)
+		}
+		sources = ParseSources(sourcesResult)
+	}
+	s.logSynchronization(sources)
+	serverSources := make(map[string]*Source)
+	if ntp != nil {
+		for _, server := range ntp.Servers {
+			if source := s.serverSource(server.Address, sources); source != nil {
+				serverSources[server.Address] = source
+			}
+		}
+	}
+
+	err := gnmiServer.InternalUpdate(func(config ygot.ValidatedGoStruct) error {
+		device, ok := config.(*ocstruct.Device)
+		if !ok {
+			return errors.New("configuration has invalid type")
+		}
+		apConfig := ocutil.FindAPConfig(device, s.hostName)
+		if apConfig == nil || apConfig.System == nil {
+			return nil
+		}
+		updateSystemState(apConfig.System, s.softwareVersion)
+		if apConfig.System.Ntp != nil {
+			updateNTPState(apConfig.System.Ntp, ntp != nil, serverSources)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Errorf("Error in updating the system state: %v", err)
+	}
+}
+
+// updateSystemState sets the software version in the system state, if it is known.
+func updateSystemState(system *ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System, softwareVersion string) {
+	if softwareVersion == "" {
+		return
+	}
+	if system.State == nil {
+		system.State = &ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System_State{}
+	}
+	system.State.SoftwareVersion = ygot.String(softwareVersion)
+}
+
+// updateNTPState copies the NTP settings to their state, with the status of the sources of the servers (address -> source).
+func updateNTPState(ntpConfig *ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System_Ntp, enabled bool, serverSources map[string]*Source) {
+	ntpState := &ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System_Ntp_State{
+		Enabled: ygot.Bool(enabled),
+	}
+	if ntpConfig.Config != nil {
+		ntpState.EnableNtpAuth = ntpConfig.Config.EnableNtpAuth
+		ntpState.NtpSourceAddress = ntpConfig.Config.NtpSourceAddress
+	}
+	ntpConfig.State = ntpState
+
+	if ntpConfig.Servers == nil {
+		return
+	}
+	for address, server := range ntpConfig.Servers.Server {
+		serverState := &ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System_Ntp_Servers_Server_State{
+			Address: ygot.String(address),
+		}
+		if server.Config != nil {
+			serverState.AssociationType = server.Config.AssociationType
+			serverState.Iburst = server.Config.Iburst
+			serverState.Port = server.Config.Port
+			serverState.Prefer = server.Config.Prefer
+			serverState.Version = server.Config.Version
+		}
+		if source, ok := serverSources[address]; ok {
+			serverState.Stratum = ygot.Uint8(source.Stratum)
+			serverState.PollInterval = ygot.Uint32(source.PollInterval)
+			serverState.Offset = ygot.Uint64(source.Offset)
+		}
+		server.State = serverState
+	}
+}
@@ -0,0 +1,132 @@
+/* Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package system
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/google/link022/generated/ocstruct"
+	"github.com/openconfig/ygot/ygot"
+)
+
+const testSources = `^,*,192.168.1.1,2,6,377,35,-0.001512345,-0.001511000,0.000123000
+^,+,203.0.113.10,1,10,377,300,0.012000000,0.012000000,0.001000000
+^,?,203.0.113.11,0,10,0,-,+0.000000000,+0.000000000,0.000000000
+malformed line
+`
+
+func TestParseSources(t *testing.T) {
+	want := map[string]*Source{
+		"192.168.1.1":  {Address: "192.168.1.1", Stratum: 2, PollInterval: 64, Offset: 1, Selected: true},
+		"203.0.113.10": {Address: "203.0.113.10", Stratum: 1, PollInterval: 1024, Offset: 12},
+		"203.0.113.11": {Address: "203.0.113.11", Stratum: 0, PollInterval: 1024, Offset: 0},
+	}
+	if got := ParseSources(testSources); !reflect.DeepEqual(got, want) {
+		t.Errorf("Incorrect sources (got: %v, want: %v).", got, want)
+	}
+}
+
+func TestServerSource(t *testing.T) {
+	s := NewServices(nil, testHostname, "", nil)
+	s.lookupHost = func(host string) ([]string, error) {
+		if host == "pool.ntp.org" {
+			return []string{"203.0.113.11", "203.0.113.10"}, nil
+		}
+		return nil, errors.New("no such host")
+	}
+	sources := ParseSources(testSources)
+
+	tests := []struct {
+		address string
+		want    *Source
+	}{
+		{address: "192.168.1.1", want: sources["192.168.1.1"]},
+		{address: "192.168.1.2", want: nil},
+		{address: "pool.ntp.org", want: sources["203.0.113.11"]},
+		{address: "unknown.example.com", want: nil},
+	}
+	for _, test := range tests {
+		if got := s.serverSource(test.address, sources); got != test.want {
+			t.Errorf("Incorrect source of %s (got: %v, want: %v).", test.address, got, test.want)
+		}
+	}
+}
+
+func TestUpdateNTPState(t *testing.T) {
+	ntpConfig := &ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System_Ntp{
+		Config: &ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System_Ntp_Config{
+			Enabled:       ygot.Bool(true),
+			EnableNtpAuth: ygot.Bool(false),
+		},
+		Servers: &ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System_Ntp_Servers{},
+	}
+	for _, address := range []string{"192.168.1.1", "192.168.1.2"} {
+		server, _ := ntpConfig.Servers.NewServer(address)
+		server.Config = &ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System_Ntp_Servers_Server_Config{
+			Address: ygot.String(address),
+			Iburst:  ygot.Bool(true),
+		}
+	}
+
+	updateNTPState(ntpConfig, true, map[string]*Source{"192.168.1.1": ParseSources(testSources)["192.168.1.1"]})
+
+	wantState := &ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System_Ntp_State{
+		Enabled:       ygot.Bool(true),
+		EnableNtpAuth: ygot.Bool(false),
+	}
+	if !reflect.DeepEqual(ntpConfig.State, wantState) {
+		t.Errorf("Incorrect NTP state (got: %+v, want: %+v).", ntpConfig.State, wantState)
+	}
+	wantServerStates := map[string]*ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System_Ntp_Servers_Server_State{
+		"192.168.1.1": {
+			Address:      ygot.String("192.168.1.1"),
+			Iburst:       ygot.Bool(true),
+			Stratum:      ygot.Uint8(2),
+			PollInterval: ygot.Uint32(64),
+			Offset:       ygot.Uint64(1),
+		},
+		// The server is not a source of chronyd yet.
+		"192.168.1.2": {
+			Address: ygot.String("192.168.1.2"),
+			Iburst:  ygot.Bool(true),
+		},
+	}
+	for address, server := range ntpConfig.Servers.Server {
+		if !reflect.DeepEqual(server.State, wantServerStates[address]) {
+			t.Errorf("Incorrect state of NTP server %s (got: %+v, want: %+v).", address, server.State, wantServerStates[address])
+		}
+	}
+}
+
+func TestUpdateSystemState(t *testing.T) {
+	system := &ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System{}
+	updateSystemState(system, "")
+	if system.State != nil {
+		t.Errorf("Expected no system state without software version, got %+v.", system.State)
+	}
+
+	system.State = &ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System_State{Hostname: ygot.String(testHostname)}
+	updateSystemState(system, "1.1")
+	want := &ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System_State{
+		Hostname:        ygot.String(testHostname),
+		SoftwareVersion: ygot.String("1.1"),
+	}
+	if !reflect.DeepEqual(system.State, want) {
+		t.Errorf("Incorrect system state (got: %+v, want: %+v).", system.State, want)
+	}
+}
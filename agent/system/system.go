@@ -0,0 +1,368 @@
+/* Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package system applies the system services settings of the AP: the NTP client, the DNS resolver,
+// the static host entries and the timezone.
+//
+// The agent only writes resolv.conf here, with the DNS servers of the system settings followed by the ones of
+// the management interface.
+//
+// The NTP client is a chronyd process supervised by the agent. It is restarted when its configuration
+// changes or when it exits. The synchronization status of its sources is published in the NTP state of the AP.
+package system
+
+import (
+	ctx "context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/golang/glog"
+	"github.com/google/link022/agent/gnmi"
+	"github.com/google/link022/agent/syscmd"
+	"github.com/google/link022/agent/util/ocutil"
+	"github.com/google/link022/generated/ocstruct"
+	"github.com/openconfig/ygot/ygot"
+)
+
+const (
+	// refreshInterval is how often the settings are applied, chronyd is checked and the NTP state is published.
+	refreshInterval = 10 * time.Second
+
+	chronyConfFileName  = "chrony.conf"
+	chronyKeysFileName  = "chrony.keys"
+	chronyDriftFileName = "chrony.drift"
+	resolvConfFileName  = "resolv.conf"
+	hostsFileName       = "hosts"
+	timezoneFileName    = "timezone"
+	localtimeFileName   = "localtime"
+)
+
+var (
+	runFolder      = "/var/run/link022"
+	etcFolder      = "/etc"
+	zoneinfoFolder = "/usr/share/zoneinfo"
+
+	errInvalidTimezone = errors.New("invalid timezone")
+)
+
+// Services applies the system services settings of the AP and supervises chronyd.
+type Services struct {
+	cmdRunner *syscmd.CommandRunner
+	hostName  string
+	// softwareVersion is the version of the running agent, published in the system state.
+	softwareVersion string
+	// lookupHost resolves NTP servers configured by name, to find their sources in chronyd.
+	lookupHost func(host string) ([]string, error)
+	// synchronizedTo is the source the local clock is synchronized to, only used to log its changes.
+	synchronizedTo string
+	// managementDNS returns the DNS servers of the management interface, may be nil.
+	managementDNS func() []string
+
+	mu sync.Mutex
+	// chronyConf is the configuration chronyd runs with, empty if NTP is disabled.
+	chronyConf string
+	// hostsManaged indicates the hosts file was generated from the settings.
+	hostsManaged bool
+}
+
+// NewServices creates a Services applying the settings of the AP with the given hostname.
+// The software version is published in the system state unless it is empty.
+// resolv.conf also lists the DNS servers returned by managementDNS, unless it is nil.
+func NewServices(cmdRunner *syscmd.CommandRunner, hostName, softwareVersion string, managementDNS func() []string) *Services {
+	return &Services{
+		cmdRunner:       cmdRunner,
+		hostName:        hostName,
+		softwareVersion: softwareVersion,
+		lookupHost:      net.LookupHost,
+		managementDNS:   managementDNS,
+	}
+}
+
+// Run applies the system services settings periodically until the context is done.
+// The settings are loaded from the GNMI server, and the NTP state is published there.
+func (s *Services) Run(bkgdContext ctx.Context, gnmiServer *gnmi.Server) {
+	refresh := time.NewTicker(refreshInterval)
+	defer refresh.Stop()
+	defer s.stop()
+	for {
+		ntp, dns, timezone := systemSettings(gnmiServer, s.hostName)
+		for _, err := range s.Update(ntp, dns, timezone) {
+			log.Errorf("Error in applying the system services settings: %v", err)
+		}
+		s.publishState(gnmiServer, ntp)
+
+		select {
+		case <-bkgdContext.Done():
+			return
+		case <-refresh.C:
+		}
+	}
+}
+
+// Update applies the given NTP, DNS and timezone settings. Nil settings or an empty timezone leave the
+// system as it is, except chronyd which is stopped without NTP settings.
+// It goes through all settings even if some fail, and returns all errors.
+func (s *Services) Update(ntp *ocutil.NTP, dns *ocutil.DNS, timezone string) []error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var errs []error
+	if err := s.applyNTP(ntp); err != nil {
+		errs = append(errs, err)
+	}
+	if err := s.applyDNS(dns); err != nil {
+		errs = append(errs, err)
+	}
+	if err := s.applyTimezone(timezone); err != nil {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+// stop stops chronyd.
+func (s *Services) stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.applyNTP(nil); err != nil {
+		log.Errorf("Error in stopping chronyd: %v", err)
+	}
+}
+
+// applyNTP restarts chronyd if its configuration changed, or starts it again if it exited.
+func (s *Services) applyNTP(ntp *ocutil.NTP) error {
+	confPath := path.Join(runFolder, chronyConfFileName)
+	if ntp == nil {
+		if s.chronyConf == "" {
+			return nil
+		}
+		s.chronyConf = ""
+		return s.cmdRunner.StopChronyd(confPath)
+	}
+
+	conf, keys := ChronyConfig(ntp, path.Join(runFolder, chronyKeysFileName), path.Join(runFolder, chronyDriftFileName))
+	running := s.cmdRunner.ChronydRunning(confPath)
+	if conf == s.chronyConf {
+		if running {
+			return nil
+		}
+		log.Warning("chronyd is not running, starting it again.")
+		return s.cmdRunner.StartChronyd(confPath)
+	}
+
+	// The keys file is only readable by root, chronyd reads it before dropping its privileges.
+	if err := syscmd.SaveToFile(runFolder, chronyKeysFileName, keys); err != nil {
+		return err
+	}
+	if err := syscmd.SaveToFile(runFolder, chronyConfFileName, conf); err != nil {
+		return err
+	}
+	if running {
+		// chronyd may also be left by a previous run of the agent.
+		if err := s.cmdRunner.StopChronyd(confPath); err != nil {
+			return err
+		}
+	}
+	if err := s.cmdRunner.StartChronyd(confPath); err != nil {
+		return err
+	}
+	s.chronyConf = conf
+	return nil
+}
+
+// ChronyConfig generates the chronyd configuration of the given NTP settings, and the content of its keys file.
+// The keys are only used if authentication is enabled, each server with its own key. Servers without a known key
+// are left out then, so that the clock is never synchronized to an unauthenticated server.
+func ChronyConfig(ntp *ocutil.NTP, keysFilePath, driftFilePath string) (string, string) {
+	conf := fmt.Sprintf("driftfile %s\n", driftFilePath)
+	// The device may have no RTC, step the clock if it is far off after boot.
+	conf += "makestep 1.0 3\n"
+	if ntp.SourceAddress != "" {
+		conf += fmt.Sprintf("bindacqaddress %s\n", ntp.SourceAddress)
+	}
+
+	keys := ""
+	keyIDs := make(map[uint16]bool)
+	if ntp.Auth && len(ntp.Keys) != 0 {
+		conf += fmt.Sprintf("keyfile %s\n", keysFilePath)
+		for _, key := range ntp.Keys {
+			keys += fmt.Sprintf("%d %s %s\n", key.ID, key.Type, key.Value)
+			keyIDs[key.ID] = true
+		}
+	}
+
+	for _, server := range ntp.Servers {
+		if ntp.Auth && !keyIDs[server.KeyID] {
+			log.Warningf("NTP server %s has no valid key, skipping it.", server.Address)
+			continue
+		}
+		conf += fmt.Sprintf("%s %s", server.Type, server.Address)
+		if server.Port != 0 {
+			conf += fmt.Sprintf(" port %d", server.Port)
+		}
+		if server.Version != 0 {
+			conf += fmt.Sprintf(" version %d", server.Version)
+		}
+		if server.IBurst {
+			conf += " iburst"
+		}
+		if server.Prefer {
+			conf += " prefer"
+		}
+		if ntp.Auth {
+			conf += fmt.Sprintf(" key %d", server.KeyID)
+		}
+		conf += "\n"
+	}
+	return conf, keys
+}
+
+// applyDNS updates resolv.conf and the hosts file if they differ from the settings.
+func (s *Services) applyDNS(dns *ocutil.DNS) error {
+	if dns == nil {
+		dns = &ocutil.DNS{}
+	}
+
+	var managementServers []string
+	if s.managementDNS != nil {
+		managementServers = s.managementDNS()
+	}
+	if dns.HasResolver() || len(managementServers) != 0 {
+		existing, _ := ioutil.ReadFile(path.Join(etcFolder, resolvConfFileName))
+		if err := saveIfChanged(resolvConfFileName, ResolvConf(dns, managementServers, string(existing))); err != nil {
+			return err
+		}
+	}
+
+	if len(dns.Hosts) == 0 && !s.hostsManaged {
+		return nil
+	}
+	// Once managed, the hosts file is kept with the entries of the device only.
+	if err := saveIfChanged(hostsFileName, Hosts(s.hostName, dns.Hosts)); err != nil {
+		return err
+	}
+	s.hostsManaged = true
+	return nil
+}
+
+// ResolvConf generates resolv.conf with the given DNS settings, followed by the DNS servers of the management interface.
+// Without DNS servers, the name servers of the existing resolv.conf are kept, e.g. the ones set by DHCP.
+func ResolvConf(dns *ocutil.DNS, managementServers []string, existing string) string {
+	resolvConf := ""
+	if len(dns.Search) != 0 {
+		resolvConf += fmt.Sprintf("search %s\n", strings.Join(dns.Search, " "))
+	}
+	servers := append([]string(nil), dns.Servers...)
+	for _, server := range managementServers {
+		if !contains(servers, server) {
+			servers = append(servers, server)
+		}
+	}
+	if len(servers) == 0 {
+		for _, line := range strings.Split(existing, "\n") {
+			if fields := strings.Fields(line); len(fields) != 0 && fields[0] == "nameserver" {
+				resolvConf += strings.Join(fields, " ") + "\n"
+			}
+		}
+	}
+	for _, server := range servers {
+		resolvConf += fmt.Sprintf("nameserver %s\n", server)
+	}
+	return resolvConf
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Hosts generates the hosts file with the entries of the device and the given host entries.
+func Hosts(hostName string, entries []ocutil.HostEntry) string {
+	hosts := "127.0.0.1\tlocalhost\n"
+	hosts += "::1\t\tlocalhost ip6-localhost ip6-loopback\n"
+	hosts += "ff02::1\t\tip6-allnodes\n"
+	hosts += "ff02::2\t\tip6-allrouters\n\n"
+	hosts += fmt.Sprintf("127.0.1.1\t%s\n", hostName)
+	if len(entries) != 0 {
+		hosts += "\n"
+	}
+	for _, entry := range entries {
+		names := strings.Join(append([]string{entry.Hostname}, entry.Aliases...), " ")
+		for _, address := range entry.Addresses {
+			hosts += fmt.Sprintf("%s\t%s\n", address, names)
+		}
+	}
+	return hosts
+}
+
+// applyTimezone points the local time to the zoneinfo file of the given timezone, if it does not already.
+// The agent keeps the timezone it started with for its own logs.
+func (s *Services) applyTimezone(timezone string) error {
+	if timezone == "" {
+		return nil
+	}
+	zoneinfoPath := path.Join(zoneinfoFolder, timezone)
+	if !strings.HasPrefix(zoneinfoPath, zoneinfoFolder+"/") {
+		return fmt.Errorf("%v %q", errInvalidTimezone, timezone)
+	}
+	if fileInfo, err := os.Stat(zoneinfoPath); err != nil || !fileInfo.Mode().IsRegular() {
+		return fmt.Errorf("%v %q", errInvalidTimezone, timezone)
+	}
+
+	if target, err := os.Readlink(path.Join(etcFolder, localtimeFileName)); err != nil || target != zoneinfoPath {
+		if err := s.cmdRunner.SetLocaltime(zoneinfoPath); err != nil {
+			return err
+		}
+	}
+	return saveIfChanged(timezoneFileName, timezone+"\n")
+}
+
+// saveIfChanged saves a file in the system configuration folder, if its content differs.
+func saveIfChanged(fileName, content string) error {
+	if existing, err := ioutil.ReadFile(path.Join(etcFolder, fileName)); err == nil && string(existing) == content {
+		return nil
+	}
+	return syscmd.SaveToPublicFile(etcFolder, fileName, content)
+}
+
+// systemSettings returns the NTP, DNS and timezone settings of the AP with the given hostname.
+func systemSettings(gnmiServer *gnmi.Server, hostName string) (*ocutil.NTP, *ocutil.DNS, string) {
+	var ntp *ocutil.NTP
+	var dns *ocutil.DNS
+	var timezone string
+	gnmiServer.InternalUpdate(func(config ygot.ValidatedGoStruct) error {
+		device, ok := config.(*ocstruct.Device)
+		if !ok {
+			return errors.New("configuration has invalid type")
+		}
+		apConfig := ocutil.FindAPConfig(device, hostName)
+		ntp = ocutil.NTPSettings(apConfig)
+		dns = ocutil.DNSSettings(apConfig)
+		timezone = ocutil.TimezoneName(apConfig)
+		return nil
+	})
+	return ntp, dns, timezone
+}
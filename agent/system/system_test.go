@@ -0,0 +1,305 @@
+/* Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package system
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/link022/agent/syscmd"
+	"github.com/google/link022/agent/util/ocutil"
+)
+
+const testHostname = "test-pi-1"
+
+var testNTP = &ocutil.NTP{
+	Servers: []ocutil.NTPServer{
+		{Address: "192.168.1.1", Port: 1123, Version: 3, Type: "server", Prefer: true, KeyID: 20},
+		{Address: "pool.ntp.org", Type: "pool", IBurst: true, KeyID: 10},
+	},
+	Keys:          []ocutil.NTPKey{{ID: 10, Type: "MD5", Value: "secret10"}, {ID: 20, Type: "MD5", Value: "secret20"}},
+	Auth:          true,
+	SourceAddress: "192.168.1.20",
+}
+
+// testServices creates a Services writing its files to temp folders, with a chronyd running while chronydRunning is set.
+// It returns the Services, the commands it runs and a func restoring the folders.
+func testServices(t *testing.T, chronydRunning *bool) (*Services, *[]string, func()) {
+	tempFolder, err := ioutil.TempDir("", "link022")
+	if err != nil {
+		t.Fatalf("Unable to create a temp folder.")
+	}
+	originalRunFolder, originalEtcFolder, originalZoneinfoFolder := runFolder, etcFolder, zoneinfoFolder
+	runFolder = path.Join(tempFolder, "run")
+	etcFolder = path.Join(tempFolder, "etc")
+	zoneinfoFolder = path.Join(tempFolder, "zoneinfo")
+
+	var cmds []string
+	cmdRunner := &syscmd.CommandRunner{
+		ExecCommand: func(wait bool, cmd string, args ...string) (string, error) {
+			cmdLine := cmd + " " + strings.Join(args, " ")
+			cmds = append(cmds, cmdLine)
+			if cmd == "pgrep" && !*chronydRunning {
+				return "", errors.New("exit status 1")
+			}
+			return "", nil
+		},
+	}
+	return NewServices(cmdRunner, testHostname, "", nil), &cmds, func() {
+		runFolder, etcFolder, zoneinfoFolder = originalRunFolder, originalEtcFolder, originalZoneinfoFolder
+		os.RemoveAll(tempFolder)
+	}
+}
+
+func TestChronyConfig(t *testing.T) {
+	conf, keys := ChronyConfig(testNTP, "/var/run/link022/chrony.keys", "/var/run/link022/chrony.drift")
+	wantConf := `driftfile /var/run/link022/chrony.drift
+makestep 1.0 3
+bindacqaddress 192.168.1.20
+keyfile /var/run/link022/chrony.keys
+server 192.168.1.1 port 1123 version 3 prefer key 20
+pool pool.ntp.org iburst key 10
+`
+	if conf != wantConf {
+		t.Errorf("Incorrect chronyd config (got:\n%s\nwant:\n%s).", conf, wantConf)
+	}
+	if keys != "10 MD5 secret10\n20 MD5 secret20\n" {
+		t.Errorf("Incorrect chronyd keys:\n%s", keys)
+	}
+
+	// Servers without a known key are not used with authentication.
+	conf, _ = ChronyConfig(&ocutil.NTP{
+		Servers: []ocutil.NTPServer{{Address: "192.168.1.1", Type: "server", KeyID: 30}, {Address: "192.168.1.2", Type: "server"}},
+		Keys:    testNTP.Keys,
+		Auth:    true,
+	}, "/var/run/link022/chrony.keys", "/var/run/link022/chrony.drift")
+	if strings.Contains(conf, "server ") {
+		t.Errorf("Unexpected servers without a valid key:\n%s", conf)
+	}
+
+	// Keys are not used without authentication.
+	conf, keys = ChronyConfig(&ocutil.NTP{
+		Servers: testNTP.Servers,
+		Keys:    testNTP.Keys,
+	}, "/var/run/link022/chrony.keys", "/var/run/link022/chrony.drift")
+	if strings.Contains(conf, "key") || keys != "" {
+		t.Errorf("Unexpected keys without authentication:\n%s\n%s", conf, keys)
+	}
+}
+
+func TestApplyNTP(t *testing.T) {
+	chronydRunning := false
+	s, cmds, restore := testServices(t, &chronydRunning)
+	defer restore()
+	confPath := path.Join(runFolder, chronyConfFileName)
+
+	if errs := s.Update(testNTP, nil, ""); len(errs) != 0 {
+		t.Fatalf("Applying the NTP settings failed. Errors: %v.", errs)
+	}
+	wantCmds := []string{
+		"pgrep -f chronyd -d -f " + confPath,
+		"chronyd -d -f " + confPath,
+	}
+	if !reflect.DeepEqual(*cmds, wantCmds) {
+		t.Errorf("Incorrect commands starting chronyd (got: %v, want: %v).", *cmds, wantCmds)
+	}
+	if keys, err := ioutil.ReadFile(path.Join(runFolder, chronyKeysFileName)); err != nil || string(keys) != "10 MD5 secret10\n20 MD5 secret20\n" {
+		t.Errorf("Incorrect chronyd keys file (error: %v):\n%s", err, keys)
+	}
+
+	// chronyd is left running while the settings are unchanged.
+	chronydRunning = true
+	*cmds = nil
+	s.Update(testNTP, nil, "")
+	wantCmds = []string{"pgrep -f chronyd -d -f " + confPath}
+	if !reflect.DeepEqual(*cmds, wantCmds) {
+		t.Errorf("Incorrect commands checking chronyd (got: %v, want: %v).", *cmds, wantCmds)
+	}
+
+	// chronyd is started again when it exits.
+	chronydRunning = false
+	*cmds = nil
+	s.Update(testNTP, nil, "")
+	wantCmds = []string{
+		"pgrep -f chronyd -d -f " + confPath,
+		"chronyd -d -f " + confPath,
+	}
+	if !reflect.DeepEqual(*cmds, wantCmds) {
+		t.Errorf("Incorrect commands restarting chronyd (got: %v, want: %v).", *cmds, wantCmds)
+	}
+
+	// chronyd is restarted when the settings change.
+	chronydRunning = true
+	*cmds = nil
+	s.Update(&ocutil.NTP{Servers: testNTP.Servers[:1]}, nil, "")
+	wantCmds = []string{
+		"pgrep -f chronyd -d -f " + confPath,
+		"pkill -f chronyd -d -f " + confPath,
+		"chronyd -d -f " + confPath,
+	}
+	if !reflect.DeepEqual(*cmds, wantCmds) {
+		t.Errorf("Incorrect commands reconfiguring chronyd (got: %v, want: %v).", *cmds, wantCmds)
+	}
+
+	// chronyd is stopped when NTP is disabled.
+	*cmds = nil
+	s.Update(nil, nil, "")
+	s.Update(nil, nil, "")
+	wantCmds = []string{"pkill -f chronyd -d -f " + confPath}
+	if !reflect.DeepEqual(*cmds, wantCmds) {
+		t.Errorf("Incorrect commands stopping chronyd (got: %v, want: %v).", *cmds, wantCmds)
+	}
+}
+
+func TestResolvConf(t *testing.T) {
+	existing := "# Generated by resolvconf\nnameserver 10.0.0.1\nnameserver  10.0.0.2\noptions rotate\n"
+	tests := []struct {
+		dns               *ocutil.DNS
+		managementServers []string
+		want              string
+	}{{
+		dns:  &ocutil.DNS{Servers: []string{"192.168.1.53", "2001:db8::53"}, Search: []string{"corp.example.com", "example.com"}},
+		want: "search corp.example.com example.com\nnameserver 192.168.1.53\nnameserver 2001:db8::53\n",
+	}, {
+		// The name servers set by DHCP are kept.
+		dns:  &ocutil.DNS{Search: []string{"example.com"}},
+		want: "search example.com\nnameserver 10.0.0.1\nnameserver 10.0.0.2\n",
+	}, {
+		// The servers of the management interface follow the system ones.
+		dns:               &ocutil.DNS{Servers: []string{"192.168.1.53"}, Search: []string{"example.com"}},
+		managementServers: []string{"10.0.0.53", "192.168.1.53"},
+		want:              "search example.com\nnameserver 192.168.1.53\nnameserver 10.0.0.53\n",
+	}, {
+		dns:               &ocutil.DNS{},
+		managementServers: []string{"10.0.0.53"},
+		want:              "nameserver 10.0.0.53\n",
+	}}
+
+	for _, test := range tests {
+		if got := ResolvConf(test.dns, test.managementServers, existing); got != test.want {
+			t.Errorf("Incorrect resolv.conf of %+v (got:\n%s\nwant:\n%s).", test.dns, got, test.want)
+		}
+	}
+}
+
+func TestApplyDNS(t *testing.T) {
+	chronydRunning := false
+	s, _, restore := testServices(t, &chronydRunning)
+	defer restore()
+
+	// Files are left as they are without settings.
+	if errs := s.Update(nil, nil, ""); len(errs) != 0 {
+		t.Fatalf("Applying no DNS settings failed. Errors: %v.", errs)
+	}
+	for _, fileName := range []string{resolvConfFileName, hostsFileName} {
+		if _, err := os.Stat(path.Join(etcFolder, fileName)); !os.IsNotExist(err) {
+			t.Errorf("Unexpected %s without DNS settings.", fileName)
+		}
+	}
+
+	dns := &ocutil.DNS{
+		Servers: []string{"192.168.1.53"},
+		Hosts: []ocutil.HostEntry{{
+			Hostname:  "radius",
+			Aliases:   []string{"radius.corp.example.com"},
+			Addresses: []string{"192.168.1.10", "2001:db8::10"},
+		}},
+	}
+	if errs := s.Update(nil, dns, ""); len(errs) != 0 {
+		t.Fatalf("Applying the DNS settings failed. Errors: %v.", errs)
+	}
+	if resolvConf, err := ioutil.ReadFile(path.Join(etcFolder, resolvConfFileName)); err != nil || string(resolvConf) != "nameserver 192.168.1.53\n" {
+		t.Errorf("Incorrect resolv.conf (error: %v):\n%s", err, resolvConf)
+	}
+	hosts, err := ioutil.ReadFile(path.Join(etcFolder, hostsFileName))
+	if err != nil {
+		t.Fatalf("Unable to read the hosts file. Error: %v.", err)
+	}
+	wantEntries := "\n192.168.1.10\tradius radius.corp.example.com\n2001:db8::10\tradius radius.corp.example.com\n"
+	if !strings.Contains(string(hosts), fmt.Sprintf("127.0.1.1\t%s\n", testHostname)) || !strings.HasSuffix(string(hosts), wantEntries) {
+		t.Errorf("Incorrect hosts file:\n%s", hosts)
+	}
+
+	// The DNS servers of the management interface are merged in, even without system DNS settings.
+	s.managementDNS = func() []string { return []string{"10.0.0.53"} }
+	if errs := s.Update(nil, dns, ""); len(errs) != 0 {
+		t.Fatalf("Applying the DNS settings failed. Errors: %v.", errs)
+	}
+	if resolvConf, err := ioutil.ReadFile(path.Join(etcFolder, resolvConfFileName)); err != nil || string(resolvConf) != "nameserver 192.168.1.53\nnameserver 10.0.0.53\n" {
+		t.Errorf("Incorrect resolv.conf with the management interface servers (error: %v):\n%s", err, resolvConf)
+	}
+	if errs := s.Update(nil, nil, ""); len(errs) != 0 {
+		t.Fatalf("Removing the DNS settings failed. Errors: %v.", errs)
+	}
+	if resolvConf, err := ioutil.ReadFile(path.Join(etcFolder, resolvConfFileName)); err != nil || string(resolvConf) != "nameserver 10.0.0.53\n" {
+		t.Errorf("Incorrect resolv.conf with the management interface servers only (error: %v):\n%s", err, resolvConf)
+	}
+	s.managementDNS = nil
+
+	// Removed host entries are removed from the hosts file.
+	if errs := s.Update(nil, nil, ""); len(errs) != 0 {
+		t.Fatalf("Removing the DNS settings failed. Errors: %v.", errs)
+	}
+	if hosts, err := ioutil.ReadFile(path.Join(etcFolder, hostsFileName)); err != nil || string(hosts) != Hosts(testHostname, nil) {
+		t.Errorf("Incorrect hosts file without entries (error: %v):\n%s", err, hosts)
+	}
+}
+
+func TestApplyTimezone(t *testing.T) {
+	chronydRunning := false
+	s, cmds, restore := testServices(t, &chronydRunning)
+	defer restore()
+
+	zoneinfoPath := path.Join(zoneinfoFolder, "America", "Los_Angeles")
+	if err := syscmd.SaveToFile(path.Dir(zoneinfoPath), path.Base(zoneinfoPath), "TZif2"); err != nil {
+		t.Fatalf("Unable to create the zoneinfo file. Error: %v.", err)
+	}
+
+	for _, timezone := range []string{"Mars/Olympus_Mons", "../../etc/passwd", "America"} {
+		if errs := s.Update(nil, nil, timezone); len(errs) != 1 {
+			t.Errorf("Expected an error for the invalid timezone %s.", timezone)
+		}
+	}
+	if len(*cmds) != 0 {
+		t.Errorf("Unexpected commands for invalid timezones: %v.", *cmds)
+	}
+
+	if errs := s.Update(nil, nil, "America/Los_Angeles"); len(errs) != 0 {
+		t.Fatalf("Applying the timezone failed. Errors: %v.", errs)
+	}
+	wantCmds := []string{"ln -sf " + zoneinfoPath + " /etc/localtime"}
+	if !reflect.DeepEqual(*cmds, wantCmds) {
+		t.Errorf("Incorrect commands setting the timezone (got: %v, want: %v).", *cmds, wantCmds)
+	}
+	if timezone, err := ioutil.ReadFile(path.Join(etcFolder, timezoneFileName)); err != nil || string(timezone) != "America/Los_Angeles\n" {
+		t.Errorf("Incorrect timezone file (error: %v):\n%s", err, timezone)
+	}
+
+	// The local time is not changed again once it points to the timezone.
+	if err := os.Symlink(zoneinfoPath, path.Join(etcFolder, localtimeFileName)); err != nil {
+		t.Fatalf("Unable to link the local time. Error: %v.", err)
+	}
+	*cmds = nil
+	if errs := s.Update(nil, nil, "America/Los_Angeles"); len(errs) != 0 || len(*cmds) != 0 {
+		t.Errorf("Unexpected commands for an unchanged timezone: %v (errors: %v).", *cmds, errs)
+	}
+}
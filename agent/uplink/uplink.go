@@ -0,0 +1,358 @@
+/* Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package uplink manages the management interface of the AP on its wired uplink.
+//
+// The management interface gets its addresses by DHCP or statically, optionally on a tagged VLAN.
+// A change of the management interface has to be confirmed by a GNMI request reaching the agent
+// after the change, otherwise the previous settings are restored when the confirmation timer expires.
+package uplink
+
+import (
+	ctx "context"
+	"errors"
+	"net"
+	"reflect"
+	"sync"
+	"time"
+
+	log "github.com/golang/glog"
+	"github.com/google/link022/agent/gnmi"
+	"github.com/google/link022/agent/syscmd"
+	"github.com/google/link022/agent/util/ocutil"
+	"github.com/google/link022/generated/ocstruct"
+	"github.com/openconfig/ygot/ygot"
+)
+
+// refreshInterval is how often the management interface settings are loaded and checked.
+const refreshInterval = 5 * time.Second
+
+var (
+	// fallbackSettings are restored when a change is not confirmed and no settings were confirmed before.
+	// The management interface gets its address by DHCP, as when the device boots.
+	fallbackSettings = &ocutil.ManagementIntf{DHCP: true}
+)
+
+// Manager applies the settings of the management interface and reverts the changes that are not confirmed.
+type Manager struct {
+	cmdRunner   *syscmd.CommandRunner
+	ethIntfName string
+	hostName    string
+	now         func() time.Time
+	// addressChanged is called with the new IPv4 address of the management interface when it changes.
+	addressChanged func(ipAddress string)
+
+	mu        sync.Mutex
+	started   bool
+	managed   bool                   // Whether the AP has management interface settings.
+	applied   *ocutil.ManagementIntf // The settings applied on the interface, nil if none.
+	confirmed *ocutil.ManagementIntf // The settings restored if the applied ones are not confirmed.
+	deadline  time.Time              // When the applied settings are reverted, zero if they are confirmed.
+	rejected  *ocutil.ManagementIntf // Reverted settings, not applied again until they change.
+	address   string                 // The IPv4 address of the management interface.
+}
+
+// NewManager creates a Manager configuring the management interface on the given wired interface with the given runner.
+// addressChanged is called with the new IPv4 address of the management interface when it changes.
+func NewManager(cmdRunner *syscmd.CommandRunner, ethIntfName, hostName string, addressChanged func(ipAddress string)) *Manager {
+	return &Manager{
+		cmdRunner:      cmdRunner,
+		ethIntfName:    ethIntfName,
+		hostName:       hostName,
+		now:            time.Now,
+		addressChanged: addressChanged,
+		confirmed:      fallbackSettings,
+	}
+}
+
+// Run applies and checks the management interface settings periodically until the context is done.
+// The settings are loaded from the GNMI server.
+func (m *Manager) Run(bkgdContext ctx.Context, gnmiServer *gnmi.Server) {
+	if err := m.Update(managementSettings(gnmiServer, m.hostName)); err != nil {
+		log.Errorf("Error in updating the management interface: %v", err)
+	}
+	refresh := time.NewTicker(refreshInterval)
+	defer refresh.Stop()
+	for {
+		select {
+		case <-bkgdContext.Done():
+			return
+		case <-refresh.C:
+			if err := m.Update(managementSettings(gnmiServer, m.hostName)); err != nil {
+				log.Errorf("Error in updating the management interface: %v", err)
+			}
+		}
+	}
+}
+
+// Update applies the given settings of the management interface if they changed, and restores the
+// static settings lost on the interface otherwise. The settings given in the first update are the ones
+// loaded when the agent starts, they are considered confirmed. Nil settings leave the interface as it is.
+func (m *Manager) Update(settings *ocutil.ManagementIntf) error {
+	address, err := m.update(settings)
+	if address != "" && m.addressChanged != nil {
+		m.addressChanged(address)
+	}
+	return err
+}
+
+// update returns the new IPv4 address of the management interface if it changed.
+func (m *Manager) update(settings *ocutil.ManagementIntf) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	initial := !m.started
+	m.started = true
+	if err := m.revertIfExpired(); err != nil {
+		return m.checkAddress(), err
+	}
+	if m.rejected != nil {
+		if reflect.DeepEqual(settings, m.rejected) {
+			return m.checkAddress(), m.reconcile()
+		}
+		m.rejected = nil
+	}
+
+	m.managed = settings != nil
+	if !m.managed {
+		m.deadline = time.Time{}
+		return m.checkAddress(), nil
+	}
+	if reflect.DeepEqual(settings, m.applied) {
+		return m.checkAddress(), m.reconcile()
+	}
+
+	// A change failing to apply is reverted like an unconfirmed one.
+	err := m.apply(settings)
+	if initial || settings.ConfirmTimeout == 0 {
+		m.confirmed = settings
+		m.deadline = time.Time{}
+	} else {
+		m.deadline = m.now().Add(settings.ConfirmTimeout)
+		log.Infof("Management interface changed, waiting %v for a confirmation.", settings.ConfirmTimeout)
+	}
+	return m.checkAddress(), err
+}
+
+// revertIfExpired restores the confirmed settings if the applied ones were not confirmed in time.
+func (m *Manager) revertIfExpired() error {
+	if m.deadline.IsZero() || m.now().Before(m.deadline) {
+		return nil
+	}
+	log.Errorf("Management interface change not confirmed in time, restoring the previous settings.")
+	m.deadline = time.Time{}
+	m.rejected = m.applied
+	return m.apply(m.confirmed)
+}
+
+// Confirm keeps the pending change of the management interface.
+func (m *Manager) Confirm() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.deadline.IsZero() {
+		return
+	}
+	m.deadline = time.Time{}
+	m.confirmed = m.applied
+	log.Info("Management interface change confirmed.")
+}
+
+// apply replaces the applied settings of the management interface with the given ones.
+func (m *Manager) apply(settings *ocutil.ManagementIntf) error {
+	previous := m.applied
+	m.applied = settings
+	intfName := settings.IntfName(m.ethIntfName)
+	log.Infof("Configuring management interface %s: %+v.", intfName, *settings)
+
+	// Addresses configured by the system before the agent managed the interface are kept.
+	if previous != nil {
+		previousIntfName := previous.IntfName(m.ethIntfName)
+		if previous.DHCP {
+			if err := m.cmdRunner.StopDHCPClient(previousIntfName); err != nil {
+				log.Warningf("Failed to stop the DHCP client on %s: %v", previousIntfName, err)
+			}
+		}
+		// The addresses are kept when the DHCP client restarts on the same interface.
+		if !previous.DHCP || !settings.DHCP || previousIntfName != intfName {
+			if err := m.cmdRunner.FlushIntfIP(previousIntfName); err != nil {
+				log.Warningf("Failed to remove the addresses of %s: %v", previousIntfName, err)
+			}
+		}
+		if previous.VLANID != 0 && previous.VLANID != settings.VLANID {
+			if err := m.cmdRunner.DeleteVLAN(m.ethIntfName, previous.VLANID); err != nil {
+				log.Warningf("Failed to delete the management VLAN %d: %v", previous.VLANID, err)
+			}
+		}
+	}
+
+	if settings.VLANID != 0 {
+		vlanIDs, err := m.cmdRunner.VLANOnIntf(m.ethIntfName)
+		if err != nil {
+			return err
+		}
+		if !containsVLAN(vlanIDs, settings.VLANID) {
+			if _, err := m.cmdRunner.CreateVLAN(m.ethIntfName, settings.VLANID); err != nil {
+				return err
+			}
+		}
+	}
+	if err := m.cmdRunner.BringUpIntf(intfName); err != nil {
+		return err
+	}
+	if settings.DHCP {
+		if err := m.cmdRunner.SendDHCPRequest(intfName, m.hostName); err != nil {
+			return err
+		}
+	}
+	return m.applyStatic(intfName, settings)
+}
+
+// applyStatic configures the static addresses and default gateways of the management interface.
+func (m *Manager) applyStatic(intfName string, settings *ocutil.ManagementIntf) error {
+	for _, prefix := range staticPrefixes(settings) {
+		if err := m.cmdRunner.SetIntfIP(intfName, prefix); err != nil {
+			return err
+		}
+	}
+	for _, gateway := range staticGateways(settings) {
+		if err := m.cmdRunner.SetDefaultRoute(intfName, gateway); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DNSServers returns the DNS servers of the settings applied on the management interface.
+// The system services write them to resolv.conf, with the DNS servers of the system settings.
+func (m *Manager) DNSServers() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.managed || m.applied == nil {
+		return nil
+	}
+	return append([]string(nil), m.applied.DNSServers...)
+}
+
+// reconcile restores the static settings of the management interface removed from the system,
+// e.g. the default routes dropped when the interface went down.
+func (m *Manager) reconcile() error {
+	if !m.managed || m.applied == nil {
+		return nil
+	}
+	intfName := m.applied.IntfName(m.ethIntfName)
+	prefixes, err := m.cmdRunner.IntfIPPrefixes(intfName)
+	if err != nil {
+		return err
+	}
+	gateways, err := m.cmdRunner.DefaultGateways(intfName)
+	if err != nil {
+		return err
+	}
+	if !containsAll(prefixes, staticPrefixes(m.applied)) || !containsAll(gateways, staticGateways(m.applied)) {
+		log.Warningf("Static settings missing on management interface %s, restoring them.", intfName)
+		return m.applyStatic(intfName, m.applied)
+	}
+	return nil
+}
+
+// checkAddress returns the IPv4 address of the management interface if it changed since the last check.
+func (m *Manager) checkAddress() string {
+	intfName := m.ethIntfName
+	if m.applied != nil {
+		intfName = m.applied.IntfName(m.ethIntfName)
+	}
+	prefixes, err := m.cmdRunner.IntfIPPrefixes(intfName)
+	if err != nil {
+		log.Errorf("Failed to fetch the addresses of management interface %s: %v", intfName, err)
+		return ""
+	}
+	for _, prefix := range prefixes {
+		ip, _, err := net.ParseCIDR(prefix)
+		if err != nil || ip.To4() == nil || ip.IsLinkLocalUnicast() {
+			continue
+		}
+		if ip.String() == m.address {
+			return ""
+		}
+		log.Infof("Management interface %s has IPv4 address %s.", intfName, ip)
+		m.address = ip.String()
+		return m.address
+	}
+	return ""
+}
+
+// staticPrefixes returns the static addresses of the management interface. The static IPv4 address
+// is not used with DHCP.
+func staticPrefixes(settings *ocutil.ManagementIntf) []string {
+	var prefixes []string
+	if !settings.DHCP && settings.IPv4Address != "" {
+		prefixes = append(prefixes, settings.IPv4Address)
+	}
+	if settings.IPv6Address != "" {
+		prefixes = append(prefixes, settings.IPv6Address)
+	}
+	return prefixes
+}
+
+// staticGateways returns the static default gateways of the management interface. The static IPv4 gateway
+// is not used with DHCP.
+func staticGateways(settings *ocutil.ManagementIntf) []string {
+	var gateways []string
+	if !settings.DHCP && settings.IPv4Gateway != "" {
+		gateways = append(gateways, settings.IPv4Gateway)
+	}
+	if settings.IPv6Gateway != "" {
+		gateways = append(gateways, settings.IPv6Gateway)
+	}
+	return gateways
+}
+
+func containsAll(list, wanted []string) bool {
+	found := make(map[string]bool)
+	for _, item := range list {
+		found[item] = true
+	}
+	for _, item := range wanted {
+		if !found[item] {
+			return false
+		}
+	}
+	return true
+}
+
+func containsVLAN(vlanIDs []int, vlanID int) bool {
+	for _, id := range vlanIDs {
+		if id == vlanID {
+			return true
+		}
+	}
+	return false
+}
+
+// managementSettings returns the management interface settings of the AP with the given hostname.
+func managementSettings(gnmiServer *gnmi.Server, hostName string) *ocutil.ManagementIntf {
+	var settings *ocutil.ManagementIntf
+	gnmiServer.InternalUpdate(func(config ygot.ValidatedGoStruct) error {
+		device, ok := config.(*ocstruct.Device)
+		if !ok {
+			return errors.New("configuration has invalid type")
+		}
+		settings = ocutil.ManagementIntfSettings(device.Gasket, ocutil.FindAPConfig(device, hostName))
+		return nil
+	})
+	return settings
+}
@@ -0,0 +1,234 @@
+/* Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package uplink
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/link022/agent/syscmd"
+	"github.com/google/link022/agent/util/ocutil"
+)
+
+const (
+	testETHIntf  = "eth0"
+	testHostname = "test-pi-1"
+)
+
+// fakeNetwork emulates the addresses and default routes of the interfaces, and records the commands
+// changing them.
+type fakeNetwork struct {
+	addrs    map[string][]string // Interface -> addresses with prefix length.
+	gateways map[string][]string // Interface -> default gateways.
+	vlanIDs  []int
+	cmds     []string
+}
+
+func newFakeNetwork() *fakeNetwork {
+	return &fakeNetwork{
+		addrs:    make(map[string][]string),
+		gateways: make(map[string][]string),
+	}
+}
+
+func (n *fakeNetwork) execCommand(wait bool, cmd string, args ...string) (string, error) {
+	cmdLine := cmd + " " + strings.Join(args, " ")
+	switch {
+	case strings.HasPrefix(cmdLine, "ip -o addr show dev "):
+		output := ""
+		for _, prefix := range n.addrs[args[4]] {
+			output += fmt.Sprintf("2: %s    inet %s scope global %s\\       valid_lft forever preferred_lft forever\n", args[4], prefix, args[4])
+		}
+		return output, nil
+	case strings.HasPrefix(cmdLine, "ip -4 route show default dev "):
+		output := ""
+		for _, gateway := range n.gateways[args[5]] {
+			output += fmt.Sprintf("default via %s proto static\n", gateway)
+		}
+		return output, nil
+	case strings.HasPrefix(cmdLine, "ip -6 route show default dev "):
+		return "", nil
+	case cmdLine == "ip -o -d link show":
+		output := ""
+		for _, vlanID := range n.vlanIDs {
+			output += fmt.Sprintf("5: eth0.%d@eth0: <BROADCAST> mtu 1500\\    vlan protocol 802.1Q id %d <REORDER_HDR>\n", vlanID, vlanID)
+		}
+		return output, nil
+	case strings.HasPrefix(cmdLine, "ip addr replace "):
+		n.addrs[args[4]] = append(n.addrs[args[4]], args[2])
+	case strings.HasPrefix(cmdLine, "ip -4 route replace default via "):
+		n.gateways[args[7]] = []string{args[5]}
+	case strings.HasPrefix(cmdLine, "ip addr flush dev "):
+		delete(n.addrs, args[3])
+		delete(n.gateways, args[3])
+	case strings.HasPrefix(cmdLine, "udhcpc -i "):
+		n.addrs[args[1]] = []string{"10.0.0.20/24"}
+	}
+	n.cmds = append(n.cmds, cmdLine)
+	return "", nil
+}
+
+func testManager() (*Manager, *fakeNetwork, *[]string) {
+	network := newFakeNetwork()
+	var addresses []string
+	m := NewManager(&syscmd.CommandRunner{ExecCommand: network.execCommand}, testETHIntf, testHostname, func(ipAddress string) {
+		addresses = append(addresses, ipAddress)
+	})
+	return m, network, &addresses
+}
+
+var staticSettings = &ocutil.ManagementIntf{
+	IPv4Address:    "192.168.1.20/24",
+	IPv4Gateway:    "192.168.1.1",
+	DNSServers:     []string{"192.168.1.53", "2001:db8::53"},
+	ConfirmTimeout: time.Minute,
+}
+
+func TestUpdate(t *testing.T) {
+	m, network, addresses := testManager()
+	now := time.Unix(1538000000, 0)
+	m.now = func() time.Time { return now }
+
+	// The settings loaded when the agent starts are confirmed.
+	if err := m.Update(staticSettings); err != nil {
+		t.Fatalf("Applying the initial settings failed. Error: %v.", err)
+	}
+	wantCmds := []string{
+		"ifconfig eth0 up",
+		"ip addr replace 192.168.1.20/24 dev eth0",
+		"ip -4 route replace default via 192.168.1.1 dev eth0",
+	}
+	if !reflect.DeepEqual(network.cmds, wantCmds) {
+		t.Errorf("Incorrect commands of the initial settings (got: %v, want: %v).", network.cmds, wantCmds)
+	}
+	if dnsServers := m.DNSServers(); !reflect.DeepEqual(dnsServers, staticSettings.DNSServers) {
+		t.Errorf("Incorrect DNS servers (got: %v, want: %v).", dnsServers, staticSettings.DNSServers)
+	}
+	if !reflect.DeepEqual(*addresses, []string{"192.168.1.20"}) {
+		t.Errorf("Incorrect address changes %v.", *addresses)
+	}
+
+	// Unchanged settings are not applied again.
+	network.cmds = nil
+	if err := m.Update(staticSettings); err != nil || len(network.cmds) != 0 {
+		t.Errorf("Unexpected commands for unchanged settings: %v (error: %v).", network.cmds, err)
+	}
+
+	// A change on a VLAN by DHCP is reverted when not confirmed.
+	dhcpSettings := &ocutil.ManagementIntf{VLANID: 10, DHCP: true, ConfirmTimeout: time.Minute}
+	network.cmds = nil
+	if err := m.Update(dhcpSettings); err != nil {
+		t.Fatalf("Applying the DHCP settings failed. Error: %v.", err)
+	}
+	wantCmds = []string{
+		"ip addr flush dev eth0 scope global",
+		"ip link add link eth0 name eth0.10 type vlan id 10",
+		"ifconfig eth0.10 up",
+		"udhcpc -i eth0.10 -x hostname:test-pi-1",
+	}
+	if !reflect.DeepEqual(network.cmds, wantCmds) {
+		t.Errorf("Incorrect commands of the DHCP settings (got: %v, want: %v).", network.cmds, wantCmds)
+	}
+	if !reflect.DeepEqual(*addresses, []string{"192.168.1.20", "10.0.0.20"}) {
+		t.Errorf("Incorrect address changes %v.", *addresses)
+	}
+
+	now = now.Add(time.Minute)
+	network.cmds = nil
+	if err := m.Update(dhcpSettings); err != nil {
+		t.Fatalf("Reverting the DHCP settings failed. Error: %v.", err)
+	}
+	wantCmds = []string{
+		"pkill -f udhcpc -i eth0.10 -x",
+		"ip addr flush dev eth0.10 scope global",
+		"ip link delete eth0.10",
+		"ifconfig eth0 up",
+		"ip addr replace 192.168.1.20/24 dev eth0",
+		"ip -4 route replace default via 192.168.1.1 dev eth0",
+	}
+	if !reflect.DeepEqual(network.cmds, wantCmds) {
+		t.Errorf("Incorrect commands of the revert (got: %v, want: %v).", network.cmds, wantCmds)
+	}
+	if !reflect.DeepEqual(*addresses, []string{"192.168.1.20", "10.0.0.20", "192.168.1.20"}) {
+		t.Errorf("Incorrect address changes %v.", *addresses)
+	}
+
+	// The reverted settings are not applied again until they change.
+	network.cmds = nil
+	if err := m.Update(dhcpSettings); err != nil || len(network.cmds) != 0 {
+		t.Errorf("Unexpected commands for rejected settings: %v (error: %v).", network.cmds, err)
+	}
+
+	// A confirmed change is kept.
+	confirmedSettings := &ocutil.ManagementIntf{
+		IPv4Address:    "192.168.1.30/24",
+		IPv4Gateway:    "192.168.1.1",
+		ConfirmTimeout: time.Minute,
+	}
+	if err := m.Update(confirmedSettings); err != nil {
+		t.Fatalf("Applying the new static settings failed. Error: %v.", err)
+	}
+	m.Confirm()
+	now = now.Add(time.Hour)
+	network.cmds = nil
+	if err := m.Update(confirmedSettings); err != nil || len(network.cmds) != 0 {
+		t.Errorf("Unexpected commands for confirmed settings: %v (error: %v).", network.cmds, err)
+	}
+}
+
+func TestUpdateWithoutConfirmation(t *testing.T) {
+	m, network, _ := testManager()
+	now := time.Unix(1538000000, 0)
+	m.now = func() time.Time { return now }
+
+	if err := m.Update(nil); err != nil || len(network.cmds) != 0 {
+		t.Errorf("Unexpected commands without settings: %v (error: %v).", network.cmds, err)
+	}
+	settings := &ocutil.ManagementIntf{DHCP: true}
+	if err := m.Update(settings); err != nil {
+		t.Fatalf("Applying the DHCP settings failed. Error: %v.", err)
+	}
+	now = now.Add(time.Hour)
+	network.cmds = nil
+	if err := m.Update(settings); err != nil || len(network.cmds) != 0 {
+		t.Errorf("Unexpected commands for settings kept without confirmation: %v (error: %v).", network.cmds, err)
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	m, network, _ := testManager()
+
+	if err := m.Update(staticSettings); err != nil {
+		t.Fatalf("Applying the static settings failed. Error: %v.", err)
+	}
+
+	// The default route is dropped, e.g. when the interface restarts.
+	delete(network.gateways, testETHIntf)
+	network.cmds = nil
+	if err := m.Update(staticSettings); err != nil {
+		t.Fatalf("Restoring the static settings failed. Error: %v.", err)
+	}
+	wantCmds := []string{
+		"ip addr replace 192.168.1.20/24 dev eth0",
+		"ip -4 route replace default via 192.168.1.1 dev eth0",
+	}
+	if !reflect.DeepEqual(network.cmds, wantCmds) {
+		t.Errorf("Incorrect commands restoring the static settings (got: %v, want: %v).", network.cmds, wantCmds)
+	}
+}
@@ -17,8 +17,14 @@ limitations under the License.
 package ocutil
 
 import (
+	"errors"
+	"fmt"
+	"net"
+	"path"
 	"reflect"
 	"sort"
+	"strings"
+	"time"
 
 	"github.com/google/link022/generated/ocstruct"
 )
@@ -37,6 +43,35 @@ func FindAPConfig(apConfigs *ocstruct.Device, hostname string) *ocstruct.Opencon
 	return apConfig
 }
 
+// FindRadio finds the configuration of the radio with a specific ID on the AP with a specific hostname.
+// It returns nil if not matching radio found.
+func FindRadio(apConfigs *ocstruct.Device, hostname string, radioID uint8) *ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_Radios_Radio {
+	apConfig := FindAPConfig(apConfigs, hostname)
+	if apConfig == nil || apConfig.Radios == nil {
+		return nil
+	}
+
+	radio, ok := apConfig.Radios.Radio[radioID]
+	if !ok {
+		return nil
+	}
+	return radio
+}
+
+// RadioIDs returns the IDs of the radios configured on the AP, in ascending order.
+// The agent runs the radios on the WLAN interfaces of the device in this order.
+func RadioIDs(apConfig *ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint) []uint8 {
+	if apConfig == nil || apConfig.Radios == nil {
+		return nil
+	}
+	var radioIDs []uint8
+	for id := range apConfig.Radios.Radio {
+		radioIDs = append(radioIDs, id)
+	}
+	sort.Slice(radioIDs, func(i, j int) bool { return radioIDs[i] < radioIDs[j] })
+	return radioIDs
+}
+
 // VLANChanged checkes whether there is any difference between the given two VLAN ID lists.
 func VLANChanged(existingVLANIDs, updatedVLANIDs []int) bool {
 	sort.Ints(existingVLANIDs)
@@ -44,8 +79,15 @@ func VLANChanged(existingVLANIDs, updatedVLANIDs []int) bool {
 	return !reflect.DeepEqual(existingVLANIDs, updatedVLANIDs)
 }
 
-// VLANIDs fetches the ID of all VLANs appears in the given office configuration.
+// VLANIDs fetches the ID of all VLANs appears in the given office configuration,
+// including the default VLAN and the dynamic VLAN list of each SSID.
 func VLANIDs(apConfig *ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint) []int {
+	return UplinkVLANIDs(apConfig, nil)
+}
+
+// UplinkVLANIDs fetches the ID of all VLANs bridged to the uplink, i.e. the VLANs of all SSIDs
+// except the ones put on a local NAT subnet.
+func UplinkVLANIDs(apConfig *ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint, natSSIDs map[string]*NAT) []int {
 	vlanIDs := []int{}
 
 	if apConfig == nil {
@@ -57,17 +99,978 @@ func VLANIDs(apConfig *ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint)
 		return vlanIDs
 	}
 
-	for _, wlan := range wlans.Ssid {
-		vlanIDs = append(vlanIDs, int(*wlan.Config.DefaultVlan))
+	added := make(map[int]bool)
+	for wlanName, wlan := range wlans.Ssid {
+		if _, ok := natSSIDs[wlanName]; ok {
+			continue
+		}
+		for _, vlanID := range SSIDVLANIDs(wlan.Config) {
+			if !added[vlanID] {
+				added[vlanID] = true
+				vlanIDs = append(vlanIDs, vlanID)
+			}
+		}
+	}
+
+	return vlanIDs
+}
+
+// SSIDVLANIDs fetches the ID of all VLANs the clients of the given SSID can be assigned to.
+// The default VLAN goes first, followed by the VLAN list in ascending order.
+func SSIDVLANIDs(wlanConfig *ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_Ssids_Ssid_Config) []int {
+	defaultVLANID := int(*wlanConfig.DefaultVlan)
+	vlanIDs := []int{defaultVLANID}
+
+	var vlanList []int
+	for _, vlanID := range wlanConfig.VlanList {
+		if int(vlanID) != defaultVLANID {
+			vlanList = append(vlanList, int(vlanID))
+		}
+	}
+	sort.Ints(vlanList)
+	for i, vlanID := range vlanList {
+		if i == 0 || vlanID != vlanList[i-1] {
+			vlanIDs = append(vlanIDs, vlanID)
+		}
 	}
 
 	return vlanIDs
 }
 
-// RadiusServers fetches the radius server assigned to the given AP.
-// It returns a SSID -> RadiusServer map.
-func RadiusServers(ap *ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint) map[string]*ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System_Aaa_ServerGroups_ServerGroup_Servers_Server {
-	wlanRadiusMap := make(map[string]*ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System_Aaa_ServerGroups_ServerGroup_Servers_Server)
+// SSIDEnabled checks whether the given SSID is enabled. SSIDs are enabled by default.
+func SSIDEnabled(wlanConfig *ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_Ssids_Ssid_Config) bool {
+	return wlanConfig.Enabled == nil || *wlanConfig.Enabled
+}
+
+// defaultSteeringRSSI is the steering RSSI used when band steering is enabled without a threshold.
+const defaultSteeringRSSI = -70
+
+// BandSteeringSSIDs fetches the SSIDs with band steering enabled in the given AP configuration.
+// It returns a SSID -> steering RSSI map.
+func BandSteeringSSIDs(ap *ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint) map[string]int8 {
+	steeringRSSIs := make(map[string]int8)
+	if ap == nil || ap.Ssids == nil {
+		return steeringRSSIs
+	}
+
+	for wlanName, wlan := range ap.Ssids.Ssid {
+		if wlan.BandSteering == nil || wlan.BandSteering.Config == nil {
+			continue
+		}
+		steeringConfig := wlan.BandSteering.Config
+		if steeringConfig.BandSteering == nil || !*steeringConfig.BandSteering {
+			continue
+		}
+		steeringRSSIs[wlanName] = defaultSteeringRSSI
+		if steeringConfig.SteeringRssi != nil {
+			steeringRSSIs[wlanName] = *steeringConfig.SteeringRssi
+		}
+	}
+
+	return steeringRSSIs
+}
+
+// defaultBlacklistTime is how long a client is blacklisted when the blacklist time is not configured.
+const defaultBlacklistTime = 60 * time.Second
+
+// Dot1XBlacklist contains the 802.1X blacklist settings of an SSID.
+type Dot1XBlacklist struct {
+	// MaxAuthFailures is the number of consecutive authentication failures before a client is blacklisted.
+	MaxAuthFailures uint8
+	// BlacklistTime is how long a client stays blacklisted.
+	BlacklistTime time.Duration
+}
+
+// Dot1XBlacklistSSIDs fetches the SSIDs with a 802.1X authentication failure limit in the given AP configuration.
+// It returns a SSID -> blacklist settings map.
+func Dot1XBlacklistSSIDs(ap *ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint) map[string]Dot1XBlacklist {
+	blacklists := make(map[string]Dot1XBlacklist)
+	if ap == nil || ap.Ssids == nil {
+		return blacklists
+	}
+
+	for wlanName, wlan := range ap.Ssids.Ssid {
+		if wlan.Dot1XTimers == nil || wlan.Dot1XTimers.Config == nil {
+			continue
+		}
+		timersConfig := wlan.Dot1XTimers.Config
+		if timersConfig.MaxAuthFailures == nil || *timersConfig.MaxAuthFailures == 0 {
+			continue
+		}
+		blacklist := Dot1XBlacklist{
+			MaxAuthFailures: *timersConfig.MaxAuthFailures,
+			BlacklistTime:   defaultBlacklistTime,
+		}
+		if timersConfig.BlacklistTime != nil {
+			blacklist.BlacklistTime = time.Duration(*timersConfig.BlacklistTime) * time.Second
+		}
+		blacklists[wlanName] = blacklist
+	}
+
+	return blacklists
+}
+
+// L2Filter contains the layer 2 traffic filters of an SSID.
+type L2Filter struct {
+	// BroadcastFilter drops the broadcast frames sent to clients, except ARP and DHCP.
+	BroadcastFilter bool
+	// MulticastFilter drops the multicast frames sent to clients, except DHCPv6 and IPv6 neighbor discovery.
+	MulticastFilter bool
+	// NDPFilter lets the AP answer neighbor solicitations on behalf of its clients instead of forwarding them.
+	NDPFilter bool
+	// RouterAdvertInterval is the minimum interval between router advertisements sent to clients, 0 for no limit.
+	RouterAdvertInterval time.Duration
+	// DHCPRequired drops the traffic of clients that did not complete DHCP.
+	DHCPRequired bool
+	// StationIsolation blocks the traffic between clients of the SSID.
+	StationIsolation bool
+	// DynamicVLANIDs contains the VLANs clients can be assigned to, if the SSID allows dynamic VLAN.
+	DynamicVLANIDs []int
+}
+
+// ProxyARP checks whether the filters rely on hostapd proxy ARP, which snoops DHCP and
+// neighbor discovery of the clients and answers ARP and neighbor solicitations for them.
+func (f L2Filter) ProxyARP() bool {
+	return f.NDPFilter || f.DHCPRequired
+}
+
+// SSIDL2Filter fetches the layer 2 traffic filters of the given SSID.
+func SSIDL2Filter(wlanConfig *ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_Ssids_Ssid_Config) L2Filter {
+	filter := L2Filter{
+		BroadcastFilter:  wlanConfig.BroadcastFilter != nil && *wlanConfig.BroadcastFilter,
+		MulticastFilter:  wlanConfig.MulticastFilter != nil && *wlanConfig.MulticastFilter,
+		NDPFilter:        wlanConfig.Ipv6NdpFilter != nil && *wlanConfig.Ipv6NdpFilter,
+		DHCPRequired:     wlanConfig.DhcpRequired != nil && *wlanConfig.DhcpRequired,
+		StationIsolation: wlanConfig.StationIsolation != nil && *wlanConfig.StationIsolation,
+	}
+	if filter.NDPFilter && wlanConfig.Ipv6NdpFilterTimer != nil {
+		filter.RouterAdvertInterval = time.Duration(*wlanConfig.Ipv6NdpFilterTimer) * time.Second
+	}
+	if len(wlanConfig.VlanList) != 0 {
+		filter.DynamicVLANIDs = SSIDVLANIDs(wlanConfig)
+	}
+	return filter
+}
+
+// L2FilterSSIDs fetches the SSIDs with layer 2 traffic filters in the given AP configuration.
+// It returns a SSID -> filters map.
+func L2FilterSSIDs(ap *ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint) map[string]L2Filter {
+	filters := make(map[string]L2Filter)
+	if ap == nil || ap.Ssids == nil {
+		return filters
+	}
+
+	for wlanName, wlan := range ap.Ssids.Ssid {
+		if wlan.Config == nil {
+			continue
+		}
+		filter := SSIDL2Filter(wlan.Config)
+		if filter.BroadcastFilter || filter.MulticastFilter || filter.ProxyARP() {
+			filters[wlanName] = filter
+		}
+	}
+
+	return filters
+}
+
+// MACACL contains the MAC address access control lists of an SSID.
+// MAC addresses are in lower case and ascending order.
+type MACACL struct {
+	// Accept lists the only stations allowed to associate. Empty means all stations are allowed.
+	Accept []string
+	// Deny lists the stations rejected.
+	Deny []string
+}
+
+// MACACLSSIDs fetches the MAC address access control lists configured in gasket.
+// It returns a SSID -> ACL map.
+func MACACLSSIDs(gasketConfig *ocstruct.OpenconfigGasket_Gasket) map[string]*MACACL {
+	acls := make(map[string]*MACACL)
+	if gasketConfig == nil || gasketConfig.MacAcls == nil {
+		return acls
+	}
+
+	for wlanName, ssidACL := range gasketConfig.MacAcls.Ssid {
+		acls[wlanName] = &MACACL{
+			Accept: normalizeMACs(ssidACL.AcceptMac),
+			Deny:   normalizeMACs(ssidACL.DenyMac),
+		}
+	}
+	return acls
+}
+
+// normalizeMACs returns the given MAC addresses in lower case and ascending order, without duplicates.
+func normalizeMACs(macs []string) []string {
+	macSet := make(map[string]bool)
+	for _, mac := range macs {
+		macSet[strings.ToLower(mac)] = true
+	}
+	var normalized []string
+	for mac := range macSet {
+		normalized = append(normalized, mac)
+	}
+	sort.Strings(normalized)
+	return normalized
+}
+
+// defaultLeaseTime is the DHCP lease time of NAT SSIDs without a configured lease time.
+const defaultLeaseTime = time.Hour
+
+// NAT contains the local subnet of an SSID whose traffic is NATed to the management interface.
+type NAT struct {
+	// Index is the position of the SSID among the NAT SSIDs in name order.
+	// It identifies the local bridge of the SSID.
+	Index int
+	// Gateway is the address of the AP on the subnet.
+	Gateway net.IP
+	// Subnet is the local subnet of the SSID.
+	Subnet *net.IPNet
+	// LeaseTime is the DHCP lease time of the clients.
+	LeaseTime time.Duration
+}
+
+// DHCPRange returns the first and last addresses leased to clients. The range covers the addresses
+// between the gateway and the broadcast address, or between the network address and the gateway
+// if the gateway is the last host address.
+func (n *NAT) DHCPRange() (net.IP, net.IP) {
+	lastHost := addIPv4(broadcastIPv4(n.Subnet), -1)
+	if n.Gateway.Equal(lastHost) {
+		return addIPv4(n.Subnet.IP, 1), addIPv4(n.Gateway, -1)
+	}
+	return addIPv4(n.Gateway, 1), lastHost
+}
+
+// broadcastIPv4 returns the broadcast address of an IPv4 subnet.
+func broadcastIPv4(subnet *net.IPNet) net.IP {
+	network := subnet.IP.To4()
+	broadcast := make(net.IP, net.IPv4len)
+	for i := range broadcast {
+		broadcast[i] = network[i] | ^subnet.Mask[i]
+	}
+	return broadcast
+}
+
+// addIPv4 returns the IPv4 address at the given offset from ip.
+func addIPv4(ip net.IP, offset int) net.IP {
+	ip4 := ip.To4()
+	value := uint32(ip4[0])<<24 | uint32(ip4[1])<<16 | uint32(ip4[2])<<8 | uint32(ip4[3])
+	value += uint32(offset)
+	return net.IPv4(byte(value>>24), byte(value>>16), byte(value>>8), byte(value)).To4()
+}
+
+// CheckCtrlInterface checks that the hostapd control interface configured in gasket is the given folder of
+// control interface sockets, either as a path or as "DIR=<path> GROUP=<group>". The agent drives hostapd
+// through these sockets, hostapd would stop creating them with another control interface, e.g. "udp:8888".
+func CheckCtrlInterface(gasketConfig *ocstruct.OpenconfigGasket_Gasket, ctrlInterfaceDir string) error {
+	if gasketConfig == nil || gasketConfig.CtrlInterface == nil || len(*gasketConfig.CtrlInterface) == 0 {
+		return nil
+	}
+	ctrlInterface := *gasketConfig.CtrlInterface
+	dir := ctrlInterface
+	if strings.HasPrefix(dir, "DIR=") {
+		dir = strings.TrimPrefix(strings.Fields(dir)[0], "DIR=")
+	}
+	if len(dir) == 0 || path.Clean(dir) != path.Clean(ctrlInterfaceDir) {
+		return fmt.Errorf("hostapd control interface %q is not supported, the agent uses the control interface sockets in %s", ctrlInterface, ctrlInterfaceDir)
+	}
+	return nil
+}
+
+// CheckNATSSIDs checks that the local subnet of each NAT SSID configured in gasket is valid: an IPv4 gateway address
+// with a prefix of /30 or shorter, which is neither the network nor the broadcast address of the subnet.
+// An invalid subnet has to be rejected, otherwise the SSID would be bridged to its VLANs instead.
+func CheckNATSSIDs(gasketConfig *ocstruct.OpenconfigGasket_Gasket) error {
+	if gasketConfig == nil || gasketConfig.NatSsids == nil {
+		return nil
+	}
+	var wlanNames []string
+	for wlanName := range gasketConfig.NatSsids.Ssid {
+		wlanNames = append(wlanNames, wlanName)
+	}
+	sort.Strings(wlanNames)
+	for _, wlanName := range wlanNames {
+		if _, _, err := natGateway(gasketConfig.NatSsids.Ssid[wlanName]); err != nil {
+			return fmt.Errorf("invalid NAT subnet of SSID %s: %v", wlanName, err)
+		}
+	}
+	return nil
+}
+
+// natGateway parses the gateway address of a NAT SSID, and returns the gateway and its subnet.
+func natGateway(ssidNAT *ocstruct.OpenconfigGasket_Gasket_NatSsids_Ssid) (net.IP, *net.IPNet, error) {
+	if ssidNAT.GatewayAddress == nil {
+		return nil, nil, errors.New("missing gateway address")
+	}
+	gateway, subnet, err := net.ParseCIDR(*ssidNAT.GatewayAddress)
+	if err != nil || gateway.To4() == nil {
+		return nil, nil, fmt.Errorf("gateway address %q is not an IPv4 address with a prefix length", *ssidNAT.GatewayAddress)
+	}
+	if ones, _ := subnet.Mask.Size(); ones > 30 {
+		return nil, nil, fmt.Errorf("subnet %v has no room for clients, its prefix must be /30 or shorter", subnet)
+	}
+	if gateway.Equal(subnet.IP) || gateway.Equal(broadcastIPv4(subnet)) {
+		return nil, nil, fmt.Errorf("gateway address %v is the network or broadcast address of %v", gateway, subnet)
+	}
+	return gateway.To4(), subnet, nil
+}
+
+// NATSSIDs fetches the SSIDs put on a local NAT subnet, configured in gasket.
+// It returns a SSID -> NAT map. SSIDs with an invalid subnet, rejected by CheckNATSSIDs, are skipped.
+func NATSSIDs(gasketConfig *ocstruct.OpenconfigGasket_Gasket) map[string]*NAT {
+	natSSIDs := make(map[string]*NAT)
+	if gasketConfig == nil || gasketConfig.NatSsids == nil {
+		return natSSIDs
+	}
+
+	var wlanNames []string
+	for wlanName := range gasketConfig.NatSsids.Ssid {
+		wlanNames = append(wlanNames, wlanName)
+	}
+	sort.Strings(wlanNames)
+	for _, wlanName := range wlanNames {
+		ssidNAT := gasketConfig.NatSsids.Ssid[wlanName]
+		gateway, subnet, err := natGateway(ssidNAT)
+		if err != nil {
+			continue
+		}
+		leaseTime := defaultLeaseTime
+		if ssidNAT.LeaseTime != nil {
+			leaseTime = time.Duration(*ssidNAT.LeaseTime) * time.Second
+		}
+		natSSIDs[wlanName] = &NAT{
+			Index:     len(natSSIDs),
+			Gateway:   gateway,
+			Subnet:    subnet,
+			LeaseTime: leaseTime,
+		}
+	}
+	return natSSIDs
+}
+
+// RateLimits contains the rate limits of an SSID, in kbit/s. Zero means unlimited.
+type RateLimits struct {
+	SSIDDownKbps   uint64
+	SSIDUpKbps     uint64
+	ClientDownKbps uint64
+	ClientUpKbps   uint64
+}
+
+// Down checks whether the traffic sent to the clients is limited.
+func (r *RateLimits) Down() bool {
+	return r.SSIDDownKbps != 0 || r.ClientDownKbps != 0
+}
+
+// Up checks whether the traffic received from the clients is limited.
+func (r *RateLimits) Up() bool {
+	return r.SSIDUpKbps != 0 || r.ClientUpKbps != 0
+}
+
+// RateLimitSSIDs fetches the rate limits of SSIDs configured in gasket.
+// It returns a SSID -> rate limits map. SSIDs without any limit are skipped.
+func RateLimitSSIDs(gasketConfig *ocstruct.OpenconfigGasket_Gasket) map[string]*RateLimits {
+	rateLimits := make(map[string]*RateLimits)
+	if gasketConfig == nil || gasketConfig.RateLimits == nil {
+		return rateLimits
+	}
+
+	kbps := func(value *uint64) uint64 {
+		if value == nil {
+			return 0
+		}
+		return *value
+	}
+	for wlanName, ssidLimits := range gasketConfig.RateLimits.Ssid {
+		limits := &RateLimits{
+			SSIDDownKbps:   kbps(ssidLimits.SsidDownKbps),
+			SSIDUpKbps:     kbps(ssidLimits.SsidUpKbps),
+			ClientDownKbps: kbps(ssidLimits.ClientDownKbps),
+			ClientUpKbps:   kbps(ssidLimits.ClientUpKbps),
+		}
+		if limits.Down() || limits.Up() {
+			rateLimits[wlanName] = limits
+		}
+	}
+	return rateLimits
+}
+
+const (
+	// defaultPortalPort is the port of captive portals without a configured port.
+	defaultPortalPort = 8081
+	// defaultSessionTimeout is how long clients stay authorized on captive portals without a configured session timeout.
+	defaultSessionTimeout = 24 * time.Hour
+)
+
+// CaptivePortal contains the captive portal settings of an SSID.
+type CaptivePortal struct {
+	// Port is the TCP port the portal listens on, at the gateway address of the SSID.
+	Port uint16
+	// SessionTimeout is how long a client stays authorized once it passed the portal.
+	SessionTimeout time.Duration
+	// Vouchers lists the accepted voucher codes. Empty means clients click through the splash page.
+	Vouchers []string
+}
+
+// CaptivePortalSSIDs fetches the captive portals configured in gasket.
+// It returns a SSID -> portal map. Only SSIDs on a local NAT subnet support captive portals, other SSIDs are skipped.
+func CaptivePortalSSIDs(gasketConfig *ocstruct.OpenconfigGasket_Gasket) map[string]*CaptivePortal {
+	portals := make(map[string]*CaptivePortal)
+	if gasketConfig == nil || gasketConfig.CaptivePortals == nil {
+		return portals
+	}
+
+	natSSIDs := NATSSIDs(gasketConfig)
+	for wlanName, ssidPortal := range gasketConfig.CaptivePortals.Ssid {
+		if _, ok := natSSIDs[wlanName]; !ok {
+			continue
+		}
+		portal := &CaptivePortal{
+			Port:           defaultPortalPort,
+			SessionTimeout: defaultSessionTimeout,
+		}
+		if ssidPortal.Port != nil {
+			portal.Port = *ssidPortal.Port
+		}
+		if ssidPortal.SessionTimeout != nil {
+			portal.SessionTimeout = time.Duration(*ssidPortal.SessionTimeout) * time.Second
+		}
+		for _, voucher := range ssidPortal.Voucher {
+			if len(voucher) != 0 {
+				portal.Vouchers = append(portal.Vouchers, voucher)
+			}
+		}
+		sort.Strings(portal.Vouchers)
+		portals[wlanName] = portal
+	}
+	return portals
+}
+
+// defaultConfirmTimeout is how long management interface changes wait for a confirmation without a configured timeout.
+const defaultConfirmTimeout = 5 * time.Minute
+
+// ManagementIntf contains the settings of the management interface of an AP.
+type ManagementIntf struct {
+	// VLANID is the tagged VLAN of the management interface, 0 if untagged.
+	VLANID int
+	// DHCP indicates the IPv4 address, the default gateway and the DNS servers come from DHCP.
+	DHCP bool
+	// IPv4Address and IPv6Address are static addresses with prefix length, e.g. "192.168.1.20/24". Empty if not set.
+	IPv4Address string
+	IPv6Address string
+	// IPv4Gateway and IPv6Gateway are static default gateways. Empty if not set.
+	IPv4Gateway string
+	IPv6Gateway string
+	// DNSServers replace the DNS servers of the system if not empty. They are left empty if the AP has
+	// its own resolver settings, see DNS.HasResolver.
+	DNSServers []string
+	// ConfirmTimeout is how long a change waits for a confirmation before being reverted. 0 means no confirmation.
+	ConfirmTimeout time.Duration
+}
+
+// IntfName returns the name of the management interface on the given wired interface.
+func (m *ManagementIntf) IntfName(ethIntfName string) string {
+	if m.VLANID == 0 {
+		return ethIntfName
+	}
+	// The VLAN interface is named the same way as the VLAN interfaces of SSIDs.
+	return fmt.Sprintf("%s.%d", ethIntfName, m.VLANID)
+}
+
+// ManagementIntfSettings fetches the management interface settings of the given AP, configured in gasket.
+// It returns nil if the AP has no management interface settings, or if its management VLAN is a VLAN of its SSIDs.
+// Invalid addresses are ignored.
+func ManagementIntfSettings(gasketConfig *ocstruct.OpenconfigGasket_Gasket, ap *ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint) *ManagementIntf {
+	if gasketConfig == nil || gasketConfig.ManagementInterfaces == nil || ap == nil || ap.Hostname == nil {
+		return nil
+	}
+	mgmtConfig, ok := gasketConfig.ManagementInterfaces.AccessPoint[*ap.Hostname]
+	if !ok {
+		return nil
+	}
+
+	mgmtIntf := &ManagementIntf{
+		DHCP:           mgmtConfig.Dhcp == nil || *mgmtConfig.Dhcp,
+		ConfirmTimeout: defaultConfirmTimeout,
+	}
+	if mgmtConfig.VlanId != nil {
+		mgmtIntf.VLANID = int(*mgmtConfig.VlanId)
+		for _, vlanID := range UplinkVLANIDs(ap, NATSSIDs(gasketConfig)) {
+			if vlanID == mgmtIntf.VLANID {
+				return nil
+			}
+		}
+	}
+	if mgmtConfig.Ipv4Address != nil {
+		if ip, _, err := net.ParseCIDR(*mgmtConfig.Ipv4Address); err == nil && ip.To4() != nil {
+			mgmtIntf.IPv4Address = *mgmtConfig.Ipv4Address
+		}
+	}
+	if mgmtConfig.Ipv4Gateway != nil {
+		if ip := net.ParseIP(*mgmtConfig.Ipv4Gateway); ip != nil && ip.To4() != nil {
+			mgmtIntf.IPv4Gateway = ip.String()
+		}
+	}
+	if mgmtConfig.Ipv6Address != nil {
+		if ip, subnet, err := net.ParseCIDR(*mgmtConfig.Ipv6Address); err == nil && ip.To4() == nil {
+			// Use the same format as the system, to compare with the addresses of the interface.
+			ones, _ := subnet.Mask.Size()
+			mgmtIntf.IPv6Address = fmt.Sprintf("%s/%d", ip, ones)
+		}
+	}
+	if mgmtConfig.Ipv6Gateway != nil {
+		if ip := net.ParseIP(*mgmtConfig.Ipv6Gateway); ip != nil && ip.To4() == nil {
+			mgmtIntf.IPv6Gateway = ip.String()
+		}
+	}
+	if dns := DNSSettings(ap); dns == nil || !dns.HasResolver() {
+		for _, server := range mgmtConfig.DnsServer {
+			if ip := net.ParseIP(server); ip != nil {
+				mgmtIntf.DNSServers = append(mgmtIntf.DNSServers, ip.String())
+			}
+		}
+	}
+	if mgmtConfig.ConfirmTimeout != nil {
+		mgmtIntf.ConfirmTimeout = time.Duration(*mgmtConfig.ConfirmTimeout) * time.Second
+	}
+	return mgmtIntf
+}
+
+// NTP contains the NTP client settings of an AP.
+type NTP struct {
+	// Servers are ordered by address.
+	Servers []NTPServer
+	// Keys are ordered by ID. They are only used if Auth is set.
+	Keys []NTPKey
+	// Auth indicates the servers are authenticated, each with its own key.
+	Auth bool
+	// SourceAddress is the local address NTP packets are sent from. Empty if not set.
+	SourceAddress string
+}
+
+// NTPServer contains the settings of an NTP server.
+type NTPServer struct {
+	Address string
+	// Port is 0 for the default NTP port.
+	Port uint16
+	// Version is 0 for the default NTP version.
+	Version uint8
+	// Type is "server", "peer" or "pool".
+	Type   string
+	IBurst bool
+	Prefer bool
+	// KeyID is the ID of the key authenticating the server, 0 if not set.
+	KeyID uint16
+}
+
+// NTPKey contains an NTP authentication key.
+type NTPKey struct {
+	ID uint16
+	// Type is the key type, e.g. "MD5".
+	Type  string
+	Value string
+}
+
+// NTPSettings fetches the NTP settings of the given AP.
+// It returns nil if NTP is not enabled. Servers with an unknown association type are ignored.
+func NTPSettings(ap *ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint) *NTP {
+	if ap == nil || ap.System == nil || ap.System.Ntp == nil {
+		return nil
+	}
+	ntpConfig := ap.System.Ntp
+	if ntpConfig.Config == nil || ntpConfig.Config.Enabled == nil || !*ntpConfig.Config.Enabled {
+		return nil
+	}
+
+	ntp := &NTP{
+		Auth: ntpConfig.Config.EnableNtpAuth != nil && *ntpConfig.Config.EnableNtpAuth,
+	}
+	if ntpConfig.Config.NtpSourceAddress != nil {
+		if ip := net.ParseIP(*ntpConfig.Config.NtpSourceAddress); ip != nil {
+			ntp.SourceAddress = ip.String()
+		}
+	}
+	if ntpConfig.Servers != nil {
+		for address, server := range ntpConfig.Servers.Server {
+			ntpServer := NTPServer{Address: address, Type: "server"}
+			if serverConfig := server.Config; serverConfig != nil {
+				switch serverConfig.AssociationType {
+				case ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System_Ntp_Servers_Server_Config_AssociationType_UNSET,
+					ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System_Ntp_Servers_Server_Config_AssociationType_SERVER:
+				case ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System_Ntp_Servers_Server_Config_AssociationType_PEER:
+					ntpServer.Type = "peer"
+				case ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System_Ntp_Servers_Server_Config_AssociationType_POOL:
+					ntpServer.Type = "pool"
+				default:
+					continue
+				}
+				if serverConfig.Port != nil {
+					ntpServer.Port = *serverConfig.Port
+				}
+				if serverConfig.Version != nil {
+					ntpServer.Version = *serverConfig.Version
+				}
+				ntpServer.IBurst = serverConfig.Iburst != nil && *serverConfig.Iburst
+				ntpServer.Prefer = serverConfig.Prefer != nil && *serverConfig.Prefer
+				if serverConfig.KeyId != nil {
+					ntpServer.KeyID = *serverConfig.KeyId
+				}
+			}
+			ntp.Servers = append(ntp.Servers, ntpServer)
+		}
+	}
+	sort.Slice(ntp.Servers, func(i, j int) bool {
+		return ntp.Servers[i].Address < ntp.Servers[j].Address
+	})
+	if ntpConfig.NtpKeys != nil {
+		for keyID, key := range ntpConfig.NtpKeys.NtpKey {
+			if key.Config == nil || key.Config.KeyValue == nil {
+				continue
+			}
+			// MD5 is the only key type of the model.
+			if key.Config.KeyType != ocstruct.OpenconfigSystem_NTP_AUTH_TYPE_NTP_AUTH_MD5 && key.Config.KeyType != ocstruct.OpenconfigSystem_NTP_AUTH_TYPE_UNSET {
+				continue
+			}
+			ntp.Keys = append(ntp.Keys, NTPKey{ID: keyID, Type: "MD5", Value: *key.Config.KeyValue})
+		}
+	}
+	sort.Slice(ntp.Keys, func(i, j int) bool {
+		return ntp.Keys[i].ID < ntp.Keys[j].ID
+	})
+	return ntp
+}
+
+// DNS contains the DNS resolver settings of an AP.
+type DNS struct {
+	// Servers are the addresses of the DNS servers, ordered by address.
+	Servers []string
+	// Search lists the search domains, in the configured order.
+	Search []string
+	// Hosts are the static host entries, ordered by hostname.
+	Hosts []HostEntry
+}
+
+// HasResolver indicates the settings include DNS servers or search domains, which replace resolv.conf.
+func (d *DNS) HasResolver() bool {
+	return len(d.Servers) != 0 || len(d.Search) != 0
+}
+
+// HostEntry is a static host entry.
+type HostEntry struct {
+	Hostname string
+	Aliases  []string
+	// Addresses contains the IPv4 addresses, then the IPv6 addresses of the host.
+	Addresses []string
+}
+
+// DNSSettings fetches the DNS settings of the given AP.
+// It returns nil if the AP has no DNS settings. Invalid addresses are ignored.
+func DNSSettings(ap *ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint) *DNS {
+	if ap == nil || ap.System == nil || ap.System.Dns == nil {
+		return nil
+	}
+	dnsConfig := ap.System.Dns
+
+	dns := &DNS{}
+	if dnsConfig.Servers != nil {
+		for address := range dnsConfig.Servers.Server {
+			if ip := net.ParseIP(address); ip != nil {
+				dns.Servers = append(dns.Servers, ip.String())
+			}
+		}
+	}
+	sort.Strings(dns.Servers)
+	if dnsConfig.Config != nil {
+		dns.Search = append(dns.Search, dnsConfig.Config.Search...)
+	}
+	if dnsConfig.HostEntries != nil {
+		for hostname, hostEntry := range dnsConfig.HostEntries.HostEntry {
+			if hostEntry.Config == nil {
+				continue
+			}
+			entry := HostEntry{Hostname: hostname, Aliases: append([]string{}, hostEntry.Config.Alias...)}
+			for _, address := range append(append([]string{}, hostEntry.Config.Ipv4Address...), hostEntry.Config.Ipv6Address...) {
+				if ip := net.ParseIP(address); ip != nil {
+					entry.Addresses = append(entry.Addresses, ip.String())
+				}
+			}
+			if len(entry.Addresses) != 0 {
+				dns.Hosts = append(dns.Hosts, entry)
+			}
+		}
+	}
+	sort.Slice(dns.Hosts, func(i, j int) bool {
+		return dns.Hosts[i].Hostname < dns.Hosts[j].Hostname
+	})
+	if len(dns.Servers) == 0 && len(dns.Search) == 0 && len(dns.Hosts) == 0 {
+		return nil
+	}
+	return dns
+}
+
+// TimezoneName fetches the timezone of the given AP, e.g. "America/Los_Angeles". Empty if not set.
+func TimezoneName(ap *ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint) string {
+	if ap == nil || ap.System == nil || ap.System.Clock == nil || ap.System.Clock.Config == nil || ap.System.Clock.Config.TimezoneName == nil {
+		return ""
+	}
+	return *ap.System.Clock.Config.TimezoneName
+}
+
+// Syslog ports of each transport. The transport of a remote syslog server is inferred from its port.
+const (
+	SyslogUDPPort = 514
+	SyslogTCPPort = 601
+	SyslogTLSPort = 6514
+)
+
+// syslogFacilities maps the facilities of the model to their syslog codes, -1 for all facilities.
+var syslogFacilities = map[ocstruct.E_OpenconfigSystemLogging_SYSLOG_FACILITY]int{
+	ocstruct.OpenconfigSystemLogging_SYSLOG_FACILITY_ALL:           -1,
+	ocstruct.OpenconfigSystemLogging_SYSLOG_FACILITY_KERNEL:        0,
+	ocstruct.OpenconfigSystemLogging_SYSLOG_FACILITY_USER:          1,
+	ocstruct.OpenconfigSystemLogging_SYSLOG_FACILITY_MAIL:          2,
+	ocstruct.OpenconfigSystemLogging_SYSLOG_FACILITY_SYSTEM_DAEMON: 3,
+	ocstruct.OpenconfigSystemLogging_SYSLOG_FACILITY_AUTH:          4,
+	ocstruct.OpenconfigSystemLogging_SYSLOG_FACILITY_SYSLOG:        5,
+	ocstruct.OpenconfigSystemLogging_SYSLOG_FACILITY_AUTHPRIV:      10,
+	ocstruct.OpenconfigSystemLogging_SYSLOG_FACILITY_NTP:           12,
+	ocstruct.OpenconfigSystemLogging_SYSLOG_FACILITY_AUDIT:         13,
+	ocstruct.OpenconfigSystemLogging_SYSLOG_FACILITY_CONSOLE:       14,
+	ocstruct.OpenconfigSystemLogging_SYSLOG_FACILITY_LOCAL0:        16,
+	ocstruct.OpenconfigSystemLogging_SYSLOG_FACILITY_LOCAL1:        17,
+	ocstruct.OpenconfigSystemLogging_SYSLOG_FACILITY_LOCAL2:        18,
+	ocstruct.OpenconfigSystemLogging_SYSLOG_FACILITY_LOCAL3:        19,
+	ocstruct.OpenconfigSystemLogging_SYSLOG_FACILITY_LOCAL4:        20,
+	ocstruct.OpenconfigSystemLogging_SYSLOG_FACILITY_LOCAL5:        21,
+	ocstruct.OpenconfigSystemLogging_SYSLOG_FACILITY_LOCAL6:        22,
+	ocstruct.OpenconfigSystemLogging_SYSLOG_FACILITY_LOCAL7:        23,
+}
+
+// SyslogSelector selects the messages of a facility with a given severity or a higher one.
+type SyslogSelector struct {
+	// Facility is the syslog facility code, -1 for all facilities.
+	Facility int
+	// Severity is the syslog severity code, from 0 (emergency) to 7 (debug).
+	Severity int
+}
+
+// Match checks whether the selector selects a message of the given facility and severity codes.
+func (s SyslogSelector) Match(facility, severity int) bool {
+	return (s.Facility == -1 || s.Facility == facility) && severity <= s.Severity
+}
+
+// RemoteSyslogServer contains the settings of a remote syslog server.
+type RemoteSyslogServer struct {
+	Host string
+	Port uint16
+	// Transport is "udp", "tcp" or "tls".
+	Transport string
+	// SourceAddress is the local address messages are sent from. Empty if not set.
+	SourceAddress string
+	// Selectors select the messages sent to the server.
+	Selectors []SyslogSelector
+}
+
+// RemoteSyslogServers fetches the remote syslog servers of the given AP, ordered by host.
+// The port defaults to the UDP syslog port. The TCP and TLS syslog ports select their transport, other ports use UDP.
+// Servers without a valid selector are ignored, since they would get no message.
+func RemoteSyslogServers(ap *ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint) []*RemoteSyslogServer {
+	var servers []*RemoteSyslogServer
+	if ap == nil || ap.System == nil || ap.System.Logging == nil || ap.System.Logging.RemoteServers == nil {
+		return servers
+	}
+
+	for host, remoteServer := range ap.System.Logging.RemoteServers.RemoteServer {
+		server := &RemoteSyslogServer{
+			Host:      host,
+			Port:      SyslogUDPPort,
+			Transport: "udp",
+		}
+		if remoteServer.Config != nil {
+			if remoteServer.Config.RemotePort != nil {
+				server.Port = *remoteServer.Config.RemotePort
+			}
+			if remoteServer.Config.SourceAddress != nil {
+				if ip := net.ParseIP(*remoteServer.Config.SourceAddress); ip != nil {
+					server.SourceAddress = ip.String()
+				}
+			}
+		}
+		switch server.Port {
+		case SyslogTCPPort:
+			server.Transport = "tcp"
+		case SyslogTLSPort:
+			server.Transport = "tls"
+		}
+		if remoteServer.Selectors != nil {
+			for key := range remoteServer.Selectors.Selector {
+				if selector, ok := syslogSelector(key.Facility, key.Severity); ok {
+					server.Selectors = append(server.Selectors, selector)
+				}
+			}
+		}
+		if len(server.Selectors) == 0 {
+			continue
+		}
+		sortSyslogSelectors(server.Selectors)
+		servers = append(servers, server)
+	}
+	sort.Slice(servers, func(i, j int) bool {
+		return servers[i].Host < servers[j].Host
+	})
+	return servers
+}
+
+// ConsoleSyslogSelectors fetches the selectors of the messages logged on the console of the given AP.
+func ConsoleSyslogSelectors(ap *ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint) []SyslogSelector {
+	var selectors []SyslogSelector
+	if ap == nil || ap.System == nil || ap.System.Logging == nil || ap.System.Logging.Console == nil || ap.System.Logging.Console.Selectors == nil {
+		return selectors
+	}
+	for key := range ap.System.Logging.Console.Selectors.Selector {
+		if selector, ok := syslogSelector(key.Facility, key.Severity); ok {
+			selectors = append(selectors, selector)
+		}
+	}
+	sortSyslogSelectors(selectors)
+	return selectors
+}
+
+func syslogSelector(facility ocstruct.E_OpenconfigSystemLogging_SYSLOG_FACILITY, severity ocstruct.E_OpenconfigSystemLogging_SyslogSeverity) (SyslogSelector, bool) {
+	facilityCode, ok := syslogFacilities[facility]
+	if !ok || severity < ocstruct.OpenconfigSystemLogging_SyslogSeverity_EMERGENCY || severity > ocstruct.OpenconfigSystemLogging_SyslogSeverity_DEBUG {
+		return SyslogSelector{}, false
+	}
+	// The severities of the model are ordered as the syslog codes, from 1 (emergency).
+	return SyslogSelector{Facility: facilityCode, Severity: int(severity) - 1}, true
+}
+
+func sortSyslogSelectors(selectors []SyslogSelector) {
+	sort.Slice(selectors, func(i, j int) bool {
+		if selectors[i].Facility != selectors[j].Facility {
+			return selectors[i].Facility < selectors[j].Facility
+		}
+		return selectors[i].Severity < selectors[j].Severity
+	})
+}
+
+// AnyAddress is the listen address of a server listening on all IPv4 and IPv6 addresses.
+const AnyAddress = "::"
+
+// GRPCServer contains the settings of the gRPC server of the agent.
+type GRPCServer struct {
+	Enable bool
+	// Port is 0 if not set.
+	Port uint16
+	// ListenAddresses are the IP addresses the server listens on, in ascending order. It only contains AnyAddress
+	// if the server listens on all addresses. Empty if not set.
+	ListenAddresses []string
+	// TransportSecurity indicates the server uses TLS. Nil if not set.
+	TransportSecurity *bool
+	// CertificateID is the ID of the certificate of the server in the certificate store.
+	CertificateID string
+}
+
+// GRPCServerSettings fetches the gRPC server settings of the given AP. It returns nil if the AP has no gRPC server settings.
+// The server is enabled by default. Invalid listen addresses are ignored.
+func GRPCServerSettings(ap *ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint) *GRPCServer {
+	if ap == nil || ap.System == nil || ap.System.GrpcServer == nil || ap.System.GrpcServer.Config == nil {
+		return nil
+	}
+	grpcConfig := ap.System.GrpcServer.Config
+	settings := &GRPCServer{
+		Enable:            grpcConfig.Enable == nil || *grpcConfig.Enable,
+		TransportSecurity: grpcConfig.TransportSecurity,
+	}
+	if grpcConfig.Port != nil {
+		settings.Port = *grpcConfig.Port
+	}
+	if grpcConfig.CertificateId != nil {
+		settings.CertificateID = *grpcConfig.CertificateId
+	}
+
+	addresses := make(map[string]bool)
+	for _, listenAddress := range grpcConfig.ListenAddresses {
+		switch address := listenAddress.(type) {
+		case *ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System_GrpcServer_Config_ListenAddresses_Union_E_OpenconfigAccessPoints_AccessPoints_AccessPoint_System_GrpcServer_Config_ListenAddresses:
+			if address.E_OpenconfigAccessPoints_AccessPoints_AccessPoint_System_GrpcServer_Config_ListenAddresses == ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System_GrpcServer_Config_ListenAddresses_ANY {
+				addresses[AnyAddress] = true
+			}
+		case *ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System_GrpcServer_Config_ListenAddresses_Union_String:
+			if ip := net.ParseIP(address.String); ip != nil && ip.IsUnspecified() {
+				addresses[AnyAddress] = true
+			} else if ip != nil {
+				addresses[ip.String()] = true
+			}
+		}
+	}
+	if addresses[AnyAddress] {
+		// Listening on all addresses conflicts with listening on specific ones.
+		settings.ListenAddresses = []string{AnyAddress}
+		return settings
+	}
+	for address := range addresses {
+		settings.ListenAddresses = append(settings.ListenAddresses, address)
+	}
+	sort.Strings(settings.ListenAddresses)
+	return settings
+}
+
+// SSHServer contains the settings of the SSH server of the AP.
+type SSHServer struct {
+	Enable bool
+	// RateLimit is the maximum number of new connections per minute, 0 if not limited.
+	RateLimit uint16
+	// SessionLimit is the maximum number of simultaneous sessions, 0 if not limited.
+	SessionLimit uint16
+	// Timeout is the idle timeout of sessions in seconds, 0 if sessions do not time out.
+	Timeout uint16
+}
+
+// SSHServerSettings fetches the SSH server settings of the given AP. It returns nil if the AP has no SSH server settings.
+// The server is enabled by default.
+func SSHServerSettings(ap *ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint) *SSHServer {
+	if ap == nil || ap.System == nil || ap.System.SshServer == nil || ap.System.SshServer.Config == nil {
+		return nil
+	}
+	sshConfig := ap.System.SshServer.Config
+	settings := &SSHServer{
+		Enable: sshConfig.Enable == nil || *sshConfig.Enable,
+	}
+	if sshConfig.RateLimit != nil {
+		settings.RateLimit = *sshConfig.RateLimit
+	}
+	if sshConfig.SessionLimit != nil {
+		settings.SessionLimit = *sshConfig.SessionLimit
+	}
+	if sshConfig.Timeout != nil {
+		settings.Timeout = *sshConfig.Timeout
+	}
+	return settings
+}
+
+// CheckTerminalServers checks that the terminal server settings of the given AP are supported:
+// the telnet server is always disabled, and the SSH server only supports protocol version 2.
+func CheckTerminalServers(ap *ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint) error {
+	if ap == nil || ap.System == nil {
+		return nil
+	}
+	if telnet := ap.System.TelnetServer; telnet != nil && telnet.Config != nil && telnet.Config.Enable != nil && *telnet.Config.Enable {
+		return errors.New("the telnet server is not supported and must stay disabled, use the SSH server instead")
+	}
+	if ssh := ap.System.SshServer; ssh != nil && ssh.Config != nil {
+		switch ssh.Config.ProtocolVersion {
+		case ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System_SshServer_Config_ProtocolVersion_UNSET,
+			ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System_SshServer_Config_ProtocolVersion_V2:
+		default:
+			return errors.New("SSH protocol version 1 is not supported, the SSH server only supports version 2")
+		}
+	}
+	return nil
+}
+
+// RadiusServers fetches the radius servers assigned to the given AP.
+// It returns a SSID -> RadiusServers map, servers of each SSID are in priority order.
+func RadiusServers(ap *ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint) map[string][]*ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System_Aaa_ServerGroups_ServerGroup_Servers_Server {
+	wlanRadiusMap := make(map[string][]*ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System_Aaa_ServerGroups_ServerGroup_Servers_Server)
 	if ap.Ssids == nil {
 		return wlanRadiusMap
 	}
@@ -84,8 +1087,8 @@ func RadiusServers(ap *ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint)
 
 		aaaServerGPName := *wlan.Config.ServerGroup
 		if serverGP, ok := apServerGPs[aaaServerGPName]; ok {
-			if radiusServer := aaaRadiusServer(serverGP); radiusServer != nil {
-				wlanRadiusMap[wlanName] = radiusServer
+			if radiusServers := aaaRadiusServers(serverGP); len(radiusServers) != 0 {
+				wlanRadiusMap[wlanName] = radiusServers
 			}
 		}
 	}
@@ -93,6 +1096,46 @@ func RadiusServers(ap *ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint)
 	return wlanRadiusMap
 }
 
+// RadiusAccountingServers fetches the radius servers used for accounting by the given AP, in priority order.
+// The accounting method is either RADIUS_ALL (all radius server groups) or the name of a server group.
+func RadiusAccountingServers(ap *ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint) []*ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System_Aaa_ServerGroups_ServerGroup_Servers_Server {
+	var acctServers []*ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System_Aaa_ServerGroups_ServerGroup_Servers_Server
+	if ap.System == nil || ap.System.Aaa == nil || ap.System.Aaa.Accounting == nil || ap.System.Aaa.Accounting.Config == nil {
+		return acctServers
+	}
+
+	apServerGPs := aaaServerGroups(ap)
+	var serverGPNames []string
+	for _, method := range ap.System.Aaa.Accounting.Config.AccountingMethod {
+		switch m := method.(type) {
+		case *ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System_Aaa_Accounting_Config_AccountingMethod_Union_E_OpenconfigAaaTypes_AAA_METHOD_TYPE:
+			if m.E_OpenconfigAaaTypes_AAA_METHOD_TYPE != ocstruct.OpenconfigAaaTypes_AAA_METHOD_TYPE_RADIUS_ALL {
+				continue
+			}
+			var allGPNames []string
+			for serverGPName := range apServerGPs {
+				allGPNames = append(allGPNames, serverGPName)
+			}
+			sort.Strings(allGPNames)
+			serverGPNames = append(serverGPNames, allGPNames...)
+		case *ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System_Aaa_Accounting_Config_AccountingMethod_Union_String:
+			serverGPNames = append(serverGPNames, m.String)
+		}
+	}
+
+	added := make(map[string]bool)
+	for _, serverGPName := range serverGPNames {
+		for _, radiusServer := range aaaRadiusServers(apServerGPs[serverGPName]) {
+			if added[*radiusServer.Address] {
+				continue
+			}
+			added[*radiusServer.Address] = true
+			acctServers = append(acctServers, radiusServer)
+		}
+	}
+	return acctServers
+}
+
 func aaaServerGroups(ap *ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint) map[string]*ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System_Aaa_ServerGroups_ServerGroup {
 	apSystemInfo := ap.System
 	if apSystemInfo == nil {
@@ -112,7 +1155,9 @@ func aaaServerGroups(ap *ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoin
 	return serverGP.ServerGroup
 }
 
-func aaaRadiusServer(serverGP *ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System_Aaa_ServerGroups_ServerGroup) *ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System_Aaa_ServerGroups_ServerGroup_Servers_Server {
+// aaaRadiusServers returns the radius servers in the given server group.
+// The servers are ordered by name, then by address. The first one is the primary server.
+func aaaRadiusServers(serverGP *ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System_Aaa_ServerGroups_ServerGroup) []*ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System_Aaa_ServerGroups_ServerGroup_Servers_Server {
 	if serverGP == nil || serverGP.Config == nil || serverGP.Config.Type != ocstruct.OpenconfigAaaTypes_AAA_SERVER_TYPE_RADIUS {
 		return nil
 	}
@@ -121,12 +1166,25 @@ func aaaRadiusServer(serverGP *ocstruct.OpenconfigAccessPoints_AccessPoints_Acce
 		return nil
 	}
 
+	var radiusServers []*ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System_Aaa_ServerGroups_ServerGroup_Servers_Server
 	for _, radiusServer := range serverGP.Servers.Server {
-		if radiusServer != nil {
-			// Return the first radius server specified.
-			return radiusServer
+		if radiusServer != nil && radiusServer.Address != nil {
+			radiusServers = append(radiusServers, radiusServer)
 		}
 	}
-	// Not found Radius server
-	return nil
+	sort.Slice(radiusServers, func(i, j int) bool {
+		nameI, nameJ := radiusServerName(radiusServers[i]), radiusServerName(radiusServers[j])
+		if nameI != nameJ {
+			return nameI < nameJ
+		}
+		return *radiusServers[i].Address < *radiusServers[j].Address
+	})
+	return radiusServers
+}
+
+func radiusServerName(radiusServer *ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System_Aaa_ServerGroups_ServerGroup_Servers_Server) string {
+	if radiusServer.Config == nil || radiusServer.Config.Name == nil {
+		return ""
+	}
+	return *radiusServer.Config.Name
 }
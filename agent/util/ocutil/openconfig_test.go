@@ -16,12 +16,16 @@ limitations under the License.
 package ocutil
 
 import (
+	"fmt"
+	"net"
 	"reflect"
 	"sort"
 	"testing"
+	"time"
 
 	"github.com/google/link022/agent/util/mock"
 	"github.com/google/link022/generated/ocstruct"
+	"github.com/openconfig/ygot/ygot"
 )
 
 func TestFindAPConfig(t *testing.T) {
@@ -50,6 +54,56 @@ func TestFindAPConfig(t *testing.T) {
 	}
 }
 
+func TestFindRadio(t *testing.T) {
+	// Define test cases.
+	tests := []struct {
+		apConfigs    *ocstruct.Device
+		targetAPName string
+		radioID      uint8
+		found        bool
+	}{{
+		apConfigs:    mock.GenerateConfig(true),
+		targetAPName: "fake AP",
+		radioID:      1,
+		found:        false,
+	}, {
+		apConfigs:    mock.GenerateConfig(true),
+		targetAPName: "test-pi-1",
+		radioID:      2,
+		found:        false,
+	}, {
+		apConfigs:    mock.GenerateConfig(true),
+		targetAPName: "test-pi-1",
+		radioID:      1,
+		found:        true,
+	}}
+
+	for _, test := range tests {
+		matchedRadio := FindRadio(test.apConfigs, test.targetAPName, test.radioID)
+		foundMatch := matchedRadio != nil
+
+		if foundMatch != test.found {
+			t.Errorf("Incorrect FindRadio result (got: %v, want:%v).", foundMatch, test.found)
+		}
+	}
+}
+
+func TestRadioIDs(t *testing.T) {
+	if got := RadioIDs(&ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint{}); len(got) != 0 {
+		t.Errorf("Expected no radio, got %v.", got)
+	}
+
+	apConfig := mock.GenerateAPConfig(true)
+	for _, id := range []uint8{5, 3} {
+		if _, err := apConfig.Radios.NewRadio(id); err != nil {
+			t.Fatalf("Unable to add radio %d. Error: %v.", id, err)
+		}
+	}
+	if got, want := RadioIDs(apConfig), []uint8{1, 3, 5}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Incorrect radio IDs (got: %v, want: %v).", got, want)
+	}
+}
+
 func TestVLANIDs(t *testing.T) {
 	// Define test cases.
 	tests := []struct {
@@ -61,6 +115,12 @@ func TestVLANIDs(t *testing.T) {
 	}, {
 		apConfig: mock.GenerateAPConfig(false),
 		vlanIDs:  []int{666},
+	}, {
+		apConfig: dynamicVLANConfig(),
+		vlanIDs:  []int{100, 250, 300, 666},
+	}, {
+		apConfig: disabledSSIDConfig(),
+		vlanIDs:  []int{250, 666},
 	}, {
 		apConfig: nil,
 		vlanIDs:  []int{},
@@ -77,6 +137,83 @@ func TestVLANIDs(t *testing.T) {
 	}
 }
 
+func TestUplinkVLANIDs(t *testing.T) {
+	natSSIDs := map[string]*NAT{mock.GuestWLANName: {}}
+
+	// Define test cases.
+	tests := []struct {
+		apConfig *ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint
+		vlanIDs  []int
+	}{{
+		apConfig: mock.GenerateAPConfig(true),
+		vlanIDs:  []int{250},
+	}, {
+		apConfig: mock.GenerateAPConfig(false),
+		vlanIDs:  []int{},
+	}, {
+		apConfig: dynamicVLANConfig(),
+		vlanIDs:  []int{100, 250, 300},
+	}}
+
+	for _, test := range tests {
+		got := UplinkVLANIDs(test.apConfig, natSSIDs)
+		sort.Ints(got)
+		if !reflect.DeepEqual(got, test.vlanIDs) {
+			t.Errorf("Incorrect uplink VLAN IDs (got: %v, want: %v).", got, test.vlanIDs)
+		}
+	}
+}
+
+// dynamicVLANConfig generates an AP configuration with VLAN lists on both SSIDs.
+func dynamicVLANConfig() *ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint {
+	apConfig := mock.GenerateAPConfig(true)
+	apConfig.Ssids.Ssid[mock.GuestWLANName].Config.VlanList = []uint16{300, 666}
+	apConfig.Ssids.Ssid[mock.AuthWLANName].Config.VlanList = []uint16{300, 100, 300}
+	return apConfig
+}
+
+func TestSSIDVLANIDs(t *testing.T) {
+	apConfig := dynamicVLANConfig()
+
+	// Define test cases.
+	tests := []struct {
+		wlanName string
+		vlanIDs  []int
+	}{{
+		wlanName: mock.GuestWLANName,
+		vlanIDs:  []int{666, 300},
+	}, {
+		wlanName: mock.AuthWLANName,
+		vlanIDs:  []int{250, 100, 300},
+	}}
+
+	for _, test := range tests {
+		got := SSIDVLANIDs(apConfig.Ssids.Ssid[test.wlanName].Config)
+		if !reflect.DeepEqual(got, test.vlanIDs) {
+			t.Errorf("Incorrect VLAN IDs of %s (got: %v, want: %v).", test.wlanName, got, test.vlanIDs)
+		}
+	}
+}
+
+// disabledSSIDConfig generates an AP configuration with the guest SSID disabled.
+func disabledSSIDConfig() *ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint {
+	apConfig := mock.GenerateAPConfig(true)
+	apConfig.Ssids.Ssid[mock.GuestWLANName].Config.Enabled = ygot.Bool(false)
+	return apConfig
+}
+
+func TestSSIDEnabled(t *testing.T) {
+	apConfig := disabledSSIDConfig()
+	apConfig.Ssids.Ssid[mock.AuthWLANName].Config.Enabled = nil
+
+	if SSIDEnabled(apConfig.Ssids.Ssid[mock.GuestWLANName].Config) {
+		t.Error("Disabled SSID reported as enabled.")
+	}
+	if !SSIDEnabled(apConfig.Ssids.Ssid[mock.AuthWLANName].Config) {
+		t.Error("SSID without enabled flag reported as disabled.")
+	}
+}
+
 func TestVLANChanged(t *testing.T) {
 	// Define test cases.
 	tests := []struct {
@@ -118,18 +255,729 @@ func TestVLANChanged(t *testing.T) {
 	}
 }
 
+func TestBandSteeringSSIDs(t *testing.T) {
+	apConfig := mock.GenerateAPConfig(true)
+	apConfig.Ssids.Ssid[mock.GuestWLANName].BandSteering = &ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_Ssids_Ssid_BandSteering{
+		Config: &ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_Ssids_Ssid_BandSteering_Config{
+			BandSteering: ygot.Bool(true),
+			SteeringRssi: ygot.Int8(-60),
+		},
+	}
+	apConfig.Ssids.Ssid[mock.AuthWLANName].BandSteering = &ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_Ssids_Ssid_BandSteering{
+		Config: &ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_Ssids_Ssid_BandSteering_Config{
+			BandSteering: ygot.Bool(true),
+		},
+	}
+	disabledConfig := mock.GenerateAPConfig(true)
+	disabledConfig.Ssids.Ssid[mock.GuestWLANName].BandSteering = &ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_Ssids_Ssid_BandSteering{
+		Config: &ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_Ssids_Ssid_BandSteering_Config{
+			BandSteering: ygot.Bool(false),
+			SteeringRssi: ygot.Int8(-60),
+		},
+	}
+
+	// Define test cases.
+	tests := []struct {
+		apConfig      *ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint
+		steeringRSSIs map[string]int8
+	}{{
+		apConfig:      apConfig,
+		steeringRSSIs: map[string]int8{mock.GuestWLANName: -60, mock.AuthWLANName: defaultSteeringRSSI},
+	}, {
+		apConfig:      disabledConfig,
+		steeringRSSIs: map[string]int8{},
+	}, {
+		apConfig:      mock.GenerateAPConfig(false),
+		steeringRSSIs: map[string]int8{},
+	}, {
+		apConfig:      nil,
+		steeringRSSIs: map[string]int8{},
+	}}
+
+	for _, test := range tests {
+		got := BandSteeringSSIDs(test.apConfig)
+		if !reflect.DeepEqual(got, test.steeringRSSIs) {
+			t.Errorf("Incorrect band steering SSIDs (got: %v, want: %v).", got, test.steeringRSSIs)
+		}
+	}
+}
+
+func TestDot1XBlacklistSSIDs(t *testing.T) {
+	apConfig := mock.GenerateAPConfig(true)
+	apConfig.Ssids.Ssid[mock.AuthWLANName].Dot1XTimers = &ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_Ssids_Ssid_Dot1XTimers{
+		Config: &ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_Ssids_Ssid_Dot1XTimers_Config{
+			MaxAuthFailures: ygot.Uint8(3),
+			BlacklistTime:   ygot.Uint16(120),
+		},
+	}
+	apConfig.Ssids.Ssid[mock.GuestWLANName].Dot1XTimers = &ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_Ssids_Ssid_Dot1XTimers{
+		Config: &ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_Ssids_Ssid_Dot1XTimers_Config{
+			MaxAuthFailures: ygot.Uint8(5),
+		},
+	}
+	noLimitConfig := mock.GenerateAPConfig(true)
+	noLimitConfig.Ssids.Ssid[mock.AuthWLANName].Dot1XTimers = &ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_Ssids_Ssid_Dot1XTimers{
+		Config: &ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_Ssids_Ssid_Dot1XTimers_Config{
+			MaxAuthFailures: ygot.Uint8(0),
+			BlacklistTime:   ygot.Uint16(120),
+		},
+	}
+
+	// Define test cases.
+	tests := []struct {
+		apConfig   *ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint
+		blacklists map[string]Dot1XBlacklist
+	}{{
+		apConfig: apConfig,
+		blacklists: map[string]Dot1XBlacklist{
+			mock.AuthWLANName:  {MaxAuthFailures: 3, BlacklistTime: 120 * time.Second},
+			mock.GuestWLANName: {MaxAuthFailures: 5, BlacklistTime: defaultBlacklistTime},
+		},
+	}, {
+		apConfig:   noLimitConfig,
+		blacklists: map[string]Dot1XBlacklist{},
+	}, {
+		apConfig:   nil,
+		blacklists: map[string]Dot1XBlacklist{},
+	}}
+
+	for _, test := range tests {
+		got := Dot1XBlacklistSSIDs(test.apConfig)
+		if !reflect.DeepEqual(got, test.blacklists) {
+			t.Errorf("Incorrect 802.1X blacklist settings (got: %v, want: %v).", got, test.blacklists)
+		}
+	}
+}
+
+func TestL2FilterSSIDs(t *testing.T) {
+	apConfig := mock.GenerateAPConfig(true)
+	guestWLANConfig := apConfig.Ssids.Ssid[mock.GuestWLANName].Config
+	guestWLANConfig.BroadcastFilter = ygot.Bool(true)
+	guestWLANConfig.DhcpRequired = ygot.Bool(false)
+	guestWLANConfig.Ipv6NdpFilter = ygot.Bool(true)
+	guestWLANConfig.Ipv6NdpFilterTimer = ygot.Uint16(30)
+	guestWLANConfig.StationIsolation = ygot.Bool(true)
+	guestWLANConfig.VlanList = []uint16{300}
+	authWLANConfig := apConfig.Ssids.Ssid[mock.AuthWLANName].Config
+	authWLANConfig.DhcpRequired = ygot.Bool(true)
+	authWLANConfig.Ipv6NdpFilterTimer = ygot.Uint16(30)
+	isolationOnlyConfig := mock.GenerateAPConfig(false)
+	isolationOnlyConfig.Ssids.Ssid[mock.GuestWLANName].Config.StationIsolation = ygot.Bool(true)
+	isolationOnlyConfig.Ssids.Ssid[mock.GuestWLANName].Config.DhcpRequired = ygot.Bool(false)
+
+	// Define test cases.
+	tests := []struct {
+		apConfig *ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint
+		filters  map[string]L2Filter
+	}{{
+		apConfig: apConfig,
+		filters: map[string]L2Filter{
+			mock.GuestWLANName: {
+				BroadcastFilter:      true,
+				NDPFilter:            true,
+				RouterAdvertInterval: 30 * time.Second,
+				StationIsolation:     true,
+				DynamicVLANIDs:       []int{666, 300},
+			},
+			mock.AuthWLANName: {DHCPRequired: true},
+		},
+	}, {
+		apConfig: isolationOnlyConfig,
+		filters:  map[string]L2Filter{},
+	}, {
+		apConfig: nil,
+		filters:  map[string]L2Filter{},
+	}}
+
+	for _, test := range tests {
+		got := L2FilterSSIDs(test.apConfig)
+		if !reflect.DeepEqual(got, test.filters) {
+			t.Errorf("Incorrect L2 filter SSIDs (got: %+v, want: %+v).", got, test.filters)
+		}
+	}
+}
+
+func TestMACACLSSIDs(t *testing.T) {
+	gasketConfig := &ocstruct.OpenconfigGasket_Gasket{MacAcls: &ocstruct.OpenconfigGasket_Gasket_MacAcls{}}
+	guestACL, err := gasketConfig.MacAcls.NewSsid(mock.GuestWLANName)
+	if err != nil {
+		t.Fatalf("Unable to create the MAC ACL of %s. Error: %v.", mock.GuestWLANName, err)
+	}
+	guestACL.AcceptMac = []string{"02:00:00:00:00:0B", "02:00:00:00:00:0a", "02:00:00:00:00:0b"}
+	guestACL.DenyMac = []string{"02:00:00:00:00:0c"}
+
+	// Define test cases.
+	tests := []struct {
+		gasketConfig *ocstruct.OpenconfigGasket_Gasket
+		acls         map[string]*MACACL
+	}{{
+		gasketConfig: gasketConfig,
+		acls: map[string]*MACACL{
+			mock.GuestWLANName: {
+				Accept: []string{"02:00:00:00:00:0a", "02:00:00:00:00:0b"},
+				Deny:   []string{"02:00:00:00:00:0c"},
+			},
+		},
+	}, {
+		gasketConfig: &ocstruct.OpenconfigGasket_Gasket{},
+		acls:         map[string]*MACACL{},
+	}, {
+		gasketConfig: nil,
+		acls:         map[string]*MACACL{},
+	}}
+
+	for _, test := range tests {
+		got := MACACLSSIDs(test.gasketConfig)
+		if !reflect.DeepEqual(got, test.acls) {
+			t.Errorf("Incorrect MAC ACL SSIDs (got: %+v, want: %+v).", got, test.acls)
+		}
+	}
+}
+
+func TestNATSSIDs(t *testing.T) {
+	gasketConfig := &ocstruct.OpenconfigGasket_Gasket{NatSsids: &ocstruct.OpenconfigGasket_Gasket_NatSsids{}}
+	addNATSSID := func(wlanName, gatewayAddress string, leaseTime *uint32) {
+		ssidNAT, err := gasketConfig.NatSsids.NewSsid(wlanName)
+		if err != nil {
+			t.Fatalf("Unable to create the NAT settings of %s. Error: %v.", wlanName, err)
+		}
+		ssidNAT.GatewayAddress = ygot.String(gatewayAddress)
+		ssidNAT.LeaseTime = leaseTime
+	}
+	addNATSSID(mock.GuestWLANName, "192.168.50.1/24", nil)
+	addNATSSID(mock.AuthWLANName, "10.10.0.254/24", ygot.Uint32(600))
+	addNATSSID("Lab-Emu", "172.16.0.1/31", nil)
+
+	natSSIDs := NATSSIDs(gasketConfig)
+	want := map[string]*NAT{
+		mock.AuthWLANName: {
+			Index:     0,
+			Gateway:   net.IPv4(10, 10, 0, 254).To4(),
+			Subnet:    &net.IPNet{IP: net.IPv4(10, 10, 0, 0).To4(), Mask: net.CIDRMask(24, 32)},
+			LeaseTime: 10 * time.Minute,
+		},
+		mock.GuestWLANName: {
+			Index:     1,
+			Gateway:   net.IPv4(192, 168, 50, 1).To4(),
+			Subnet:    &net.IPNet{IP: net.IPv4(192, 168, 50, 0).To4(), Mask: net.CIDRMask(24, 32)},
+			LeaseTime: time.Hour,
+		},
+	}
+	if !reflect.DeepEqual(natSSIDs, want) {
+		t.Errorf("Incorrect NAT SSIDs (got: %+v, want: %+v).", natSSIDs, want)
+	}
+
+	// Define test cases.
+	tests := []struct {
+		wlanName string
+		first    string
+		last     string
+	}{{
+		wlanName: mock.AuthWLANName,
+		first:    "10.10.0.1",
+		last:     "10.10.0.253",
+	}, {
+		wlanName: mock.GuestWLANName,
+		first:    "192.168.50.2",
+		last:     "192.168.50.254",
+	}}
+
+	for _, test := range tests {
+		first, last := natSSIDs[test.wlanName].DHCPRange()
+		if first.String() != test.first || last.String() != test.last {
+			t.Errorf("Incorrect DHCP range of %s (got: %v-%v, want: %v-%v).", test.wlanName, first, last, test.first, test.last)
+		}
+	}
+
+	if got := NATSSIDs(nil); len(got) != 0 {
+		t.Errorf("Expected no NAT SSIDs without gasket configuration, got %+v.", got)
+	}
+}
+
+func TestCheckCtrlInterface(t *testing.T) {
+	tests := []struct {
+		ctrlInterface string
+		valid         bool
+	}{
+		{ctrlInterface: "", valid: true},
+		{ctrlInterface: "/var/run/hostapd", valid: true},
+		{ctrlInterface: "/var/run/hostapd/", valid: true},
+		{ctrlInterface: "DIR=/var/run/hostapd GROUP=netdev", valid: true},
+		{ctrlInterface: "udp:8888"},
+		{ctrlInterface: "/tmp/hostapd"},
+		{ctrlInterface: "DIR= GROUP=netdev"},
+	}
+
+	if err := CheckCtrlInterface(nil, "/var/run/hostapd"); err != nil {
+		t.Errorf("Unexpected error without gasket configuration: %v.", err)
+	}
+	for _, test := range tests {
+		gasketConfig := &ocstruct.OpenconfigGasket_Gasket{CtrlInterface: ygot.String(test.ctrlInterface)}
+		if err := CheckCtrlInterface(gasketConfig, "/var/run/hostapd"); (err == nil) != test.valid {
+			t.Errorf("Incorrect validation of control interface %q (valid: %v, error: %v).", test.ctrlInterface, test.valid, err)
+		}
+	}
+}
+
+func TestCheckNATSSIDs(t *testing.T) {
+	tests := []struct {
+		gatewayAddress string
+		valid          bool
+	}{
+		{gatewayAddress: "192.168.50.1/24", valid: true},
+		{gatewayAddress: "172.16.0.1/30", valid: true},
+		{gatewayAddress: "192.168.50.1"},
+		{gatewayAddress: "2001:db8::1/64"},
+		{gatewayAddress: "172.16.0.1/31"},
+		{gatewayAddress: "192.168.50.0/24"},
+		{gatewayAddress: "192.168.50.255/24"},
+	}
+
+	for _, test := range tests {
+		gasketConfig := &ocstruct.OpenconfigGasket_Gasket{NatSsids: &ocstruct.OpenconfigGasket_Gasket_NatSsids{}}
+		ssidNAT, err := gasketConfig.NatSsids.NewSsid(mock.GuestWLANName)
+		if err != nil {
+			t.Fatalf("Unable to create the NAT settings of %s. Error: %v.", mock.GuestWLANName, err)
+		}
+		ssidNAT.GatewayAddress = ygot.String(test.gatewayAddress)
+		if err := CheckNATSSIDs(gasketConfig); (err == nil) != test.valid {
+			t.Errorf("Incorrect validation of gateway address %s (valid: %v, error: %v).", test.gatewayAddress, test.valid, err)
+		}
+	}
+
+	gasketConfig := &ocstruct.OpenconfigGasket_Gasket{NatSsids: &ocstruct.OpenconfigGasket_Gasket_NatSsids{}}
+	if _, err := gasketConfig.NatSsids.NewSsid(mock.GuestWLANName); err != nil {
+		t.Fatalf("Unable to create the NAT settings of %s. Error: %v.", mock.GuestWLANName, err)
+	}
+	if err := CheckNATSSIDs(gasketConfig); err == nil {
+		t.Error("Expected an error for a NAT SSID without gateway address.")
+	}
+	if err := CheckNATSSIDs(nil); err != nil {
+		t.Errorf("Expected no error without gasket configuration. Error: %v.", err)
+	}
+}
+
+func TestRateLimitSSIDs(t *testing.T) {
+	gasketConfig := &ocstruct.OpenconfigGasket_Gasket{RateLimits: &ocstruct.OpenconfigGasket_Gasket_RateLimits{}}
+	guestLimits, err := gasketConfig.RateLimits.NewSsid(mock.GuestWLANName)
+	if err != nil {
+		t.Fatalf("Unable to create the rate limits of %s. Error: %v.", mock.GuestWLANName, err)
+	}
+	guestLimits.SsidDownKbps = ygot.Uint64(20000)
+	guestLimits.ClientUpKbps = ygot.Uint64(1000)
+	authLimits, err := gasketConfig.RateLimits.NewSsid(mock.AuthWLANName)
+	if err != nil {
+		t.Fatalf("Unable to create the rate limits of %s. Error: %v.", mock.AuthWLANName, err)
+	}
+	authLimits.ClientDownKbps = ygot.Uint64(0)
+
+	got := RateLimitSSIDs(gasketConfig)
+	want := map[string]*RateLimits{mock.GuestWLANName: {SSIDDownKbps: 20000, ClientUpKbps: 1000}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Incorrect rate limits (got: %+v, want: %+v).", got, want)
+	}
+	if !got[mock.GuestWLANName].Down() || !got[mock.GuestWLANName].Up() {
+		t.Errorf("Expected both directions of %s to be limited.", mock.GuestWLANName)
+	}
+
+	if got := RateLimitSSIDs(nil); len(got) != 0 {
+		t.Errorf("Expected no rate limits without gasket configuration, got %+v.", got)
+	}
+}
+
+func TestCaptivePortalSSIDs(t *testing.T) {
+	gasketConfig := &ocstruct.OpenconfigGasket_Gasket{
+		NatSsids:       &ocstruct.OpenconfigGasket_Gasket_NatSsids{},
+		CaptivePortals: &ocstruct.OpenconfigGasket_Gasket_CaptivePortals{},
+	}
+	guestNAT, err := gasketConfig.NatSsids.NewSsid(mock.GuestWLANName)
+	if err != nil {
+		t.Fatalf("Unable to create the NAT settings of %s. Error: %v.", mock.GuestWLANName, err)
+	}
+	guestNAT.GatewayAddress = ygot.String("192.168.50.1/24")
+	guestPortal, err := gasketConfig.CaptivePortals.NewSsid(mock.GuestWLANName)
+	if err != nil {
+		t.Fatalf("Unable to create the captive portal of %s. Error: %v.", mock.GuestWLANName, err)
+	}
+	guestPortal.Voucher = []string{"spring", "autumn"}
+	authPortal, err := gasketConfig.CaptivePortals.NewSsid(mock.AuthWLANName)
+	if err != nil {
+		t.Fatalf("Unable to create the captive portal of %s. Error: %v.", mock.AuthWLANName, err)
+	}
+	authPortal.Port = ygot.Uint16(8000)
+
+	// The portal of the SSID without NAT is skipped.
+	want := map[string]*CaptivePortal{
+		mock.GuestWLANName: {
+			Port:           8081,
+			SessionTimeout: 24 * time.Hour,
+			Vouchers:       []string{"autumn", "spring"},
+		},
+	}
+	if got := CaptivePortalSSIDs(gasketConfig); !reflect.DeepEqual(got, want) {
+		t.Errorf("Incorrect captive portal SSIDs (got: %+v, want: %+v).", got, want)
+	}
+
+	guestPortal.SessionTimeout = ygot.Uint32(3600)
+	if got := CaptivePortalSSIDs(gasketConfig)[mock.GuestWLANName].SessionTimeout; got != time.Hour {
+		t.Errorf("Incorrect session timeout (got: %v, want: %v).", got, time.Hour)
+	}
+	if got := CaptivePortalSSIDs(nil); len(got) != 0 {
+		t.Errorf("Expected no captive portals without gasket configuration, got %+v.", got)
+	}
+}
+
+func TestManagementIntfSettings(t *testing.T) {
+	apConfig := mock.GenerateAPConfig(false)
+	gasketConfig := &ocstruct.OpenconfigGasket_Gasket{
+		ManagementInterfaces: &ocstruct.OpenconfigGasket_Gasket_ManagementInterfaces{},
+	}
+	if got := ManagementIntfSettings(gasketConfig, apConfig); got != nil {
+		t.Errorf("Expected no management interface settings for an AP without an entry, got %+v.", got)
+	}
+
+	mgmtConfig, err := gasketConfig.ManagementInterfaces.NewAccessPoint(*apConfig.Hostname)
+	if err != nil {
+		t.Fatalf("Unable to create the management interface of %s. Error: %v.", *apConfig.Hostname, err)
+	}
+	want := &ManagementIntf{DHCP: true, ConfirmTimeout: 5 * time.Minute}
+	if got := ManagementIntfSettings(gasketConfig, apConfig); !reflect.DeepEqual(got, want) {
+		t.Errorf("Incorrect default management interface settings (got: %+v, want: %+v).", got, want)
+	}
+
+	mgmtConfig.VlanId = ygot.Uint16(10)
+	mgmtConfig.Dhcp = ygot.Bool(false)
+	mgmtConfig.Ipv4Address = ygot.String("192.168.1.20/24")
+	mgmtConfig.Ipv4Gateway = ygot.String("192.168.1.1")
+	mgmtConfig.Ipv6Address = ygot.String("2001:DB8:0::20/64")
+	mgmtConfig.Ipv6Gateway = ygot.String("2001:db8::1")
+	mgmtConfig.DnsServer = []string{"192.168.1.53", "not-an-address", "2001:db8::53"}
+	mgmtConfig.ConfirmTimeout = ygot.Uint32(0)
+	want = &ManagementIntf{
+		VLANID:      10,
+		IPv4Address: "192.168.1.20/24",
+		IPv4Gateway: "192.168.1.1",
+		IPv6Address: "2001:db8::20/64",
+		IPv6Gateway: "2001:db8::1",
+		DNSServers:  []string{"192.168.1.53", "2001:db8::53"},
+	}
+	got := ManagementIntfSettings(gasketConfig, apConfig)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Incorrect static management interface settings (got: %+v, want: %+v).", got, want)
+	}
+	if intfName := got.IntfName("eth0"); intfName != "eth0.10" {
+		t.Errorf("Incorrect management interface name %s.", intfName)
+	}
+
+	// The management VLAN cannot be a VLAN of SSIDs.
+	mgmtConfig.VlanId = ygot.Uint16(uint16(VLANIDs(apConfig)[0]))
+	if got := ManagementIntfSettings(gasketConfig, apConfig); got != nil {
+		t.Errorf("Expected no management interface settings on a VLAN of SSIDs, got %+v.", got)
+	}
+}
+
+func TestNTPSettings(t *testing.T) {
+	apConfig := mock.GenerateAPConfig(false)
+	if got := NTPSettings(apConfig); got != nil {
+		t.Errorf("Expected no NTP settings for an AP without NTP, got %+v.", got)
+	}
+
+	apConfig.System = &ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System{
+		Ntp: &ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System_Ntp{
+			Config: &ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System_Ntp_Config{
+				EnableNtpAuth:    ygot.Bool(true),
+				NtpSourceAddress: ygot.String("192.168.1.20"),
+			},
+			Servers: &ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System_Ntp_Servers{},
+			NtpKeys: &ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System_Ntp_NtpKeys{},
+		},
+	}
+	ntpConfig := apConfig.System.Ntp
+	if got := NTPSettings(apConfig); got != nil {
+		t.Errorf("Expected no NTP settings while NTP is disabled, got %+v.", got)
+	}
+
+	ntpConfig.Config.Enabled = ygot.Bool(true)
+	pool, _ := ntpConfig.Servers.NewServer("pool.ntp.org")
+	pool.Config = &ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System_Ntp_Servers_Server_Config{
+		AssociationType: ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System_Ntp_Servers_Server_Config_AssociationType_POOL,
+		Iburst:          ygot.Bool(true),
+		KeyId:           ygot.Uint16(10),
+	}
+	server, _ := ntpConfig.Servers.NewServer("192.168.1.1")
+	server.Config = &ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System_Ntp_Servers_Server_Config{
+		Port:    ygot.Uint16(1123),
+		Version: ygot.Uint8(3),
+		Prefer:  ygot.Bool(true),
+		KeyId:   ygot.Uint16(20),
+	}
+	for _, keyID := range []uint16{20, 10} {
+		key, _ := ntpConfig.NtpKeys.NewNtpKey(keyID)
+		key.Config = &ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System_Ntp_NtpKeys_NtpKey_Config{
+			KeyType:  ocstruct.OpenconfigSystem_NTP_AUTH_TYPE_NTP_AUTH_MD5,
+			KeyValue: ygot.String(fmt.Sprintf("secret%d", keyID)),
+		}
+	}
+
+	want := &NTP{
+		Servers: []NTPServer{
+			{Address: "192.168.1.1", Port: 1123, Version: 3, Type: "server", Prefer: true, KeyID: 20},
+			{Address: "pool.ntp.org", Type: "pool", IBurst: true, KeyID: 10},
+		},
+		Keys: []NTPKey{
+			{ID: 10, Type: "MD5", Value: "secret10"},
+			{ID: 20, Type: "MD5", Value: "secret20"},
+		},
+		Auth:          true,
+		SourceAddress: "192.168.1.20",
+	}
+	if got := NTPSettings(apConfig); !reflect.DeepEqual(got, want) {
+		t.Errorf("Incorrect NTP settings (got: %+v, want: %+v).", got, want)
+	}
+}
+
+func TestDNSSettings(t *testing.T) {
+	apConfig := mock.GenerateAPConfig(false)
+	if got := DNSSettings(apConfig); got != nil {
+		t.Errorf("Expected no DNS settings for an AP without DNS, got %+v.", got)
+	}
+
+	apConfig.System = &ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System{
+		Dns: &ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System_Dns{
+			Config: &ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System_Dns_Config{
+				Search: []string{"corp.example.com", "example.com"},
+			},
+			Servers:     &ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System_Dns_Servers{},
+			HostEntries: &ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System_Dns_HostEntries{},
+		},
+	}
+	dnsConfig := apConfig.System.Dns
+	for _, address := range []string{"2001:DB8::53", "192.168.1.53", "not-an-address"} {
+		dnsConfig.Servers.NewServer(address)
+	}
+	radius, _ := dnsConfig.HostEntries.NewHostEntry("radius")
+	radius.Config = &ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System_Dns_HostEntries_HostEntry_Config{
+		Alias:       []string{"radius.corp.example.com"},
+		Ipv4Address: []string{"192.168.1.10"},
+		Ipv6Address: []string{"2001:db8::10"},
+	}
+	// Host entries without a valid address are ignored.
+	invalid, _ := dnsConfig.HostEntries.NewHostEntry("invalid")
+	invalid.Config = &ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System_Dns_HostEntries_HostEntry_Config{
+		Ipv4Address: []string{"192.168.1"},
+	}
+
+	want := &DNS{
+		Servers: []string{"192.168.1.53", "2001:db8::53"},
+		Search:  []string{"corp.example.com", "example.com"},
+		Hosts: []HostEntry{{
+			Hostname:  "radius",
+			Aliases:   []string{"radius.corp.example.com"},
+			Addresses: []string{"192.168.1.10", "2001:db8::10"},
+		}},
+	}
+	got := DNSSettings(apConfig)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Incorrect DNS settings (got: %+v, want: %+v).", got, want)
+	}
+	if !got.HasResolver() {
+		t.Error("Expected resolver settings with DNS servers.")
+	}
+
+	// The DNS servers of the AP replace the ones of its management interface.
+	gasketConfig := &ocstruct.OpenconfigGasket_Gasket{
+		ManagementInterfaces: &ocstruct.OpenconfigGasket_Gasket_ManagementInterfaces{},
+	}
+	mgmtConfig, _ := gasketConfig.ManagementInterfaces.NewAccessPoint(*apConfig.Hostname)
+	mgmtConfig.DnsServer = []string{"192.168.1.54"}
+	if mgmtIntf := ManagementIntfSettings(gasketConfig, apConfig); len(mgmtIntf.DNSServers) != 0 {
+		t.Errorf("Unexpected DNS servers of the management interface: %v.", mgmtIntf.DNSServers)
+	}
+}
+
+func TestTimezoneName(t *testing.T) {
+	apConfig := mock.GenerateAPConfig(false)
+	if got := TimezoneName(apConfig); got != "" {
+		t.Errorf("Expected no timezone, got %s.", got)
+	}
+	apConfig.System = &ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System{
+		Clock: &ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System_Clock{
+			Config: &ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System_Clock_Config{
+				TimezoneName: ygot.String("America/Los_Angeles"),
+			},
+		},
+	}
+	if got := TimezoneName(apConfig); got != "America/Los_Angeles" {
+		t.Errorf("Incorrect timezone %s.", got)
+	}
+}
+
+func TestRemoteSyslogServers(t *testing.T) {
+	apConfig := mock.GenerateAPConfig(false)
+	if got := RemoteSyslogServers(apConfig); len(got) != 0 {
+		t.Errorf("Expected no remote syslog servers, got %v.", got)
+	}
+
+	apConfig.System = &ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System{
+		Logging: &ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System_Logging{
+			RemoteServers: &ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System_Logging_RemoteServers{},
+		},
+	}
+	remoteServers := apConfig.System.Logging.RemoteServers
+	ports := map[string]uint16{"192.168.1.10": 0, "192.168.1.11": SyslogTLSPort, "192.168.1.12": 1514}
+	for host, port := range ports {
+		server, _ := remoteServers.NewRemoteServer(host)
+		server.Config = &ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System_Logging_RemoteServers_RemoteServer_Config{
+			Host:          ygot.String(host),
+			SourceAddress: ygot.String("192.168.1.20"),
+		}
+		if port != 0 {
+			server.Config.RemotePort = ygot.Uint16(port)
+		}
+		server.Selectors = &ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System_Logging_RemoteServers_RemoteServer_Selectors{}
+		server.Selectors.NewSelector(ocstruct.OpenconfigSystemLogging_SYSLOG_FACILITY_SYSTEM_DAEMON, ocstruct.OpenconfigSystemLogging_SyslogSeverity_DEBUG)
+		server.Selectors.NewSelector(ocstruct.OpenconfigSystemLogging_SYSLOG_FACILITY_ALL, ocstruct.OpenconfigSystemLogging_SyslogSeverity_ERROR)
+	}
+	// Servers without selectors receive no message.
+	remoteServers.NewRemoteServer("192.168.1.13")
+
+	selectors := []SyslogSelector{{Facility: -1, Severity: 3}, {Facility: 3, Severity: 7}}
+	want := []*RemoteSyslogServer{
+		{Host: "192.168.1.10", Port: SyslogUDPPort, Transport: "udp", SourceAddress: "192.168.1.20", Selectors: selectors},
+		{Host: "192.168.1.11", Port: SyslogTLSPort, Transport: "tls", SourceAddress: "192.168.1.20", Selectors: selectors},
+		{Host: "192.168.1.12", Port: 1514, Transport: "udp", SourceAddress: "192.168.1.20", Selectors: selectors},
+	}
+	if got := RemoteSyslogServers(apConfig); !reflect.DeepEqual(got, want) {
+		t.Errorf("Incorrect remote syslog servers (got: %+v, want: %+v).", got, want)
+	}
+}
+
+func TestConsoleSyslogSelectors(t *testing.T) {
+	apConfig := mock.GenerateAPConfig(false)
+	if got := ConsoleSyslogSelectors(apConfig); got != nil {
+		t.Errorf("Expected no console selectors, got %v.", got)
+	}
+
+	apConfig.System = &ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System{
+		Logging: &ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System_Logging{
+			Console: &ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System_Logging_Console{
+				Selectors: &ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System_Logging_Console_Selectors{},
+			},
+		},
+	}
+	consoleSelectors := apConfig.System.Logging.Console.Selectors
+	consoleSelectors.NewSelector(ocstruct.OpenconfigSystemLogging_SYSLOG_FACILITY_SYSTEM_DAEMON, ocstruct.OpenconfigSystemLogging_SyslogSeverity_WARNING)
+	consoleSelectors.NewSelector(ocstruct.OpenconfigSystemLogging_SYSLOG_FACILITY_KERNEL, ocstruct.OpenconfigSystemLogging_SyslogSeverity_INFORMATIONAL)
+	// Unset severities are ignored.
+	consoleSelectors.NewSelector(ocstruct.OpenconfigSystemLogging_SYSLOG_FACILITY_MAIL, ocstruct.OpenconfigSystemLogging_SyslogSeverity_UNSET)
+
+	want := []SyslogSelector{{Facility: 0, Severity: 6}, {Facility: 3, Severity: 4}}
+	if got := ConsoleSyslogSelectors(apConfig); !reflect.DeepEqual(got, want) {
+		t.Errorf("Incorrect console selectors (got: %v, want: %v).", got, want)
+	}
+}
+
+func TestGRPCServerSettings(t *testing.T) {
+	apConfig := mock.GenerateAPConfig(false)
+	if got := GRPCServerSettings(apConfig); got != nil {
+		t.Errorf("Expected no gRPC server settings, got %+v.", got)
+	}
+
+	grpcConfig := &ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System_GrpcServer_Config{
+		Port:              ygot.Uint16(9339),
+		TransportSecurity: ygot.Bool(true),
+		CertificateId:     ygot.String("gnmi"),
+	}
+	apConfig.System = &ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System{
+		GrpcServer: &ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System_GrpcServer{Config: grpcConfig},
+	}
+	for _, address := range []string{"2001:db8::0:20", "192.168.1.20", "invalid"} {
+		grpcConfig.ListenAddresses = append(grpcConfig.ListenAddresses,
+			&ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System_GrpcServer_Config_ListenAddresses_Union_String{String: address})
+	}
+	want := &GRPCServer{
+		Enable:            true,
+		Port:              9339,
+		ListenAddresses:   []string{"192.168.1.20", "2001:db8::20"},
+		TransportSecurity: ygot.Bool(true),
+		CertificateID:     "gnmi",
+	}
+	if got := GRPCServerSettings(apConfig); !reflect.DeepEqual(got, want) {
+		t.Errorf("Incorrect gRPC server settings (got: %+v, want: %+v).", got, want)
+	}
+
+	// Listening on any address replaces the other addresses.
+	grpcConfig.ListenAddresses = append(grpcConfig.ListenAddresses,
+		&ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System_GrpcServer_Config_ListenAddresses_Union_E_OpenconfigAccessPoints_AccessPoints_AccessPoint_System_GrpcServer_Config_ListenAddresses{
+			E_OpenconfigAccessPoints_AccessPoints_AccessPoint_System_GrpcServer_Config_ListenAddresses: ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System_GrpcServer_Config_ListenAddresses_ANY,
+		})
+	grpcConfig.Enable = ygot.Bool(false)
+	want.ListenAddresses = []string{AnyAddress}
+	want.Enable = false
+	if got := GRPCServerSettings(apConfig); !reflect.DeepEqual(got, want) {
+		t.Errorf("Incorrect gRPC server settings (got: %+v, want: %+v).", got, want)
+	}
+}
+
+func TestSSHServerSettings(t *testing.T) {
+	apConfig := mock.GenerateAPConfig(false)
+	if got := SSHServerSettings(apConfig); got != nil {
+		t.Errorf("Expected no SSH server settings, got %+v.", got)
+	}
+
+	apConfig.System = &ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System{
+		SshServer: &ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System_SshServer{
+			Config: &ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System_SshServer_Config{
+				RateLimit: ygot.Uint16(10),
+				Timeout:   ygot.Uint16(600),
+			},
+		},
+	}
+	want := &SSHServer{Enable: true, RateLimit: 10, Timeout: 600}
+	if got := SSHServerSettings(apConfig); !reflect.DeepEqual(got, want) {
+		t.Errorf("Incorrect SSH server settings (got: %+v, want: %+v).", got, want)
+	}
+}
+
+func TestCheckTerminalServers(t *testing.T) {
+	apConfig := mock.GenerateAPConfig(false)
+	apConfig.System = &ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System{
+		SshServer: &ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System_SshServer{
+			Config: &ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System_SshServer_Config{
+				ProtocolVersion: ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System_SshServer_Config_ProtocolVersion_V2,
+			},
+		},
+		TelnetServer: &ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System_TelnetServer{
+			Config: &ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System_TelnetServer_Config{Enable: ygot.Bool(false)},
+		},
+	}
+	if err := CheckTerminalServers(apConfig); err != nil {
+		t.Errorf("Expected supported terminal servers. Error: %v.", err)
+	}
+
+	apConfig.System.SshServer.Config.ProtocolVersion = ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System_SshServer_Config_ProtocolVersion_V1_V2
+	if err := CheckTerminalServers(apConfig); err == nil {
+		t.Error("Expected an error for SSH protocol version 1.")
+	}
+	apConfig.System.SshServer = nil
+	apConfig.System.TelnetServer.Config.Enable = ygot.Bool(true)
+	if err := CheckTerminalServers(apConfig); err == nil {
+		t.Error("Expected an error for an enabled telnet server.")
+	}
+}
+
 func TestRadiusServers(t *testing.T) {
 	// Define test cases.
 	tests := []struct {
 		apConfig      *ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint
-		radiusServers map[string]*ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System_Aaa_ServerGroups_ServerGroup_Servers_Server
+		radiusServers map[string][]*ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System_Aaa_ServerGroups_ServerGroup_Servers_Server
 	}{{
 		apConfig:      mock.GenerateAPConfig(false),
-		radiusServers: make(map[string]*ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System_Aaa_ServerGroups_ServerGroup_Servers_Server),
+		radiusServers: make(map[string][]*ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System_Aaa_ServerGroups_ServerGroup_Servers_Server),
 	}, {
 		apConfig: mock.GenerateAPConfig(true),
-		radiusServers: map[string]*ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System_Aaa_ServerGroups_ServerGroup_Servers_Server{
-			mock.AuthWLANName: mock.RadiusServer(),
+		radiusServers: map[string][]*ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System_Aaa_ServerGroups_ServerGroup_Servers_Server{
+			mock.AuthWLANName: {mock.RadiusServer()},
 		},
 	}}
 
@@ -141,3 +989,88 @@ func TestRadiusServers(t *testing.T) {
 		}
 	}
 }
+
+// addRadiusServer adds a radius server to the first server group of the given AP.
+func addRadiusServer(apConfig *ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint, name, address string) {
+	for _, serverGP := range apConfig.System.Aaa.ServerGroups.ServerGroup {
+		server := mock.RadiusServer()
+		server.Address = ygot.String(address)
+		server.Config.Address = ygot.String(address)
+		server.Config.Name = ygot.String(name)
+		serverGP.Servers.Server[address] = server
+		return
+	}
+}
+
+func TestRadiusServersOrder(t *testing.T) {
+	apConfig := mock.GenerateAPConfig(true)
+	addRadiusServer(apConfig, "2-backup", "10.0.0.2")
+	addRadiusServer(apConfig, "1-primary", "10.0.0.3")
+
+	var got []string
+	for _, server := range RadiusServers(apConfig)[mock.AuthWLANName] {
+		got = append(got, *server.Address)
+	}
+	// The mock server has no name, so it goes first.
+	want := []string{*mock.RadiusServer().Address, "10.0.0.3", "10.0.0.2"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Incorrect radius server order (got: %v, want: %v).", got, want)
+	}
+}
+
+func TestRadiusAccountingServers(t *testing.T) {
+	radiusAll := &ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System_Aaa_Accounting_Config_AccountingMethod_Union_E_OpenconfigAaaTypes_AAA_METHOD_TYPE{
+		E_OpenconfigAaaTypes_AAA_METHOD_TYPE: ocstruct.OpenconfigAaaTypes_AAA_METHOD_TYPE_RADIUS_ALL,
+	}
+	local := &ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System_Aaa_Accounting_Config_AccountingMethod_Union_E_OpenconfigAaaTypes_AAA_METHOD_TYPE{
+		E_OpenconfigAaaTypes_AAA_METHOD_TYPE: ocstruct.OpenconfigAaaTypes_AAA_METHOD_TYPE_LOCAL,
+	}
+	var serverGPName string
+	for name := range mock.GenerateAPConfig(true).System.Aaa.ServerGroups.ServerGroup {
+		serverGPName = name
+	}
+	namedGroup := &ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System_Aaa_Accounting_Config_AccountingMethod_Union_String{
+		String: serverGPName,
+	}
+	unknownGroup := &ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System_Aaa_Accounting_Config_AccountingMethod_Union_String{
+		String: "unknown",
+	}
+
+	// Define test cases.
+	tests := []struct {
+		methods []ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System_Aaa_Accounting_Config_AccountingMethod_Union
+		servers []string
+	}{{
+		methods: nil,
+		servers: nil,
+	}, {
+		methods: []ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System_Aaa_Accounting_Config_AccountingMethod_Union{radiusAll},
+		servers: []string{*mock.RadiusServer().Address, "10.0.0.2"},
+	}, {
+		methods: []ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System_Aaa_Accounting_Config_AccountingMethod_Union{namedGroup, radiusAll},
+		servers: []string{*mock.RadiusServer().Address, "10.0.0.2"},
+	}, {
+		methods: []ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System_Aaa_Accounting_Config_AccountingMethod_Union{local, unknownGroup},
+		servers: nil,
+	}}
+
+	for _, test := range tests {
+		apConfig := mock.GenerateAPConfig(true)
+		addRadiusServer(apConfig, "backup", "10.0.0.2")
+		if test.methods != nil {
+			apConfig.System.Aaa.Accounting = &ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System_Aaa_Accounting{
+				Config: &ocstruct.OpenconfigAccessPoints_AccessPoints_AccessPoint_System_Aaa_Accounting_Config{
+					AccountingMethod: test.methods,
+				},
+			}
+		}
+
+		var got []string
+		for _, server := range RadiusAccountingServers(apConfig) {
+			got = append(got, *server.Address)
+		}
+		if !reflect.DeepEqual(got, test.servers) {
+			t.Errorf("Incorrect accounting servers for %v (got: %v, want: %v).", test.methods, got, test.servers)
+		}
+	}
+}
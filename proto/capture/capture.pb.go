@@ -0,0 +1,687 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.9
+// 	protoc        (unknown)
+// source: capture/capture.proto
+
+package link022_capture
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// CaptureParams are the parameters of a capture.
+type CaptureParams struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// The interface to capture, e.g. br_100. It must exist: a WLAN monitor
+	// interface is not created by the capture.
+	Interface string `protobuf:"bytes,1,opt,name=interface,proto3" json:"interface,omitempty"`
+	// The BPF filter of the packets to capture, e.g. "ether proto 0x888e".
+	Filter string `protobuf:"bytes,2,opt,name=filter,proto3" json:"filter,omitempty"`
+	// The maximum duration of the capture, in nanoseconds.
+	MaxDuration uint64 `protobuf:"varint,3,opt,name=max_duration,json=maxDuration,proto3" json:"max_duration,omitempty"`
+	// The maximum number of bytes captured.
+	MaxBytes uint64 `protobuf:"varint,4,opt,name=max_bytes,json=maxBytes,proto3" json:"max_bytes,omitempty"`
+	// The number of bytes captured of each packet, 0 for the whole packets.
+	SnapLength    uint32 `protobuf:"varint,5,opt,name=snap_length,json=snapLength,proto3" json:"snap_length,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CaptureParams) Reset() {
+	*x = CaptureParams{}
+	mi := &file_capture_capture_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CaptureParams) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CaptureParams) ProtoMessage() {}
+
+func (x *CaptureParams) ProtoReflect() protoreflect.Message {
+	mi := &file_capture_capture_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CaptureParams.ProtoReflect.Descriptor instead.
+func (*CaptureParams) Descriptor() ([]byte, []int) {
+	return file_capture_capture_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *CaptureParams) GetInterface() string {
+	if x != nil {
+		return x.Interface
+	}
+	return ""
+}
+
+func (x *CaptureParams) GetFilter() string {
+	if x != nil {
+		return x.Filter
+	}
+	return ""
+}
+
+func (x *CaptureParams) GetMaxDuration() uint64 {
+	if x != nil {
+		return x.MaxDuration
+	}
+	return 0
+}
+
+func (x *CaptureParams) GetMaxBytes() uint64 {
+	if x != nil {
+		return x.MaxBytes
+	}
+	return 0
+}
+
+func (x *CaptureParams) GetSnapLength() uint32 {
+	if x != nil {
+		return x.SnapLength
+	}
+	return 0
+}
+
+type StartRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Params        *CaptureParams         `protobuf:"bytes,1,opt,name=params,proto3" json:"params,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *StartRequest) Reset() {
+	*x = StartRequest{}
+	mi := &file_capture_capture_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StartRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StartRequest) ProtoMessage() {}
+
+func (x *StartRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_capture_capture_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StartRequest.ProtoReflect.Descriptor instead.
+func (*StartRequest) Descriptor() ([]byte, []int) {
+	return file_capture_capture_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *StartRequest) GetParams() *CaptureParams {
+	if x != nil {
+		return x.Params
+	}
+	return nil
+}
+
+type StartResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// The ID of the capture.
+	Id string `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	// The path of the capture file on the AP.
+	File          string `protobuf:"bytes,2,opt,name=file,proto3" json:"file,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *StartResponse) Reset() {
+	*x = StartResponse{}
+	mi := &file_capture_capture_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StartResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StartResponse) ProtoMessage() {}
+
+func (x *StartResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_capture_capture_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StartResponse.ProtoReflect.Descriptor instead.
+func (*StartResponse) Descriptor() ([]byte, []int) {
+	return file_capture_capture_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *StartResponse) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *StartResponse) GetFile() string {
+	if x != nil {
+		return x.File
+	}
+	return ""
+}
+
+type StopRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *StopRequest) Reset() {
+	*x = StopRequest{}
+	mi := &file_capture_capture_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StopRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StopRequest) ProtoMessage() {}
+
+func (x *StopRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_capture_capture_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StopRequest.ProtoReflect.Descriptor instead.
+func (*StopRequest) Descriptor() ([]byte, []int) {
+	return file_capture_capture_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *StopRequest) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+type StopResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *StopResponse) Reset() {
+	*x = StopResponse{}
+	mi := &file_capture_capture_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StopResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StopResponse) ProtoMessage() {}
+
+func (x *StopResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_capture_capture_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StopResponse.ProtoReflect.Descriptor instead.
+func (*StopResponse) Descriptor() ([]byte, []int) {
+	return file_capture_capture_proto_rawDescGZIP(), []int{4}
+}
+
+type ListRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListRequest) Reset() {
+	*x = ListRequest{}
+	mi := &file_capture_capture_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListRequest) ProtoMessage() {}
+
+func (x *ListRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_capture_capture_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListRequest.ProtoReflect.Descriptor instead.
+func (*ListRequest) Descriptor() ([]byte, []int) {
+	return file_capture_capture_proto_rawDescGZIP(), []int{5}
+}
+
+type ListResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Captures      []*CaptureInfo         `protobuf:"bytes,1,rep,name=captures,proto3" json:"captures,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListResponse) Reset() {
+	*x = ListResponse{}
+	mi := &file_capture_capture_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListResponse) ProtoMessage() {}
+
+func (x *ListResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_capture_capture_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListResponse.ProtoReflect.Descriptor instead.
+func (*ListResponse) Descriptor() ([]byte, []int) {
+	return file_capture_capture_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *ListResponse) GetCaptures() []*CaptureInfo {
+	if x != nil {
+		return x.Captures
+	}
+	return nil
+}
+
+// CaptureInfo is the information of a capture file.
+type CaptureInfo struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Id    string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	// The path of the capture file on the AP.
+	File string `protobuf:"bytes,2,opt,name=file,proto3" json:"file,omitempty"`
+	// Whether the packets are still being captured.
+	Running bool `protobuf:"varint,3,opt,name=running,proto3" json:"running,omitempty"`
+	// The size of the capture file in bytes.
+	Size uint64 `protobuf:"varint,4,opt,name=size,proto3" json:"size,omitempty"`
+	// The last modification time of the capture file, in nanoseconds since the
+	// epoch.
+	LastModified  uint64 `protobuf:"varint,5,opt,name=last_modified,json=lastModified,proto3" json:"last_modified,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CaptureInfo) Reset() {
+	*x = CaptureInfo{}
+	mi := &file_capture_capture_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CaptureInfo) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CaptureInfo) ProtoMessage() {}
+
+func (x *CaptureInfo) ProtoReflect() protoreflect.Message {
+	mi := &file_capture_capture_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CaptureInfo.ProtoReflect.Descriptor instead.
+func (*CaptureInfo) Descriptor() ([]byte, []int) {
+	return file_capture_capture_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *CaptureInfo) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *CaptureInfo) GetFile() string {
+	if x != nil {
+		return x.File
+	}
+	return ""
+}
+
+func (x *CaptureInfo) GetRunning() bool {
+	if x != nil {
+		return x.Running
+	}
+	return false
+}
+
+func (x *CaptureInfo) GetSize() uint64 {
+	if x != nil {
+		return x.Size
+	}
+	return 0
+}
+
+func (x *CaptureInfo) GetLastModified() uint64 {
+	if x != nil {
+		return x.LastModified
+	}
+	return 0
+}
+
+type StreamRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Params        *CaptureParams         `protobuf:"bytes,1,opt,name=params,proto3" json:"params,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *StreamRequest) Reset() {
+	*x = StreamRequest{}
+	mi := &file_capture_capture_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StreamRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StreamRequest) ProtoMessage() {}
+
+func (x *StreamRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_capture_capture_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StreamRequest.ProtoReflect.Descriptor instead.
+func (*StreamRequest) Descriptor() ([]byte, []int) {
+	return file_capture_capture_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *StreamRequest) GetParams() *CaptureParams {
+	if x != nil {
+		return x.Params
+	}
+	return nil
+}
+
+// Packet is a captured packet.
+type Packet struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// The time the packet was captured, in nanoseconds since the epoch.
+	Timestamp uint64 `protobuf:"varint,1,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+	// The length of the packet, which may be larger than the captured data.
+	Length uint32 `protobuf:"varint,2,opt,name=length,proto3" json:"length,omitempty"`
+	// The captured data of the packet.
+	Data []byte `protobuf:"bytes,3,opt,name=data,proto3" json:"data,omitempty"`
+	// The pcap link type of the interface, e.g. 1 for Ethernet or 127 for
+	// 802.11 with radiotap headers.
+	LinkType      uint32 `protobuf:"varint,4,opt,name=link_type,json=linkType,proto3" json:"link_type,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Packet) Reset() {
+	*x = Packet{}
+	mi := &file_capture_capture_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Packet) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Packet) ProtoMessage() {}
+
+func (x *Packet) ProtoReflect() protoreflect.Message {
+	mi := &file_capture_capture_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Packet.ProtoReflect.Descriptor instead.
+func (*Packet) Descriptor() ([]byte, []int) {
+	return file_capture_capture_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *Packet) GetTimestamp() uint64 {
+	if x != nil {
+		return x.Timestamp
+	}
+	return 0
+}
+
+func (x *Packet) GetLength() uint32 {
+	if x != nil {
+		return x.Length
+	}
+	return 0
+}
+
+func (x *Packet) GetData() []byte {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
+func (x *Packet) GetLinkType() uint32 {
+	if x != nil {
+		return x.LinkType
+	}
+	return 0
+}
+
+var File_capture_capture_proto protoreflect.FileDescriptor
+
+const file_capture_capture_proto_rawDesc = "" +
+	"\n" +
+	"\x15capture/capture.proto\x12\x0flink022.capture\"\xa6\x01\n" +
+	"\rCaptureParams\x12\x1c\n" +
+	"\tinterface\x18\x01 \x01(\tR\tinterface\x12\x16\n" +
+	"\x06filter\x18\x02 \x01(\tR\x06filter\x12!\n" +
+	"\fmax_duration\x18\x03 \x01(\x04R\vmaxDuration\x12\x1b\n" +
+	"\tmax_bytes\x18\x04 \x01(\x04R\bmaxBytes\x12\x1f\n" +
+	"\vsnap_length\x18\x05 \x01(\rR\n" +
+	"snapLength\"F\n" +
+	"\fStartRequest\x126\n" +
+	"\x06params\x18\x01 \x01(\v2\x1e.link022.capture.CaptureParamsR\x06params\"3\n" +
+	"\rStartResponse\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x12\n" +
+	"\x04file\x18\x02 \x01(\tR\x04file\"\x1d\n" +
+	"\vStopRequest\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\"\x0e\n" +
+	"\fStopResponse\"\r\n" +
+	"\vListRequest\"H\n" +
+	"\fListResponse\x128\n" +
+	"\bcaptures\x18\x01 \x03(\v2\x1c.link022.capture.CaptureInfoR\bcaptures\"\x84\x01\n" +
+	"\vCaptureInfo\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x12\n" +
+	"\x04file\x18\x02 \x01(\tR\x04file\x12\x18\n" +
+	"\arunning\x18\x03 \x01(\bR\arunning\x12\x12\n" +
+	"\x04size\x18\x04 \x01(\x04R\x04size\x12#\n" +
+	"\rlast_modified\x18\x05 \x01(\x04R\flastModified\"G\n" +
+	"\rStreamRequest\x126\n" +
+	"\x06params\x18\x01 \x01(\v2\x1e.link022.capture.CaptureParamsR\x06params\"o\n" +
+	"\x06Packet\x12\x1c\n" +
+	"\ttimestamp\x18\x01 \x01(\x04R\ttimestamp\x12\x16\n" +
+	"\x06length\x18\x02 \x01(\rR\x06length\x12\x12\n" +
+	"\x04data\x18\x03 \x01(\fR\x04data\x12\x1b\n" +
+	"\tlink_type\x18\x04 \x01(\rR\blinkType2\xa8\x02\n" +
+	"\aCapture\x12H\n" +
+	"\x05Start\x12\x1d.link022.capture.StartRequest\x1a\x1e.link022.capture.StartResponse\"\x00\x12E\n" +
+	"\x04Stop\x12\x1c.link022.capture.StopRequest\x1a\x1d.link022.capture.StopResponse\"\x00\x12E\n" +
+	"\x04List\x12\x1c.link022.capture.ListRequest\x1a\x1d.link022.capture.ListResponse\"\x00\x12E\n" +
+	"\x06Stream\x12\x1e.link022.capture.StreamRequest\x1a\x17.link022.capture.Packet\"\x000\x01B9Z7github.com/google/link022/proto/capture;link022_captureb\x06proto3"
+
+var (
+	file_capture_capture_proto_rawDescOnce sync.Once
+	file_capture_capture_proto_rawDescData []byte
+)
+
+func file_capture_capture_proto_rawDescGZIP() []byte {
+	file_capture_capture_proto_rawDescOnce.Do(func() {
+		file_capture_capture_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_capture_capture_proto_rawDesc), len(file_capture_capture_proto_rawDesc)))
+	})
+	return file_capture_capture_proto_rawDescData
+}
+
+var file_capture_capture_proto_msgTypes = make([]protoimpl.MessageInfo, 10)
+var file_capture_capture_proto_goTypes = []any{
+	(*CaptureParams)(nil), // 0: link022.capture.CaptureParams
+	(*StartRequest)(nil),  // 1: link022.capture.StartRequest
+	(*StartResponse)(nil), // 2: link022.capture.StartResponse
+	(*StopRequest)(nil),   // 3: link022.capture.StopRequest
+	(*StopResponse)(nil),  // 4: link022.capture.StopResponse
+	(*ListRequest)(nil),   // 5: link022.capture.ListRequest
+	(*ListResponse)(nil),  // 6: link022.capture.ListResponse
+	(*CaptureInfo)(nil),   // 7: link022.capture.CaptureInfo
+	(*StreamRequest)(nil), // 8: link022.capture.StreamRequest
+	(*Packet)(nil),        // 9: link022.capture.Packet
+}
+var file_capture_capture_proto_depIdxs = []int32{
+	0, // 0: link022.capture.StartRequest.params:type_name -> link022.capture.CaptureParams
+	7, // 1: link022.capture.ListResponse.captures:type_name -> link022.capture.CaptureInfo
+	0, // 2: link022.capture.StreamRequest.params:type_name -> link022.capture.CaptureParams
+	1, // 3: link022.capture.Capture.Start:input_type -> link022.capture.StartRequest
+	3, // 4: link022.capture.Capture.Stop:input_type -> link022.capture.StopRequest
+	5, // 5: link022.capture.Capture.List:input_type -> link022.capture.ListRequest
+	8, // 6: link022.capture.Capture.Stream:input_type -> link022.capture.StreamRequest
+	2, // 7: link022.capture.Capture.Start:output_type -> link022.capture.StartResponse
+	4, // 8: link022.capture.Capture.Stop:output_type -> link022.capture.StopResponse
+	6, // 9: link022.capture.Capture.List:output_type -> link022.capture.ListResponse
+	9, // 10: link022.capture.Capture.Stream:output_type -> link022.capture.Packet
+	7, // [7:11] is the sub-list for method output_type
+	3, // [3:7] is the sub-list for method input_type
+	3, // [3:3] is the sub-list for extension type_name
+	3, // [3:3] is the sub-list for extension extendee
+	0, // [0:3] is the sub-list for field type_name
+}
+
+func init() { file_capture_capture_proto_init() }
+func file_capture_capture_proto_init() {
+	if File_capture_capture_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_capture_capture_proto_rawDesc), len(file_capture_capture_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   10,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_capture_capture_proto_goTypes,
+		DependencyIndexes: file_capture_capture_proto_depIdxs,
+		MessageInfos:      file_capture_capture_proto_msgTypes,
+	}.Build()
+	File_capture_capture_proto = out.File
+	file_capture_capture_proto_goTypes = nil
+	file_capture_capture_proto_depIdxs = nil
+}
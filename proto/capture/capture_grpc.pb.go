@@ -0,0 +1,276 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             (unknown)
+// source: capture/capture.proto
+
+package link022_capture
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	Capture_Start_FullMethodName  = "/link022.capture.Capture/Start"
+	Capture_Stop_FullMethodName   = "/link022.capture.Capture/Stop"
+	Capture_List_FullMethodName   = "/link022.capture.Capture/List"
+	Capture_Stream_FullMethodName = "/link022.capture.Capture/Stream"
+)
+
+// CaptureClient is the client API for Capture service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type CaptureClient interface {
+	// Start starts a capture written to a pcapng file of the capture folder of
+	// the AP, which can be retrieved with the gNOI File service. It returns once
+	// the capture started, the capture stops once one of its limits is reached.
+	Start(ctx context.Context, in *StartRequest, opts ...grpc.CallOption) (*StartResponse, error)
+	// Stop stops a capture started by Start.
+	Stop(ctx context.Context, in *StopRequest, opts ...grpc.CallOption) (*StopResponse, error)
+	// List lists the capture files, and whether they are being captured.
+	List(ctx context.Context, in *ListRequest, opts ...grpc.CallOption) (*ListResponse, error)
+	// Stream captures packets and streams them to the caller, until one of the
+	// limits of the capture is reached or the call ends.
+	Stream(ctx context.Context, in *StreamRequest, opts ...grpc.CallOption) (Capture_StreamClient, error)
+}
+
+type captureClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewCaptureClient(cc grpc.ClientConnInterface) CaptureClient {
+	return &captureClient{cc}
+}
+
+func (c *captureClient) Start(ctx context.Context, in *StartRequest, opts ...grpc.CallOption) (*StartResponse, error) {
+	out := new(StartResponse)
+	err := c.cc.Invoke(ctx, Capture_Start_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *captureClient) Stop(ctx context.Context, in *StopRequest, opts ...grpc.CallOption) (*StopResponse, error) {
+	out := new(StopResponse)
+	err := c.cc.Invoke(ctx, Capture_Stop_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *captureClient) List(ctx context.Context, in *ListRequest, opts ...grpc.CallOption) (*ListResponse, error) {
+	out := new(ListResponse)
+	err := c.cc.Invoke(ctx, Capture_List_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *captureClient) Stream(ctx context.Context, in *StreamRequest, opts ...grpc.CallOption) (Capture_StreamClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Capture_ServiceDesc.Streams[0], Capture_Stream_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &captureStreamClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Capture_StreamClient interface {
+	Recv() (*Packet, error)
+	grpc.ClientStream
+}
+
+type captureStreamClient struct {
+	grpc.ClientStream
+}
+
+func (x *captureStreamClient) Recv() (*Packet, error) {
+	m := new(Packet)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// CaptureServer is the server API for Capture service.
+// All implementations must embed UnimplementedCaptureServer
+// for forward compatibility
+type CaptureServer interface {
+	// Start starts a capture written to a pcapng file of the capture folder of
+	// the AP, which can be retrieved with the gNOI File service. It returns once
+	// the capture started, the capture stops once one of its limits is reached.
+	Start(context.Context, *StartRequest) (*StartResponse, error)
+	// Stop stops a capture started by Start.
+	Stop(context.Context, *StopRequest) (*StopResponse, error)
+	// List lists the capture files, and whether they are being captured.
+	List(context.Context, *ListRequest) (*ListResponse, error)
+	// Stream captures packets and streams them to the caller, until one of the
+	// limits of the capture is reached or the call ends.
+	Stream(*StreamRequest, Capture_StreamServer) error
+	mustEmbedUnimplementedCaptureServer()
+}
+
+// UnimplementedCaptureServer must be embedded to have forward compatible implementations.
+type UnimplementedCaptureServer struct {
+}
+
+func (UnimplementedCaptureServer) Start(context.Context, *StartRequest) (*StartResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Start not implemented")
+}
+func (UnimplementedCaptureServer) Stop(context.Context, *StopRequest) (*StopResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Stop not implemented")
+}
+func (UnimplementedCaptureServer) List(context.Context, *ListRequest) (*ListResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method List not implemented")
+}
+func (UnimplementedCaptureServer) Stream(*StreamRequest, Capture_StreamServer) error {
+	return status.Errorf(codes.Unimplemented, "method Stream not implemented")
+}
+func (UnimplementedCaptureServer) mustEmbedUnimplementedCaptureServer() {}
+
+// UnsafeCaptureServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to CaptureServer will
+// result in compilation errors.
+type UnsafeCaptureServer interface {
+	mustEmbedUnimplementedCaptureServer()
+}
+
+func RegisterCaptureServer(s grpc.ServiceRegistrar, srv CaptureServer) {
+	s.RegisterService(&Capture_ServiceDesc, srv)
+}
+
+func _Capture_Start_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StartRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CaptureServer).Start(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Capture_Start_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CaptureServer).Start(ctx, req.(*StartRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Capture_Stop_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StopRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CaptureServer).Stop(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Capture_Stop_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CaptureServer).Stop(ctx, req.(*StopRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Capture_List_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CaptureServer).List(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Capture_List_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CaptureServer).List(ctx, req.(*ListRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Capture_Stream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(StreamRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(CaptureServer).Stream(m, &captureStreamServer{stream})
+}
+
+type Capture_StreamServer interface {
+	Send(*Packet) error
+	grpc.ServerStream
+}
+
+type captureStreamServer struct {
+	grpc.ServerStream
+}
+
+func (x *captureStreamServer) Send(m *Packet) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// Capture_ServiceDesc is the grpc.ServiceDesc for Capture service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Capture_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "link022.capture.Capture",
+	HandlerType: (*CaptureServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Start",
+			Handler:    _Capture_Start_Handler,
+		},
+		{
+			MethodName: "Stop",
+			Handler:    _Capture_Stop_Handler,
+		},
+		{
+			MethodName: "List",
+			Handler:    _Capture_List_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Stream",
+			Handler:       _Capture_Stream_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "capture/capture.proto",
+}